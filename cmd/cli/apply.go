@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"text/tabwriter"
+)
+
+type planAction struct {
+	Action string          `json:"action"`
+	Bucket string          `json:"bucket"`
+	Before json.RawMessage `json:"before,omitempty"`
+	After  json.RawMessage `json:"after,omitempty"`
+}
+
+type applyResult struct {
+	DryRun    bool         `json:"dry_run"`
+	Actions   []planAction `json:"actions"`
+	Unmanaged []string     `json:"unmanaged"`
+	Applied   int          `json:"applied"`
+}
+
+func (c *CLI) apply(args []string) error {
+	fs := flag.NewFlagSet("apply", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "Show the plan without applying it")
+	args, err := parseCommandFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if len(args) != 1 {
+		return fmt.Errorf("usage: storage-cli apply [--dry-run] <config.json>")
+	}
+
+	data, err := os.ReadFile(args[0])
+	if err != nil {
+		return fmt.Errorf("failed to read config: %w", err)
+	}
+
+	url := fmt.Sprintf("%s/admin/apply?dry_run=%t", c.config.ServerUrl, *dryRun)
+	resp, err := c.client.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to apply config: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to apply config: %s", responseError(resp))
+	}
+
+	var result applyResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ACTION\tBUCKET\tCHANGE")
+	fmt.Fprintln(w, "------\t------\t------")
+	for _, action := range result.Actions {
+		change := ""
+		if action.Action == "update" {
+			change = fmt.Sprintf("%s -> %s", action.Before, action.After)
+		} else if action.Action == "create" {
+			change = string(action.After)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", action.Action, action.Bucket, change)
+	}
+	for _, name := range result.Unmanaged {
+		fmt.Fprintf(w, "unmanaged\t%s\t\n", name)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if result.DryRun {
+		fmt.Println("\nDry run: no changes applied.")
+	} else {
+		fmt.Printf("\n%d change(s) applied.\n", result.Applied)
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// tokenTransport sends the access token as a bearer token, or the admin
+// token instead for /admin/ endpoints when one is set.
+type tokenTransport struct {
+	next       http.RoundTripper
+	token      string
+	adminToken string
+}
+
+func (t *tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token := t.token
+	if t.adminToken != "" && strings.HasPrefix(req.URL.Path, "/admin/") {
+		token = t.adminToken
+	}
+	if token != "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return t.next.RoundTrip(req)
+}
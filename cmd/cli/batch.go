@@ -0,0 +1,137 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+type batchFailure struct {
+	Name string
+	Err  error
+}
+
+// batchReport collects the outcome of a multi-item operation. Workers may call
+// Success and Failure concurrently; each call prints one complete line so the
+// output of parallel transfers never interleaves.
+type batchReport struct {
+	mu        sync.Mutex
+	out       io.Writer
+	start     time.Time
+	succeeded int
+	skipped   int
+	failures  []batchFailure
+	bytes     int64
+}
+
+func newBatchReport(out io.Writer) *batchReport {
+	return &batchReport{
+		out:   out,
+		start: time.Now(),
+	}
+}
+
+func (r *batchReport) Success(name string, size int64, message string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.succeeded++
+	r.bytes += size
+	fmt.Fprintf(r.out, "%s: %s (%s)\n", name, message, formatSize(size))
+}
+
+func (r *batchReport) Skipped(name, reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.skipped++
+	fmt.Fprintf(r.out, "%s: skipped (%s)\n", name, reason)
+}
+
+func (r *batchReport) Failure(name string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.failures = append(r.failures, batchFailure{Name: name, Err: err})
+	fmt.Fprintf(r.out, "%s: FAILED: %v\n", name, err)
+}
+
+func (r *batchReport) Summary() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	elapsed := time.Since(r.start)
+	throughput := float64(r.bytes) / elapsed.Seconds()
+
+	fmt.Fprintln(r.out)
+	fmt.Fprintf(r.out, "%d succeeded, %d skipped, %d failed, %s transferred in %s (%s/s)\n",
+		r.succeeded, r.skipped, len(r.failures), formatSize(r.bytes),
+		elapsed.Round(time.Millisecond), formatSize(int64(throughput)))
+
+	for _, failure := range r.failures {
+		fmt.Fprintf(r.out, "  FAILED %s: %v\n", failure.Name, failure.Err)
+	}
+}
+
+func (r *batchReport) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.failures) > 0 {
+		return fmt.Errorf("%d of %d operations failed", len(r.failures), len(r.failures)+r.succeeded+r.skipped)
+	}
+	return nil
+}
+
+// runParallel calls fn for every item using at most n concurrent workers.
+func runParallel[T any](n int, items []T, fn func(T)) {
+	if n < 1 {
+		n = 1
+	}
+
+	work := make(chan T)
+	var wg sync.WaitGroup
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range work {
+				fn(item)
+			}
+		}()
+	}
+
+	for _, item := range items {
+		work <- item
+	}
+	close(work)
+	wg.Wait()
+}
+
+// parseCommandFlags parses command flags that may appear before, between or
+// after positional arguments and returns the positional arguments in order.
+func parseCommandFlags(fs *flag.FlagSet, args []string) ([]string, error) {
+	fs.SetOutput(io.Discard)
+
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+
+		if args[0] == "--" {
+			return append(positional, args[1:]...), nil
+		}
+
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// bulkETagBatch is the number of keys sent per bulk-ETag request.
+const bulkETagBatch = 1000
+
+func fileMD5(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := md5.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func fileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// errChecksumMismatch is returned when a downloaded file does not match the
+// object it was downloaded from.
+var errChecksumMismatch = errors.New("downloaded file does not match the object")
+
+// verifyDownload checks a downloaded file against the object's SHA-256
+// checksum, or its ETag when the object was stored without one; the ETag
+// of an object is the MD5 of its data.
+func (c *CLI) verifyDownload(bucketName, objectKey, localPath string) error {
+	resp, err := c.client.Head(fmt.Sprintf("%s/objects/%s/%s", c.config.ServerUrl, bucketName, objectKey))
+	if err != nil {
+		return fmt.Errorf("failed to verify download: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to verify download: %s", resp.Status)
+	}
+
+	algorithm, want, sum := "SHA-256", resp.Header.Get("X-Checksum-Sha256"), fileSHA256
+	if want == "" {
+		algorithm, want, sum = "MD5", strings.Trim(resp.Header.Get("ETag"), `"`), fileMD5
+		if len(want) != 32 {
+			return fmt.Errorf("failed to verify download: '%s/%s' has no SHA-256 checksum or MD5 ETag", bucketName, objectKey)
+		}
+	}
+
+	got, err := sum(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to verify download: %w", err)
+	}
+	if got != want {
+		return fmt.Errorf("%w: '%s' has %s %s, but '%s/%s' has %s", errChecksumMismatch, localPath, algorithm, got, bucketName, objectKey, want)
+	}
+	if c.config.Verbose {
+		fmt.Printf("Verified '%s' against the object's %s.\n", localPath, algorithm)
+	}
+	return nil
+}
+
+// remoteETags fetches the ETags of many keys with one request per batch.
+// Keys that do not exist remotely are absent from the result.
+func (c *CLI) remoteETags(bucketName string, keys []string) (map[string]string, error) {
+	etags := make(map[string]string, len(keys))
+
+	for start := 0; start < len(keys); start += bulkETagBatch {
+		end := min(start+bulkETagBatch, len(keys))
+
+		body, err := json.Marshal(map[string][]string{"keys": keys[start:end]})
+		if err != nil {
+			return nil, err
+		}
+
+		url := fmt.Sprintf("%s/objects/%s?etags", c.config.ServerUrl, bucketName)
+		resp, err := c.client.Post(url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch ETags: %w", err)
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			msg := responseError(resp)
+			resp.Body.Close()
+			return nil, fmt.Errorf("failed to fetch ETags: %s", msg)
+		}
+
+		var batch map[string]string
+		err = json.NewDecoder(resp.Body).Decode(&batch)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode response: %w", err)
+		}
+
+		for key, etag := range batch {
+			etags[key] = etag
+		}
+	}
+
+	return etags, nil
+}
+
+// unchangedFiles compares local files (keyed by object key) with the remote
+// ETags and reports which keys have identical content on both sides. Sizes
+// and modification times are deliberately ignored.
+func (c *CLI) unchangedFiles(bucketName string, files map[string]string) (map[string]bool, error) {
+	keys := make([]string, 0, len(files))
+	for key := range files {
+		keys = append(keys, key)
+	}
+
+	etags, err := c.remoteETags(bucketName, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	unchanged := make(map[string]bool)
+	for key, localPath := range files {
+		etag, ok := etags[key]
+		if !ok {
+			continue
+		}
+
+		sum, err := fileMD5(localPath)
+		if err != nil {
+			continue
+		}
+		if sum == etag {
+			unchanged[key] = true
+		}
+	}
+	return unchanged, nil
+}
+
+// referenceUploadMinSize is the smallest file for which an upload first
+// tries to complete by reference; below it the extra round trip costs more
+// than sending the data.
+const referenceUploadMinSize = 1 << 20
+
+// putByReference asks the server to create the object from content it
+// already stores, identified by the SHA-256 in headers. It reports false
+// when the server does not have the content and the body must be sent.
+// The Content-MD5 and checksum headers make a server without reference
+// support reject the empty body instead of storing it.
+func (c *CLI) putByReference(url string, headers http.Header) (bool, error) {
+	req, err := http.NewRequest(http.MethodPut, url, http.NoBody)
+	if err != nil {
+		return false, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header = headers.Clone()
+	req.Header.Set("X-Content-Reference", "sha256:"+headers.Get("X-Checksum-Sha256"))
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("failed to upload file: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusPreconditionFailed, http.StatusBadRequest:
+		return false, nil
+	default:
+		return false, fmt.Errorf("failed to upload file: %s", responseError(resp))
+	}
+}
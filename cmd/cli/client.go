@@ -1,13 +1,24 @@
 package main
 
 import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"mime"
+	"net"
 	"net/http"
+	neturl "net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"text/tabwriter"
 	"time"
@@ -16,35 +27,100 @@ import (
 const (
 	defaultServerUrl = "http://localhost:8080"
 	version          = "1.0.0"
+
+	defaultConnectTimeout  = 10 * time.Second
+	defaultResponseTimeout = time.Minute
 )
 
 type Config struct {
-	ServerUrl string
-	Verbose   bool
+	ServerUrl  string
+	Verbose    bool
+	Debug      bool
+	LogFile    string
+	Token      string
+	AdminToken string
+	Output     string
+
+	// Retries is how many times idempotent requests are retried, the first
+	// time after RetryDelay.
+	Retries    int
+	RetryDelay time.Duration
+
+	// ConnectTimeout limits connecting to the server, including the TLS
+	// handshake, and ResponseTimeout the wait for a response once the
+	// request has been sent. Timeout limits whole requests, including
+	// their bodies. Zero means no limit.
+	ConnectTimeout  time.Duration
+	ResponseTimeout time.Duration
+	Timeout         time.Duration
 }
 
 type BucketInfo struct {
 	Name    string    `json:"name"`
-	Created time.Time `json::"created"`
+	Created time.Time `json:"created"`
+	Owner   string    `json:"owner"`
+	Usage   *struct {
+		Objects int64 `json:"objects"`
+		Bytes   int64 `json:"bytes"`
+	} `json:"usage"`
 }
 
 type ObjectInfo struct {
-	Key          string    `json:"key"`
-	Size         int64     `json:"size"`
-	ContentType  string    `json:"content_type"`
-	ETag         string    `json:"etag"`
-	LastModified time.Time `json:"last_modified"`
+	Key          string     `json:"key"`
+	Size         int64      `json:"size"`
+	ContentType  string     `json:"content_type"`
+	ETag         string     `json:"etag"`
+	LastModified time.Time  `json:"last_modified,omitzero"`
+	RetainUntil  *time.Time `json:"retain_until"`
+	LegalHold    bool       `json:"legal_hold"`
+}
+
+// lockStatus summarizes whether an object is protected from deletion.
+func (o ObjectInfo) lockStatus() string {
+	switch {
+	case o.LegalHold:
+		return "hold"
+	case o.RetainUntil != nil && o.RetainUntil.After(time.Now()):
+		return "until " + o.RetainUntil.Format("2006-01-02")
+	}
+	return "-"
 }
 
 type CLI struct {
-	config *Config
-	client *http.Client
+	config    *Config
+	client    *http.Client
+	transfers *transferLog
 }
 
+// NewCLI creates a CLI whose requests have no overall deadline unless
+// --timeout is set, so large transfers are not cut off; a server that does
+// not accept the connection or does not start to answer in time fails the
+// request instead.
 func NewCLI(config *Config) *CLI {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	dialer := &net.Dialer{Timeout: config.ConnectTimeout, KeepAlive: 30 * time.Second}
+	transport.DialContext = dialer.DialContext
+	transport.TLSHandshakeTimeout = config.ConnectTimeout
+	transport.ResponseHeaderTimeout = config.ResponseTimeout
+
+	client := &http.Client{Transport: transport, Timeout: config.Timeout}
+	if config.Debug {
+		client.Transport = newDebugTransport(client.Transport, os.Stderr)
+	}
+	if config.Token != "" || config.AdminToken != "" {
+		client.Transport = &tokenTransport{next: client.Transport, token: config.Token, adminToken: config.AdminToken}
+	}
+	if config.Retries > 0 {
+		retry := &retryTransport{next: client.Transport, retries: config.Retries, delay: config.RetryDelay}
+		if config.Verbose {
+			retry.log = os.Stderr
+		}
+		client.Transport = retry
+	}
+
 	return &CLI{
 		config: config,
-		client: &http.Client{Timeout: 30 * time.Second},
+		client: client,
 	}
 }
 
@@ -65,10 +141,38 @@ func (c *CLI) Run(args []string) error {
 		return c.copy(commandArgs)
 	case "rm", "remove":
 		return c.remove(commandArgs)
+	case "sync":
+		return c.sync(commandArgs)
+	case "mirror":
+		return c.mirror(commandArgs)
+	case "du":
+		return c.du(commandArgs)
+	case "find":
+		return c.find(commandArgs)
+	case "alias":
+		return c.alias(commandArgs)
+	case "presign":
+		return c.presign(commandArgs)
+	case "mv", "move":
+		return c.move(commandArgs)
+	case "restore":
+		return c.restore(commandArgs)
+	case "trash":
+		return c.trash(commandArgs)
 	case "cat":
 		return c.cat(commandArgs)
+	case "head":
+		return c.head(commandArgs)
+	case "tail":
+		return c.tail(commandArgs)
 	case "stat":
 		return c.stat(commandArgs)
+	case "apply":
+		return c.apply(commandArgs)
+	case "events":
+		return c.events(commandArgs)
+	case "share":
+		return c.share(commandArgs)
 	case "version":
 		return c.showVersion()
 	case "help", "--help", "-h":
@@ -83,6 +187,20 @@ func (c *CLI) showVersion() error {
 	return nil
 }
 
+// objectStat is what stat reports about an object, from its HEAD response.
+type objectStat struct {
+	Bucket       string    `json:"bucket"`
+	Key          string    `json:"key"`
+	ContentType  string    `json:"content_type"`
+	Size         int64     `json:"size"`
+	ETag         string    `json:"etag"`
+	LastModified time.Time `json:"last_modified,omitzero"`
+	SHA256       string    `json:"sha256,omitempty"`
+	CRC32C       string    `json:"crc32c,omitempty"`
+	RetainUntil  string    `json:"retain_until,omitempty"`
+	LegalHold    string    `json:"legal_hold,omitempty"`
+}
+
 func (c *CLI) stat(args []string) error {
 	if len(args) != 1 {
 		return fmt.Errorf("usage: storage-cli stat <bucket/object>")
@@ -99,29 +217,53 @@ func (c *CLI) stat(args []string) error {
 	url := fmt.Sprintf("%s/objects/%s/%s", c.config.ServerUrl, bucketName, objectKey)
 	resp, err := c.client.Head(url)
 	if err != nil {
-		resp, err := c.client.Get(url)
-		if err != nil {
-			return fmt.Errorf("failed to get objects info: %w", err)
-		}
-		resp.Body.Close()
+		return fmt.Errorf("failed to get objects info: %w", err)
 	}
+	resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		return fmt.Errorf("object not found")
 	}
 
-	fmt.Printf("Object: %s/%s\n", bucketName, objectKey)
-	fmt.Printf("Content-Type: %s\n", resp.Header.Get("Content-Type"))
-	fmt.Printf("Content-Length: %s\n", resp.Header.Get("Content-Length"))
-	fmt.Printf("ETag: %s\n", resp.Header.Get("ETag"))
-	fmt.Printf("Last-Modified: %s\n", resp.Header.Get("Last-Modified"))
-
-	return nil
+	info := objectStat{
+		Bucket:      bucketName,
+		Key:         objectKey,
+		ContentType: resp.Header.Get("Content-Type"),
+		Size:        resp.ContentLength,
+		ETag:        strings.Trim(resp.Header.Get("ETag"), `"`),
+		SHA256:      resp.Header.Get("X-Checksum-Sha256"),
+		CRC32C:      resp.Header.Get("X-Checksum-Crc32c"),
+		RetainUntil: resp.Header.Get("X-Object-Lock-Retain-Until"),
+		LegalHold:   resp.Header.Get("X-Object-Legal-Hold"),
+	}
+	info.LastModified, _ = http.ParseTime(resp.Header.Get("Last-Modified"))
+
+	return c.render(info, func() error {
+		fmt.Printf("Object: %s/%s\n", bucketName, objectKey)
+		fmt.Printf("Content-Type: %s\n", resp.Header.Get("Content-Type"))
+		fmt.Printf("Content-Length: %s\n", resp.Header.Get("Content-Length"))
+		fmt.Printf("ETag: %s\n", resp.Header.Get("ETag"))
+		fmt.Printf("Last-Modified: %s\n", resp.Header.Get("Last-Modified"))
+		for _, header := range []string{"X-Checksum-Sha256", "X-Checksum-Crc32c", "X-Object-Lock-Retain-Until", "X-Object-Legal-Hold"} {
+			if value := resp.Header.Get(header); value != "" {
+				fmt.Printf("%s: %s\n", strings.TrimPrefix(header, "X-"), value)
+			}
+		}
+		return nil
+	})
 }
 
 func (c *CLI) cat(args []string) error {
+	fs := flag.NewFlagSet("cat", flag.ContinueOnError)
+	offset := fs.String("offset", "0", "Start at this byte, such as 1048576 or 1MB")
+	length := fs.String("length", "", "Print at most this many bytes, such as 4096 or 4KB")
+	args, err := parseCommandFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
 	if len(args) != 1 {
-		return fmt.Errorf("usage: storage-cli stat <bucket/object>")
+		return fmt.Errorf("usage: storage-cli cat [--offset N] [--length N] <bucket/object>")
 	}
 
 	remotePath := args[0]
@@ -132,25 +274,92 @@ func (c *CLI) cat(args []string) error {
 
 	bucketName, objectKey := parts[0], parts[1]
 
+	start, err := parseSize(*offset)
+	if err != nil {
+		return fmt.Errorf("--offset: %w", err)
+	}
+	var n int64
+	if *length != "" {
+		if n, err = parseSize(*length); err != nil {
+			return fmt.Errorf("--length: %w", err)
+		}
+		if n == 0 {
+			return nil
+		}
+	}
+
+	_, err = c.readRange(bucketName, objectKey, start, n, os.Stdout)
+	return err
+}
+
+// readRange writes length bytes of an object from offset to w, or the rest
+// of the object when length is 0, using a Range request so the rest is
+// never transferred. It returns the size of the whole object.
+func (c *CLI) readRange(bucketName, objectKey string, offset, length int64, w io.Writer) (int64, error) {
 	url := fmt.Sprintf("%s/objects/%s/%s", c.config.ServerUrl, bucketName, objectKey)
-	resp, err := c.client.Get(url)
+	req, err := http.NewRequest(http.MethodGet, url, nil)
 	if err != nil {
-		return fmt.Errorf("failed to get object: %w", err)
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	if offset > 0 || length > 0 {
+		end := ""
+		if length > 0 {
+			end = strconv.FormatInt(offset+length-1, 10)
+		}
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%s", offset, end))
 	}
-	resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("failed to get Object: %s", string(body))
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get object: %w", err)
 	}
+	defer resp.Body.Close()
 
-	_, err = io.Copy(os.Stdout, resp.Body)
-	return err
+	var size int64
+	body := io.Reader(resp.Body)
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		// Content-Range is "bytes first-last/size".
+		_, total, _ := strings.Cut(resp.Header.Get("Content-Range"), "/")
+		size, _ = strconv.ParseInt(total, 10, 64)
+	case http.StatusOK:
+		// The server sent the whole object, so skip to the range here.
+		size = resp.ContentLength
+		if _, err := io.CopyN(io.Discard, body, offset); err != nil && err != io.EOF {
+			return 0, fmt.Errorf("failed to read object: %w", err)
+		}
+		if length > 0 {
+			body = io.LimitReader(body, length)
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		_, total, _ := strings.Cut(resp.Header.Get("Content-Range"), "/")
+		return 0, fmt.Errorf("offset %d is beyond the end of the object (%s bytes)", offset, total)
+	default:
+		return 0, fmt.Errorf("failed to get object: %s", responseError(resp))
+	}
+
+	if _, err := io.Copy(w, body); err != nil {
+		return 0, fmt.Errorf("failed to read object: %w", err)
+	}
+	return size, nil
 }
 
 func (c *CLI) remove(args []string) error {
+	fs := flag.NewFlagSet("rm", flag.ContinueOnError)
+	recursive := fs.Bool("recursive", false, "Delete every object under a prefix")
+	fs.BoolVar(recursive, "r", false, "Delete every object under a prefix (short form)")
+	yes := fs.Bool("yes", false, "Do not ask for confirmation")
+	fs.BoolVar(yes, "y", false, "Do not ask for confirmation (short form)")
+	args, err := parseCommandFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
 	if len(args) != 1 {
-		return fmt.Errorf("usage: storage-cli rm <bucket/object>")
+		return fmt.Errorf("usage: storage-cli rm [--recursive [--yes]] <bucket/object or bucket/prefix/>")
+	}
+	if *recursive {
+		return c.removePrefix(args[0], *yes)
 	}
 
 	remotePath := args[0]
@@ -165,6 +374,19 @@ func (c *CLI) remove(args []string) error {
 		fmt.Printf("Removing object '%s/%s'...\n", bucketName, objectKey)
 	}
 
+	if err := c.deleteObject(bucketName, objectKey); err != nil {
+		return err
+	}
+
+	fmt.Printf("Object '%s/%s' removed successfully.\n", bucketName, objectKey)
+	return nil
+}
+
+func (c *CLI) deleteObject(bucketName, objectKey string) (err error) {
+	defer func() {
+		c.transfers.Record(transferDeleted, bucketName+"/"+objectKey, "", 0, err)
+	}()
+
 	url := fmt.Sprintf("%s/objects/%s/%s", c.config.ServerUrl, bucketName, objectKey)
 	req, err := http.NewRequest("DELETE", url, nil)
 	if err != nil {
@@ -178,36 +400,201 @@ func (c *CLI) remove(args []string) error {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("failed to delete object: %s", string(body))
+		return fmt.Errorf("failed to delete object: %s", responseError(resp))
 	}
+	return nil
+}
 
-	fmt.Printf("Object '%s/%s' removed successfully.\n", bucketName, objectKey)
+// move moves an object or file. Within a bucket the server renames the
+// object in place, so tags, checksums and retention are kept; other moves
+// copy, check the copy, and only then delete the source.
+func (c *CLI) move(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: storage-cli mv <source> <destination>\n" +
+			"Examples:\n" +
+			"  storage-cli mv mybucket/a.txt mybucket/b.txt       # Rename within a bucket\n" +
+			"  storage-cli mv mybucket/a.txt otherbucket/a.txt    # Move between buckets\n" +
+			"  storage-cli mv ./a.txt mybucket/a.txt              # Upload, then delete the local file\n" +
+			"  storage-cli mv mybucket/a.txt ./a.txt              # Download, then delete the object")
+	}
+
+	source, dest := args[0], args[1]
+	switch srcLocal, destLocal := isLocalPath(source), isLocalPath(dest); {
+	case srcLocal && destLocal:
+		return fmt.Errorf("both paths are local; use your shell's mv")
+	case srcLocal:
+		return c.moveUpload(source, dest)
+	case destLocal:
+		return c.moveDownload(source, dest)
+	}
+
+	srcBucket, srcKey, ok := strings.Cut(source, "/")
+	if !ok || srcKey == "" {
+		return fmt.Errorf("path must be in format: bucket/object")
+	}
+	dstBucket, dstKey, ok := strings.Cut(dest, "/")
+	if !ok || dstKey == "" {
+		return fmt.Errorf("path must be in format: bucket/object")
+	}
+	if strings.HasSuffix(dstKey, "/") {
+		// Moving into a "directory" keeps the object's name.
+		dstKey += path.Base(srcKey)
+	}
+	if dstBucket != srcBucket {
+		return c.moveBetweenBuckets(srcBucket, srcKey, dstBucket, dstKey)
+	}
+
+	body, err := json.Marshal(map[string]string{"to": dstKey})
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/objects/%s/%s?rename", c.config.ServerUrl, srcBucket, srcKey)
+	resp, err := c.client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to rename object: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to rename object: %s", responseError(resp))
+	}
+
+	fmt.Printf("Object '%s/%s' renamed to '%s/%s'.\n", srcBucket, srcKey, dstBucket, dstKey)
 	return nil
+}
 
+type copyOptions struct {
+	Parallel     int
+	ChecksumOnly bool
+
+	// PartSize is the part size of multipart uploads, which are used for
+	// files larger than one part, and PartParallel how many parts of a file
+	// are sent at once. Resume continues an interrupted upload.
+	PartSize     int64
+	PartParallel int
+	Resume       bool
+
+	// IfMatch only overwrites an object that still has this ETag;
+	// NoClobber skips uploads to keys that already exist. Both are checked
+	// by the server when the upload commits.
+	IfMatch   string
+	NoClobber bool
+
+	// Verify checks a downloaded file against the object's checksum, and
+	// downloads it again up to VerifyRetries times when they differ.
+	Verify        bool
+	VerifyRetries int
+}
+
+// errObjectExists is returned for a --no-clobber upload to a key that is
+// taken. It is reported as a skip, not a failure.
+var errObjectExists = errors.New("destination already exists")
+
+// errPreconditionFailed is returned when the server refuses a conditional
+// upload.
+var errPreconditionFailed = errors.New("precondition failed")
+
+// preconditionError explains why the server refused a conditional upload
+// to remote.
+func (opts copyOptions) preconditionError(remote string) error {
+	if opts.NoClobber {
+		return fmt.Errorf("%w: '%s'", errObjectExists, remote)
+	}
+	return fmt.Errorf("'%s' is missing or no longer has ETag %s; not overwritten", remote, opts.IfMatch)
 }
 
 func (c *CLI) copy(args []string) error {
+	fs := flag.NewFlagSet("cp", flag.ContinueOnError)
+	var opts copyOptions
+	fs.IntVar(&opts.Parallel, "parallel", 4, "Number of concurrent transfers: files for multi-file copies, parts or ranges of a single large file")
+	fs.BoolVar(&opts.ChecksumOnly, "checksum-only", false, "Skip files whose MD5 matches the remote ETag")
+	partSizeMB := fs.Int64("part-size", defaultPartSize>>20, "Part size in MiB for multipart uploads of large files")
+	fs.BoolVar(&opts.Resume, "resume", false, "Resume an interrupted multipart upload or parallel download")
+	fs.StringVar(&opts.IfMatch, "if-match", "", "Only overwrite the remote object if its ETag is still this one")
+	fs.BoolVar(&opts.NoClobber, "no-clobber", false, "Skip uploads to objects that already exist")
+	fs.BoolVar(&opts.Verify, "verify", false, "Check a downloaded file against the object's SHA-256 or MD5 ETag")
+	fs.IntVar(&opts.VerifyRetries, "verify-retries", 0, "Download again up to N times when --verify finds a mismatch")
+	recursive := fs.Bool("recursive", false, "Upload a local directory and everything under it")
+	fs.BoolVar(recursive, "r", false, "Upload a local directory and everything under it (short form)")
+	args, err := parseCommandFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if *partSizeMB < 1 {
+		return fmt.Errorf("--part-size must be at least 1 (MiB)")
+	}
+	opts.PartSize = *partSizeMB << 20
+	opts.IfMatch = strings.Trim(opts.IfMatch, `"`)
+	if opts.IfMatch != "" && opts.NoClobber {
+		return fmt.Errorf("--if-match and --no-clobber cannot be combined")
+	}
+	if opts.VerifyRetries < 0 {
+		return fmt.Errorf("--verify-retries cannot be negative")
+	}
+	if opts.VerifyRetries > 0 {
+		opts.Verify = true
+	}
+
+	if opts.Verify && (*recursive || len(args) > 2) {
+		return fmt.Errorf("--verify only applies to downloads")
+	}
+
+	if *recursive {
+		if len(args) != 2 {
+			return fmt.Errorf("usage: storage-cli cp --recursive <local-dir> <bucket>[/prefix/]")
+		}
+		if opts.IfMatch != "" {
+			return fmt.Errorf("--if-match applies to a single upload")
+		}
+		return c.uploadDir(args[0], args[1], opts)
+	}
+
+	if len(args) > 2 {
+		if opts.IfMatch != "" {
+			return fmt.Errorf("--if-match applies to a single upload")
+		}
+		return c.uploadFiles(args[:len(args)-1], args[len(args)-1], opts)
+	}
+
 	if len(args) != 2 {
-		return fmt.Errorf("usage: storage-cli cp <source> <destination>\n" +
+		return fmt.Errorf("usage: storage-cli cp [--recursive] [--parallel N] [--checksum-only] [--part-size MiB] [--resume] [--if-match ETAG | --no-clobber] [--verify] [--verify-retries N] <source>... <destination>\n" +
 			"Examples:\n" +
-			"  storage-cli cp file.txt mybucket/file.txt  # Upload local file\n" +
-			"  storage-cli cp mybucket/file.txt file.txt  # Download to local file")
+			"  storage-cli cp file.txt mybucket/file.txt          # Upload local file\n" +
+			"  storage-cli cp mybucket/file.txt file.txt          # Download to local file\n" +
+			"  storage-cli cp a.txt b.txt mybucket/docs/          # Upload several files\n" +
+			"  storage-cli cp -r ./site mybucket/www/             # Upload a directory\n" +
+			"  tar cz dir | storage-cli cp - mybucket/dir.tgz     # Upload standard input\n" +
+			"  storage-cli cp mybucket/app.log - | grep ERROR     # Download to standard output")
 	}
 
 	source := args[0]
 	dest := args[1]
 
+	if source == stdioPath || dest == stdioPath {
+		return c.copyStream(source, dest, opts)
+	}
 	if strings.Contains(source, "/") && !strings.Contains(dest, "/") {
-		return c.downloadFile(source, dest)
+		if opts.IfMatch != "" || opts.NoClobber {
+			return fmt.Errorf("--if-match and --no-clobber only apply to uploads")
+		}
+		opts.PartParallel = opts.Parallel
+		return c.downloadFile(source, dest, opts)
 	} else if !strings.Contains(source, "/") && strings.Contains(dest, "/") {
-		return c.uploadFile(source, dest)
+		if opts.Verify {
+			return fmt.Errorf("--verify only applies to downloads")
+		}
+		// A single file has the connections to itself, so its parts are
+		// transferred in parallel; batches send each file's parts in turn.
+		opts.PartParallel = opts.Parallel
+		return c.uploadFile(source, dest, opts)
 	} else {
 		return fmt.Errorf("invalid copy operation. Use format: localfile bucket/object or bucket/object localfile")
 	}
 }
 
-func (c *CLI) uploadFile(localPath, remotePath string) error {
+func (c *CLI) uploadFile(localPath, remotePath string, opts copyOptions) error {
 	parts := strings.SplitN(remotePath, "/", 2)
 	if len(parts) < 2 {
 		return fmt.Errorf("remote path must be in format: bucket/object")
@@ -215,9 +602,102 @@ func (c *CLI) uploadFile(localPath, remotePath string) error {
 
 	bucketName, objectKey := parts[0], parts[1]
 
+	if opts.ChecksumOnly {
+		unchanged, err := c.unchangedFiles(bucketName, map[string]string{objectKey: localPath})
+		if err != nil {
+			return err
+		}
+		if unchanged[objectKey] {
+			c.transfers.Record(transferSkipped, localPath, remotePath, 0, nil)
+			fmt.Printf("Skipped '%s': '%s' has the same checksum.\n", localPath, remotePath)
+			return nil
+		}
+	}
+
+	if _, err := c.putFile(localPath, bucketName, objectKey, opts); errors.Is(err, errObjectExists) {
+		fmt.Printf("Skipped '%s': '%s' already exists.\n", localPath, remotePath)
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	fmt.Printf("File uploaded successfully to '%s/%s'.\n", bucketName, objectKey)
+	return nil
+}
+
+func (c *CLI) uploadFiles(localPaths []string, remotePrefix string, opts copyOptions) error {
+	parts := strings.SplitN(remotePrefix, "/", 2)
+	if len(parts) < 2 || (parts[1] != "" && !strings.HasSuffix(parts[1], "/")) {
+		return fmt.Errorf("destination for multiple files must be in format: bucket/ or bucket/prefix/")
+	}
+
+	bucketName, prefix := parts[0], parts[1]
+	files := make([]uploadItem, 0, len(localPaths))
+	for _, localPath := range localPaths {
+		files = append(files, uploadItem{LocalPath: localPath, Key: prefix + filepath.Base(localPath)})
+	}
+	return c.uploadBatch(bucketName, files, opts)
+}
+
+// uploadItem is a local file and the object key it is uploaded to.
+type uploadItem struct {
+	LocalPath string
+	Key       string
+}
+
+// uploadBatch uploads files to a bucket in parallel and prints a summary.
+func (c *CLI) uploadBatch(bucketName string, files []uploadItem, opts copyOptions) error {
+	report := newBatchReport(os.Stdout)
+
+	var unchanged map[string]bool
+	if opts.ChecksumOnly {
+		byKey := make(map[string]string, len(files))
+		for _, file := range files {
+			byKey[file.Key] = file.LocalPath
+		}
+
+		var err error
+		unchanged, err = c.unchangedFiles(bucketName, byKey)
+		if err != nil {
+			return err
+		}
+	}
+
+	runParallel(opts.Parallel, files, func(file uploadItem) {
+		if unchanged[file.Key] {
+			c.transfers.Record(transferSkipped, file.LocalPath, bucketName+"/"+file.Key, 0, nil)
+			report.Skipped(file.LocalPath, "unchanged")
+			return
+		}
+
+		size, err := c.putFile(file.LocalPath, bucketName, file.Key, opts)
+		if errors.Is(err, errObjectExists) {
+			report.Skipped(file.LocalPath, "exists")
+			return
+		}
+		if err != nil {
+			report.Failure(file.LocalPath, err)
+			return
+		}
+		report.Success(file.LocalPath, size, fmt.Sprintf("uploaded to '%s/%s'", bucketName, file.Key))
+	})
+
+	report.Summary()
+	return report.Err()
+}
+
+func (c *CLI) putFile(localPath, bucketName, objectKey string, opts copyOptions) (size int64, err error) {
+	defer func() {
+		if errors.Is(err, errObjectExists) {
+			c.transfers.Record(transferSkipped, localPath, bucketName+"/"+objectKey, 0, nil)
+			return
+		}
+		c.transfers.Record(transferUploaded, localPath, bucketName+"/"+objectKey, size, err)
+	}()
+
 	fileInfo, err := os.Stat(localPath)
 	if err != nil {
-		return fmt.Errorf("local file not found: %w", err)
+		return 0, fmt.Errorf("local file not found: %w", err)
 	}
 
 	if c.config.Verbose {
@@ -227,36 +707,82 @@ func (c *CLI) uploadFile(localPath, remotePath string) error {
 
 	file, err := os.Open(localPath)
 	if err != nil {
-		return fmt.Errorf("failed to open local file: %w", err)
+		return 0, fmt.Errorf("failed to open local file: %w", err)
 	}
 	defer file.Close()
 
 	contentType := getContentType(localPath)
 
+	hash := md5.New()
+	sha := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(hash, sha), file); err != nil {
+		return 0, fmt.Errorf("failed to read local file: %w", err)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("failed to read local file: %w", err)
+	}
+
 	url := fmt.Sprintf("%s/objects/%s/%s", c.config.ServerUrl, bucketName, objectKey)
-	req, err := http.NewRequest("PUT", url, file)
-	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+	headers := http.Header{}
+	headers.Set("Content-Type", contentType)
+	headers.Set("Content-MD5", base64.StdEncoding.EncodeToString(hash.Sum(nil)))
+	headers.Set("X-Checksum-Algorithm", "sha256")
+	headers.Set("X-Checksum-Sha256", hex.EncodeToString(sha.Sum(nil)))
+	if opts.IfMatch != "" {
+		headers.Set("If-Match", `"`+opts.IfMatch+`"`)
+	}
+	if opts.NoClobber {
+		headers.Set("If-None-Match", "*")
+	}
+
+	if fileInfo.Size() >= referenceUploadMinSize {
+		ok, err := c.putByReference(url, headers)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			if c.config.Verbose {
+				fmt.Printf("Server already stores this content; uploaded by reference.\n")
+			}
+			return fileInfo.Size(), nil
+		}
+	}
+
+	if opts.PartSize > 0 && fileInfo.Size() > opts.PartSize {
+		err := c.putMultipart(file, fileInfo, bucketName, objectKey, headers, opts)
+		if errors.Is(err, errPreconditionFailed) {
+			return 0, opts.preconditionError(bucketName + "/" + objectKey)
+		}
+		if err != nil {
+			return 0, err
+		}
+		return fileInfo.Size(), nil
 	}
 
-	req.Header.Set("Content-Type", contentType)
+	req, err := http.NewRequest("PUT", url, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	replayable(req, file, fileInfo.Size())
+	req.Header = headers
 
 	resp, err := c.client.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to upload file: %w", err)
+		return 0, fmt.Errorf("failed to upload file: %w", err)
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusPreconditionFailed {
+		return 0, opts.preconditionError(bucketName + "/" + objectKey)
+	}
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("failed to upload file: %s", string(body))
+		return 0, fmt.Errorf("failed to upload file: %s", responseError(resp))
 	}
 
-	fmt.Printf("File uploaded successfully to '%s/%s'.\n", bucketName, objectKey)
-	return nil
+	return fileInfo.Size(), nil
 }
 
-func (c *CLI) downloadFile(remotePath, localPath string) error {
+func (c *CLI) downloadFile(remotePath, localPath string, opts copyOptions) error {
 	parts := strings.SplitN(remotePath, "/", 2)
 	if len(parts) < 2 {
 		return fmt.Errorf("remote path must be in format: bucket/object")
@@ -264,6 +790,47 @@ func (c *CLI) downloadFile(remotePath, localPath string) error {
 
 	bucketName, objectKey := parts[0], parts[1]
 
+	if opts.ChecksumOnly {
+		unchanged, err := c.unchangedFiles(bucketName, map[string]string{objectKey: localPath})
+		if err != nil {
+			return err
+		}
+		if unchanged[objectKey] {
+			c.transfers.Record(transferSkipped, remotePath, localPath, 0, nil)
+			fmt.Printf("Skipped '%s': '%s' has the same checksum.\n", remotePath, localPath)
+			return nil
+		}
+	}
+
+	var size int64
+	var err error
+	for attempt := 0; ; attempt++ {
+		if opts.PartParallel > 1 {
+			size, err = c.getFileSegmented(bucketName, objectKey, localPath, opts)
+		} else {
+			size, err = c.getFile(bucketName, objectKey, localPath)
+		}
+		if err == nil && opts.Verify {
+			err = c.verifyDownload(bucketName, objectKey, localPath)
+		}
+		if !errors.Is(err, errChecksumMismatch) || attempt == opts.VerifyRetries {
+			break
+		}
+		fmt.Printf("%v; downloading again (retry %d of %d)\n", err, attempt+1, opts.VerifyRetries)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("File downloaded successfully to '%s' (%s).\n", localPath, formatSize(size))
+	return nil
+}
+
+func (c *CLI) getFile(bucketName, objectKey, localPath string) (size int64, err error) {
+	defer func() {
+		c.transfers.Record(transferDownloaded, bucketName+"/"+objectKey, localPath, size, err)
+	}()
+
 	if c.config.Verbose {
 		fmt.Printf("Downloading '%s/%s' to '%s'...\n", bucketName, objectKey, localPath)
 	}
@@ -271,37 +838,75 @@ func (c *CLI) downloadFile(remotePath, localPath string) error {
 	url := fmt.Sprintf("%s/objects/%s/%s", c.config.ServerUrl, bucketName, objectKey)
 	resp, err := c.client.Get(url)
 	if err != nil {
-		return fmt.Errorf("failed to download file: %w", err)
+		return 0, fmt.Errorf("failed to download file: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("failed to download file: %s", string(body))
+		return 0, fmt.Errorf("failed to download file: %s", responseError(resp))
 	}
 
 	localFile, err := os.Create(localPath)
 	if err != nil {
-		return fmt.Errorf("failed to create local file: %w", err)
+		return 0, fmt.Errorf("failed to create local file: %w", err)
 	}
 	defer localFile.Close()
 
-	size, err := io.Copy(localFile, resp.Body)
+	size, err = io.Copy(localFile, resp.Body)
 	if err != nil {
-		return fmt.Errorf("failed to write file: %w", err)
+		return size, fmt.Errorf("failed to write file: %w", err)
 	}
 
-	fmt.Printf("File downloaded successfully to '%s' (%s).\n", localPath, formatSize(size))
-	return nil
+	return size, nil
 }
 
 func (c *CLI) list(args []string) error {
+	fs := flag.NewFlagSet("ls", flag.ContinueOnError)
+	sort := fs.String("sort", "", "Sort objects by key, size or modified")
+	order := fs.String("order", "", "Sort order: asc or desc")
+	limit := fs.Int("limit", 0, "Show at most N entries")
+	match := fs.String("match", "", "Only show objects whose name matches a glob such as '*.log'")
+	prefix := fs.String("prefix", "", "Only show keys under a prefix")
+	var recursive, long, human bool
+	fs.BoolVar(&recursive, "recursive", false, "List every object under the prefix instead of one level")
+	fs.BoolVar(&recursive, "r", false, "Shorthand for --recursive")
+	fs.BoolVar(&long, "long", false, "Show size, content type, ETag, modification time and lock")
+	fs.BoolVar(&long, "l", false, "Shorthand for --long")
+	fs.BoolVar(&human, "human", false, "Show sizes as 1.5MB rather than in bytes")
+	fs.BoolVar(&human, "h", false, "Shorthand for --human")
+	args, err := parseCommandFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
 	if len(args) == 0 {
 		return c.listBuckets()
 	}
+	if len(args) != 1 || *limit < 0 {
+		return fmt.Errorf("usage: storage-cli ls [--recursive] [--prefix P] [--long] [--human] [--sort key|size|modified] [--order asc|desc] [--limit N] [--match GLOB] [bucket[/prefix]]")
+	}
 
-	bucketName := args[0]
-	return c.listObjects(bucketName)
+	query := neturl.Values{}
+	// Sorting by size or time only makes sense across the whole subtree.
+	if *sort != "" && *sort != "key" {
+		recursive = true
+		query.Set("sort", *sort)
+	}
+	if !recursive {
+		query.Set("delimiter", "/")
+	}
+	if *order != "" {
+		query.Set("order", *order)
+	}
+	if *match != "" {
+		query.Set("match", *match)
+	}
+
+	bucketName, pathPrefix, _ := strings.Cut(args[0], "/")
+	if p := pathPrefix + *prefix; p != "" {
+		query.Set("prefix", p)
+	}
+	return c.listObjects(bucketName, query, *limit, listFormat{Long: long, Human: human})
 }
 
 func (c *CLI) listBuckets() error {
@@ -317,8 +922,7 @@ func (c *CLI) listBuckets() error {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("failed to list buckets: %s", string(body))
+		return fmt.Errorf("failed to list buckets: %s", responseError(resp))
 	}
 
 	var buckets []BucketInfo
@@ -326,66 +930,181 @@ func (c *CLI) listBuckets() error {
 		return fmt.Errorf("failed to decode response: %w", err)
 	}
 
-	if len(buckets) == 0 {
-		fmt.Println("No buckets found.")
-		return nil
-	}
+	return c.render(buckets, func() error {
+		if len(buckets) == 0 {
+			fmt.Println("No buckets found.")
+			return nil
+		}
 
-	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
-	fmt.Fprintln(w, "BUCKET NAME\tCREATED")
-	fmt.Fprintln(w, "-----------\t-------")
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "BUCKET NAME\tOBJECTS\tSIZE\tOWNER\tCREATED")
+		fmt.Fprintln(w, "-----------\t-------\t----\t-----\t-------")
+
+		for _, bucket := range buckets {
+			var objects, size int64
+			if bucket.Usage != nil {
+				objects, size = bucket.Usage.Objects, bucket.Usage.Bytes
+			}
+			owner := bucket.Owner
+			if owner == "" {
+				owner = "-"
+			}
+			fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\n", bucket.Name, objects, formatSize(size), owner, bucket.Created.Format("2006-01-02 15:04:05"))
+		}
 
-	for _, bucket := range buckets {
-		fmt.Fprintf(w, "%s\t%s\n", bucket.Name, bucket.Created.Format("2025-01-02 15:04:05"))
-	}
+		return w.Flush()
+	})
+}
 
-	return w.Flush()
+// listEntry is a line of ls: an object, or a common prefix of the keys
+// below the level listed, which has only a key.
+type listEntry struct {
+	Type string `json:"type"`
+	ObjectInfo
 }
 
-func (c *CLI) listObjects(bucketName string) error {
+// listFormat selects the columns and size format of ls.
+type listFormat struct {
+	Long  bool
+	Human bool
+}
+
+func (f listFormat) size(size int64) string {
+	if f.Human {
+		return formatSize(size)
+	}
+	return strconv.FormatInt(size, 10)
+}
+
+func (c *CLI) listObjects(bucketName string, query neturl.Values, limit int, format listFormat) error {
 	if c.config.Verbose {
 		fmt.Printf("Listing objects in bucket '%s'...\n", bucketName)
 	}
 
-	url := fmt.Sprintf("%s/objects/%s", c.config.ServerUrl, bucketName)
-	resp, err := c.client.Get(url)
+	objects, prefixes, err := c.fetchListing(bucketName, query, limit)
 	if err != nil {
-		return fmt.Errorf("failed to list objects: %w", err)
+		return err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("failed to list objects: %s", string(body))
+	entries := make([]listEntry, 0, len(prefixes)+len(objects))
+	for _, prefix := range prefixes {
+		entries = append(entries, listEntry{Type: "prefix", ObjectInfo: ObjectInfo{Key: prefix}})
 	}
-
-	var objects []ObjectInfo
-	if err := json.NewDecoder(resp.Body).Decode(&objects); err != nil {
-		return fmt.Errorf("failed to decode response: %w", err)
+	for _, obj := range objects {
+		entries = append(entries, listEntry{Type: "object", ObjectInfo: obj})
 	}
 
-	if len(objects) == 0 {
-		fmt.Printf("No objects found in bucket '%s'.\n", bucketName)
-		return nil
-	}
+	return c.render(entries, func() error {
+		if len(entries) == 0 {
+			fmt.Printf("No objects found in '%s'.\n", strings.TrimSuffix(bucketName+"/"+query.Get("prefix"), "/"))
+			return nil
+		}
 
-	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
-	fmt.Fprintln(w, "OBJECT KEY\tSIZE\tCONTENT TYPE\tLAST MODIFIED")
-	fmt.Fprintln(w, "----------\t----\t------------\t-------------")
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		if format.Long {
+			fmt.Fprintln(w, "OBJECT KEY\tSIZE\tCONTENT TYPE\tETAG\tLAST MODIFIED\tLOCK")
+			fmt.Fprintln(w, "----------\t----\t------------\t----\t-------------\t----")
+		} else {
+			fmt.Fprintln(w, "OBJECT KEY\tSIZE\tLAST MODIFIED")
+			fmt.Fprintln(w, "----------\t----\t-------------")
+		}
 
-	for _, obj := range objects {
-		sizeStr := formatSize(obj.Size)
-		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n",
-			obj.Key, sizeStr, obj.ContentType,
-			obj.LastModified.Format("2006-01-02 15:04:05"))
+		for _, entry := range entries {
+			obj := entry.ObjectInfo
+			switch {
+			case entry.Type == "prefix" && format.Long:
+				fmt.Fprintf(w, "%s\tPRE\t-\t-\t-\t-\n", obj.Key)
+			case entry.Type == "prefix":
+				fmt.Fprintf(w, "%s\tPRE\t-\n", obj.Key)
+			case format.Long:
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+					obj.Key, format.size(obj.Size), obj.ContentType, obj.ETag,
+					obj.LastModified.Format("2006-01-02 15:04:05"), obj.lockStatus())
+			default:
+				fmt.Fprintf(w, "%s\t%s\t%s\n", obj.Key, format.size(obj.Size), obj.LastModified.Format("2006-01-02 15:04:05"))
+			}
+		}
+
+		return w.Flush()
+	})
+}
+
+// fetchListing reads a listing up to limit entries, or all of them when
+// limit is 0, a page at a time. With a delimiter in query the server also
+// returns the common prefixes, which count towards the limit.
+func (c *CLI) fetchListing(bucketName string, query neturl.Values, limit int) ([]ObjectInfo, []string, error) {
+	var (
+		objects  []ObjectInfo
+		prefixes []string
+	)
+	for {
+		remaining := 1000
+		if limit > 0 {
+			remaining = min(remaining, limit-len(objects)-len(prefixes))
+		}
+		query.Set("max-keys", strconv.Itoa(remaining))
+
+		url := fmt.Sprintf("%s/objects/%s?%s", c.config.ServerUrl, bucketName, query.Encode())
+		resp, err := c.client.Get(url)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to list objects: %w", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			msg := responseError(resp)
+			resp.Body.Close()
+			return nil, nil, fmt.Errorf("failed to list objects: %s", msg)
+		}
+
+		var page struct {
+			Objects        []ObjectInfo `json:"objects"`
+			CommonPrefixes []string     `json:"common_prefixes"`
+		}
+		if query.Has("delimiter") {
+			err = json.NewDecoder(resp.Body).Decode(&page)
+		} else {
+			err = json.NewDecoder(resp.Body).Decode(&page.Objects)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to decode response: %w", err)
+		}
+		objects = append(objects, page.Objects...)
+		prefixes = append(prefixes, page.CommonPrefixes...)
+
+		token := resp.Header.Get("X-Next-Continuation-Token")
+		if token == "" || (limit > 0 && len(objects)+len(prefixes) >= limit) {
+			return objects, prefixes, nil
+		}
+		query.Set("continuation-token", token)
 	}
+}
 
-	return w.Flush()
+// fetchObjects returns every object in a bucket under prefix, and
+// matching a glob unless match is empty, following continuation tokens so
+// large buckets are read a page at a time.
+func (c *CLI) fetchObjects(bucketName, prefix, match string) ([]ObjectInfo, error) {
+	query := neturl.Values{}
+	if prefix != "" {
+		query.Set("prefix", prefix)
+	}
+	if match != "" {
+		query.Set("match", match)
+	}
+	objects, _, err := c.fetchListing(bucketName, query, 0)
+	return objects, err
 }
 
 func (c *CLI) makeBucket(args []string) error {
+	fs := flag.NewFlagSet("mb", flag.ContinueOnError)
+	template := fs.String("template", "", "Provision the bucket from a server-defined template")
+	location := fs.String("location", "", "Region the bucket belongs to")
+	args, err := parseCommandFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
 	if len(args) != 1 {
-		return fmt.Errorf("usage: storage-cli mb <bucket-name>")
+		return fmt.Errorf("usage: storage-cli mb [--template NAME] [--location REGION] <bucket-name>")
 	}
 
 	bucketName := args[0]
@@ -395,10 +1114,16 @@ func (c *CLI) makeBucket(args []string) error {
 	}
 
 	url := fmt.Sprintf("%s/buckets/%s", c.config.ServerUrl, bucketName)
+	if *template != "" {
+		url += "?template=" + neturl.QueryEscape(*template)
+	}
 	req, err := http.NewRequest("PUT", url, nil)
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
+	if *location != "" {
+		req.Header.Set("X-Bucket-Location", *location)
+	}
 
 	resp, err := c.client.Do(req)
 	if err != nil {
@@ -407,8 +1132,7 @@ func (c *CLI) makeBucket(args []string) error {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusCreated {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("failed to create bucket: %s", string(body))
+		return fmt.Errorf("failed to create bucket: %s", responseError(resp))
 	}
 
 	fmt.Printf("Bucket '%s' created successfully.\n", bucketName)
@@ -422,17 +1146,70 @@ USAGE:
     storage-cli [OPTIONS] COMMAND [ARGS...]
 
 OPTIONS:
-    --server URL    Storage server URL (default: %s)
+    --server URL    Storage server URL (default: $STORAGE_SERVER_URL or %s)
     --verbose, -v   Enable verbose output
+    --debug         Log HTTP requests and responses to stderr
+    --log-file FILE Append an NDJSON record of every transfer to FILE
+    --token T       Access token for servers with auth (default: $STORAGE_TOKEN
+                    or $STORAGE_ACCESS_KEY)
+    --admin-token T Token for apply, share and presign (default: $STORAGE_ADMIN_TOKEN)
+    --output, -o F  Print ls, stat, du, find and trash ls as table, json or csv
+    --profile NAME  Use the server and tokens of a profile (see alias;
+                    default: $STORAGE_CLI_PROFILE)
+    --retries N     Retry failed idempotent requests up to N times (default: 3)
+    --retry-delay D Wait before the first retry, doubled for each later one
+                    (default: 1s)
+    --connect-timeout D
+                    Time limit for connecting to the server (default: 10s)
+    --response-timeout D
+                    Time limit for the server to start answering a request
+                    (default: 1m)
+    --timeout D     Time limit for each whole request, including its data
+                    (default: none)
     --help, -h      Show this help message
 
 COMMANDS:
-    mb, makebucket <bucket>           Create a new bucket
-    ls, list [bucket]                 List buckets or objects in bucket
-    cp, copy <source> <dest>          Upload or download files
+    mb, makebucket <bucket>           Create a new bucket (--template NAME, --location REGION)
+    ls, list [bucket[/prefix]]        List buckets, or one level of a bucket or prefix
+                                      (--recursive, --prefix P, --long, --human,
+                                      --sort key|size|modified, --order asc|desc, --limit N,
+                                      --match GLOB)
+    cp, copy <source>... <dest>       Upload or download files; '-' is standard input or output
+                                      (--recursive, --parallel N, --checksum-only,
+                                      --part-size MiB, --resume, --if-match ETAG, --no-clobber,
+                                      --verify, --verify-retries N)
+    sync <local-dir> <bucket/prefix>  Transfer only new and changed files, either direction;
+                                      write the local directory as ./dir or /dir
+                                      (--delete, --dry-run, --parallel N, --direction up|down)
+    mirror <src> <dst>                Copy a bucket between servers, e.g.
+                                      http://old:8080/photos http://new:8080/photos
+                                      (--match GLOB, --parallel N, --dry-run,
+                                      --src-token T, --dst-token T)
     rm, remove <bucket/object>        Delete an object
-    cat <bucket/object>               Display object content
+                                      (--recursive <bucket/prefix/> deletes a prefix, --yes)
+    mv, move <source> <dest>          Rename an object, keeping its metadata, or move it
+                                      to another bucket, a local file to the server or
+                                      an object to a local file
+    restore <bucket/object>           Restore a deleted object from the trash (--id ID)
+    trash ls <bucket>                 List deleted objects in a bucket's trash
+    du [bucket[/prefix]]              Show total size and object count (--depth N)
+    find <bucket>[/prefix]            Find objects by attributes and print or delete them
+                                      (--name GLOB, --larger-than SIZE, --smaller-than SIZE,
+                                      --older-than AGE, --newer-than AGE, --print, --delete, --yes)
+    cat <bucket/object>               Display object content, or part of it
+                                      (--offset N, --length N)
+    head [-n 10] <bucket/object>      Print the first lines of an object
+    tail [-n 10] <bucket/object>      Print the last lines of an object
+                                      (--follow prints appended data)
     stat <bucket/object>              Show object information
+    apply [--dry-run] <config.json>   Reconcile buckets with a declarative config
+    events <bucket>[/prefix]          Print object changes as they happen
+                                      (--prefix P, --suffix S, --type put,delete,copy)
+    share <bucket>[/prefix]           Print a signed link to list a folder (--expires 1h)
+    presign <bucket/object>           Print a signed link to download or upload an object
+                                      (--expires 1h, --method GET|PUT)
+    alias set <name> <server-url>     Save a server profile (--token T, --admin-token T, --default)
+    alias ls | alias rm <name>        List or remove server profiles
     version                           Show version information
     help                              Show this help message
 
@@ -440,18 +1217,59 @@ EXAMPLES:
     # Create a bucket
     storage-cli mb my-bucket
 
+    # Save a server profile and use it
+    storage-cli alias set prod https://storage.example.com --token ci-token
+    storage-cli --profile prod ls
+
     # List all buckets
     storage-cli ls
 
     # List objects in a bucket
     storage-cli ls my-bucket
 
+    # Show the ten largest objects
+    storage-cli ls --sort size --order desc --limit 10 my-bucket
+
+    # List only log files
+    storage-cli ls --match '*.log' my-bucket
+
+    # List objects as JSON for scripts
+    storage-cli -o json ls my-bucket
+
+    # List one "folder", or everything below it with readable sizes
+    storage-cli ls my-bucket/photos/2024/
+    storage-cli ls -r -l -h my-bucket/photos/2024/
+
     # Upload a file
     storage-cli cp local-file.txt my-bucket/remote-file.txt
 
     # Download a file
     storage-cli cp my-bucket/remote-file.txt downloaded-file.txt
 
+    # Download and check the file against the object's checksum
+    storage-cli cp --verify --verify-retries 2 my-bucket/backup.tar backup.tar
+
+    # Upload several files in parallel
+    storage-cli cp --parallel 8 a.txt b.txt c.txt my-bucket/docs/
+
+    # Upload a directory tree, keeping relative paths as keys
+    storage-cli cp --recursive ./site my-bucket/www/
+
+    # Back up a directory, removing remote files deleted locally
+    storage-cli sync --delete ./documents my-bucket/backup/
+
+    # Migrate a bucket to another server
+    storage-cli mirror --parallel 8 http://old:8080/photos http://new:8080/photos
+
+    # Upload without replacing files that already exist
+    storage-cli cp --no-clobber a.txt b.txt my-bucket/docs/
+
+    # Show the size of each top-level folder in a bucket
+    storage-cli du --depth 1 my-bucket
+
+    # Delete CSV files over 10 MB that are older than 30 days
+    storage-cli find my-bucket --name '*.csv' --larger-than 10MB --older-than 30d --delete
+
     # View file content
     storage-cli cat my-bucket/readme.txt
 
@@ -461,12 +1279,53 @@ EXAMPLES:
     # Delete an object
     storage-cli rm my-bucket/old-file.txt
 
+    # Delete everything under a prefix without asking
+    storage-cli rm --recursive --yes my-bucket/logs/2023/
+
+    # Rename an object
+    storage-cli mv my-bucket/draft.txt my-bucket/final.txt
+
+    # Upload a file and delete it locally once the upload is verified
+    storage-cli mv ./report.pdf my-bucket/reports/
+
+    # Restore a deleted object
+    storage-cli restore my-bucket/old-file.txt
+
 For more information, visit: https://github.com/yourusername/storage-cli
 `, version, defaultServerUrl)
 
 	return nil
 }
 
+// responseError extracts a readable message from an error response, including
+// the server's request ID when one was returned.
+func responseError(resp *http.Response) string {
+	body, _ := io.ReadAll(resp.Body)
+
+	var apiErr struct {
+		Error     string `json:"error"`
+		RequestID string `json:"request_id"`
+	}
+
+	message := strings.TrimSpace(string(body))
+	if json.Unmarshal(body, &apiErr) == nil && apiErr.Error != "" {
+		message = apiErr.Error
+	}
+	if message == "" {
+		message = resp.Status
+	}
+
+	requestID := apiErr.RequestID
+	if requestID == "" {
+		requestID = resp.Header.Get("X-Request-Id")
+	}
+	if requestID != "" {
+		message = fmt.Sprintf("%s (request id: %s)", message, requestID)
+	}
+
+	return message
+}
+
 func getContentType(filename string) string {
 	ext := strings.ToLower(filepath.Ext(filename))
 
@@ -492,6 +1351,9 @@ func getContentType(filename string) string {
 	if contentType, exists := contentTypes[ext]; exists {
 		return contentType
 	}
+	if contentType := mime.TypeByExtension(ext); contentType != "" {
+		return contentType
+	}
 
 	return "application/octet-stream"
 }
@@ -517,29 +1379,85 @@ func formatSize(size int64) string {
 
 func main() {
 	var (
-		serverURL = flag.String("server", defaultServerUrl, "Storage server URL")
-		verbose   = flag.Bool("verbose", false, "Enable verbose output")
-		v         = flag.Bool("v", false, "Enable verbose output (short form)")
-		help      = flag.Bool("help", false, "Show help message")
-		h         = flag.Bool("h", false, "Show help message (short form)")
+		serverURL  = flag.String("server", defaultServerUrl, "Storage server URL")
+		verbose    = flag.Bool("verbose", false, "Enable verbose output")
+		v          = flag.Bool("v", false, "Enable verbose output (short form)")
+		debug      = flag.Bool("debug", false, "Log HTTP requests and responses to stderr")
+		logFile    = flag.String("log-file", "", "Append an NDJSON record of every transfer to this file")
+		token      = flag.String("token", "", "Access token for servers with auth enabled")
+		adminToken = flag.String("admin-token", "", "Token for admin commands")
+		output     = flag.String("output", outputTable, "Output format of listings and reports: table, json or csv")
+		profile    = flag.String("profile", "", "Server profile from ~/"+profilesFile)
+		retries    = flag.Int("retries", defaultRetries, "Retry failed idempotent requests up to N times (0 disables retries)")
+		retryDelay = flag.Duration("retry-delay", defaultRetryDelay, "Wait before the first retry; later retries double it")
+		connectTO  = flag.Duration("connect-timeout", defaultConnectTimeout, "Time limit for connecting to the server (0 for none)")
+		responseTO = flag.Duration("response-timeout", defaultResponseTimeout, "Time limit for the server to start answering a request (0 for none)")
+		timeout    = flag.Duration("timeout", 0, "Time limit for each whole request, including its data (0 for none)")
+		help       = flag.Bool("help", false, "Show help message")
+		h          = flag.Bool("h", false, "Show help message (short form)")
 	)
 
+	flag.StringVar(output, "o", outputTable, "Output format (short form)")
 	flag.Parse()
 
-	config := &Config{
-		ServerUrl: *serverURL,
-		Verbose:   *verbose || *v,
+	format, err := parseOutputFormat(*output)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
 	}
 
-	cli := NewCLI(config)
+	if *retries < 0 || *retryDelay < 0 {
+		fmt.Fprintf(os.Stderr, "Error: --retries and --retry-delay cannot be negative\n")
+		os.Exit(1)
+	}
+	if *connectTO < 0 || *responseTO < 0 || *timeout < 0 {
+		fmt.Fprintf(os.Stderr, "Error: timeouts cannot be negative\n")
+		os.Exit(1)
+	}
+
+	config := &Config{
+		ServerUrl:  *serverURL,
+		Verbose:    *verbose || *v,
+		Debug:      *debug,
+		LogFile:    *logFile,
+		Token:      *token,
+		AdminToken: *adminToken,
+		Output:     format,
+		Retries:    *retries,
+		RetryDelay: *retryDelay,
+
+		ConnectTimeout:  *connectTO,
+		ResponseTimeout: *responseTO,
+		Timeout:         *timeout,
+	}
 
 	if *help || *h {
-		cli.showHelp()
+		NewCLI(config).showHelp()
 		return
 	}
 
+	explicit := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	if err := resolveConfig(config, *profile, explicit); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	cli := NewCLI(config)
+
+	if config.LogFile != "" {
+		transfers, err := openTransferLog(config.LogFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		defer transfers.Close()
+		cli.transfers = transfers
+	}
+
 	args := flag.Args()
 	if err := cli.Run(args); err != nil {
+		cli.transfers.Close()
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sensitiveHeaders carry credentials, so --debug prints [REDACTED] for
+// their values. Headers whose names read like a credential, such as
+// X-Admin-Token, are redacted too; see isSensitiveHeader.
+var sensitiveHeaders = map[string]bool{
+	"Authorization":        true,
+	"Proxy-Authorization":  true,
+	"Cookie":               true,
+	"Set-Cookie":           true,
+	"X-Access-Key":         true,
+	"X-Secret-Key":         true,
+	"X-Amz-Security-Token": true,
+}
+
+// sensitiveQueryParams carry credentials in URLs, such as the signature
+// of a shared link, which would otherwise be as good as the link itself.
+// Names are compared without regard to case.
+var sensitiveQueryParams = map[string]bool{
+	"signature":            true,
+	"token":                true,
+	"access_token":         true,
+	"x-amz-signature":      true,
+	"x-amz-credential":     true,
+	"x-amz-security-token": true,
+}
+
+// urlHeaders hold URLs, whose query may carry a signature.
+var urlHeaders = map[string]bool{
+	"Location":         true,
+	"Content-Location": true,
+}
+
+type debugTransport struct {
+	next http.RoundTripper
+	out  io.Writer
+	mu   sync.Mutex
+}
+
+func newDebugTransport(next http.RoundTripper, out io.Writer) *debugTransport {
+	return &debugTransport{next: next, out: out}
+}
+
+func (t *debugTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	elapsed := time.Since(start)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	fmt.Fprintf(t.out, "> %s %s %s\n", req.Method, redactURL(req.URL), req.Proto)
+	writeHeaders(t.out, "> ", req.Header)
+
+	if err != nil {
+		fmt.Fprintf(t.out, "! %v (%s)\n\n", err, elapsed.Round(time.Millisecond))
+		return nil, err
+	}
+
+	fmt.Fprintf(t.out, "< %s %s (%s)\n", resp.Proto, resp.Status, elapsed.Round(time.Millisecond))
+	writeHeaders(t.out, "< ", resp.Header)
+	fmt.Fprintln(t.out)
+
+	return resp, nil
+}
+
+func writeHeaders(w io.Writer, prefix string, header http.Header) {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		values := header[name]
+		switch {
+		case isSensitiveHeader(name):
+			values = []string{"[REDACTED]"}
+		case urlHeaders[http.CanonicalHeaderKey(name)]:
+			values = make([]string, len(header[name]))
+			for i, value := range header[name] {
+				if u, err := url.Parse(value); err == nil {
+					value = redactURL(u)
+				}
+				values[i] = value
+			}
+		}
+		fmt.Fprintf(w, "%s%s: %s\n", prefix, name, strings.Join(values, ", "))
+	}
+}
+
+// isSensitiveHeader reports whether a header's value is a credential:
+// it is one of sensitiveHeaders, or its name says it holds a token, secret,
+// signature or other authorization.
+func isSensitiveHeader(name string) bool {
+	if sensitiveHeaders[http.CanonicalHeaderKey(name)] {
+		return true
+	}
+	name = strings.ToLower(name)
+	for _, word := range []string{"authorization", "token", "secret", "signature", "credential", "password", "api-key"} {
+		if strings.Contains(name, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactURL formats u with the values of sensitive query parameters and any
+// password replaced. The other parameters are kept as sent, in order.
+func redactURL(u *url.URL) string {
+	redacted := *u
+	if redacted.RawQuery != "" {
+		params := strings.Split(redacted.RawQuery, "&")
+		for i, param := range params {
+			rawName, _, hasValue := strings.Cut(param, "=")
+			name, err := url.QueryUnescape(rawName)
+			if err != nil {
+				name = rawName
+			}
+			if hasValue && sensitiveQueryParams[strings.ToLower(name)] {
+				params[i] = rawName + "=REDACTED"
+			}
+		}
+		redacted.RawQuery = strings.Join(params, "&")
+	}
+	return redacted.Redacted()
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// downloadStateSuffix names the file next to a local file that records the
+// segments of a parallel download already written, so cp --resume can
+// continue it.
+const downloadStateSuffix = ".download"
+
+// downloadState identifies an interrupted segmented download and the
+// version of the object it was downloading.
+type downloadState struct {
+	Server   string `json:"server"`
+	Bucket   string `json:"bucket"`
+	Key      string `json:"key"`
+	ETag     string `json:"etag"`
+	Size     int64  `json:"size"`
+	PartSize int64  `json:"part_size"`
+	Done     []int  `json:"done"`
+}
+
+func (s downloadState) matches(other downloadState) bool {
+	return s.Server == other.Server && s.Bucket == other.Bucket && s.Key == other.Key &&
+		s.ETag == other.ETag && s.Size == other.Size && s.PartSize == other.PartSize
+}
+
+func readDownloadState(path string) (*downloadState, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var state downloadState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+func writeDownloadState(path string, state *downloadState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// errObjectChanged is returned when an object is replaced while it is
+// being downloaded in segments.
+var errObjectChanged = errors.New("object changed during the download")
+
+// getFileSegmented downloads an object in opts.PartSize segments with
+// opts.PartParallel concurrent Range requests, each written at its offset
+// in a file allocated to the object's size. The segments written are saved
+// next to the file until the download completes; with resume, a download
+// of the same object version is continued. Small objects, and servers that
+// do not accept ranges, are downloaded in one request.
+func (c *CLI) getFileSegmented(bucketName, objectKey, localPath string, opts copyOptions) (size int64, err error) {
+	objectURL := fmt.Sprintf("%s/objects/%s/%s", c.config.ServerUrl, bucketName, objectKey)
+	resp, err := c.client.Head(objectURL)
+	if err != nil {
+		return 0, fmt.Errorf("failed to download file: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("failed to download file: %s", resp.Status)
+	}
+	if resp.Header.Get("Accept-Ranges") != "bytes" || resp.ContentLength <= opts.PartSize {
+		return c.getFile(bucketName, objectKey, localPath)
+	}
+
+	defer func() {
+		c.transfers.Record(transferDownloaded, bucketName+"/"+objectKey, localPath, size, err)
+	}()
+
+	statePath := localPath + downloadStateSuffix
+	want := downloadState{
+		Server:   c.config.ServerUrl,
+		Bucket:   bucketName,
+		Key:      objectKey,
+		ETag:     strings.Trim(resp.Header.Get("ETag"), `"`),
+		Size:     resp.ContentLength,
+		PartSize: opts.PartSize,
+	}
+
+	flags := os.O_RDWR | os.O_CREATE | os.O_TRUNC
+	if state, err := readDownloadState(statePath); err == nil && opts.Resume {
+		if state.matches(want) {
+			want.Done = state.Done
+			flags &^= os.O_TRUNC
+			fmt.Printf("Resuming download: %d segment(s) already downloaded.\n", len(state.Done))
+		} else {
+			fmt.Printf("'%s' changed since the download was interrupted; starting over.\n", bucketName+"/"+objectKey)
+		}
+	} else if opts.Resume {
+		fmt.Printf("No interrupted download of '%s' found; starting a new one.\n", localPath)
+	}
+
+	file, err := os.OpenFile(localPath, flags, 0644)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create local file: %w", err)
+	}
+	defer file.Close()
+	if err := file.Truncate(want.Size); err != nil {
+		return 0, fmt.Errorf("failed to allocate local file: %w", err)
+	}
+	if err := writeDownloadState(statePath, &want); err != nil {
+		return 0, fmt.Errorf("failed to save download state: %w", err)
+	}
+
+	segmentCount := int((want.Size + opts.PartSize - 1) / opts.PartSize)
+	var pending []int
+	for n := 1; n <= segmentCount; n++ {
+		if !slices.Contains(want.Done, n) {
+			pending = append(pending, n)
+		}
+	}
+
+	var (
+		mu      sync.Mutex
+		failure error
+	)
+	runParallel(opts.PartParallel, pending, func(n int) {
+		mu.Lock()
+		failed := failure != nil
+		mu.Unlock()
+		if failed {
+			return
+		}
+
+		offset := int64(n-1) * opts.PartSize
+		length := min(opts.PartSize, want.Size-offset)
+		err := c.getSegment(objectURL, want.ETag, file, offset, length)
+
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			if failure == nil {
+				failure = fmt.Errorf("segment %d: %w", n, err)
+			}
+			return
+		}
+		want.Done = append(want.Done, n)
+		if err := writeDownloadState(statePath, &want); err != nil && failure == nil {
+			failure = fmt.Errorf("failed to save download state: %w", err)
+		}
+		if c.config.Verbose {
+			fmt.Printf("Downloaded segment %d/%d (%s)\n", n, segmentCount, formatSize(length))
+		}
+	})
+	if errors.Is(failure, errObjectChanged) {
+		os.Remove(statePath)
+		return 0, failure
+	}
+	if failure != nil {
+		return 0, fmt.Errorf("%w\nRun the same command with --resume to continue the download", failure)
+	}
+
+	if sum, err := fileMD5(localPath); err == nil && len(want.ETag) == 32 && sum != want.ETag {
+		os.Remove(statePath)
+		return 0, fmt.Errorf("%w: '%s' has MD5 %s, but the object's ETag is %s", errChecksumMismatch, localPath, sum, want.ETag)
+	}
+	os.Remove(statePath)
+	return want.Size, nil
+}
+
+// getSegment writes length bytes of an object at offset into file. The
+// request is conditional on the ETag, so a replaced object is never mixed
+// with the old one.
+func (c *CLI) getSegment(objectURL, etag string, file *os.File, offset, length int64) error {
+	req, err := http.NewRequest(http.MethodGet, objectURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	req.Header.Set("If-Range", `"`+etag+`"`)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to download: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		return errObjectChanged
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		return fmt.Errorf("failed to download: %s", responseError(resp))
+	}
+
+	n, err := io.Copy(io.NewOffsetWriter(file, offset), io.LimitReader(resp.Body, length))
+	if err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if n != length {
+		return fmt.Errorf("received %d of %d bytes", n, length)
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+)
+
+// usageRow is one line of du output: a bucket or a prefix in one.
+type usageRow struct {
+	Path    string `json:"path"`
+	Objects int64  `json:"objects"`
+	Bytes   int64  `json:"bytes"`
+}
+
+// du shows how much is stored in every bucket, a bucket or a prefix. Whole
+// buckets use the usage the server tracks; prefixes and --depth list the
+// objects and add them up.
+func (c *CLI) du(args []string) error {
+	fs := flag.NewFlagSet("du", flag.ContinueOnError)
+	depth := fs.Int("depth", 0, "Also show the usage of prefixes up to N levels below the path")
+	args, err := parseCommandFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) > 1 || *depth < 0 {
+		return fmt.Errorf("usage: storage-cli du [--depth N] [bucket[/prefix]]")
+	}
+
+	var rows []usageRow
+	var total usageRow
+	switch {
+	case len(args) == 0:
+		rows, err = c.bucketUsageRows()
+		total.Path = "total"
+		for _, row := range rows {
+			total.Objects += row.Objects
+			total.Bytes += row.Bytes
+		}
+	case !strings.Contains(strings.TrimSuffix(args[0], "/"), "/") && *depth == 0:
+		total, err = c.bucketUsage(strings.TrimSuffix(args[0], "/"))
+	default:
+		bucketName, prefix := splitSyncRemote(args[0])
+		rows, total, err = c.prefixUsage(bucketName, prefix, *depth)
+	}
+	if err != nil {
+		return err
+	}
+
+	rows = append(rows, total)
+	return c.render(rows, func() error {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "SIZE\tOBJECTS\tPATH")
+		for _, row := range rows {
+			fmt.Fprintf(w, "%s\t%d\t%s\n", formatSize(row.Bytes), row.Objects, row.Path)
+		}
+		return w.Flush()
+	})
+}
+
+// bucketUsageRows reads the usage of every bucket from the bucket list.
+func (c *CLI) bucketUsageRows() ([]usageRow, error) {
+	resp, err := c.client.Get(fmt.Sprintf("%s/buckets", c.config.ServerUrl))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list buckets: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to list buckets: %s", responseError(resp))
+	}
+	var buckets []BucketInfo
+	if err := json.NewDecoder(resp.Body).Decode(&buckets); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	rows := make([]usageRow, 0, len(buckets))
+	for _, bucket := range buckets {
+		row := usageRow{Path: bucket.Name}
+		if bucket.Usage != nil {
+			row.Objects, row.Bytes = bucket.Usage.Objects, bucket.Usage.Bytes
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// bucketUsage reads GET /buckets/{bucket}/usage.
+func (c *CLI) bucketUsage(bucketName string) (usageRow, error) {
+	resp, err := c.client.Get(fmt.Sprintf("%s/buckets/%s/usage", c.config.ServerUrl, bucketName))
+	if err != nil {
+		return usageRow{}, fmt.Errorf("failed to read usage: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return usageRow{}, fmt.Errorf("failed to read usage: %s", responseError(resp))
+	}
+	row := usageRow{Path: bucketName}
+	var usage struct {
+		Objects int64 `json:"objects"`
+		Bytes   int64 `json:"bytes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&usage); err != nil {
+		return usageRow{}, fmt.Errorf("failed to decode response: %w", err)
+	}
+	row.Objects, row.Bytes = usage.Objects, usage.Bytes
+	return row, nil
+}
+
+// prefixUsage adds up the objects under prefix, and for each prefix up to
+// depth levels below it. Objects directly under a level only count towards
+// the levels above.
+func (c *CLI) prefixUsage(bucketName, prefix string, depth int) ([]usageRow, usageRow, error) {
+	objects, err := c.fetchObjects(bucketName, prefix, "")
+	if err != nil {
+		return nil, usageRow{}, err
+	}
+
+	total := usageRow{Path: bucketName + "/" + prefix}
+	groups := map[string]*usageRow{}
+	for _, object := range objects {
+		total.Objects++
+		total.Bytes += object.Size
+
+		segments := strings.Split(strings.TrimPrefix(object.Key, prefix), "/")
+		for level := 1; level <= depth && level < len(segments); level++ {
+			path := total.Path + strings.Join(segments[:level], "/") + "/"
+			group, ok := groups[path]
+			if !ok {
+				group = &usageRow{Path: path}
+				groups[path] = group
+			}
+			group.Objects++
+			group.Bytes += object.Size
+		}
+	}
+
+	rows := make([]usageRow, 0, len(groups))
+	for _, group := range groups {
+		rows = append(rows, *group)
+	}
+	slices.SortFunc(rows, func(a, b usageRow) int { return strings.Compare(a.Path, b.Path) })
+	return rows, total, nil
+}
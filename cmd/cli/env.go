@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// Environment variables the CLI reads, for CI jobs and containers.
+const (
+	envServerURL  = "STORAGE_SERVER_URL"
+	envProfile    = "STORAGE_CLI_PROFILE"
+	envToken      = "STORAGE_TOKEN"
+	envAccessKey  = "STORAGE_ACCESS_KEY"
+	envSecretKey  = "STORAGE_SECRET_KEY"
+	envAdminToken = "STORAGE_ADMIN_TOKEN"
+)
+
+// resolveConfig fills in the server and tokens that were not given on the
+// command line, first from the environment and then from a profile: the
+// one named by --profile or STORAGE_CLI_PROFILE, or else the profiles
+// file's default.
+func resolveConfig(config *Config, profileName string, explicit map[string]bool) error {
+	if profileName == "" {
+		profileName = os.Getenv(envProfile)
+	}
+
+	var p profile
+	path, err := profilesPath()
+	if err != nil && profileName != "" {
+		return err
+	}
+	if err == nil {
+		profiles, err := loadProfiles(path)
+		if err != nil {
+			return err
+		}
+		if profileName == "" {
+			profileName = profiles.Default
+		}
+		if profileName != "" {
+			var ok bool
+			if p, ok = profiles.Profiles[profileName]; !ok {
+				return fmt.Errorf("profile '%s' not found in %s (see 'storage-cli alias ls')", profileName, path)
+			}
+		}
+	}
+
+	resolve := func(flagName string, dest *string, fromProfile string, envs ...string) {
+		if explicit[flagName] {
+			return
+		}
+		for _, env := range envs {
+			if value := os.Getenv(env); value != "" {
+				*dest = value
+				return
+			}
+		}
+		if fromProfile != "" {
+			*dest = fromProfile
+		}
+	}
+	resolve("server", &config.ServerUrl, p.Server, envServerURL)
+	resolve("token", &config.Token, p.Token, envToken, envAccessKey)
+	resolve("admin-token", &config.AdminToken, p.AdminToken, envAdminToken)
+
+	// The server authenticates with bearer tokens alone; there is no
+	// secret to sign requests with, so say so rather than silently
+	// sending requests without the credentials the user meant to use.
+	if os.Getenv(envSecretKey) != "" {
+		fmt.Fprintf(os.Stderr, "Warning: %s is not used; the server authenticates with the bearer token in %s or %s\n", envSecretKey, envAccessKey, envToken)
+	}
+	return nil
+}
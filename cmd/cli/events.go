@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// objectEvent is an object change sent by the server's event stream.
+type objectEvent struct {
+	ID         string    `json:"id"`
+	Type       string    `json:"type"`
+	Time       time.Time `json:"time"`
+	Bucket     string    `json:"bucket"`
+	Key        string    `json:"key"`
+	Size       int64     `json:"size,omitempty"`
+	ETag       string    `json:"etag,omitempty"`
+	Generation int64     `json:"generation,omitempty"`
+}
+
+// events prints the object changes in a bucket as they happen, until
+// interrupted. With --output json each event is one line of JSON, and with
+// csv one row, so the output can be piped while it streams.
+func (c *CLI) events(args []string) error {
+	fs := flag.NewFlagSet("events", flag.ContinueOnError)
+	prefix := fs.String("prefix", "", "Only show events for keys under a prefix")
+	suffix := fs.String("suffix", "", "Only show events for keys ending in a suffix, such as .jpg")
+	types := fs.String("type", "", "Only show these event types: put, delete, copy (comma-separated)")
+	args, err := parseCommandFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if len(args) != 1 {
+		return fmt.Errorf("usage: storage-cli events [--prefix P] [--suffix S] [--type put,delete,copy] <bucket>[/prefix]")
+	}
+	bucketName, pathPrefix, _ := strings.Cut(args[0], "/")
+	if pathPrefix != "" {
+		if *prefix != "" {
+			return fmt.Errorf("give the prefix either in the path or with --prefix, not both")
+		}
+		*prefix = pathPrefix
+	}
+
+	query := neturl.Values{"events": {""}}
+	if *prefix != "" {
+		query.Set("prefix", *prefix)
+	}
+	if *suffix != "" {
+		query.Set("suffix", *suffix)
+	}
+	if *types != "" {
+		query.Set("types", *types)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/buckets/%s?%s", c.config.ServerUrl, bucketName, query.Encode()), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	// The stream stays open as long as the command runs, so it must not be
+	// cut off by --timeout.
+	client := *c.client
+	client.Timeout = 0
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to events: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to subscribe to events: %s", responseError(resp))
+	}
+	if c.config.Verbose {
+		fmt.Fprintf(os.Stderr, "Watching '%s' for object changes; press Ctrl-C to stop.\n", args[0])
+	}
+
+	print := c.eventPrinter()
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		// Events arrive as "event:", "id:" and "data:" lines; the data line
+		// holds the whole event, so the others are not needed.
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+		var event objectEvent
+		if err := json.Unmarshal([]byte(strings.TrimSpace(data)), &event); err != nil {
+			return fmt.Errorf("failed to decode event: %w", err)
+		}
+		if err := print(event); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("event stream failed: %w", err)
+	}
+	return fmt.Errorf("the server closed the event stream")
+}
+
+// eventPrinter returns a function that prints one event in the --output
+// format and flushes it, so each event shows up as soon as it arrives.
+func (c *CLI) eventPrinter() func(objectEvent) error {
+	switch c.config.Output {
+	case outputJSON:
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetEscapeHTML(false)
+		return func(event objectEvent) error {
+			return encoder.Encode(event)
+		}
+	case outputCSV:
+		w := csv.NewWriter(os.Stdout)
+		columns := csvColumns(reflect.TypeFor[objectEvent](), "")
+		header := make([]string, len(columns))
+		for i, column := range columns {
+			header[i] = column.name
+		}
+		w.Write(header)
+		w.Flush()
+		return func(event objectEvent) error {
+			row := make([]string, len(columns))
+			for i, column := range columns {
+				row[i] = csvValue(reflect.ValueOf(event), column.index)
+			}
+			w.Write(row)
+			w.Flush()
+			return w.Error()
+		}
+	default:
+		return func(event objectEvent) error {
+			line := fmt.Sprintf("%s  %-6s  %s/%s", event.Time.Local().Format("2006-01-02 15:04:05"), event.Type, event.Bucket, event.Key)
+			if event.Type != "delete" {
+				line += fmt.Sprintf(" (%s)", formatSize(event.Size))
+			}
+			_, err := fmt.Println(line)
+			return err
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// findFilter holds the attribute tests of find. Zero values test nothing.
+type findFilter struct {
+	largerThan, smallerThan int64
+	olderThan, newerThan    time.Duration
+}
+
+func (f findFilter) matches(object ObjectInfo, now time.Time) bool {
+	age := now.Sub(object.LastModified)
+	switch {
+	case f.largerThan > 0 && object.Size <= f.largerThan:
+		return false
+	case f.smallerThan > 0 && object.Size >= f.smallerThan:
+		return false
+	case f.olderThan > 0 && age <= f.olderThan:
+		return false
+	case f.newerThan > 0 && age >= f.newerThan:
+		return false
+	}
+	return true
+}
+
+// find lists the objects under bucket/prefix that pass every given test,
+// or deletes them. The name pattern is matched by the server.
+func (c *CLI) find(args []string) error {
+	fs := flag.NewFlagSet("find", flag.ContinueOnError)
+	name := fs.String("name", "", "Only objects whose name matches a glob such as '*.csv'")
+	largerThan := fs.String("larger-than", "", "Only objects larger than a size such as 10MB")
+	smallerThan := fs.String("smaller-than", "", "Only objects smaller than a size such as 1KB")
+	olderThan := fs.String("older-than", "", "Only objects last modified longer ago than a duration such as 30d")
+	newerThan := fs.String("newer-than", "", "Only objects last modified within a duration such as 12h")
+	fs.Bool("print", true, "Print the matching objects (the default)")
+	del := fs.Bool("delete", false, "Delete the matching objects")
+	yes := fs.Bool("yes", false, "Do not ask for confirmation before deleting")
+	parallel := fs.Int("parallel", 4, "Number of concurrent deletes")
+	args, err := parseCommandFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return fmt.Errorf("usage: storage-cli find <bucket>[/prefix] [--name GLOB] [--larger-than SIZE] [--smaller-than SIZE] [--older-than AGE] [--newer-than AGE] [--print | --delete [--yes]]")
+	}
+
+	var filter findFilter
+	for _, size := range []struct {
+		flag, value string
+		dest        *int64
+	}{{"larger-than", *largerThan, &filter.largerThan}, {"smaller-than", *smallerThan, &filter.smallerThan}} {
+		if size.value == "" {
+			continue
+		}
+		if *size.dest, err = parseSize(size.value); err != nil {
+			return fmt.Errorf("--%s: %w", size.flag, err)
+		}
+	}
+	for _, age := range []struct {
+		flag, value string
+		dest        *time.Duration
+	}{{"older-than", *olderThan, &filter.olderThan}, {"newer-than", *newerThan, &filter.newerThan}} {
+		if age.value == "" {
+			continue
+		}
+		if *age.dest, err = parseAge(age.value); err != nil {
+			return fmt.Errorf("--%s: %w", age.flag, err)
+		}
+	}
+
+	bucketName, prefix, _ := strings.Cut(args[0], "/")
+	objects, err := c.fetchObjects(bucketName, prefix, *name)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var found []ObjectInfo
+	for _, object := range objects {
+		if filter.matches(object, now) {
+			found = append(found, object)
+		}
+	}
+
+	if !*del {
+		return c.render(found, func() error {
+			for _, object := range found {
+				fmt.Printf("%s/%s\n", bucketName, object.Key)
+			}
+			return nil
+		})
+	}
+
+	if len(found) == 0 {
+		fmt.Println("No matching objects.")
+		return nil
+	}
+	if !*yes {
+		for _, object := range found {
+			fmt.Printf("  %s/%s\n", bucketName, object.Key)
+		}
+		fmt.Printf("Delete %d object(s)? [y/N] ", len(found))
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if answer = strings.ToLower(strings.TrimSpace(answer)); answer != "y" && answer != "yes" {
+			return fmt.Errorf("aborted; nothing was deleted")
+		}
+	}
+
+	report := newBatchReport(os.Stdout)
+	runParallel(*parallel, found, func(object ObjectInfo) {
+		name := bucketName + "/" + object.Key
+		if err := c.deleteObject(bucketName, object.Key); err != nil {
+			report.Failure(name, err)
+			return
+		}
+		report.Success(name, object.Size, "deleted")
+	})
+	report.Summary()
+	return report.Err()
+}
+
+// parseSize reads a size in bytes with an optional K, M, G or T suffix
+// (also KB, MB, ... and KiB, MiB, ...), all powers of 1024 like formatSize.
+func parseSize(s string) (int64, error) {
+	value := strings.ToUpper(strings.TrimSpace(s))
+	value = strings.TrimSuffix(strings.TrimSuffix(value, "B"), "I")
+	shift := 0
+	if n := len(value); n > 0 {
+		if i := strings.IndexByte("KMGT", value[n-1]); i >= 0 {
+			shift = 10 * (i + 1)
+			value = value[:n-1]
+		}
+	}
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q; use bytes or a suffix such as 10MB", s)
+	}
+	return int64(n * float64(int64(1)<<shift)), nil
+}
+
+// parseAge reads a duration, which may also be given in days such as 30d.
+func parseAge(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.ParseFloat(days, 64)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid age %q; use a duration such as 30d or 12h", s)
+		}
+		return time.Duration(n * float64(24*time.Hour)), nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid age %q; use a duration such as 30d or 12h", s)
+	}
+	return d, nil
+}
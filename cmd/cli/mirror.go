@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"strings"
+)
+
+// mirrorHeaders are the object headers carried over from the source server.
+var mirrorHeaders = []string{"Content-Type", "Cache-Control", "Expires", "X-Object-Tagging"}
+
+// mirrorEndpoint is a bucket, and optionally a prefix in it, on a server
+// given as http://host:port/bucket/prefix.
+type mirrorEndpoint struct {
+	Server string
+	Bucket string
+	Prefix string
+}
+
+func parseMirrorEndpoint(arg string) (mirrorEndpoint, error) {
+	if !strings.Contains(arg, "://") {
+		arg = "http://" + arg
+	}
+	u, err := neturl.Parse(arg)
+	if err != nil || u.Host == "" {
+		return mirrorEndpoint{}, fmt.Errorf("'%s' must be in format: http://server:port/bucket[/prefix]", arg)
+	}
+
+	bucketName, prefix := splitSyncRemote(strings.TrimPrefix(u.Path, "/"))
+	if bucketName == "" {
+		return mirrorEndpoint{}, fmt.Errorf("'%s' must name a bucket: http://server:port/bucket[/prefix]", arg)
+	}
+	return mirrorEndpoint{Server: u.Scheme + "://" + u.Host, Bucket: bucketName, Prefix: prefix}, nil
+}
+
+func (e mirrorEndpoint) String() string {
+	return e.Server + "/" + e.Bucket + "/" + e.Prefix
+}
+
+// mirror copies the objects of a bucket on one server to a bucket on
+// another, for migrations. Objects the destination already has with the
+// same ETag and size are skipped, so an interrupted mirror can be rerun.
+func (c *CLI) mirror(args []string) error {
+	fs := flag.NewFlagSet("mirror", flag.ContinueOnError)
+	parallel := fs.Int("parallel", 4, "Number of concurrent copies")
+	match := fs.String("match", "", "Only copy objects whose name matches a glob such as '*.log'")
+	dryRun := fs.Bool("dry-run", false, "Show what would be copied without doing it")
+	srcToken := fs.String("src-token", c.config.Token, "Access token for the source server")
+	dstToken := fs.String("dst-token", c.config.Token, "Access token for the destination server")
+	args, err := parseCommandFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if len(args) != 2 {
+		return fmt.Errorf("usage: storage-cli mirror [--match GLOB] [--parallel N] [--dry-run] [--src-token T] [--dst-token T] <src-server/bucket[/prefix]> <dst-server/bucket[/prefix]>")
+	}
+	src, err := parseMirrorEndpoint(args[0])
+	if err != nil {
+		return err
+	}
+	dst, err := parseMirrorEndpoint(args[1])
+	if err != nil {
+		return err
+	}
+	if src.String() == dst.String() {
+		return fmt.Errorf("source and destination are the same")
+	}
+
+	srcCLI := c.withServer(src.Server, *srcToken)
+	dstCLI := c.withServer(dst.Server, *dstToken)
+
+	objects, err := srcCLI.fetchObjects(src.Bucket, src.Prefix, *match)
+	if err != nil {
+		return fmt.Errorf("source: %w", err)
+	}
+	if len(objects) == 0 {
+		fmt.Printf("No objects found in '%s'.\n", src)
+		return nil
+	}
+
+	existing := map[string]ObjectInfo{}
+	if exists, err := dstCLI.bucketExists(dst.Bucket); err != nil {
+		return fmt.Errorf("destination: %w", err)
+	} else if exists {
+		if existing, err = dstCLI.remoteObjects(dst.Bucket, dst.Prefix); err != nil {
+			return fmt.Errorf("destination: %w", err)
+		}
+	} else if !*dryRun {
+		if err := dstCLI.createBucket(dst.Bucket); err != nil {
+			return fmt.Errorf("destination: %w", err)
+		}
+	}
+
+	report := newBatchReport(os.Stdout)
+	runParallel(*parallel, objects, func(object ObjectInfo) {
+		dstKey := dst.Prefix + strings.TrimPrefix(object.Key, src.Prefix)
+		name := src.Bucket + "/" + object.Key
+		if current, ok := existing[dstKey]; ok && current.ETag == object.ETag && current.Size == object.Size {
+			report.Skipped(name, "unchanged")
+			return
+		}
+		if *dryRun {
+			report.Skipped(name, fmt.Sprintf("dry run: would copy to '%s/%s'", dst.Bucket, dstKey))
+			return
+		}
+
+		size, err := srcCLI.mirrorObject(dstCLI, src.Bucket, object.Key, dst.Bucket, dstKey)
+		if err != nil {
+			report.Failure(name, err)
+			return
+		}
+		report.Success(name, size, fmt.Sprintf("copied to '%s/%s/%s'", dst.Server, dst.Bucket, dstKey))
+	})
+
+	report.Summary()
+	return report.Err()
+}
+
+// withServer returns a CLI for another server, sharing this one's options
+// and transfer log.
+func (c *CLI) withServer(serverURL, token string) *CLI {
+	config := *c.config
+	config.ServerUrl = serverURL
+	config.Token = token
+	other := NewCLI(&config)
+	other.transfers = c.transfers
+	return other
+}
+
+// bucketExists checks for a bucket with HEAD /buckets/{bucket}.
+func (c *CLI) bucketExists(bucketName string) (bool, error) {
+	resp, err := c.client.Head(fmt.Sprintf("%s/buckets/%s", c.config.ServerUrl, bucketName))
+	if err != nil {
+		return false, fmt.Errorf("failed to check bucket: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("failed to check bucket: %s", resp.Status)
+	}
+}
+
+func (c *CLI) createBucket(bucketName string) error {
+	req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("%s/buckets/%s", c.config.ServerUrl, bucketName), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to create bucket: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("failed to create bucket: %s", responseError(resp))
+	}
+	return nil
+}
+
+// mirrorObject streams an object from this server to dst. The source ETag
+// is sent as Content-MD5, so the destination rejects a copy that was
+// corrupted on the way.
+func (c *CLI) mirrorObject(dst *CLI, srcBucket, srcKey, dstBucket, dstKey string) (size int64, err error) {
+	source := fmt.Sprintf("%s/objects/%s/%s", c.config.ServerUrl, srcBucket, srcKey)
+	destination := fmt.Sprintf("%s/objects/%s/%s", dst.config.ServerUrl, dstBucket, dstKey)
+	defer func() {
+		c.transfers.Record(transferUploaded, source, destination, size, err)
+	}()
+
+	resp, err := c.client.Get(source)
+	if err != nil {
+		return 0, fmt.Errorf("failed to download object: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("failed to download object: %s", responseError(resp))
+	}
+
+	body := &countingReader{r: resp.Body}
+	req, err := http.NewRequest(http.MethodPut, destination, body)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.ContentLength = resp.ContentLength
+	for _, header := range mirrorHeaders {
+		if value := resp.Header.Get(header); value != "" {
+			req.Header.Set(header, value)
+		}
+	}
+	if digest, err := hex.DecodeString(strings.Trim(resp.Header.Get("ETag"), `"`)); err == nil && len(digest) == 16 {
+		req.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(digest))
+	}
+
+	put, err := dst.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to upload object: %w", err)
+	}
+	defer put.Body.Close()
+
+	if put.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("failed to upload object: %s", responseError(put))
+	}
+	return body.n, nil
+}
+
+// countingReader counts the bytes read through it. Responses the server
+// compressed have no Content-Length once decompressed, so this is how many
+// bytes an object really had.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
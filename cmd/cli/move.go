@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// isLocalPath reports whether a mv argument names a local file: it exists,
+// is written as a local path, or has no bucket/key form.
+func isLocalPath(p string) bool {
+	if strings.HasPrefix(p, "/") || strings.HasPrefix(p, "./") || strings.HasPrefix(p, "../") || !strings.Contains(p, "/") {
+		return true
+	}
+	_, err := os.Stat(p)
+	return err == nil
+}
+
+// objectETag returns the ETag of an object, or an error if it does not
+// exist.
+func (c *CLI) objectETag(bucketName, objectKey string) (string, error) {
+	resp, err := c.client.Head(fmt.Sprintf("%s/objects/%s/%s", c.config.ServerUrl, bucketName, objectKey))
+	if err != nil {
+		return "", fmt.Errorf("failed to check object: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to check object: %s", resp.Status)
+	}
+	return strings.Trim(resp.Header.Get("ETag"), `"`), nil
+}
+
+// moveUpload uploads a local file and deletes it once the object's ETag
+// matches the file's MD5.
+func (c *CLI) moveUpload(localPath, remotePath string) error {
+	bucketName, objectKey, ok := strings.Cut(remotePath, "/")
+	if !ok || objectKey == "" {
+		return fmt.Errorf("remote path must be in format: bucket/object")
+	}
+	if strings.HasSuffix(objectKey, "/") {
+		objectKey += filepath.Base(localPath)
+	}
+
+	sum, err := fileMD5(localPath)
+	if err != nil {
+		return fmt.Errorf("local file not found: %w", err)
+	}
+	if _, err := c.putFile(localPath, bucketName, objectKey, copyOptions{PartSize: defaultPartSize}); err != nil {
+		return err
+	}
+	if etag, err := c.objectETag(bucketName, objectKey); err != nil {
+		return fmt.Errorf("uploaded, but %w; '%s' was kept", err, localPath)
+	} else if etag != sum {
+		return fmt.Errorf("'%s/%s' has ETag %s, not the file's MD5 %s; '%s' was kept", bucketName, objectKey, etag, sum, localPath)
+	}
+
+	err = os.Remove(localPath)
+	c.transfers.Record(transferDeleted, localPath, "", 0, err)
+	if err != nil {
+		return fmt.Errorf("uploaded, but failed to delete the local file: %w", err)
+	}
+	fmt.Printf("Moved '%s' to '%s/%s'.\n", localPath, bucketName, objectKey)
+	return nil
+}
+
+// moveDownload downloads an object and deletes it once the file's MD5
+// matches the object's ETag.
+func (c *CLI) moveDownload(remotePath, localPath string) error {
+	bucketName, objectKey, ok := strings.Cut(remotePath, "/")
+	if !ok || objectKey == "" {
+		return fmt.Errorf("remote path must be in format: bucket/object")
+	}
+	if info, err := os.Stat(localPath); err == nil && info.IsDir() {
+		localPath = filepath.Join(localPath, path.Base(objectKey))
+	}
+
+	etag, err := c.objectETag(bucketName, objectKey)
+	if err != nil {
+		return err
+	}
+	if _, err := c.getFile(bucketName, objectKey, localPath); err != nil {
+		return err
+	}
+	if sum, err := fileMD5(localPath); err != nil || sum != etag {
+		return fmt.Errorf("'%s' does not match the object's ETag %s; '%s' was kept", localPath, etag, remotePath)
+	}
+
+	if err := c.deleteObject(bucketName, objectKey); err != nil {
+		return fmt.Errorf("downloaded, but %w", err)
+	}
+	fmt.Printf("Moved '%s' to '%s'.\n", remotePath, localPath)
+	return nil
+}
+
+// moveBetweenBuckets copies an object to another bucket on the server,
+// by reference to its content when the server can, and deletes the source
+// once the copy has the same ETag.
+func (c *CLI) moveBetweenBuckets(srcBucket, srcKey, dstBucket, dstKey string) error {
+	srcURL := fmt.Sprintf("%s/objects/%s/%s", c.config.ServerUrl, srcBucket, srcKey)
+	resp, err := c.client.Head(srcURL)
+	if err != nil {
+		return fmt.Errorf("failed to check object: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to check object: %s", resp.Status)
+	}
+	etag := strings.Trim(resp.Header.Get("ETag"), `"`)
+
+	copied := false
+	if sha := resp.Header.Get("X-Checksum-Sha256"); sha != "" {
+		headers := http.Header{}
+		for _, header := range mirrorHeaders {
+			if value := resp.Header.Get(header); value != "" {
+				headers.Set(header, value)
+			}
+		}
+		headers.Set("X-Checksum-Algorithm", "sha256")
+		headers.Set("X-Checksum-Sha256", sha)
+		if digest, err := hex.DecodeString(etag); err == nil && len(digest) == 16 {
+			headers.Set("Content-MD5", base64.StdEncoding.EncodeToString(digest))
+		}
+		dstURL := fmt.Sprintf("%s/objects/%s/%s", c.config.ServerUrl, dstBucket, dstKey)
+		if copied, err = c.putByReference(dstURL, headers); err != nil {
+			return err
+		}
+		if copied {
+			c.transfers.Record(transferUploaded, srcURL, dstURL, 0, nil)
+		}
+	}
+	if !copied {
+		if _, err := c.mirrorObject(c, srcBucket, srcKey, dstBucket, dstKey); err != nil {
+			return err
+		}
+	}
+
+	if dstETag, err := c.objectETag(dstBucket, dstKey); err != nil {
+		return fmt.Errorf("copied, but %w; the source was kept", err)
+	} else if dstETag != etag {
+		return fmt.Errorf("'%s/%s' has ETag %s, not %s; the source was kept", dstBucket, dstKey, dstETag, etag)
+	}
+
+	if err := c.deleteObject(srcBucket, srcKey); err != nil {
+		return fmt.Errorf("copied, but %w", err)
+	}
+	fmt.Printf("Moved '%s/%s' to '%s/%s'.\n", srcBucket, srcKey, dstBucket, dstKey)
+	return nil
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// defaultPartSize is the part size of multipart uploads. Files larger than
+// one part are uploaded in parts.
+const defaultPartSize = 16 << 20
+
+// uploadStateSuffix names the file next to a local file that records its
+// multipart upload in progress, so cp --resume can continue it.
+const uploadStateSuffix = ".upload"
+
+// uploadState identifies an interrupted multipart upload and the version of
+// the local file it was uploading.
+type uploadState struct {
+	Server   string    `json:"server"`
+	Bucket   string    `json:"bucket"`
+	Key      string    `json:"key"`
+	UploadID string    `json:"upload_id"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"mod_time"`
+	PartSize int64     `json:"part_size"`
+}
+
+func (s uploadState) matches(other uploadState) bool {
+	return s.Server == other.Server && s.Bucket == other.Bucket && s.Key == other.Key &&
+		s.UploadID == other.UploadID && s.Size == other.Size && s.ModTime.Equal(other.ModTime) &&
+		s.PartSize == other.PartSize
+}
+
+type uploadPart struct {
+	PartNumber int    `json:"part_number"`
+	ETag       string `json:"etag"`
+	Size       int64  `json:"size,omitempty"`
+}
+
+func readUploadState(path string) (*uploadState, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var state uploadState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+func writeUploadState(path string, state *uploadState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// errNoSuchUpload is returned when the server no longer knows an upload,
+// because it was completed, aborted or expired.
+var errNoSuchUpload = errors.New("upload no longer exists on the server")
+
+// putMultipart uploads file in parts, opts.PartParallel at a time. The
+// upload ID is saved next to the file until the upload completes; with
+// resume, an upload saved for the same server, object and file version is
+// continued, skipping the parts the server already has. headers carry the
+// whole-file checksums, which the server checks against the assembled
+// object.
+func (c *CLI) putMultipart(file *os.File, info os.FileInfo, bucketName, objectKey string, headers http.Header, opts copyOptions) error {
+	objectURL := fmt.Sprintf("%s/objects/%s/%s", c.config.ServerUrl, bucketName, objectKey)
+	statePath := file.Name() + uploadStateSuffix
+
+	want := uploadState{
+		Server:   c.config.ServerUrl,
+		Bucket:   bucketName,
+		Key:      objectKey,
+		Size:     info.Size(),
+		ModTime:  info.ModTime().UTC(),
+		PartSize: opts.PartSize,
+	}
+
+	done := map[int]int64{}
+	state, err := readUploadState(statePath)
+	if err == nil && opts.Resume {
+		want.UploadID = state.UploadID
+		if !state.matches(want) {
+			return fmt.Errorf("%s belongs to a different upload or the file has changed; remove it to start over", statePath)
+		}
+		parts, err := c.listUploadParts(objectURL, state.UploadID)
+		if errors.Is(err, errNoSuchUpload) {
+			fmt.Printf("Upload %s expired on the server; starting over.\n", state.UploadID)
+			state = nil
+		} else if err != nil {
+			return err
+		} else {
+			for _, part := range parts {
+				done[part.PartNumber] = part.Size
+			}
+			fmt.Printf("Resuming upload %s: %d part(s) already uploaded.\n", state.UploadID, len(parts))
+		}
+	} else if err == nil {
+		// A new cp replaces an interrupted upload instead of resuming it.
+		c.abortUpload(objectURL, state.UploadID)
+		state = nil
+	} else if opts.Resume {
+		fmt.Printf("No interrupted upload of '%s' found; starting a new one.\n", file.Name())
+	}
+
+	if state == nil {
+		uploadID, err := c.createUpload(objectURL, headers.Get("Content-Type"))
+		if err != nil {
+			return err
+		}
+		want.UploadID = uploadID
+		state = &want
+		if err := writeUploadState(statePath, state); err != nil {
+			return fmt.Errorf("failed to save upload state: %w", err)
+		}
+	}
+
+	partCount := int((info.Size() + opts.PartSize - 1) / opts.PartSize)
+	parts := make([]uploadPart, partCount)
+	var pending []int
+	for n := 1; n <= partCount; n++ {
+		offset := int64(n-1) * opts.PartSize
+		if done[n] == min(opts.PartSize, info.Size()-offset) {
+			parts[n-1] = uploadPart{PartNumber: n}
+			continue
+		}
+		pending = append(pending, n)
+	}
+
+	// Parts are uploaded concurrently; after the first failure the
+	// remaining ones are not started, and --resume picks up from there.
+	var (
+		mu      sync.Mutex
+		failure error
+	)
+	runParallel(opts.PartParallel, pending, func(n int) {
+		mu.Lock()
+		failed := failure != nil
+		mu.Unlock()
+		if failed {
+			return
+		}
+
+		offset := int64(n-1) * opts.PartSize
+		size := min(opts.PartSize, info.Size()-offset)
+		part, err := c.uploadPart(objectURL, state.UploadID, n, io.NewSectionReader(file, offset, size), size)
+
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			if failure == nil {
+				failure = err
+			}
+			return
+		}
+		parts[n-1] = *part
+		if c.config.Verbose {
+			fmt.Printf("Uploaded part %d/%d (%s)\n", n, partCount, formatSize(size))
+		}
+	})
+	if failure != nil {
+		return fmt.Errorf("%w\nRun the same command with --resume to continue the upload", failure)
+	}
+
+	if err := c.completeUpload(objectURL, state.UploadID, parts, headers); errors.Is(err, errPreconditionFailed) {
+		// The upload can never complete, so it is not kept for --resume.
+		c.abortUpload(objectURL, state.UploadID)
+		os.Remove(statePath)
+		return err
+	} else if err != nil {
+		return err
+	}
+	os.Remove(statePath)
+	return nil
+}
+
+func (c *CLI) createUpload(objectURL, contentType string) (string, error) {
+	resp, err := c.client.Post(objectURL+"?uploads", contentType, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to start upload: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return "", fmt.Errorf("failed to start upload: %s", responseError(resp))
+	}
+
+	var upload struct {
+		UploadID string `json:"upload_id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&upload); err != nil {
+		return "", fmt.Errorf("failed to decode response: %w", err)
+	}
+	return upload.UploadID, nil
+}
+
+func (c *CLI) listUploadParts(objectURL, uploadID string) ([]uploadPart, error) {
+	resp, err := c.client.Get(objectURL + "?upload-id=" + uploadID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list uploaded parts: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errNoSuchUpload
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to list uploaded parts: %s", responseError(resp))
+	}
+
+	var upload struct {
+		Parts []uploadPart `json:"parts"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&upload); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return upload.Parts, nil
+}
+
+func (c *CLI) uploadPart(objectURL, uploadID string, partNumber int, data io.ReaderAt, size int64) (*uploadPart, error) {
+	url := fmt.Sprintf("%s?upload-id=%s&part-number=%d", objectURL, uploadID, partNumber)
+	req, err := http.NewRequest(http.MethodPut, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	replayable(req, data, size)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload part %d: %w", partNumber, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to upload part %d: %s", partNumber, responseError(resp))
+	}
+
+	var part uploadPart
+	if err := json.NewDecoder(resp.Body).Decode(&part); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return &part, nil
+}
+
+func (c *CLI) completeUpload(objectURL, uploadID string, parts []uploadPart, headers http.Header) error {
+	body, err := json.Marshal(map[string][]uploadPart{"parts": parts})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, objectURL+"?upload-id="+uploadID, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header = headers.Clone()
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to complete upload: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusPreconditionFailed {
+		return errPreconditionFailed
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to complete upload: %s", responseError(resp))
+	}
+	return nil
+}
+
+// abortUpload discards an upload on the server. Failures are ignored: the
+// server's janitor removes abandoned uploads eventually.
+func (c *CLI) abortUpload(objectURL, uploadID string) {
+	req, err := http.NewRequest(http.MethodDelete, objectURL+"?upload-id="+uploadID, nil)
+	if err != nil {
+		return
+	}
+	if resp, err := c.client.Do(req); err == nil {
+		resp.Body.Close()
+	}
+}
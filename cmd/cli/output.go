@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Output formats selected with the global --output flag.
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputCSV   = "csv"
+)
+
+func parseOutputFormat(format string) (string, error) {
+	switch format {
+	case "", outputTable:
+		return outputTable, nil
+	case outputJSON, outputCSV:
+		return format, nil
+	}
+	return "", fmt.Errorf("--output must be table, json or csv")
+}
+
+// render prints the result of a command in the --output format. records is
+// a struct or a slice of structs; JSON and CSV name their fields after its
+// json tags, so scripts can rely on them. The table format is the
+// command's own text, printed by table.
+func (c *CLI) render(records any, table func() error) error {
+	switch c.config.Output {
+	case outputJSON:
+		if v := reflect.ValueOf(records); v.Kind() == reflect.Slice && v.IsNil() {
+			records = reflect.MakeSlice(v.Type(), 0, 0).Interface()
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.SetEscapeHTML(false)
+		return encoder.Encode(records)
+	case outputCSV:
+		return writeCSV(os.Stdout, records)
+	default:
+		return table()
+	}
+}
+
+// writeCSV writes a header of field names and a row per record. Nested
+// structs become columns named parent.field, and embedded structs add
+// their fields unprefixed, the way encoding/json does.
+func writeCSV(out io.Writer, records any) error {
+	v := reflect.ValueOf(records)
+	if v.Kind() != reflect.Slice {
+		s := reflect.MakeSlice(reflect.SliceOf(v.Type()), 1, 1)
+		s.Index(0).Set(v)
+		v = s
+	}
+
+	w := csv.NewWriter(out)
+	columns := csvColumns(v.Type().Elem(), "")
+	header := make([]string, len(columns))
+	for i, column := range columns {
+		header[i] = column.name
+	}
+	w.Write(header)
+
+	for i := 0; i < v.Len(); i++ {
+		row := make([]string, len(columns))
+		for j, column := range columns {
+			row[j] = csvValue(v.Index(i), column.index)
+		}
+		w.Write(row)
+	}
+	w.Flush()
+	return w.Error()
+}
+
+type csvColumn struct {
+	name  string
+	index []int
+}
+
+var timeType = reflect.TypeFor[time.Time]()
+
+func csvColumns(t reflect.Type, prefix string) []csvColumn {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	var columns []csvColumn
+	for _, field := range reflect.VisibleFields(t) {
+		if !field.IsExported() || len(field.Index) > 1 {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Pointer {
+			fieldType = fieldType.Elem()
+		}
+		nested := fieldType.Kind() == reflect.Struct && fieldType != timeType
+
+		if field.Anonymous && name == "" && nested {
+			for _, column := range csvColumns(fieldType, prefix) {
+				columns = append(columns, csvColumn{column.name, append([]int{field.Index[0]}, column.index...)})
+			}
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		if nested {
+			for _, column := range csvColumns(fieldType, prefix+name+".") {
+				columns = append(columns, csvColumn{column.name, append([]int{field.Index[0]}, column.index...)})
+			}
+			continue
+		}
+		columns = append(columns, csvColumn{prefix + name, field.Index})
+	}
+	return columns
+}
+
+// csvValue formats the field at index, or returns "" when a nil pointer is
+// on the way to it. Times are RFC 3339, and a zero time is empty.
+func csvValue(v reflect.Value, index []int) string {
+	for _, i := range index {
+		if v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				return ""
+			}
+			v = v.Elem()
+		}
+		v = v.Field(i)
+	}
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+
+	switch value := v.Interface().(type) {
+	case time.Time:
+		if value.IsZero() {
+			return ""
+		}
+		return value.Format(time.RFC3339)
+	case string:
+		return value
+	case bool:
+		return strconv.FormatBool(value)
+	}
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map:
+		data, _ := json.Marshal(v.Interface())
+		return string(data)
+	}
+	return fmt.Sprint(v.Interface())
+}
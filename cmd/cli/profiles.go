@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"storage-system/internal/yaml"
+)
+
+// profilesFile is where named server profiles are kept, relative to the
+// home directory.
+const profilesFile = ".storage-cli/config.yaml"
+
+// profile is a named server and the credentials to use with it. The server
+// authenticates with bearer tokens, so a token is the profile's credential.
+type profile struct {
+	Server     string `json:"server"`
+	Token      string `json:"token"`
+	AdminToken string `json:"admin_token"`
+}
+
+// profileConfig is the contents of the profiles file:
+//
+//	default: prod
+//	profiles:
+//	  prod:
+//	    server: "https://storage.example.com"
+//	    token: "ci-token"
+//	    admin_token: "admin-token"
+//
+// It is read with the YAML subset of internal/yaml, so values that would
+// read as numbers or booleans must be quoted.
+type profileConfig struct {
+	Default  string             `json:"default"`
+	Profiles map[string]profile `json:"profiles"`
+}
+
+var profileNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+func profilesPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find the profiles file: %w", err)
+	}
+	return filepath.Join(home, profilesFile), nil
+}
+
+// loadProfiles reads the profiles file; a missing file has no profiles.
+func loadProfiles(path string) (*profileConfig, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &profileConfig{Profiles: map[string]profile{}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	config, err := parseProfiles(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return config, nil
+}
+
+func parseProfiles(data []byte) (*profileConfig, error) {
+	data, err := yaml.ToJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	config := &profileConfig{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(config); err != nil {
+		return nil, err
+	}
+	if config.Profiles == nil {
+		config.Profiles = map[string]profile{}
+	}
+	return config, nil
+}
+
+// marshal writes the profiles in the format parseProfiles reads, with
+// every value double-quoted.
+func (c *profileConfig) marshal() []byte {
+	var b bytes.Buffer
+	b.WriteString("# Server profiles for storage-cli; see 'storage-cli alias'.\n")
+	if c.Default != "" {
+		fmt.Fprintf(&b, "default: %s\n", strconv.Quote(c.Default))
+	}
+	b.WriteString("profiles:\n")
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		p := c.Profiles[name]
+		fmt.Fprintf(&b, "  %s:\n", name)
+		fmt.Fprintf(&b, "    server: %s\n", strconv.Quote(p.Server))
+		if p.Token != "" {
+			fmt.Fprintf(&b, "    token: %s\n", strconv.Quote(p.Token))
+		}
+		if p.AdminToken != "" {
+			fmt.Fprintf(&b, "    admin_token: %s\n", strconv.Quote(p.AdminToken))
+		}
+	}
+	return b.Bytes()
+}
+
+// save writes the profiles readable only by the user, since they hold
+// tokens.
+func (c *profileConfig) save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, c.marshal(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// alias manages the server profiles used with --profile.
+func (c *CLI) alias(args []string) error {
+	const usage = "usage: storage-cli alias set <name> <server-url> [--token T] [--admin-token T] [--default] | alias ls | alias rm <name>"
+	if len(args) == 0 {
+		return fmt.Errorf(usage)
+	}
+	path, err := profilesPath()
+	if err != nil {
+		return err
+	}
+	profiles, err := loadProfiles(path)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "set":
+		fs := flag.NewFlagSet("alias set", flag.ContinueOnError)
+		token := fs.String("token", "", "Access token for the server")
+		fs.StringVar(token, "access-key", "", "Same as --token")
+		adminToken := fs.String("admin-token", "", "Token for apply and share")
+		makeDefault := fs.Bool("default", false, "Use this profile when --profile is not given")
+		rest, err := parseCommandFlags(fs, args[1:])
+		if err != nil {
+			return err
+		}
+		if len(rest) != 2 {
+			return fmt.Errorf(usage)
+		}
+		name, server := rest[0], strings.TrimSuffix(rest[1], "/")
+		if !profileNamePattern.MatchString(name) {
+			return fmt.Errorf("profile names may only contain letters, digits, '.', '_' and '-'")
+		}
+		if !strings.HasPrefix(server, "http://") && !strings.HasPrefix(server, "https://") {
+			return fmt.Errorf("server must be an http:// or https:// URL")
+		}
+		profiles.Profiles[name] = profile{Server: server, Token: *token, AdminToken: *adminToken}
+		if *makeDefault || len(profiles.Profiles) == 1 {
+			profiles.Default = name
+		}
+		if err := profiles.save(path); err != nil {
+			return fmt.Errorf("failed to save profiles: %w", err)
+		}
+		fmt.Printf("Profile '%s' saved to %s\n", name, path)
+		return nil
+
+	case "rm":
+		if len(args) != 2 {
+			return fmt.Errorf(usage)
+		}
+		name := args[1]
+		if _, ok := profiles.Profiles[name]; !ok {
+			return fmt.Errorf("profile '%s' not found", name)
+		}
+		delete(profiles.Profiles, name)
+		if profiles.Default == name {
+			profiles.Default = ""
+		}
+		if err := profiles.save(path); err != nil {
+			return fmt.Errorf("failed to save profiles: %w", err)
+		}
+		fmt.Printf("Profile '%s' removed\n", name)
+		return nil
+
+	case "ls":
+		type aliasRow struct {
+			Name     string `json:"name"`
+			Server   string `json:"server"`
+			HasToken bool   `json:"has_token"`
+			Default  bool   `json:"default"`
+		}
+		rows := []aliasRow{}
+		for name, p := range profiles.Profiles {
+			rows = append(rows, aliasRow{name, p.Server, p.Token != "", name == profiles.Default})
+		}
+		slices.SortFunc(rows, func(a, b aliasRow) int { return strings.Compare(a.Name, b.Name) })
+
+		return c.render(rows, func() error {
+			if len(rows) == 0 {
+				fmt.Println("No profiles; add one with 'storage-cli alias set <name> <server-url>'.")
+				return nil
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "PROFILE\tSERVER\tTOKEN\tDEFAULT")
+			fmt.Fprintln(w, "-------\t------\t-----\t-------")
+			for _, row := range rows {
+				token, isDefault := "-", ""
+				if row.HasToken {
+					token = "set"
+				}
+				if row.Default {
+					isDefault = "*"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", row.Name, row.Server, token, isDefault)
+			}
+			return w.Flush()
+		})
+	}
+	return fmt.Errorf(usage)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// uploadDir uploads every file under localDir to bucket/prefix, keeping the
+// paths relative to localDir as the rest of each key. Directories are
+// walked but symbolic links to directories are not followed.
+func (c *CLI) uploadDir(localDir, remotePrefix string, opts copyOptions) error {
+	bucketName, prefix, _ := strings.Cut(remotePrefix, "/")
+	if bucketName == "" {
+		return fmt.Errorf("destination must be in format: bucket or bucket/prefix/")
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	info, err := os.Stat(localDir)
+	if err != nil {
+		return fmt.Errorf("local directory not found: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("'%s' is not a directory", localDir)
+	}
+
+	files, err := walkUploadFiles(localDir, prefix)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		fmt.Printf("No files found in '%s'.\n", localDir)
+		return nil
+	}
+	return c.uploadBatch(bucketName, files, opts)
+}
+
+// walkUploadFiles lists the files under localDir with the keys they are
+// uploaded to. The state files of interrupted multipart uploads are left
+// out, since they belong to the file next to them.
+func walkUploadFiles(localDir, prefix string) ([]uploadItem, error) {
+	var files []uploadItem
+	err := filepath.WalkDir(localDir, func(localPath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		if entry.Type()&fs.ModeSymlink != 0 {
+			if info, err := os.Stat(localPath); err != nil || info.IsDir() {
+				return nil
+			}
+		} else if !entry.Type().IsRegular() {
+			return nil
+		}
+		if name, ok := strings.CutSuffix(localPath, uploadStateSuffix); ok {
+			if _, err := os.Stat(name); err == nil {
+				return nil
+			}
+		}
+
+		rel, err := filepath.Rel(localDir, localPath)
+		if err != nil {
+			return err
+		}
+		files = append(files, uploadItem{LocalPath: localPath, Key: prefix + filepath.ToSlash(rel)})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read local directory: %w", err)
+	}
+	return files, nil
+}
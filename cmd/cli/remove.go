@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"strings"
+)
+
+// prefixDeleteResult is the response of DELETE /objects/{bucket}?prefix.
+type prefixDeleteResult struct {
+	Deleted      int   `json:"deleted"`
+	DeletedBytes int64 `json:"deleted_bytes"`
+	Failed       []struct {
+		Key   string `json:"key"`
+		Error string `json:"error"`
+	} `json:"failed"`
+}
+
+// removePrefix deletes every object under bucket/prefix with one prefix
+// delete, after listing them and, unless yes is set, asking to confirm.
+func (c *CLI) removePrefix(remotePath string, yes bool) error {
+	bucketName, prefix, _ := strings.Cut(remotePath, "/")
+	if bucketName == "" || prefix == "" {
+		return fmt.Errorf("rm --recursive needs a prefix, such as bucket/logs/; buckets cannot be emptied at once")
+	}
+
+	objects, err := c.fetchObjects(bucketName, prefix, "")
+	if err != nil {
+		return err
+	}
+	if len(objects) == 0 {
+		fmt.Printf("No objects found under '%s'.\n", remotePath)
+		return nil
+	}
+
+	if !yes {
+		var total int64
+		for _, object := range objects {
+			total += object.Size
+		}
+		for i, object := range objects {
+			if i == 10 {
+				fmt.Printf("  ... and %d more\n", len(objects)-i)
+				break
+			}
+			fmt.Printf("  %s/%s\n", bucketName, object.Key)
+		}
+		fmt.Printf("Delete %d object(s) (%s) under '%s'? [y/N] ", len(objects), formatSize(total), remotePath)
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if answer = strings.ToLower(strings.TrimSpace(answer)); answer != "y" && answer != "yes" {
+			return fmt.Errorf("aborted; nothing was deleted")
+		}
+	}
+
+	url := fmt.Sprintf("%s/objects/%s?prefix=%s", c.config.ServerUrl, bucketName, neturl.QueryEscape(prefix))
+	req, err := http.NewRequest(http.MethodDelete, url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to delete objects: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to delete objects: %s", responseError(resp))
+	}
+	var result prefixDeleteResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	failed := make(map[string]string, len(result.Failed))
+	for _, failure := range result.Failed {
+		failed[failure.Key] = failure.Error
+	}
+	for _, object := range objects {
+		name := bucketName + "/" + object.Key
+		if msg, ok := failed[object.Key]; ok {
+			c.transfers.Record(transferDeleted, name, "", 0, fmt.Errorf("%s", msg))
+			fmt.Printf("%s: FAILED: %s\n", name, msg)
+			continue
+		}
+		c.transfers.Record(transferDeleted, name, "", object.Size, nil)
+		fmt.Printf("%s: deleted\n", name)
+	}
+
+	fmt.Printf("\n%d deleted (%s), %d failed\n", result.Deleted, formatSize(result.DeletedBytes), len(result.Failed))
+	if extra := result.Deleted - (len(objects) - len(result.Failed)); extra > 0 {
+		fmt.Printf("%d object(s) written after the listing were also deleted.\n", extra)
+	}
+	if len(result.Failed) > 0 {
+		return fmt.Errorf("%d of %d objects could not be deleted", len(result.Failed), len(result.Failed)+result.Deleted)
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultRetries    = 3
+	defaultRetryDelay = time.Second
+
+	// maxRetryDelay caps the exponential backoff between attempts, and
+	// maxRetryAfter the wait a server can ask for with Retry-After.
+	maxRetryDelay = 30 * time.Second
+	maxRetryAfter = 5 * time.Minute
+)
+
+// retryTransport retries idempotent requests that fail with a connection
+// error, a 5xx gateway or availability error, 408 or 429. Attempts are
+// spaced by exponential backoff from delay with jitter, or by the
+// Retry-After the server sent. A request whose body cannot be read again
+// is sent once.
+type retryTransport struct {
+	next    http.RoundTripper
+	retries int
+	delay   time.Duration
+
+	// log receives a line per retry; it is nil unless --verbose is set.
+	log io.Writer
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !retryable(req) {
+		return t.next.RoundTrip(req)
+	}
+
+	for attempt := 1; ; attempt++ {
+		resp, err := t.next.RoundTrip(req)
+		if attempt > t.retries || !shouldRetry(req, resp, err) {
+			return resp, err
+		}
+
+		wait := t.backoff(attempt)
+		reason := ""
+		if err != nil {
+			reason = err.Error()
+		} else {
+			reason = resp.Status
+			if after, ok := retryAfter(resp.Header.Get("Retry-After")); ok {
+				wait = min(after, maxRetryAfter)
+			}
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		}
+		if t.log != nil {
+			fmt.Fprintf(t.log, "%s %s: %s; retry %d of %d in %s\n", req.Method, req.URL.Redacted(), reason, attempt, t.retries, wait.Round(time.Millisecond))
+		}
+
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(wait):
+		}
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
+
+// retryable reports whether a request can be sent again: its method is
+// idempotent and its body, if any, can be read again.
+func retryable(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete, http.MethodOptions:
+	default:
+		return false
+	}
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+func shouldRetry(req *http.Request, resp *http.Response, err error) bool {
+	if err != nil {
+		return req.Context().Err() == nil && !errors.Is(err, context.Canceled)
+	}
+	switch resp.StatusCode {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusInternalServerError,
+		http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// backoff is the wait before retry n: delay doubled for each earlier
+// retry, up to maxRetryDelay, with up to half of it taken off at random
+// so parallel transfers do not retry in step.
+func (t *retryTransport) backoff(n int) time.Duration {
+	if t.delay <= 0 {
+		return 0
+	}
+	wait := maxRetryDelay
+	if n <= 16 {
+		wait = min(t.delay<<(n-1), maxRetryDelay)
+	}
+	return wait - rand.N(wait/2+1)
+}
+
+// retryAfter parses a Retry-After header, which is either a number of
+// seconds or an HTTP date.
+func retryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if when, err := http.ParseTime(value); err == nil {
+		return max(time.Until(when), 0), true
+	}
+	return 0, false
+}
+
+// replayable lets the transport send a body read from data again when a
+// request is retried, as http.NewRequest does for in-memory bodies.
+func replayable(req *http.Request, data io.ReaderAt, size int64) {
+	req.Body = io.NopCloser(io.NewSectionReader(data, 0, size))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(io.NewSectionReader(data, 0, size)), nil
+	}
+	req.ContentLength = size
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// signedLink is a link issued by POST /admin/presign.
+type signedLink struct {
+	URL     string    `json:"url"`
+	Method  string    `json:"method,omitempty"`
+	Expires time.Time `json:"expires"`
+}
+
+// share issues a signed, time-limited link that lists a bucket folder
+// without credentials.
+func (c *CLI) share(args []string) error {
+	fs := flag.NewFlagSet("share", flag.ContinueOnError)
+	expires := fs.Duration("expires", time.Hour, "How long the link stays valid (at most 168h)")
+	args, err := parseCommandFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if len(args) != 1 {
+		return fmt.Errorf("usage: storage-cli share [--expires 1h] <bucket>[/prefix]")
+	}
+
+	bucketName, prefix, _ := strings.Cut(args[0], "/")
+	link, err := c.presignLink(map[string]string{
+		"bucket":     bucketName,
+		"prefix":     prefix,
+		"expires_in": expires.String(),
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(link.URL)
+	if c.config.Verbose {
+		fmt.Printf("Expires: %s\n", link.Expires.Local().Format("2006-01-02 15:04:05"))
+	}
+	return nil
+}
+
+// presign issues a signed, time-limited link to download or upload one
+// object, for someone without credentials.
+func (c *CLI) presign(args []string) error {
+	fs := flag.NewFlagSet("presign", flag.ContinueOnError)
+	expires := fs.Duration("expires", time.Hour, "How long the link stays valid (at most 168h)")
+	method := fs.String("method", http.MethodGet, "GET to download the object, PUT to upload it")
+	args, err := parseCommandFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if len(args) != 1 {
+		return fmt.Errorf("usage: storage-cli presign [--expires 1h] [--method GET|PUT] <bucket/object>")
+	}
+	bucketName, objectKey, ok := strings.Cut(args[0], "/")
+	if !ok || objectKey == "" {
+		return fmt.Errorf("path must be in format: bucket/object")
+	}
+
+	link, err := c.presignLink(map[string]string{
+		"bucket":     bucketName,
+		"key":        objectKey,
+		"method":     strings.ToUpper(*method),
+		"expires_in": expires.String(),
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.render(link, func() error {
+		fmt.Println(link.URL)
+		if c.config.Verbose {
+			fmt.Printf("Method: %s\n", link.Method)
+			fmt.Printf("Expires: %s\n", link.Expires.Local().Format("2006-01-02 15:04:05"))
+		}
+		return nil
+	})
+}
+
+// presignLink asks the server to sign a link and makes its URL absolute.
+func (c *CLI) presignLink(request map[string]string) (*signedLink, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	url := fmt.Sprintf("%s/admin/presign", c.config.ServerUrl)
+	resp, err := c.client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create link: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to create link: %s", responseError(resp))
+	}
+
+	var link signedLink
+	if err := json.NewDecoder(resp.Body).Decode(&link); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	link.URL = c.config.ServerUrl + link.URL
+	return &link, nil
+}
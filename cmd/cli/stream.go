@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// stdioPath as a cp source or destination means standard input or output.
+const stdioPath = "-"
+
+// copyStream copies standard input to an object, or an object to standard
+// output, for pipelines. Progress goes to stderr, so stdout carries only
+// the object.
+func (c *CLI) copyStream(source, dest string, opts copyOptions) error {
+	if source == stdioPath && dest == stdioPath {
+		return fmt.Errorf("source and destination cannot both be '-'")
+	}
+	if opts.Resume || opts.ChecksumOnly || opts.Verify {
+		return fmt.Errorf("--resume, --checksum-only and --verify need a local file, not '-'")
+	}
+
+	remote := dest
+	if source != stdioPath {
+		remote = source
+	}
+	bucketName, objectKey, ok := strings.Cut(remote, "/")
+	if !ok || objectKey == "" || strings.HasSuffix(objectKey, "/") {
+		return fmt.Errorf("'%s' must be in format: bucket/object", remote)
+	}
+
+	if source != stdioPath {
+		if opts.IfMatch != "" || opts.NoClobber {
+			return fmt.Errorf("--if-match and --no-clobber only apply to uploads")
+		}
+		size, err := c.getStream(bucketName, objectKey, os.Stdout)
+		if err != nil {
+			return err
+		}
+		if c.config.Verbose {
+			fmt.Fprintf(os.Stderr, "Downloaded '%s' to standard output (%s).\n", remote, formatSize(size))
+		}
+		return nil
+	}
+
+	size, err := c.putStream(os.Stdin, bucketName, objectKey, opts)
+	if errors.Is(err, errObjectExists) {
+		fmt.Printf("Skipped standard input: '%s' already exists.\n", remote)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	fmt.Printf("Standard input uploaded successfully to '%s' (%s).\n", remote, formatSize(size))
+	return nil
+}
+
+// putStream uploads data of unknown length to an object. It is read a part
+// at a time: data that fits in one part is sent with a single PUT, longer
+// data as a multipart upload, so memory use stays at one part however long
+// the stream is. The checksums of the whole stream are sent with the last
+// request, and the server rejects an object that does not match them. A
+// stream cannot be read again, so a failed upload is aborted rather than
+// kept for --resume.
+func (c *CLI) putStream(data io.Reader, bucketName, objectKey string, opts copyOptions) (size int64, err error) {
+	remote := bucketName + "/" + objectKey
+	defer func() {
+		if errors.Is(err, errObjectExists) {
+			c.transfers.Record(transferSkipped, stdioPath, remote, 0, nil)
+			return
+		}
+		c.transfers.Record(transferUploaded, stdioPath, remote, size, err)
+	}()
+
+	objectURL := fmt.Sprintf("%s/objects/%s/%s", c.config.ServerUrl, bucketName, objectKey)
+	headers := http.Header{}
+	headers.Set("Content-Type", getContentType(objectKey))
+	if opts.IfMatch != "" {
+		headers.Set("If-Match", `"`+opts.IfMatch+`"`)
+	}
+	if opts.NoClobber {
+		headers.Set("If-None-Match", "*")
+	}
+	hash := md5.New()
+	sha := sha256.New()
+	setChecksums := func() {
+		headers.Set("Content-MD5", base64.StdEncoding.EncodeToString(hash.Sum(nil)))
+		headers.Set("X-Checksum-Algorithm", "sha256")
+		headers.Set("X-Checksum-Sha256", hex.EncodeToString(sha.Sum(nil)))
+	}
+
+	buf := make([]byte, opts.PartSize)
+	n, readErr := io.ReadFull(data, buf)
+	if readErr != nil && readErr != io.EOF && readErr != io.ErrUnexpectedEOF {
+		return 0, fmt.Errorf("failed to read standard input: %w", readErr)
+	}
+
+	if readErr != nil {
+		// The whole stream fits in one part.
+		hash.Write(buf[:n])
+		sha.Write(buf[:n])
+		setChecksums()
+
+		req, err := http.NewRequest(http.MethodPut, objectURL, bytes.NewReader(buf[:n]))
+		if err != nil {
+			return 0, fmt.Errorf("failed to create request: %w", err)
+		}
+		req.Header = headers
+
+		resp, err := c.client.Do(req)
+		if err != nil {
+			return 0, fmt.Errorf("failed to upload: %w", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode == http.StatusPreconditionFailed {
+			return 0, opts.preconditionError(remote)
+		}
+		if resp.StatusCode != http.StatusOK {
+			return 0, fmt.Errorf("failed to upload: %s", responseError(resp))
+		}
+		return int64(n), nil
+	}
+
+	uploadID, err := c.createUpload(objectURL, headers.Get("Content-Type"))
+	if err != nil {
+		return 0, err
+	}
+	var parts []uploadPart
+	for partNumber := 1; n > 0; partNumber++ {
+		hash.Write(buf[:n])
+		sha.Write(buf[:n])
+		part, err := c.uploadPart(objectURL, uploadID, partNumber, bytes.NewReader(buf[:n]), int64(n))
+		if err != nil {
+			c.abortUpload(objectURL, uploadID)
+			return 0, err
+		}
+		parts = append(parts, *part)
+		size += int64(n)
+		if c.config.Verbose {
+			fmt.Fprintf(os.Stderr, "Uploaded part %d (%s so far)\n", partNumber, formatSize(size))
+		}
+
+		if readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		n, readErr = io.ReadFull(data, buf)
+		if readErr != nil && readErr != io.EOF && readErr != io.ErrUnexpectedEOF {
+			c.abortUpload(objectURL, uploadID)
+			return 0, fmt.Errorf("failed to read standard input: %w", readErr)
+		}
+	}
+
+	setChecksums()
+	if err := c.completeUpload(objectURL, uploadID, parts, headers); err != nil {
+		c.abortUpload(objectURL, uploadID)
+		if errors.Is(err, errPreconditionFailed) {
+			return 0, opts.preconditionError(remote)
+		}
+		return 0, err
+	}
+	return size, nil
+}
+
+// getStream writes an object to w as it is received.
+func (c *CLI) getStream(bucketName, objectKey string, w io.Writer) (size int64, err error) {
+	remote := bucketName + "/" + objectKey
+	defer func() {
+		c.transfers.Record(transferDownloaded, remote, stdioPath, size, err)
+	}()
+
+	resp, err := c.client.Get(fmt.Sprintf("%s/objects/%s/%s", c.config.ServerUrl, bucketName, objectKey))
+	if err != nil {
+		return 0, fmt.Errorf("failed to download: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("failed to download: %s", responseError(resp))
+	}
+	size, err = io.Copy(w, resp.Body)
+	if err != nil {
+		return size, fmt.Errorf("failed to write output: %w", err)
+	}
+	return size, nil
+}
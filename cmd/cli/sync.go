@@ -0,0 +1,314 @@
+package main
+
+import (
+	"cmp"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// syncAction is one change that brings the destination of a sync in line
+// with its source.
+type syncAction struct {
+	Kind      string // transferUploaded, transferDownloaded or transferDeleted
+	LocalPath string
+	Key       string
+	Size      int64
+	Modified  time.Time // of the object, for downloads
+	Reason    string
+}
+
+// sync makes a bucket prefix match a local directory, or the reverse. Only
+// files that are new or changed are transferred, so repeated runs are
+// cheap. The direction follows from which argument is written as a local
+// path, or from --direction; whether a directory of that name exists does
+// not matter, so a bucket is never mistaken for a directory or the other
+// way round.
+func (c *CLI) sync(args []string) error {
+	fs := flag.NewFlagSet("sync", flag.ContinueOnError)
+	parallel := fs.Int("parallel", 4, "Number of concurrent transfers")
+	deleteExtra := fs.Bool("delete", false, "Delete destination files that are missing from the source")
+	dryRun := fs.Bool("dry-run", false, "Show what would be transferred without doing it")
+	direction := fs.String("direction", "", "up (local directory to bucket) or down (bucket to local directory); needed when neither argument is written as a local path")
+	args, err := parseCommandFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if len(args) != 2 {
+		return fmt.Errorf("usage: storage-cli sync [--delete] [--dry-run] [--parallel N] [--direction up|down] <local-dir> <bucket>[/prefix]\n" +
+			"       storage-cli sync [--delete] [--dry-run] [--parallel N] [--direction up|down] <bucket>[/prefix] <local-dir>\n" +
+			"Write the local directory as ./dir, ../dir, /dir or ., or give --direction")
+	}
+
+	upload, err := syncDirection(*direction, args[0], args[1])
+	if err != nil {
+		return err
+	}
+	localDir, remote := args[1], args[0]
+	if upload {
+		localDir, remote = args[0], args[1]
+	}
+	bucketName, prefix := splitSyncRemote(remote)
+	if bucketName == "" {
+		return fmt.Errorf("remote path must be in format: bucket or bucket/prefix")
+	}
+
+	var actions []syncAction
+	if upload {
+		actions, err = c.planUpload(localDir, bucketName, prefix, *deleteExtra)
+	} else {
+		actions, err = c.planDownload(bucketName, prefix, localDir, *deleteExtra)
+	}
+	if err != nil {
+		return err
+	}
+
+	slices.SortFunc(actions, func(a, b syncAction) int {
+		return cmp.Or(strings.Compare(a.Key, b.Key), strings.Compare(a.LocalPath, b.LocalPath))
+	})
+
+	if len(actions) == 0 {
+		fmt.Println("Everything is up to date.")
+		return nil
+	}
+	if *dryRun {
+		for _, action := range actions {
+			fmt.Println(action.describe(bucketName))
+		}
+		fmt.Printf("\n%d change(s) would be made.\n", len(actions))
+		return nil
+	}
+
+	report := newBatchReport(os.Stdout)
+	opts := copyOptions{PartSize: defaultPartSize}
+	runParallel(*parallel, actions, func(action syncAction) {
+		remote := bucketName + "/" + action.Key
+		switch action.Kind {
+		case transferUploaded:
+			size, err := c.putFile(action.LocalPath, bucketName, action.Key, opts)
+			if err != nil {
+				report.Failure(action.LocalPath, err)
+				return
+			}
+			report.Success(action.LocalPath, size, fmt.Sprintf("uploaded to '%s' (%s)", remote, action.Reason))
+
+		case transferDownloaded:
+			size, err := c.syncDownload(bucketName, action)
+			if err != nil {
+				report.Failure(remote, err)
+				return
+			}
+			report.Success(remote, size, fmt.Sprintf("downloaded to '%s' (%s)", action.LocalPath, action.Reason))
+
+		case transferDeleted:
+			if action.LocalPath != "" {
+				err := os.Remove(action.LocalPath)
+				c.transfers.Record(transferDeleted, action.LocalPath, "", 0, err)
+				if err != nil {
+					report.Failure(action.LocalPath, err)
+					return
+				}
+				report.Success(action.LocalPath, 0, "deleted")
+				return
+			}
+			if err := c.deleteObject(bucketName, action.Key); err != nil {
+				report.Failure(remote, err)
+				return
+			}
+			report.Success(remote, 0, "deleted")
+		}
+	})
+
+	report.Summary()
+	return report.Err()
+}
+
+// syncDirection reports whether a sync from source to dest is an upload.
+// Without an explicit direction, exactly one argument must be written as a
+// local path.
+func syncDirection(direction, source, dest string) (bool, error) {
+	switch direction {
+	case "up":
+		return true, nil
+	case "down":
+		return false, nil
+	case "":
+	default:
+		return false, fmt.Errorf("--direction must be up or down")
+	}
+
+	switch sourceLocal, destLocal := writtenAsLocalPath(source), writtenAsLocalPath(dest); {
+	case sourceLocal && destLocal:
+		return false, fmt.Errorf("both %q and %q are local paths; one side of a sync must be a bucket", source, dest)
+	case sourceLocal:
+		return true, nil
+	case destLocal:
+		return false, nil
+	}
+	return false, fmt.Errorf("cannot tell which of %q and %q is the local directory: write it as ./dir or /dir, or give --direction up or --direction down", source, dest)
+}
+
+// writtenAsLocalPath reports whether p can only be a local path: it is
+// absolute, or is or starts with . or .. .
+func writtenAsLocalPath(p string) bool {
+	return filepath.IsAbs(p) || p == "." || p == ".." ||
+		strings.HasPrefix(p, "./") || strings.HasPrefix(p, "../")
+}
+
+// splitSyncRemote splits bucket/prefix, treating the prefix as a folder.
+func splitSyncRemote(remote string) (string, string) {
+	bucketName, prefix, _ := strings.Cut(remote, "/")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return bucketName, prefix
+}
+
+func (a syncAction) describe(bucketName string) string {
+	remote := bucketName + "/" + a.Key
+	switch a.Kind {
+	case transferUploaded:
+		return fmt.Sprintf("upload %s -> %s (%s)", a.LocalPath, remote, a.Reason)
+	case transferDownloaded:
+		return fmt.Sprintf("download %s -> %s (%s)", remote, a.LocalPath, a.Reason)
+	case transferDeleted:
+		if a.LocalPath != "" {
+			return "delete " + a.LocalPath
+		}
+		return "delete " + remote
+	}
+	return ""
+}
+
+// planUpload compares a local directory with the objects under prefix.
+func (c *CLI) planUpload(localDir, bucketName, prefix string, deleteExtra bool) ([]syncAction, error) {
+	files, err := walkUploadFiles(localDir, prefix)
+	if err != nil {
+		return nil, err
+	}
+	remote, err := c.remoteObjects(bucketName, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var actions []syncAction
+	for _, file := range files {
+		info, err := os.Stat(file.LocalPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read local file: %w", err)
+		}
+		object, exists := remote[file.Key]
+		delete(remote, file.Key)
+		if reason := syncReason(file.LocalPath, info, object, exists, info.ModTime().After(object.LastModified)); reason != "" {
+			actions = append(actions, syncAction{Kind: transferUploaded, LocalPath: file.LocalPath, Key: file.Key, Size: info.Size(), Reason: reason})
+		}
+	}
+
+	if deleteExtra {
+		for key := range remote {
+			actions = append(actions, syncAction{Kind: transferDeleted, Key: key})
+		}
+	}
+	return actions, nil
+}
+
+// planDownload compares the objects under prefix with a local directory,
+// which is created if needed.
+func (c *CLI) planDownload(bucketName, prefix, localDir string, deleteExtra bool) ([]syncAction, error) {
+	remote, err := c.remoteObjects(bucketName, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var actions []syncAction
+	wanted := make(map[string]bool, len(remote))
+	for key, object := range remote {
+		rel := strings.TrimPrefix(key, prefix)
+		if rel == "" || strings.HasSuffix(rel, "/") {
+			continue
+		}
+		if !filepath.IsLocal(filepath.FromSlash(rel)) {
+			return nil, fmt.Errorf("object key '%s' would be written outside '%s'", key, localDir)
+		}
+		localPath := filepath.Join(localDir, filepath.FromSlash(rel))
+		wanted[localPath] = true
+
+		info, err := os.Stat(localPath)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to read local file: %w", err)
+		}
+		exists := err == nil
+		if reason := syncReason(localPath, info, object, exists, exists && object.LastModified.After(info.ModTime())); reason != "" {
+			actions = append(actions, syncAction{Kind: transferDownloaded, LocalPath: localPath, Key: key, Size: object.Size, Modified: object.LastModified, Reason: reason})
+		}
+	}
+
+	if deleteExtra {
+		err := filepath.WalkDir(localDir, func(localPath string, entry fs.DirEntry, err error) error {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if !entry.IsDir() && !wanted[localPath] {
+				actions = append(actions, syncAction{Kind: transferDeleted, LocalPath: localPath})
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read local directory: %w", err)
+		}
+	}
+	return actions, nil
+}
+
+// syncReason says why a file must be copied over its counterpart, or
+// returns "" if both are the same. Files of equal size are only read to
+// compare their MD5 with the ETag when the source is newer.
+func syncReason(localPath string, info os.FileInfo, object ObjectInfo, exists, sourceNewer bool) string {
+	switch {
+	case !exists || info == nil:
+		return "new"
+	case info.Size() != object.Size:
+		return "size changed"
+	case !sourceNewer:
+		return ""
+	}
+	if sum, err := fileMD5(localPath); err == nil && sum == object.ETag {
+		return ""
+	}
+	return "modified"
+}
+
+func (c *CLI) remoteObjects(bucketName, prefix string) (map[string]ObjectInfo, error) {
+	objects, err := c.fetchObjects(bucketName, prefix, "")
+	if err != nil {
+		return nil, err
+	}
+	byKey := make(map[string]ObjectInfo, len(objects))
+	for _, object := range objects {
+		byKey[object.Key] = object
+	}
+	return byKey, nil
+}
+
+// syncDownload downloads an object and gives the local file the object's
+// modification time, so the next sync sees it as unchanged.
+func (c *CLI) syncDownload(bucketName string, action syncAction) (int64, error) {
+	if err := os.MkdirAll(filepath.Dir(action.LocalPath), 0755); err != nil {
+		return 0, fmt.Errorf("failed to create local directory: %w", err)
+	}
+	size, err := c.getFile(bucketName, action.Key, action.LocalPath)
+	if err != nil {
+		return size, err
+	}
+	os.Chtimes(action.LocalPath, action.Modified, action.Modified)
+	return size, nil
+}
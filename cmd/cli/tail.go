@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// tailChunk is how much of an object tail fetches per Range request while
+// looking back for the lines to print.
+const tailChunk = 64 * 1024
+
+// objectVersion is what tail --follow compares to notice that an object
+// changed.
+type objectVersion struct {
+	Size int64
+	ETag string
+}
+
+// headObject returns the size and ETag of an object; found is false if it
+// does not exist.
+func (c *CLI) headObject(bucketName, objectKey string) (version objectVersion, found bool, err error) {
+	resp, err := c.client.Head(fmt.Sprintf("%s/objects/%s/%s", c.config.ServerUrl, bucketName, objectKey))
+	if err != nil {
+		return version, false, fmt.Errorf("failed to check object: %w", err)
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return objectVersion{Size: resp.ContentLength, ETag: resp.Header.Get("ETag")}, true, nil
+	case http.StatusNotFound:
+		return version, false, nil
+	}
+	return version, false, fmt.Errorf("failed to check object: %s", resp.Status)
+}
+
+// head prints the first lines of an object. It stops reading once it has
+// printed them, so the rest of the object is not downloaded.
+func (c *CLI) head(args []string) error {
+	fs := flag.NewFlagSet("head", flag.ContinueOnError)
+	lines := fs.Int("n", 10, "Number of lines to print")
+	args, err := parseCommandFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if len(args) != 1 || *lines < 0 {
+		return fmt.Errorf("usage: storage-cli head [-n 10] <bucket/object>")
+	}
+	bucketName, objectKey, ok := strings.Cut(args[0], "/")
+	if !ok || objectKey == "" {
+		return fmt.Errorf("path must be in format: bucket/object")
+	}
+	if *lines == 0 {
+		return nil
+	}
+
+	resp, err := c.client.Get(fmt.Sprintf("%s/objects/%s/%s", c.config.ServerUrl, bucketName, objectKey))
+	if err != nil {
+		return fmt.Errorf("failed to get object: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to get object: %s", responseError(resp))
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	for i := 0; i < *lines; i++ {
+		line, err := reader.ReadBytes('\n')
+		os.Stdout.Write(line)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to read object: %w", err)
+		}
+	}
+	return nil
+}
+
+// tail prints the last lines of an object, fetching only the end of it,
+// and with --follow keeps printing what is appended to it.
+func (c *CLI) tail(args []string) error {
+	fs := flag.NewFlagSet("tail", flag.ContinueOnError)
+	lines := fs.Int("n", 10, "Number of lines to print")
+	follow := fs.Bool("follow", false, "Keep printing data appended to the object")
+	fs.BoolVar(follow, "f", false, "Same as --follow")
+	interval := fs.Duration("interval", time.Second, "How often --follow checks the object")
+	args, err := parseCommandFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if len(args) != 1 || *lines < 0 {
+		return fmt.Errorf("usage: storage-cli tail [-n 10] [--follow] [--interval 1s] <bucket/object>")
+	}
+	bucketName, objectKey, ok := strings.Cut(args[0], "/")
+	if !ok || objectKey == "" {
+		return fmt.Errorf("path must be in format: bucket/object")
+	}
+	if *interval <= 0 {
+		return fmt.Errorf("--interval must be positive")
+	}
+
+	version, found, err := c.headObject(bucketName, objectKey)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("object not found")
+	}
+	if err := c.printLastLines(bucketName, objectKey, version.Size, *lines); err != nil {
+		return err
+	}
+
+	if !*follow {
+		return nil
+	}
+	return c.followObject(bucketName, objectKey, version, *interval)
+}
+
+// printLastLines prints the last n lines of the first size bytes of an
+// object. It fetches tailChunk bytes at a time from the end until it has
+// the start of the first line to print.
+func (c *CLI) printLastLines(bucketName, objectKey string, size int64, n int) error {
+	if n == 0 {
+		return nil
+	}
+	var data []byte
+	start := 0
+	for end := size; end > 0; {
+		from := max(end-tailChunk, 0)
+		var chunk bytes.Buffer
+		if _, err := c.readRange(bucketName, objectKey, from, end-from, &chunk); err != nil {
+			return err
+		}
+		data = append(chunk.Bytes(), data...)
+		end = from
+
+		var found bool
+		if start, found = lastLines(data, n); found {
+			break
+		}
+	}
+	_, err := os.Stdout.Write(data[start:])
+	return err
+}
+
+// lastLines returns where the last n lines of data start, and false if
+// data holds fewer than n complete lines. A final newline ends the last
+// line rather than starting another.
+func lastLines(data []byte, n int) (int, bool) {
+	end := len(data)
+	if end > 0 && data[end-1] == '\n' {
+		end--
+	}
+	for i := 0; i < n; i++ {
+		end = bytes.LastIndexByte(data[:end], '\n')
+		if end < 0 {
+			return 0, false
+		}
+	}
+	return end + 1, true
+}
+
+// followObject prints what is appended to an object after last, checking
+// its size and ETag every interval and fetching only the new bytes. An
+// object that changes without growing was replaced rather than appended
+// to, so it is printed again from the start. It runs until interrupted;
+// errors while polling are reported and the object is checked again.
+func (c *CLI) followObject(bucketName, objectKey string, last objectVersion, interval time.Duration) error {
+	remote := bucketName + "/" + objectKey
+	missing := false
+	for {
+		time.Sleep(interval)
+
+		current, found, err := c.headObject(bucketName, objectKey)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "tail: %v\n", err)
+			continue
+		}
+		if !found {
+			if !missing {
+				fmt.Fprintf(os.Stderr, "tail: '%s' has been deleted; waiting for it to be created again\n", remote)
+				missing = true
+			}
+			last = objectVersion{}
+			continue
+		}
+		missing = false
+		if current.ETag == last.ETag {
+			continue
+		}
+
+		from := last.Size
+		if current.Size <= last.Size {
+			fmt.Fprintf(os.Stderr, "tail: '%s' has been replaced; following the new object\n", remote)
+			from = 0
+		}
+		if current.Size > from {
+			if _, err := c.readRange(bucketName, objectKey, from, current.Size-from, os.Stdout); err != nil {
+				fmt.Fprintf(os.Stderr, "tail: %v\n", err)
+				continue
+			}
+		}
+		last = current
+	}
+}
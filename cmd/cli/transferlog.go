@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	transferUploaded   = "uploaded"
+	transferDownloaded = "downloaded"
+	transferSkipped    = "skipped"
+	transferDeleted    = "deleted"
+	transferFailed     = "failed"
+)
+
+type transferRecord struct {
+	Time        time.Time `json:"time"`
+	Action      string    `json:"action"`
+	Operation   string    `json:"operation,omitempty"`
+	Source      string    `json:"source,omitempty"`
+	Destination string    `json:"destination,omitempty"`
+	Size        int64     `json:"size"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// transferLog appends one JSON object per line for every action a transfer
+// command performed. A nil *transferLog discards all records.
+type transferLog struct {
+	mu      sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+}
+
+func openTransferLog(path string) (*transferLog, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log file: %w", err)
+	}
+
+	return &transferLog{file: file, encoder: json.NewEncoder(file)}, nil
+}
+
+// Record writes a single entry. When err is non-nil the entry is recorded as
+// failed and action is kept as the attempted operation.
+func (l *transferLog) Record(action, source, destination string, size int64, err error) {
+	if l == nil {
+		return
+	}
+
+	record := transferRecord{
+		Time:        time.Now().UTC(),
+		Action:      action,
+		Source:      source,
+		Destination: destination,
+		Size:        size,
+	}
+
+	if err != nil {
+		record.Action = transferFailed
+		record.Operation = action
+		record.Error = err.Error()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.encoder.Encode(record)
+}
+
+func (l *transferLog) Close() error {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
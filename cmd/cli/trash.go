@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+type trashEntry struct {
+	ID        string     `json:"id"`
+	DeletedAt time.Time  `json:"deleted_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	Object    ObjectInfo `json:"object"`
+}
+
+func (c *CLI) fetchTrash(bucketName string) ([]trashEntry, error) {
+	url := fmt.Sprintf("%s/trash/%s", c.config.ServerUrl, bucketName)
+	resp, err := c.client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list trash: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to list trash: %s", responseError(resp))
+	}
+
+	var entries []trashEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return entries, nil
+}
+
+// trash dispatches the trash subcommands. Only ls exists today.
+func (c *CLI) trash(args []string) error {
+	if len(args) != 2 || args[0] != "ls" {
+		return fmt.Errorf("usage: storage-cli trash ls <bucket>")
+	}
+
+	bucketName := args[1]
+	entries, err := c.fetchTrash(bucketName)
+	if err != nil {
+		return err
+	}
+
+	return c.render(entries, func() error {
+		if len(entries) == 0 {
+			fmt.Printf("Trash of bucket '%s' is empty.\n", bucketName)
+			return nil
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "OBJECT KEY\tSIZE\tDELETED\tEXPIRES\tID")
+		fmt.Fprintln(w, "----------\t----\t-------\t-------\t--")
+
+		for _, entry := range entries {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+				entry.Object.Key, formatSize(entry.Object.Size),
+				entry.DeletedAt.Local().Format("2006-01-02 15:04:05"),
+				entry.ExpiresAt.Local().Format("2006-01-02"),
+				entry.ID)
+		}
+
+		return w.Flush()
+	})
+}
+
+// restore brings a deleted object back from the trash. Without --id the
+// most recently deleted version of the key is restored.
+func (c *CLI) restore(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	id := fs.String("id", "", "Trash entry to restore (see 'trash ls')")
+	args, err := parseCommandFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if len(args) != 1 {
+		return fmt.Errorf("usage: storage-cli restore [--id ID] <bucket/object>")
+	}
+
+	bucketName, objectKey, ok := strings.Cut(args[0], "/")
+	if !ok || objectKey == "" {
+		return fmt.Errorf("path must be in format: bucket/object")
+	}
+
+	if *id == "" {
+		entries, err := c.fetchTrash(bucketName)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if entry.Object.Key == objectKey {
+				*id = entry.ID
+			}
+		}
+		if *id == "" {
+			return fmt.Errorf("'%s/%s' is not in the trash", bucketName, objectKey)
+		}
+	}
+
+	url := fmt.Sprintf("%s/trash/%s/%s?restore", c.config.ServerUrl, bucketName, *id)
+	resp, err := c.client.Post(url, "application/json", nil)
+	if err != nil {
+		return fmt.Errorf("failed to restore object: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to restore object: %s", responseError(resp))
+	}
+
+	var restored ObjectInfo
+	if err := json.NewDecoder(resp.Body).Decode(&restored); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	fmt.Printf("Object '%s/%s' restored.\n", bucketName, restored.Key)
+	return nil
+}
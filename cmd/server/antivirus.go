@@ -0,0 +1,449 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// When uploads are scanned.
+const (
+	scanModeSync  = "sync"  // before the upload is stored; infected uploads are refused
+	scanModeAsync = "async" // after the upload is stored; infected objects are moved away
+)
+
+// Scan states recorded in object metadata.
+const (
+	scanPending  = "pending"
+	scanClean    = "clean"
+	scanInfected = "infected"
+	scanError    = "error"
+)
+
+const (
+	defaultScanTimeout = 30 * time.Second
+	defaultScanWorkers = 2
+	scanQueueSize      = 1000
+
+	// clamdChunkSize is the largest chunk sent to clamd at once.
+	clamdChunkSize = 64 << 10
+
+	scanStatusHeader    = "X-Scan-Status"
+	scanSignatureHeader = "X-Scan-Signature"
+)
+
+// errScanStale is returned when an object changed while it was scanned.
+var errScanStale = errors.New("object changed while it was scanned")
+
+// AntivirusConfig scans uploads for malware with clamd or an external
+// scanning service.
+type AntivirusConfig struct {
+	// Clamd is the address of a clamd daemon: a unix socket path starting
+	// with "/" or a host:port.
+	Clamd string `json:"clamd,omitempty"`
+
+	// WebhookURL receives a POST with the data of every upload and answers
+	// with {"infected": bool, "signature": "..."}. Exactly one of Clamd and
+	// WebhookURL must be set.
+	WebhookURL string `json:"webhook_url,omitempty"`
+
+	// Mode is sync (the default) or async.
+	Mode string `json:"mode,omitempty"`
+
+	// QuarantineBucket receives infected objects under "{bucket}/{key}".
+	// It is created when missing and is never scanned. Without it,
+	// infected uploads are refused or deleted.
+	QuarantineBucket string `json:"quarantine_bucket,omitempty"`
+
+	// Buckets limits scanning to these buckets; empty scans all.
+	Buckets []string `json:"buckets,omitempty"`
+
+	// FailOpen stores uploads the scanner could not check, with the scan
+	// status error, instead of refusing them. It only applies to sync.
+	FailOpen bool `json:"fail_open,omitempty"`
+
+	Timeout Duration `json:"timeout,omitempty"`
+
+	// Workers is how many objects are scanned at once in async mode.
+	Workers int `json:"workers,omitempty"`
+}
+
+func (c *AntivirusConfig) validate(config *Config) error {
+	if c == nil {
+		return nil
+	}
+	if (c.Clamd == "") == (c.WebhookURL == "") {
+		return fmt.Errorf("antivirus needs exactly one of clamd and webhook_url")
+	}
+	if c.WebhookURL != "" && !strings.HasPrefix(c.WebhookURL, "http://") && !strings.HasPrefix(c.WebhookURL, "https://") {
+		return fmt.Errorf("antivirus webhook_url must be an http or https URL")
+	}
+	switch c.Mode {
+	case "", scanModeSync:
+	case scanModeAsync:
+		if config.Backend != backendFilesystem {
+			return fmt.Errorf("antivirus mode async requires the filesystem backend")
+		}
+		if c.FailOpen {
+			return fmt.Errorf("antivirus fail_open only applies to mode sync")
+		}
+	default:
+		return fmt.Errorf("antivirus mode must be sync or async")
+	}
+	if c.Timeout < 0 || c.Workers < 0 {
+		return fmt.Errorf("antivirus timeout and workers must not be negative")
+	}
+	if c.QuarantineBucket != "" {
+		if strings.Contains(c.QuarantineBucket, "/") {
+			return fmt.Errorf("antivirus quarantine_bucket must be a bucket name")
+		}
+		for _, bucket := range c.Buckets {
+			if bucket == c.QuarantineBucket {
+				return fmt.Errorf("antivirus quarantine_bucket must not be scanned")
+			}
+		}
+	}
+	return nil
+}
+
+// ScanStatus is the result of scanning an object, kept in its metadata.
+type ScanStatus struct {
+	// Status is pending, clean, infected or error.
+	Status string `json:"status"`
+
+	// Signature names the malware found in an infected object.
+	Signature string `json:"signature,omitempty"`
+
+	ScannedAt *time.Time `json:"scanned_at,omitempty"`
+}
+
+func newScanStatus(status, signature string) *ScanStatus {
+	now := time.Now().UTC().Truncate(time.Second)
+	return &ScanStatus{Status: status, Signature: signature, ScannedAt: &now}
+}
+
+// setScanHeaders reports an object's scan status.
+func setScanHeaders(w http.ResponseWriter, metadata *ObjectMetadata) {
+	if metadata.Scan == nil {
+		return
+	}
+	w.Header().Set(scanStatusHeader, metadata.Scan.Status)
+	if metadata.Scan.Signature != "" {
+		w.Header().Set(scanSignatureHeader, metadata.Scan.Signature)
+	}
+}
+
+// virusScanner checks data for malware. It returns the name of the malware
+// found, or "" for clean data.
+type virusScanner interface {
+	scan(ctx context.Context, data io.Reader) (string, error)
+}
+
+// clamdScanner streams data to clamd with the INSTREAM command.
+type clamdScanner struct {
+	address string
+}
+
+func (c *clamdScanner) scan(ctx context.Context, data io.Reader) (string, error) {
+	network := "tcp"
+	if strings.HasPrefix(c.address, "/") {
+		network = "unix"
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, c.address)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return "", err
+	}
+	buf := make([]byte, 4+clamdChunkSize)
+	for {
+		n, readErr := io.ReadFull(data, buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf, uint32(n))
+			if _, err := conn.Write(buf[:4+n]); err != nil {
+				return "", err
+			}
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			return "", readErr
+		}
+	}
+	if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return "", err
+	}
+
+	reply, err := io.ReadAll(io.LimitReader(conn, 4096))
+	if err != nil {
+		return "", err
+	}
+	return parseClamdReply(string(bytes.TrimRight(reply, "\x00\n")))
+}
+
+// parseClamdReply reads clamd's answer to INSTREAM: "stream: OK",
+// "stream: <signature> FOUND" or "<message> ERROR".
+func parseClamdReply(reply string) (string, error) {
+	result := strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case result == "OK":
+		return "", nil
+	case strings.HasSuffix(result, " FOUND"):
+		return strings.TrimSuffix(result, " FOUND"), nil
+	}
+	return "", fmt.Errorf("clamd: %s", reply)
+}
+
+// webhookScanner posts data to an external scanning service.
+type webhookScanner struct {
+	url    string
+	client *http.Client
+}
+
+func (c *webhookScanner) scan(ctx context.Context, data io.Reader) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, data)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set(webhookEventHeader, "upload.scan")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", fmt.Errorf("scanner returned %s", resp.Status)
+	}
+
+	var result struct {
+		Infected  bool   `json:"infected"`
+		Signature string `json:"signature"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("invalid scanner response: %w", err)
+	}
+	if !result.Infected {
+		return "", nil
+	}
+	if result.Signature == "" {
+		result.Signature = "unknown"
+	}
+	return result.Signature, nil
+}
+
+type scanJob struct {
+	bucket     string
+	key        string
+	generation int64
+}
+
+// antivirus scans uploads. In sync mode it runs as an upload validator; in
+// async mode it marks uploads pending and scans them in the background.
+type antivirus struct {
+	config  *AntivirusConfig
+	scanner virusScanner
+	timeout time.Duration
+	backend Backend
+	queue   chan scanJob
+}
+
+func newAntivirus(config *AntivirusConfig, backend Backend) *antivirus {
+	timeout := time.Duration(config.Timeout)
+	if timeout == 0 {
+		timeout = defaultScanTimeout
+	}
+	av := &antivirus{config: config, timeout: timeout, backend: backend}
+	if config.Clamd != "" {
+		av.scanner = &clamdScanner{address: config.Clamd}
+	} else {
+		av.scanner = &webhookScanner{url: config.WebhookURL, client: &http.Client{Timeout: timeout}}
+	}
+	if config.Mode == scanModeAsync {
+		av.queue = make(chan scanJob, scanQueueSize)
+	}
+	return av
+}
+
+func (av *antivirus) async() bool {
+	return av.config.Mode == scanModeAsync
+}
+
+func (av *antivirus) readsBody() bool { return !av.async() }
+
+func (av *antivirus) validate(ctx context.Context, upload *pendingUpload) error {
+	if upload.Bucket == av.config.QuarantineBucket {
+		return nil
+	}
+	if av.async() {
+		upload.Scan = &ScanStatus{Status: scanPending}
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, av.timeout)
+	defer cancel()
+	signature, err := av.scanner.scan(ctx, upload.data())
+	if err != nil {
+		if av.config.FailOpen {
+			upload.Scan = newScanStatus(scanError, "")
+			return nil
+		}
+		return fmt.Errorf("%w: virus scan failed: %v", ErrValidatorUnavailable, err)
+	}
+	if signature == "" {
+		upload.Scan = newScanStatus(scanClean, "")
+		return nil
+	}
+
+	if err := av.quarantine(upload.Bucket, upload.Key, upload.data(), upload.ContentType, signature); err != nil {
+		return fmt.Errorf("%w: failed to quarantine infected upload: %v", ErrValidatorUnavailable, err)
+	}
+	return fmt.Errorf("%w: infected with %s", ErrUploadRejected, signature)
+}
+
+// quarantine stores infected data in the quarantine bucket, if there is one.
+func (av *antivirus) quarantine(bucketName, objectKey string, data io.Reader, contentType, signature string) error {
+	if av.config.QuarantineBucket == "" {
+		return nil
+	}
+	_, err := av.backend.PutObject(av.config.QuarantineBucket, bucketName+"/"+objectKey, data, PutOptions{
+		ContentType: contentType,
+		Scan:        newScanStatus(scanInfected, signature),
+	})
+	return err
+}
+
+// ensureQuarantineBucket creates the quarantine bucket when it is missing.
+func (av *antivirus) ensureQuarantineBucket() error {
+	name := av.config.QuarantineBucket
+	if name == "" {
+		return nil
+	}
+	if _, err := av.backend.GetBucket(name); err == nil {
+		return nil
+	}
+	if err := av.backend.CreateBucket(name, "", BucketOwner{}, BucketSettings{}); err != nil {
+		return fmt.Errorf("failed to create antivirus quarantine bucket: %w", err)
+	}
+	return nil
+}
+
+// queueScan schedules a background scan of an object stored pending.
+// Objects whose scan is dropped because the queue is full stay pending.
+func (s *StorageServer) queueScan(bucketName, objectKey string, metadata *ObjectMetadata) {
+	if s.antivirus == nil || s.antivirus.queue == nil || metadata == nil || metadata.Scan == nil || metadata.Scan.Status != scanPending {
+		return
+	}
+	select {
+	case s.antivirus.queue <- scanJob{bucket: bucketName, key: objectKey, generation: metadata.Generation}:
+	default:
+		s.logger.Warn("virus scan queue full, object stays pending", "bucket", bucketName, "key", objectKey)
+	}
+}
+
+func (s *StorageServer) runAntivirus(ctx context.Context) {
+	workers := s.config.Antivirus.Workers
+	if workers == 0 {
+		workers = defaultScanWorkers
+	}
+	for range workers {
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case job := <-s.antivirus.queue:
+					s.scanObject(ctx, job)
+				}
+			}
+		}()
+	}
+}
+
+// scanObject scans a stored object, records the result and moves it to
+// quarantine when it is infected. Objects that were replaced since they
+// were queued are skipped; their new version has its own scan.
+func (s *StorageServer) scanObject(ctx context.Context, job scanJob) {
+	av := s.antivirus
+	logger := s.logger.With("bucket", job.bucket, "key", job.key, "generation", job.generation)
+
+	reader, metadata, err := s.backend.GetObject(job.bucket, job.key)
+	if err != nil {
+		return
+	}
+	defer reader.Close()
+	if metadata.Generation != job.generation {
+		return
+	}
+
+	scanCtx, cancel := context.WithTimeout(ctx, av.timeout)
+	signature, err := av.scanner.scan(scanCtx, reader)
+	cancel()
+	status := newScanStatus(scanClean, "")
+	switch {
+	case err != nil:
+		logger.Error("virus scan failed", "error", err)
+		status = newScanStatus(scanError, "")
+	case signature != "":
+		logger.Warn("infected object found", "signature", signature)
+		status = newScanStatus(scanInfected, signature)
+	}
+
+	if _, err := s.storage.setScanStatus(job.bucket, job.key, job.generation, status); err != nil {
+		if !errors.Is(err, errScanStale) {
+			logger.Error("failed to record virus scan", "error", err)
+		}
+		return
+	}
+	if status.Status != scanInfected {
+		return
+	}
+
+	if av.config.QuarantineBucket != "" {
+		data, _, err := s.backend.GetObject(job.bucket, job.key)
+		if err != nil {
+			logger.Error("failed to read infected object", "error", err)
+			return
+		}
+		err = av.quarantine(job.bucket, job.key, data, metadata.ContentType, signature)
+		data.Close()
+		if err != nil {
+			logger.Error("failed to quarantine infected object", "error", err)
+			return
+		}
+	}
+	if err := s.backend.DeleteObject(job.bucket, job.key); err != nil {
+		logger.Error("failed to delete infected object", "error", err)
+		return
+	}
+	s.notify(EventDelete, job.bucket, job.key, nil)
+}
+
+// setScanStatus records the result of scanning a version of an object. It
+// fails with errScanStale when the object has been replaced since.
+func (storage *ObjectStorage) setScanStatus(bucketName, objectKey string, generation int64, status *ScanStatus) (*ObjectMetadata, error) {
+	return storage.updateObjectMetadata(bucketName, objectKey, func(metadata *ObjectMetadata) error {
+		if metadata.Generation != generation {
+			return errScanStale
+		}
+		metadata.Scan = status
+		return nil
+	})
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ErrAppendPosition is returned when an append names a position other than
+// the object's current size.
+var ErrAppendPosition = errors.New("append position does not match object size")
+
+// AppendObject adds data to the end of an object, creating it if it does
+// not exist. limits carries the MaxSize and Validate of the rewrite; the
+// other options are taken from the existing object. With position set, the
+// append only happens if the object is exactly that long, so a writer that
+// lost track of the end is told instead of writing out of order.
+//
+// An append does not add to the stored data in place: the whole object is
+// read and written again with the new data at the end, so every append
+// costs as much as uploading the object, and encryption, compression and
+// the other storage features apply as for a PUT. The rewrite commits only
+// if no other write got in first. Appends to the same object are also
+// serialized here, so concurrent appenders never have to retry each other;
+// appends to different objects do not wait for each other.
+func (storage *ObjectStorage) AppendObject(bucketName, objectKey string, data io.Reader, position *int64, limits PutOptions) (*ObjectMetadata, error) {
+	unlock := storage.appendLocks.Lock(bucketName + "/" + objectKey)
+	defer unlock()
+
+	opts := PutOptions{ContentType: "application/octet-stream", MaxSize: limits.MaxSize, Validate: limits.Validate}
+	generation := int64(0)
+	current := io.Reader(strings.NewReader(""))
+	size := int64(0)
+
+	reader, existing, err := storage.GetObject(bucketName, objectKey)
+	if err == nil {
+		defer reader.Close()
+		current, size, generation = reader, existing.Size, existing.Generation
+		opts.ContentType = existing.ContentType
+		opts.Tags = existing.Tags
+		opts.CacheControl = existing.CacheControl
+		opts.Expires = existing.Expires
+		opts.LegalHold = existing.LegalHold
+		for algorithm := range existing.Checksums {
+			opts.ChecksumAlgorithm = algorithm
+		}
+	} else if !strings.Contains(err.Error(), "not found") {
+		return nil, err
+	}
+
+	if position != nil && *position != size {
+		return nil, fmt.Errorf("%w: object is %d bytes", ErrAppendPosition, size)
+	}
+
+	opts.IfGeneration = &generation
+	return storage.PutObject(bucketName, objectKey, io.MultiReader(current, data), opts)
+}
+
+// handleAppendObject serves PATCH /objects/{bucket}/{key}?append, which adds
+// the request body to the end of the object. position, when given, must be
+// the object's current size.
+func (s *StorageServer) handleAppendObject(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPatch {
+		s.writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/objects/")
+	bucketName, objectKey, ok := strings.Cut(path, "/")
+	if !ok || objectKey == "" {
+		s.writeError(w, r, http.StatusBadRequest, "Bucket and object key required")
+		return
+	}
+
+	var position *int64
+	if value := r.URL.Query().Get("position"); value != "" {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n < 0 {
+			s.writeError(w, r, http.StatusBadRequest, "position must be a non-negative integer")
+			return
+		}
+		position = &n
+	}
+
+	limits := PutOptions{MaxSize: s.maxObjectSize(bucketName), Validate: s.validateUpload}
+	if !s.checkObjectSize(w, r, limits.MaxSize) {
+		return
+	}
+
+	metadata, err := s.storage.AppendObject(bucketName, objectKey, r.Body, position, limits)
+	if err != nil {
+		s.writeStorageError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", metadata.ETag)
+	w.Header().Set(generationHeader, strconv.FormatInt(metadata.Generation, 10))
+	setChecksumHeaders(w, metadata)
+	json.NewEncoder(w).Encode(metadata)
+
+	s.notify(EventPut, bucketName, objectKey, metadata)
+	s.replicate(r, replicationPut, bucketName, objectKey)
+}
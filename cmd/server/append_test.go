@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestConcurrentAppends appends to two objects at once. Appends to one
+// object must all land, one after another.
+func TestConcurrentAppends(t *testing.T) {
+	storage := newTestStorage(t)
+
+	const appends = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, appends*2)
+	for _, key := range []string{"a.log", "b.log"} {
+		for range appends {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := storage.AppendObject("it", key, strings.NewReader("line\n"), nil, PutOptions{}); err != nil {
+					errs <- err
+				}
+			}()
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	for _, key := range []string{"a.log", "b.log"} {
+		reader, metadata, err := storage.GetObject("it", key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var data bytes.Buffer
+		data.ReadFrom(reader)
+		reader.Close()
+		if want := strings.Repeat("line\n", appends); data.String() != want || metadata.Generation != appends {
+			t.Errorf("%s holds %d bytes at generation %d, want %d bytes at generation %d", key, data.Len(), metadata.Generation, len(want), appends)
+		}
+	}
+}
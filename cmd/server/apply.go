@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// DesiredState is the declarative configuration accepted by /admin/apply.
+type DesiredState struct {
+	Buckets []DesiredBucket `json:"buckets"`
+}
+
+type DesiredBucket struct {
+	Name     string         `json:"name"`
+	Settings BucketSettings `json:"settings"`
+}
+
+// PlanAction describes one change needed to reach the desired state.
+type PlanAction struct {
+	Action string          `json:"action"` // create, update or unchanged
+	Bucket string          `json:"bucket"`
+	Before *BucketSettings `json:"before,omitempty"`
+	After  *BucketSettings `json:"after,omitempty"`
+}
+
+type ApplyResult struct {
+	DryRun    bool         `json:"dry_run"`
+	Actions   []PlanAction `json:"actions"`
+	Unmanaged []string     `json:"unmanaged,omitempty"`
+	Applied   int          `json:"applied"`
+}
+
+func (state DesiredState) validate() error {
+	seen := make(map[string]bool)
+	for _, bucket := range state.Buckets {
+		if bucket.Name == "" {
+			return fmt.Errorf("bucket name required")
+		}
+		if err := validateBucketName(bucket.Name); err != nil {
+			return err
+		}
+		if seen[bucket.Name] {
+			return fmt.Errorf("bucket %s declared more than once", bucket.Name)
+		}
+		seen[bucket.Name] = true
+
+		if err := validateLifecycle(bucket.Settings.Lifecycle); err != nil {
+			return fmt.Errorf("bucket %s: %w", bucket.Name, err)
+		}
+		if err := bucket.Settings.Quota.validate(); err != nil {
+			return fmt.Errorf("bucket %s: %w", bucket.Name, err)
+		}
+		if err := bucket.Settings.ObjectLock.validate(); err != nil {
+			return fmt.Errorf("bucket %s: %w", bucket.Name, err)
+		}
+		if err := bucket.Settings.Trash.validate(); err != nil {
+			return fmt.Errorf("bucket %s: %w", bucket.Name, err)
+		}
+		if err := validateNotifications(bucket.Settings.Notifications); err != nil {
+			return fmt.Errorf("bucket %s: %w", bucket.Name, err)
+		}
+		if err := bucket.Settings.Website.validate(); err != nil {
+			return fmt.Errorf("bucket %s: %w", bucket.Name, err)
+		}
+		if err := bucket.Settings.Policy.validate(); err != nil {
+			return fmt.Errorf("bucket %s: %w", bucket.Name, err)
+		}
+		if err := validateCacheControl(bucket.Settings.CacheControl); err != nil {
+			return fmt.Errorf("bucket %s: %w", bucket.Name, err)
+		}
+		if err := bucket.Settings.IPAccess.validate(); err != nil {
+			return fmt.Errorf("bucket %s: %w", bucket.Name, err)
+		}
+		if err := validateMaxObjectSize(bucket.Settings.MaxObjectSize); err != nil {
+			return fmt.Errorf("bucket %s: %w", bucket.Name, err)
+		}
+		if err := validateLocation(bucket.Settings.Location); err != nil {
+			return fmt.Errorf("bucket %s: %w", bucket.Name, err)
+		}
+	}
+	return nil
+}
+
+func settingsEqual(a, b BucketSettings) bool {
+	aJSON, _ := json.Marshal(a)
+	bJSON, _ := json.Marshal(b)
+	return bytes.Equal(aJSON, bJSON)
+}
+
+// PlanState compares the desired state with the current buckets. Buckets that
+// exist but are not declared are reported as unmanaged and left untouched.
+func (storage *ObjectStorage) PlanState(state DesiredState) (*ApplyResult, error) {
+	existing, err := storage.ListBuckets()
+	if err != nil {
+		return nil, err
+	}
+
+	current := make(map[string]Bucket, len(existing))
+	for _, bucket := range existing {
+		current[bucket.Name] = bucket
+	}
+
+	result := &ApplyResult{}
+	declared := make(map[string]bool)
+
+	for _, desired := range state.Buckets {
+		declared[desired.Name] = true
+		after := desired.Settings
+
+		bucket, ok := current[desired.Name]
+		if ok && after.Location == "" {
+			// Leaving the location out keeps the bucket where it is.
+			after.Location = bucket.Settings.Location
+		}
+		switch {
+		case !ok:
+			result.Actions = append(result.Actions, PlanAction{Action: "create", Bucket: desired.Name, After: &after})
+		case !settingsEqual(bucket.Settings, after):
+			before := bucket.Settings
+			result.Actions = append(result.Actions, PlanAction{Action: "update", Bucket: desired.Name, Before: &before, After: &after})
+		default:
+			result.Actions = append(result.Actions, PlanAction{Action: "unchanged", Bucket: desired.Name})
+		}
+	}
+
+	for _, bucket := range existing {
+		if !declared[bucket.Name] {
+			result.Unmanaged = append(result.Unmanaged, bucket.Name)
+		}
+	}
+
+	return result, nil
+}
+
+// ApplyPlan performs the create and update actions of a plan.
+func (storage *ObjectStorage) ApplyPlan(result *ApplyResult) error {
+	for _, action := range result.Actions {
+		var err error
+		switch action.Action {
+		case "create":
+			err = storage.CreateBucket(action.Bucket, "", BucketOwner{}, *action.After)
+		case "update":
+			_, err = storage.UpdateBucketSettings(action.Bucket, func(settings *BucketSettings) error {
+				*settings = *action.After
+				return nil
+			})
+		default:
+			continue
+		}
+
+		if err != nil {
+			return fmt.Errorf("failed to %s bucket %s: %w", action.Action, action.Bucket, err)
+		}
+		result.Applied++
+	}
+	return nil
+}
+
+// handleApply serves POST /admin/apply. The body is a DesiredState; with
+// ?dry_run=true only the plan is returned.
+func (s *StorageServer) handleApply(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var state DesiredState
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&state); err != nil {
+		s.writeError(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid configuration: %v", err))
+		return
+	}
+
+	if err := state.validate(); err != nil {
+		s.writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+
+	result, err := s.storage.PlanState(state)
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	result.DryRun = dryRun
+
+	if !dryRun {
+		if err := s.storage.ApplyPlan(result); err != nil {
+			s.writeError(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+		s.logger.Info("declarative configuration applied", "changes", result.Applied)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
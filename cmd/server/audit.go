@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultAuditQueryLimit = 1000
+	maxAuditQueryLimit     = 10000
+)
+
+// AuditEntry records one mutating request in the audit log.
+type AuditEntry struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"request_id"`
+
+	// Principal identifies the caller: "admin", "token:" and the first
+	// eight hex digits of the token's SHA-256, "oidc:" and the subject of
+	// an OIDC token, "scoped:" and the issuer of a scoped token, "cert:"
+	// and the name of a client certificate, or "anonymous".
+	Principal string `json:"principal"`
+	RemoteIP  string `json:"remote_ip"`
+
+	// Action names the operation, such as "object.put" or
+	// "bucket.policy.put".
+	Action string `json:"action"`
+	Method string `json:"method"`
+	Bucket string `json:"bucket,omitempty"`
+	Key    string `json:"key,omitempty"`
+	Query  string `json:"query,omitempty"`
+
+	Status int  `json:"status"`
+	OK     bool `json:"ok"`
+}
+
+// auditLog appends entries to a JSON lines file. The file is opened for
+// every entry, so it can be rotated by renaming it.
+type auditLog struct {
+	path string
+	mu   sync.Mutex
+}
+
+func (a *auditLog) append(entry AuditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	file, err := os.OpenFile(a.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// auditRequests records every request that may change state in the audit
+// log once it has been answered, including requests that were rejected.
+func (s *StorageServer) auditRequests(next http.Handler) http.Handler {
+	if s.audit == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !audited(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+
+		remoteIP := r.RemoteAddr
+		if addr, ok := s.clientAddr(r); ok {
+			remoteIP = addr.String()
+		}
+		bucketName, objectKey := auditTarget(r.URL.Path)
+		entry := AuditEntry{
+			Time:      time.Now().UTC(),
+			RequestID: RequestIDFromContext(r.Context()),
+			Principal: s.principal(r),
+			RemoteIP:  remoteIP,
+			Action:    auditAction(r),
+			Method:    r.Method,
+			Bucket:    bucketName,
+			Key:       objectKey,
+			Query:     r.URL.RawQuery,
+			Status:    recorder.status,
+			OK:        recorder.status < 400,
+		}
+		if err := s.audit.append(entry); err != nil {
+			s.logger.Error("failed to write audit log entry", "error", err, "request_id", entry.RequestID)
+		}
+	})
+}
+
+// audited reports whether a request may change state. Reads, health checks
+// and the lookups and selects sent as POST are not recorded.
+func audited(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	if isHealthPath(r.URL.Path) || r.URL.Path == "/admin/audit" {
+		return false
+	}
+	return !readOnlyPost(r)
+}
+
+// auditTarget returns the bucket and key a request path addresses.
+func auditTarget(path string) (bucketName, objectKey string) {
+	for _, prefix := range []string{"/objects/", "/buckets/", "/trash/"} {
+		if rest, ok := strings.CutPrefix(path, prefix); ok {
+			bucketName, objectKey, _ = strings.Cut(rest, "/")
+			if prefix == "/buckets/" {
+				// /buckets/{name}/usage is not a key.
+				objectKey = ""
+			}
+			return bucketName, objectKey
+		}
+	}
+	return "", ""
+}
+
+// auditAction names the operation of a request from its path, its
+// subresource query parameter and its method.
+func auditAction(r *http.Request) string {
+	path := r.URL.Path
+	verb := strings.ToLower(r.Method)
+
+	// The first query parameter naming a subresource refines the action,
+	// as in object.rename or bucket.policy.put.
+	var subresource string
+	keys := make([]string, 0, len(r.URL.Query()))
+	for key := range r.URL.Query() {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		switch key {
+		case "prefix", "position", "part-number", "upload-id", "dry_run", "max_age", "template", "location":
+			continue
+		}
+		subresource = key
+		break
+	}
+
+	switch {
+	case strings.HasPrefix(path, "/objects/"):
+		bucketName, objectKey := auditTarget(path)
+		switch {
+		case objectKey == "" && r.Method == http.MethodDelete:
+			return "object.delete-prefix"
+		case objectKey == "" && isFormUpload(r):
+			return "object.form-upload"
+		case r.URL.Query().Has("uploads"):
+			return "object.multipart.create"
+		case r.URL.Query().Has("upload-id"):
+			return "object.multipart." + map[string]string{
+				http.MethodPut:    "part",
+				http.MethodPost:   "complete",
+				http.MethodDelete: "abort",
+			}[r.Method]
+		case subresource != "":
+			return "object." + subresource
+		case r.Method == http.MethodPatch:
+			return "object.metadata.update"
+		case bucketName != "" && objectKey != "":
+			return "object." + verb
+		}
+	case strings.HasPrefix(path, "/buckets/"):
+		if subresource != "" {
+			return "bucket." + subresource + "." + verb
+		}
+		if r.Method == http.MethodPut {
+			return "bucket.create"
+		}
+		return "bucket." + verb
+	case strings.HasPrefix(path, "/trash/"):
+		if subresource != "" {
+			return "trash." + subresource
+		}
+		return "trash." + verb
+	case path == "/auth/token":
+		return "auth.token.issue"
+	case isAdminPath(path):
+		return "admin." + strings.ReplaceAll(strings.TrimPrefix(path, "/admin/"), "/", ".")
+	}
+	return verb + " " + path
+}
+
+// handleAudit serves GET /admin/audit, which returns audit log entries in
+// the order they were written, filtered by bucket, principal and time.
+func (s *StorageServer) handleAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if s.audit == nil {
+		s.writeErrorCode(w, r, http.StatusNotFound, "AuditLogDisabled", "No audit_log is configured")
+		return
+	}
+
+	query := r.URL.Query()
+	bucketName := query.Get("bucket")
+	principal := query.Get("principal")
+
+	var since, until time.Time
+	for name, t := range map[string]*time.Time{"since": &since, "until": &until} {
+		if v := query.Get(name); v != "" {
+			parsed, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				s.writeError(w, r, http.StatusBadRequest, fmt.Sprintf("%s must be an RFC 3339 timestamp", name))
+				return
+			}
+			*t = parsed
+		}
+	}
+
+	limit := defaultAuditQueryLimit
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxAuditQueryLimit {
+			s.writeError(w, r, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxAuditQueryLimit))
+			return
+		}
+		limit = n
+	}
+
+	file, err := os.Open(s.audit.path)
+	if os.IsNotExist(err) {
+		file = nil
+	} else if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	entries := []AuditEntry{}
+	truncated := false
+	if file != nil {
+		defer file.Close()
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64<<10), 1<<20)
+		for scanner.Scan() {
+			var entry AuditEntry
+			if json.Unmarshal(scanner.Bytes(), &entry) != nil {
+				continue
+			}
+			switch {
+			case bucketName != "" && entry.Bucket != bucketName,
+				principal != "" && entry.Principal != principal,
+				!since.IsZero() && entry.Time.Before(since),
+				!until.IsZero() && !entry.Time.Before(until):
+				continue
+			}
+			if len(entries) == limit {
+				truncated = true
+				break
+			}
+			entries = append(entries, entry)
+		}
+		if err := scanner.Err(); err != nil {
+			s.writeError(w, r, http.StatusInternalServerError, fmt.Sprintf("failed to read audit log: %v", err))
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"entries":   entries,
+		"truncated": truncated,
+	})
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// AuthConfig turns on authentication for the API. Requests must then send
+// one of Tokens (or the admin token), or a token from the OIDC provider, as
+// a bearer token, unless the policy of the bucket they address allows
+// anonymous access.
+type AuthConfig struct {
+	Tokens []string `json:"tokens"`
+
+	// OIDC, when set, also accepts tokens from an OpenID Connect provider.
+	OIDC *OIDCConfig `json:"oidc,omitempty"`
+
+	// ClientCerts, when set, authenticates requests without a bearer token
+	// by their verified client certificate.
+	ClientCerts *ClientCertConfig `json:"client_certs,omitempty"`
+}
+
+func (auth *AuthConfig) validate() error {
+	if auth == nil {
+		return nil
+	}
+	for _, token := range auth.Tokens {
+		if token == "" {
+			return fmt.Errorf("auth tokens must not be empty")
+		}
+	}
+	if err := auth.OIDC.validate(); err != nil {
+		return err
+	}
+	return auth.ClientCerts.validate()
+}
+
+// Anonymous access levels of a bucket policy.
+const (
+	anonymousNone      = "none"
+	anonymousRead      = "read"
+	anonymousReadWrite = "read-write"
+)
+
+// BucketPolicy controls what requests without credentials may do in a
+// bucket when authentication is enabled.
+type BucketPolicy struct {
+	// Anonymous is "none", "read" (download, stat and list objects and
+	// serve the bucket's website) or "read-write" (also upload, rename and
+	// delete objects).
+	Anonymous string `json:"anonymous"`
+}
+
+func (policy *BucketPolicy) validate() error {
+	if policy == nil {
+		return nil
+	}
+	switch policy.Anonymous {
+	case anonymousNone, anonymousRead, anonymousReadWrite:
+		return nil
+	}
+	return fmt.Errorf("policy anonymous must be none, read or read-write")
+}
+
+// anonymous returns the anonymous access level, which is none without a
+// policy.
+func (policy *BucketPolicy) anonymous() string {
+	if policy == nil {
+		return anonymousNone
+	}
+	return policy.Anonymous
+}
+
+// handleBucketPolicy serves GET, PUT and DELETE on /buckets/{name}?policy.
+func (s *StorageServer) handleBucketPolicy(w http.ResponseWriter, r *http.Request) {
+	bucketName := strings.TrimPrefix(r.URL.Path, "/buckets/")
+
+	var policy *BucketPolicy
+	switch r.Method {
+	case http.MethodGet, http.MethodDelete:
+	case http.MethodPut:
+		policy = &BucketPolicy{}
+		if err := json.NewDecoder(r.Body).Decode(policy); err != nil {
+			s.writeError(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid bucket policy: %v", err))
+			return
+		}
+		if err := policy.validate(); err != nil {
+			s.writeError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+	default:
+		s.writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var bucket Bucket
+	var err error
+	if r.Method == http.MethodGet {
+		bucket, err = s.storage.GetBucket(bucketName)
+	} else {
+		bucket, err = s.storage.UpdateBucketSettings(bucketName, func(settings *BucketSettings) error {
+			settings.Policy = policy
+			return nil
+		})
+	}
+	if err != nil {
+		s.writeStorageError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]*BucketPolicy{"policy": bucket.Settings.Policy})
+}
+
+// requireAuth rejects requests without valid credentials when
+// authentication is enabled, except health checks and requests the target
+// bucket's policy allows anonymously.
+func (s *StorageServer) requireAuth(next http.Handler) http.Handler {
+	if s.config.Auth == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isHealthPath(r.URL.Path) || s.authenticated(r) || s.anonymousAllowed(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if identity := s.roleIdentity(r); identity != nil {
+			if identity.allows(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			s.writeErrorCode(w, r, http.StatusForbidden, "AccessDenied", "Your role does not allow this request")
+			return
+		}
+		if scope := s.scopedToken(r); scope != nil {
+			if scope.allows(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			s.writeErrorCode(w, r, http.StatusForbidden, "AccessDenied", "The token's scope does not cover this request")
+			return
+		}
+		w.Header().Set("WWW-Authenticate", `Bearer realm="storage"`)
+		s.writeErrorCode(w, r, http.StatusUnauthorized, "Unauthorized", "Credentials required")
+	})
+}
+
+// authenticated reports whether r carries one of the configured tokens or
+// the admin token.
+func (s *StorageServer) authenticated(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return false
+	}
+
+	match := 0
+	for _, want := range s.config.Auth.Tokens {
+		match |= subtle.ConstantTimeCompare([]byte(token), []byte(want))
+	}
+	if s.config.AdminToken != "" {
+		match |= subtle.ConstantTimeCompare([]byte(token), []byte(s.config.AdminToken))
+	}
+	return match == 1
+}
+
+// principal identifies the caller of a request for the audit log without
+// revealing its token: "admin", "token:" and the first eight hex digits of
+// the token's SHA-256, "oidc:" and the subject of an OIDC token, "scoped:"
+// and the issuer of a scoped token, "cert:" and the name of a client
+// certificate, or "anonymous".
+func (s *StorageServer) principal(r *http.Request) string {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		if identity := s.certIdentity(r); identity != nil {
+			return "cert:" + identity.Subject
+		}
+		return "anonymous"
+	}
+	if s.config.AdminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.config.AdminToken)) == 1 {
+		return "admin"
+	}
+	if identity := s.oidcIdentity(r); identity != nil {
+		return "oidc:" + identity.Subject
+	}
+	if scope := s.scopedToken(r); scope != nil {
+		return "scoped:" + scope.Subject
+	}
+	sum := sha256.Sum256([]byte(token))
+	return "token:" + hex.EncodeToString(sum[:4])
+}
+
+// anonymousAllowed reports whether the policy of the bucket a request
+// addresses lets it through without credentials. Only object and website
+// requests can be anonymous; bucket settings, search and admin endpoints
+// always need credentials.
+func (s *StorageServer) anonymousAllowed(r *http.Request) bool {
+	// Browser uploads are authorized by their signed policy, which the
+	// upload handler verifies.
+	if path, ok := strings.CutPrefix(r.URL.Path, "/objects/"); ok && !strings.Contains(path, "/") && isFormUpload(r) {
+		return true
+	}
+
+	// Signed listing and object links carry their own authorization, which
+	// the listing and signed object handlers verify.
+	if r.URL.Query().Has("signature") && signedLinkRequest(r) {
+		return true
+	}
+
+	bucketName, write, ok := anonymousTarget(r)
+	if !ok {
+		return false
+	}
+
+	bucket, err := s.backend.GetBucket(bucketName)
+	if err != nil {
+		return false
+	}
+	switch bucket.Settings.Policy.anonymous() {
+	case anonymousReadWrite:
+		return true
+	case anonymousRead:
+		return !write
+	}
+	return false
+}
+
+// signedLinkRequest reports whether r goes to a handler that verifies a
+// signed link: a GET of a bucket listing, or a GET, HEAD or PUT of an
+// object. Other requests with a signature, such as the bulk lookups, do not
+// check it and need credentials or a bucket policy like any other.
+func signedLinkRequest(r *http.Request) bool {
+	path, ok := strings.CutPrefix(r.URL.Path, "/objects/")
+	if !ok {
+		return false
+	}
+	bucketName, _, hasKey := strings.Cut(path, "/")
+	if bucketName == "" {
+		return false
+	}
+	if !hasKey {
+		return r.Method == http.MethodGet
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodPut:
+		return true
+	}
+	return false
+}
+
+// readOnlyPost reports whether r is a POST that only reads: the bulk ETag
+// and stat lookups of a bucket or a select over an object.
+func readOnlyPost(r *http.Request) bool {
+	if r.Method != http.MethodPost {
+		return false
+	}
+	query := r.URL.Query()
+	return query.Has("etags") || query.Has("stat") || query.Has("select")
+}
+
+// anonymousTarget returns the bucket an object or website request
+// addresses and whether it modifies the bucket. ok is false for requests
+// that are never allowed anonymously.
+func anonymousTarget(r *http.Request) (bucketName string, write, ok bool) {
+	read := r.Method == http.MethodGet || r.Method == http.MethodHead
+	query := r.URL.Query()
+
+	if path, found := strings.CutPrefix(r.URL.Path, "/website/"); found {
+		bucketName, _, _ = strings.Cut(path, "/")
+		return bucketName, false, read
+	}
+
+	path, found := strings.CutPrefix(r.URL.Path, "/objects/")
+	if !found {
+		return "", false, false
+	}
+	bucketName, _, hasKey := strings.Cut(path, "/")
+	switch {
+	case bucketName == "":
+		return "", false, false
+	case !hasKey:
+		// Listings, including the bulk ETag and stat lookups.
+		return bucketName, false, read || readOnlyPost(r)
+	case query.Has("retention") || query.Has("legal-hold"):
+		// Object lock is only ever changed with credentials.
+		return bucketName, false, read
+	case readOnlyPost(r):
+		return bucketName, false, true
+	}
+	return bucketName, !read, true
+}
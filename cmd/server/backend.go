@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+)
+
+// Backend stores the buckets and objects served by the API. The handlers
+// for buckets and plain object reads, writes, deletes and listings only use
+// this interface, so any implementation can serve them.
+//
+// ObjectStorage, the filesystem backend, also provides the features that
+// work on its files directly, such as the trash, object lock, dedup and
+// garbage collection. Those endpoints answer 501 on other backends.
+type Backend interface {
+	CreateBucket(bucketName, template string, owner BucketOwner, settings BucketSettings) error
+	GetBucket(bucketName string) (Bucket, error)
+	ListBuckets() ([]Bucket, error)
+
+	// PutObject stores an object, replacing any object with the same key.
+	PutObject(bucketName, objectKey string, data io.Reader, opts PutOptions) (*ObjectMetadata, error)
+
+	// GetObject returns the data of an object with the metadata describing
+	// that exact version.
+	GetObject(bucketName, objectKey string) (io.ReadCloser, *ObjectMetadata, error)
+
+	// StatObject returns the metadata of an object.
+	StatObject(bucketName, objectKey string) (*ObjectMetadata, error)
+
+	DeleteObject(bucketName, objectKey string) error
+
+	// WalkObjects calls fn for every object in a bucket, in key order.
+	// Returning an error from fn stops the walk and returns that error.
+	WalkObjects(bucketName string, fn func(ObjectMetadata) error) error
+
+	// WalkKeyRange calls fn for the objects of a bucket in keys, in key
+	// order or its reverse with keys.Desc, seeking to where the range
+	// starts so a listing page need not walk the whole bucket.
+	WalkKeyRange(bucketName string, keys KeyRange, fn func(ObjectMetadata) error) error
+}
+
+const backendFilesystem = "filesystem"
+
+func (config *Config) validateBackend() error {
+	switch config.Backend {
+	case backendFilesystem:
+		return nil
+	case backendMemory, backendS3:
+		// These change how the filesystem backend lays out files.
+		if len(config.DataDirs) > 0 || config.KMS != nil || config.Compression != nil || config.Dedup != nil || config.Erasure != nil {
+			return fmt.Errorf("data_dirs, kms, compression, dedup and erasure require the filesystem backend")
+		}
+		if config.MetadataStore != metadataStoreFiles {
+			return fmt.Errorf("metadata_store requires the filesystem backend")
+		}
+		if config.Backend == backendS3 {
+			return config.S3.validate()
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown backend %q (use filesystem, memory or s3)", config.Backend)
+}
+
+// NewBackend builds the backend selected by config.Backend.
+func NewBackend(config *Config, logger *slog.Logger) (Backend, error) {
+	switch config.Backend {
+	case backendFilesystem:
+		return newFilesystemBackend(config, logger)
+	case backendMemory:
+		return newMemoryBackend(), nil
+	case backendS3:
+		return newGatewayBackend(config.S3)
+	}
+	return nil, fmt.Errorf("unknown backend %q", config.Backend)
+}
+
+// newFilesystemBackend opens the object store in config.DataDir with the
+// storage features the config enables and loads its indexes.
+func newFilesystemBackend(config *Config, logger *slog.Logger) (*ObjectStorage, error) {
+	storage := NewObjectStorage(config.DataDir, logger.With("component", "storage"))
+	if config.MetadataStore == metadataStoreKV {
+		store, err := openKVMetadataStore(filepath.Join(storage.metadataDir, kvMetadataFile), storage.logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open metadata store: %w", err)
+		}
+		storage.metadata = store
+	}
+	for _, dir := range config.DataDirs {
+		if err := storage.AddDataRoot(dir); err != nil {
+			return nil, err
+		}
+	}
+
+	var err error
+	storage.kms, err = NewKMS(config.KMS)
+	if err != nil {
+		return nil, err
+	}
+	storage.compression = config.Compression
+	if config.Dedup != nil {
+		storage.chunks = newChunkStore(filepath.Join(config.DataDir, "chunks"), config.Dedup.ChunkSize)
+	}
+	if config.Erasure != nil {
+		storage.erasure, err = newErasureStore(config.Erasure, logger.With("component", "erasure"))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := storage.loadIndexes(); err != nil {
+		return nil, fmt.Errorf("failed to load object indexes: %w", err)
+	}
+	return storage, nil
+}
+
+// StatObject returns the metadata of an object.
+func (storage *ObjectStorage) StatObject(bucketName, objectKey string) (*ObjectMetadata, error) {
+	metadata, err := storage.loadObjectMetadata(bucketName, objectKey)
+	if storage.IsNotExist(err) {
+		return nil, fmt.Errorf("object not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load metadata: %w", err)
+	}
+	return metadata, nil
+}
+
+// requireFilesystem wraps the handler of a feature that only the
+// filesystem backend provides.
+func (s *StorageServer) requireFilesystem(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.storage == nil {
+			s.writeNotSupported(w, r)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func (s *StorageServer) writeNotSupported(w http.ResponseWriter, r *http.Request) {
+	s.writeErrorCode(w, r, http.StatusNotImplemented, "NotImplemented", fmt.Sprintf("Not supported by the %s backend", s.config.Backend))
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// What a bandwidth limit is shared by.
+const (
+	bandwidthPerClient     = "client"     // requests with the same credentials, or from the same address without any
+	bandwidthPerConnection = "connection" // requests on the same connection
+)
+
+// maxThrottleChunk caps how much is read or written between waits, so
+// transfers stay smooth even with a large burst.
+const maxThrottleChunk = 64 << 10
+
+// BandwidthConfig limits how fast each client can upload and download, so a
+// single bulk transfer cannot saturate the network.
+type BandwidthConfig struct {
+	// UploadBytesPerSecond limits request bodies; 0 is unlimited.
+	UploadBytesPerSecond int64 `json:"upload_bytes_per_second,omitempty"`
+
+	// DownloadBytesPerSecond limits response bodies; 0 is unlimited.
+	DownloadBytesPerSecond int64 `json:"download_bytes_per_second,omitempty"`
+
+	// Per is client (the default) or connection.
+	Per string `json:"per,omitempty"`
+
+	// Exempt lists principals, as recorded in the audit log, that are not
+	// limited, such as "admin" or "token:ba7816bf".
+	Exempt []string `json:"exempt,omitempty"`
+}
+
+func (c *BandwidthConfig) validate() error {
+	if c == nil {
+		return nil
+	}
+	if c.UploadBytesPerSecond < 0 || c.DownloadBytesPerSecond < 0 {
+		return fmt.Errorf("bandwidth limits must not be negative")
+	}
+	switch c.Per {
+	case "", bandwidthPerClient, bandwidthPerConnection:
+	default:
+		return fmt.Errorf("bandwidth per must be client or connection")
+	}
+	return nil
+}
+
+// tokenBucket paces a byte stream to rate bytes per second, allowing bursts
+// of up to one second's worth.
+type tokenBucket struct {
+	rate float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate int64) *tokenBucket {
+	return &tokenBucket{rate: float64(rate), tokens: float64(rate), last: time.Now()}
+}
+
+// wait takes n bytes from the bucket, sleeping until the bucket has
+// refilled enough to cover them or ctx is done.
+func (b *tokenBucket) wait(ctx context.Context, n int) error {
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens = min(b.tokens+now.Sub(b.last).Seconds()*b.rate, b.rate)
+	b.last = now
+	b.tokens -= float64(n)
+	delay := time.Duration(-b.tokens / b.rate * float64(time.Second))
+	b.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// chunk returns how much may be moved before the next wait.
+func (b *tokenBucket) chunk(n int) int {
+	return min(n, maxThrottleChunk, max(int(b.rate), 1))
+}
+
+// bandwidthLimits are the token buckets shared by the requests of one
+// client or connection.
+type bandwidthLimits struct {
+	upload   *tokenBucket
+	download *tokenBucket
+	requests int
+}
+
+// bandwidthThrottle hands out the limits of each client. Limits are dropped
+// once a client has no requests in flight.
+type bandwidthThrottle struct {
+	config *BandwidthConfig
+
+	mu      sync.Mutex
+	clients map[string]*bandwidthLimits
+}
+
+func newBandwidthThrottle(config *BandwidthConfig) *bandwidthThrottle {
+	return &bandwidthThrottle{config: config, clients: make(map[string]*bandwidthLimits)}
+}
+
+func (t *bandwidthThrottle) acquire(key string) *bandwidthLimits {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	limits, ok := t.clients[key]
+	if !ok {
+		limits = &bandwidthLimits{}
+		if t.config.UploadBytesPerSecond > 0 {
+			limits.upload = newTokenBucket(t.config.UploadBytesPerSecond)
+		}
+		if t.config.DownloadBytesPerSecond > 0 {
+			limits.download = newTokenBucket(t.config.DownloadBytesPerSecond)
+		}
+		t.clients[key] = limits
+	}
+	limits.requests++
+	return limits
+}
+
+func (t *bandwidthThrottle) release(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if limits := t.clients[key]; limits != nil {
+		limits.requests--
+		if limits.requests == 0 {
+			delete(t.clients, key)
+		}
+	}
+}
+
+// throttledBody paces reads of a request body.
+type throttledBody struct {
+	io.ReadCloser
+	ctx    context.Context
+	bucket *tokenBucket
+}
+
+func (b *throttledBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p[:b.bucket.chunk(len(p))])
+	if n > 0 {
+		if waitErr := b.bucket.wait(b.ctx, n); waitErr != nil && err == nil {
+			err = waitErr
+		}
+	}
+	return n, err
+}
+
+// throttledWriter paces writes of a response body.
+type throttledWriter struct {
+	http.ResponseWriter
+	ctx    context.Context
+	bucket *tokenBucket
+}
+
+func (w *throttledWriter) Write(data []byte) (int, error) {
+	written := 0
+	for written < len(data) {
+		chunk := w.bucket.chunk(len(data) - written)
+		if err := w.bucket.wait(w.ctx, chunk); err != nil {
+			return written, err
+		}
+		n, err := w.ResponseWriter.Write(data[written : written+chunk])
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+func (w *throttledWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// throttleBandwidth paces request and response bodies to the configured
+// bandwidth of the client. Health checks are not limited.
+func (s *StorageServer) throttleBandwidth(next http.Handler) http.Handler {
+	if s.bandwidth == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isHealthPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		principal := s.principal(r)
+		for _, exempt := range s.config.Bandwidth.Exempt {
+			if principal == exempt {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		key := "client " + principal
+		switch {
+		case s.config.Bandwidth.Per == bandwidthPerConnection:
+			key = "connection " + r.RemoteAddr
+		case principal == "anonymous":
+			if addr, ok := s.clientAddr(r); ok {
+				key = "address " + addr.String()
+			}
+		}
+		limits := s.bandwidth.acquire(key)
+		defer s.bandwidth.release(key)
+
+		if limits.upload != nil && r.Body != nil && r.Body != http.NoBody {
+			r.Body = &throttledBody{ReadCloser: r.Body, ctx: r.Context(), bucket: limits.upload}
+		}
+		if limits.download != nil {
+			w = &throttledWriter{ResponseWriter: w, ctx: r.Context(), bucket: limits.download}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Per-request usage headers let automated clients throttle themselves
+// before they run into limits.
+const (
+	requestClassHeader          = "X-Request-Class"
+	bytesBilledHeader           = "X-Bytes-Billed"
+	quotaRemainingBytesHeader   = "X-Quota-Remaining-Bytes"
+	quotaRemainingObjectsHeader = "X-Quota-Remaining-Objects"
+)
+
+// Request classes: class A covers writes and listings, class B object reads;
+// deletes and health checks are free.
+const (
+	requestClassA     = "A"
+	requestClassB     = "B"
+	requestClassAdmin = "admin"
+	requestClassFree  = "free"
+)
+
+func requestClass(r *http.Request) string {
+	path := r.URL.Path
+	switch {
+	case isHealthPath(path):
+		return requestClassFree
+	case isAdminPath(path):
+		return requestClassAdmin
+	case r.Method == http.MethodDelete:
+		return requestClassFree
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		if rest, ok := strings.CutPrefix(path, "/objects/"); ok && strings.Contains(rest, "/") {
+			return requestClassB
+		}
+		if strings.HasPrefix(path, "/content/") || strings.HasPrefix(path, "/website/") {
+			return requestClassB
+		}
+		if rest, ok := strings.CutPrefix(path, "/buckets/"); ok && r.Method == http.MethodHead && !strings.Contains(rest, "/") {
+			return requestClassB
+		}
+	}
+	return requestClassA
+}
+
+// QuotaRemaining returns how many bytes and objects can still be written to
+// a bucket, or ok=false if it has no quota. A zero limit is unlimited and
+// reported as -1.
+func (storage *ObjectStorage) QuotaRemaining(bucketName string) (bytes, objects int64, ok bool) {
+	storage.bucketMu.RLock()
+	defer storage.bucketMu.RUnlock()
+
+	bucket, err := storage.GetBucket(bucketName)
+	if err != nil || bucket.Settings.Quota == nil {
+		return 0, 0, false
+	}
+
+	usage, err := storage.bucketUsage(&bucket)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	quota := bucket.Settings.Quota
+	bytes, objects = -1, -1
+	if quota.MaxBytes > 0 {
+		bytes = max(quota.MaxBytes-usage.Bytes, 0)
+	}
+	if quota.MaxObjects > 0 {
+		objects = max(quota.MaxObjects-usage.Objects, 0)
+	}
+	return bytes, objects, true
+}
+
+type countingBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+// usageWriter adds the usage headers just before the response headers are
+// sent, when the request body has been consumed and the response size is
+// known.
+type usageWriter struct {
+	http.ResponseWriter
+	s           *StorageServer
+	r           *http.Request
+	body        *countingBody
+	wroteHeader bool
+}
+
+func (w *usageWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.setUsageHeaders()
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *usageWriter) Write(data []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *usageWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *usageWriter) setUsageHeaders() {
+	header := w.Header()
+
+	billed := w.body.n
+	if w.r.Method != http.MethodHead {
+		if length, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64); err == nil {
+			billed += length
+		}
+	}
+	header.Set(bytesBilledHeader, strconv.FormatInt(billed, 10))
+
+	rest, ok := strings.CutPrefix(w.r.URL.Path, "/objects/")
+	if !ok {
+		return
+	}
+	bucketName, _, _ := strings.Cut(rest, "/")
+	if w.s.storage == nil {
+		return
+	}
+	if bytes, objects, ok := w.s.storage.QuotaRemaining(bucketName); ok {
+		header.Set(quotaRemainingBytesHeader, strconv.FormatInt(bytes, 10))
+		header.Set(quotaRemainingObjectsHeader, strconv.FormatInt(objects, 10))
+	}
+}
+
+// usageHeaders reports the request class, billed bytes and remaining bucket
+// quota on every response.
+func (s *StorageServer) usageHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(requestClassHeader, requestClass(r))
+
+		body := &countingBody{ReadCloser: r.Body}
+		r.Body = body
+
+		next.ServeHTTP(&usageWriter{ResponseWriter: w, s: s, r: r, body: body}, r)
+	})
+}
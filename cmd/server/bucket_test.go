@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestRecreateBucketKeepsSettings creates an existing bucket again, plainly
+// and from a template. Neither may drop settings the request does not set,
+// nor reset the creation time or usage.
+func TestRecreateBucketKeepsSettings(t *testing.T) {
+	storage := newTestStorage(t)
+	config := defaultConfig()
+	config.BucketTemplates = map[string]BucketSettings{"cached": {CacheControl: "max-age=60"}}
+	handler := NewStorageServer(storage, config, discardLogger()).Handler()
+
+	_, err := storage.UpdateBucketSettings("it", func(settings *BucketSettings) error {
+		settings.Quota = &BucketQuota{MaxBytes: 10}
+		settings.Labels = map[string]string{"team": "storage"}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	putString(t, storage, "it", "a", "12345")
+	before, err := storage.GetBucket("it")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, target := range []string{"/buckets/it", "/buckets/it?template=cached"} {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPut, target, nil))
+		if recorder.Code != http.StatusCreated {
+			t.Fatalf("PUT %s: %d %s", target, recorder.Code, recorder.Body)
+		}
+	}
+
+	after, err := storage.GetBucket("it")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Settings.Quota == nil || after.Settings.Quota.MaxBytes != 10 || after.Settings.Labels["team"] != "storage" {
+		t.Errorf("settings were dropped: %+v", after.Settings)
+	}
+	if after.Settings.CacheControl != "max-age=60" || after.Template != "cached" {
+		t.Errorf("template was not applied: %+v", after)
+	}
+	if !after.Created.Equal(before.Created) {
+		t.Errorf("created changed from %s to %s", before.Created, after.Created)
+	}
+	if after.Usage == nil || before.Usage == nil || after.Usage.Objects != before.Usage.Objects || after.Usage.Bytes != before.Usage.Bytes {
+		t.Errorf("usage changed from %+v to %+v", before.Usage, after.Usage)
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPut, "/objects/it/b", strings.NewReader("1234567890123456")))
+	if recorder.Code == http.StatusOK {
+		t.Error("an upload over the quota succeeded after the bucket was created again")
+	}
+}
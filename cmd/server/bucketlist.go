@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	// bucketTenantHeader names the tenant a bucket is created for, and is
+	// returned with bucketOwnerHeader by HEAD /buckets/{bucket}.
+	bucketTenantHeader = "X-Bucket-Tenant"
+	bucketOwnerHeader  = "X-Bucket-Owner"
+)
+
+// BucketOwner records who a bucket belongs to. Owner is the principal that
+// created it and is empty for anonymous requests; Tenant is whatever the
+// creator sent in X-Bucket-Tenant. Both are fixed at creation.
+type BucketOwner struct {
+	Owner  string `json:"owner,omitempty"`
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// keepOwner carries the owner of an existing bucket over into next, so
+// re-creating a bucket never changes who it belongs to.
+func keepOwner(current BucketOwner, next *BucketOwner) {
+	if current != (BucketOwner{}) {
+		*next = current
+	}
+}
+
+// requestedOwner returns the owner recorded for a bucket created by r.
+func (s *StorageServer) requestedOwner(r *http.Request) BucketOwner {
+	owner := BucketOwner{Tenant: r.Header.Get(bucketTenantHeader)}
+	if principal := s.principal(r); principal != "anonymous" {
+		owner.Owner = principal
+	}
+	return owner
+}
+
+// bucketListRequest is a page of the bucket list as selected by the prefix,
+// owner, tenant, max-keys and continuation-token query parameters.
+type bucketListRequest struct {
+	prefix  string
+	owner   BucketOwner
+	after   string
+	maxKeys int // 0 returns every remaining bucket
+}
+
+func parseBucketListRequest(query url.Values) (bucketListRequest, error) {
+	req := bucketListRequest{
+		prefix: query.Get("prefix"),
+		owner:  BucketOwner{Owner: query.Get("owner"), Tenant: query.Get("tenant")},
+	}
+
+	if v := query.Get("max-keys"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxListKeys {
+			return req, fmt.Errorf("max-keys must be between 1 and %d", maxListKeys)
+		}
+		req.maxKeys = n
+	}
+
+	if token := query.Get("continuation-token"); token != "" {
+		cursor, err := decodeContinuationToken(token)
+		if err != nil {
+			return req, err
+		}
+		if cursor.Prefix != req.prefix || cursor.Owner != req.owner.Owner || cursor.Tenant != req.owner.Tenant {
+			return req, errInvalidContinuationToken
+		}
+		req.after = cursor.After
+	}
+	return req, nil
+}
+
+func (req bucketListRequest) matches(bucket Bucket) bool {
+	return strings.HasPrefix(bucket.Name, req.prefix) && bucket.Name > req.after &&
+		(req.owner.Owner == "" || bucket.Owner == req.owner.Owner) &&
+		(req.owner.Tenant == "" || bucket.Tenant == req.owner.Tenant)
+}
+
+// handleListBuckets serves GET /buckets. Buckets are listed by name with
+// their object and byte counts. With max-keys the list is paged like object
+// listings: X-Next-Continuation-Token is set while more buckets remain.
+func (s *StorageServer) handleListBuckets(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	req, err := parseBucketListRequest(r.URL.Query())
+	if err != nil {
+		s.writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	all, err := s.backend.ListBuckets()
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	slices.SortFunc(all, func(a, b Bucket) int { return strings.Compare(a.Name, b.Name) })
+
+	buckets := []Bucket{}
+	for _, bucket := range all {
+		if !req.matches(bucket) {
+			continue
+		}
+		if req.maxKeys > 0 && len(buckets) == req.maxKeys {
+			last := buckets[len(buckets)-1].Name
+			w.Header().Set(continuationTokenHeader, encodeContinuationToken(listCursor{
+				After: last, Prefix: req.prefix, Owner: req.owner.Owner, Tenant: req.owner.Tenant,
+			}))
+			break
+		}
+		buckets = append(buckets, bucket)
+	}
+
+	// Usage is only counted for the buckets on this page, since backends
+	// without tracked usage walk every object to count it.
+	for i := range buckets {
+		usage, err := s.bucketUsageReport(buckets[i].Name)
+		if err != nil {
+			s.writeStorageError(w, r, err)
+			return
+		}
+		buckets[i].Usage = &usage
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(buckets)
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const maxBulkKeys = 10000
+
+type bulkKeysRequest struct {
+	Keys []string `json:"keys"`
+}
+
+// BulkStatResponse is returned by POST /objects/{bucket}?stat.
+type BulkStatResponse struct {
+	Objects map[string]ObjectMetadata `json:"objects"`
+	Missing []string                  `json:"missing"`
+}
+
+func (s *StorageServer) decodeBulkKeys(w http.ResponseWriter, r *http.Request) ([]string, bool) {
+	var req bulkKeysRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeError(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid request body: %v", err))
+		return nil, false
+	}
+
+	if len(req.Keys) > maxBulkKeys {
+		s.writeError(w, r, http.StatusBadRequest, fmt.Sprintf("At most %d keys per request", maxBulkKeys))
+		return nil, false
+	}
+	for _, key := range req.Keys {
+		if err := validateObjectKey(key); err != nil {
+			s.writeStorageError(w, r, err)
+			return nil, false
+		}
+	}
+	return req.Keys, true
+}
+
+// handleBulkETags serves POST /objects/{bucket}?etags. The body is
+// {"keys": [...]} and the response maps every existing key to its ETag;
+// keys that do not exist are omitted.
+func (s *StorageServer) handleBulkETags(w http.ResponseWriter, r *http.Request) {
+	bucketName := strings.TrimPrefix(r.URL.Path, "/objects/")
+
+	keys, ok := s.decodeBulkKeys(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := s.backend.GetBucket(bucketName); err != nil {
+		s.writeStorageError(w, r, err)
+		return
+	}
+
+	etags := make(map[string]string, len(keys))
+	for _, key := range keys {
+		metadata, err := s.backend.StatObject(bucketName, key)
+		if err != nil {
+			continue
+		}
+		etags[key] = metadata.ETag
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(etags)
+}
+
+// handleBulkStat serves POST /objects/{bucket}?stat, the batch equivalent
+// of HEAD: it returns the metadata of every requested key and lists the
+// keys that do not exist.
+func (s *StorageServer) handleBulkStat(w http.ResponseWriter, r *http.Request) {
+	bucketName := strings.TrimPrefix(r.URL.Path, "/objects/")
+
+	keys, ok := s.decodeBulkKeys(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := s.backend.GetBucket(bucketName); err != nil {
+		s.writeStorageError(w, r, err)
+		return
+	}
+
+	response := BulkStatResponse{
+		Objects: make(map[string]ObjectMetadata, len(keys)),
+		Missing: []string{},
+	}
+	for _, key := range keys {
+		metadata, err := s.backend.StatObject(bucketName, key)
+		if err != nil {
+			response.Missing = append(response.Missing, key)
+			continue
+		}
+		response.Objects[key] = *metadata
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// PrefixDeleteResponse is returned by DELETE /objects/{bucket}?prefix=.
+type PrefixDeleteResponse struct {
+	Prefix       string                `json:"prefix"`
+	Deleted      int                   `json:"deleted"`
+	DeletedBytes int64                 `json:"deleted_bytes"`
+	Failed       []PrefixDeleteFailure `json:"failed,omitempty"`
+}
+
+// PrefixDeleteFailure is an object a prefix delete could not remove, such
+// as one under retention.
+type PrefixDeleteFailure struct {
+	Key   string `json:"key"`
+	Error string `json:"error"`
+}
+
+// handleDeletePrefix serves DELETE /objects/{bucket}?prefix={prefix}, which
+// deletes every object whose key starts with prefix. Objects are deleted
+// one by one like single deletes, so the trash, object lock, notifications
+// and replication apply to each; objects that cannot be deleted are listed
+// in the response instead of failing the request.
+func (s *StorageServer) handleDeletePrefix(w http.ResponseWriter, r *http.Request) {
+	bucketName := strings.TrimPrefix(r.URL.Path, "/objects/")
+	prefix := r.URL.Query().Get("prefix")
+	if prefix == "" {
+		s.writeError(w, r, http.StatusBadRequest, "A non-empty prefix is required")
+		return
+	}
+
+	if _, err := s.backend.GetBucket(bucketName); err != nil {
+		s.writeStorageError(w, r, err)
+		return
+	}
+
+	// Keys are collected first so deletes do not run during the walk.
+	var objects []ObjectMetadata
+	err := s.backend.WalkObjects(bucketName, func(metadata ObjectMetadata) error {
+		if strings.HasPrefix(metadata.Key, prefix) {
+			objects = append(objects, metadata)
+		}
+		return nil
+	})
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	result := PrefixDeleteResponse{Prefix: prefix}
+	for _, object := range objects {
+		if err := s.backend.DeleteObject(bucketName, object.Key); err != nil {
+			result.Failed = append(result.Failed, PrefixDeleteFailure{Key: object.Key, Error: err.Error()})
+			continue
+		}
+		result.Deleted++
+		result.DeletedBytes += object.Size
+		s.notify(EventDelete, bucketName, object.Key, nil)
+		s.replicate(r, replicationDelete, bucketName, object.Key)
+	}
+
+	s.logger.Info("deleted prefix", "bucket", bucketName, "prefix", prefix, "deleted", result.Deleted, "failed", len(result.Failed))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
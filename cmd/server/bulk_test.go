@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBulkLookups(t *testing.T) {
+	s, storage := newAuthServer(t)
+	putString(t, storage, "it", "a", "data")
+	handler := s.Handler()
+
+	tooMany := make([]string, maxBulkKeys+1)
+	for i := range tooMany {
+		tooMany[i] = fmt.Sprintf("k%d", i)
+	}
+	tooManyBody, _ := json.Marshal(bulkKeysRequest{Keys: tooMany})
+
+	tests := []struct {
+		name   string
+		token  string
+		body   string
+		status int
+		want   string // part of the response body
+	}{
+		{"no token", "", `{"keys":["a"]}`, http.StatusUnauthorized, ""},
+		{"wrong token", "nope", `{"keys":["a"]}`, http.StatusUnauthorized, ""},
+		{"too many keys", "tok", string(tooManyBody), http.StatusBadRequest, "At most 10000 keys"},
+		{"invalid key", "tok", `{"keys":["a","../escape"]}`, http.StatusBadRequest, `"code":"InvalidKey"`},
+		{"invalid body", "tok", `{"keys":`, http.StatusBadRequest, ""},
+		{"allowed", "tok", `{"keys":["a","missing"]}`, http.StatusOK, ""},
+	}
+	for _, query := range []string{"stat", "etags"} {
+		for _, tt := range tests {
+			t.Run(query+"/"+tt.name, func(t *testing.T) {
+				req := httptest.NewRequest(http.MethodPost, "/objects/it?"+query, strings.NewReader(tt.body))
+				req.Header.Set("Content-Type", "application/json")
+				if tt.token != "" {
+					req.Header.Set("Authorization", "Bearer "+tt.token)
+				}
+				recorder := httptest.NewRecorder()
+				handler.ServeHTTP(recorder, req)
+				if recorder.Code != tt.status {
+					t.Fatalf("got %d %s, want %d", recorder.Code, recorder.Body, tt.status)
+				}
+				if !strings.Contains(recorder.Body.String(), tt.want) {
+					t.Errorf("response %s lacks %s", recorder.Body, tt.want)
+				}
+				if tt.status != http.StatusOK {
+					return
+				}
+
+				var got map[string]any
+				if err := json.Unmarshal(recorder.Body.Bytes(), &got); err != nil {
+					t.Fatal(err)
+				}
+				if query == "etags" {
+					if len(got) != 1 || got["a"] == nil {
+						t.Errorf("ETags %s should hold only a", recorder.Body)
+					}
+					return
+				}
+				var stat BulkStatResponse
+				json.Unmarshal(recorder.Body.Bytes(), &stat)
+				if len(stat.Objects) != 1 || stat.Objects["a"].Size != 4 || len(stat.Missing) != 1 || stat.Missing[0] != "missing" {
+					t.Errorf("stat %s should find a and miss missing", recorder.Body)
+				}
+			})
+		}
+	}
+}
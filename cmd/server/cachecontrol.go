@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxCacheControlLength limits stored Cache-Control values.
+const maxCacheControlLength = 1024
+
+// validateCacheControl checks a Cache-Control value before it is stored
+// and later sent as a header.
+func validateCacheControl(value string) error {
+	if len(value) > maxCacheControlLength {
+		return fmt.Errorf("cache_control must not exceed %d characters", maxCacheControlLength)
+	}
+	for _, c := range value {
+		if c < ' ' || c == 0x7f {
+			return fmt.Errorf("cache_control must not contain control characters")
+		}
+	}
+	return nil
+}
+
+// parseCacheHeaders reads the Cache-Control and Expires headers of an
+// upload, which are stored with the object and sent back on download.
+func parseCacheHeaders(r *http.Request) (cacheControl string, expires *time.Time, err error) {
+	cacheControl = strings.TrimSpace(r.Header.Get("Cache-Control"))
+	if err := validateCacheControl(cacheControl); err != nil {
+		return "", nil, err
+	}
+
+	if v := r.Header.Get("Expires"); v != "" {
+		t, err := http.ParseTime(v)
+		if err != nil {
+			return "", nil, fmt.Errorf("Expires must be an HTTP date")
+		}
+		t = t.UTC()
+		expires = &t
+	}
+	return cacheControl, expires, nil
+}
+
+// setCacheHeaders sends the caching headers of an object: its own
+// Cache-Control or, without one, the default of its bucket, and its Expires
+// date.
+func (s *StorageServer) setCacheHeaders(w http.ResponseWriter, bucketName string, metadata *ObjectMetadata) {
+	cacheControl := metadata.CacheControl
+	if cacheControl == "" {
+		if bucket, err := s.backend.GetBucket(bucketName); err == nil {
+			cacheControl = bucket.Settings.CacheControl
+		}
+	}
+	if cacheControl != "" {
+		w.Header().Set("Cache-Control", cacheControl)
+	}
+	if metadata.Expires != nil {
+		w.Header().Set("Expires", metadata.Expires.Format(http.TimeFormat))
+	}
+}
+
+// handleBucketCacheControl serves GET, PUT and DELETE on
+// /buckets/{name}?cache-control, the Cache-Control sent for objects that
+// were uploaded without one.
+func (s *StorageServer) handleBucketCacheControl(w http.ResponseWriter, r *http.Request) {
+	bucketName := strings.TrimPrefix(r.URL.Path, "/buckets/")
+
+	var req struct {
+		CacheControl string `json:"cache_control"`
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodDelete:
+	case http.MethodPut:
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			s.writeError(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid cache control setting: %v", err))
+			return
+		}
+		if err := validateCacheControl(req.CacheControl); err != nil {
+			s.writeError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+	default:
+		s.writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var bucket Bucket
+	var err error
+	if r.Method == http.MethodGet {
+		bucket, err = s.storage.GetBucket(bucketName)
+	} else {
+		bucket, err = s.storage.UpdateBucketSettings(bucketName, func(settings *BucketSettings) error {
+			settings.CacheControl = strings.TrimSpace(req.CacheControl)
+			return nil
+		})
+	}
+	if err != nil {
+		s.writeStorageError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"cache_control": bucket.Settings.CacheControl})
+}
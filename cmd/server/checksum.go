@@ -0,0 +1,69 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"net/http"
+	"strings"
+)
+
+const (
+	checksumAlgorithmHeader = "X-Checksum-Algorithm"
+
+	ChecksumSHA256 = "sha256"
+	ChecksumCRC32C = "crc32c"
+)
+
+// ErrBadChecksum is returned when uploaded data does not match the checksum
+// the client supplied.
+var ErrBadChecksum = errors.New("uploaded data does not match checksum")
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+func newChecksumHash(algorithm string) (hash.Hash, error) {
+	switch algorithm {
+	case ChecksumSHA256:
+		return sha256.New(), nil
+	case ChecksumCRC32C:
+		return crc32.New(crc32cTable), nil
+	default:
+		return nil, fmt.Errorf("unsupported checksum algorithm %q: use sha256 or crc32c", algorithm)
+	}
+}
+
+// checksumHeader is the response header carrying the checksum for an
+// algorithm, e.g. X-Checksum-Sha256.
+func checksumHeader(algorithm string) string {
+	return http.CanonicalHeaderKey("X-Checksum-" + algorithm)
+}
+
+// parseChecksumRequest reads the requested algorithm and the optional
+// expected hex value from an upload request.
+func parseChecksumRequest(r *http.Request) (algorithm, expected string, err error) {
+	algorithm = strings.ToLower(r.Header.Get(checksumAlgorithmHeader))
+	if algorithm == "" {
+		return "", "", nil
+	}
+
+	if _, err := newChecksumHash(algorithm); err != nil {
+		return "", "", err
+	}
+
+	expected = strings.ToLower(r.Header.Get(checksumHeader(algorithm)))
+	if expected != "" {
+		if _, err := hex.DecodeString(expected); err != nil {
+			return "", "", fmt.Errorf("%s must be hex encoded", checksumHeader(algorithm))
+		}
+	}
+	return algorithm, expected, nil
+}
+
+func setChecksumHeaders(w http.ResponseWriter, metadata *ObjectMetadata) {
+	for algorithm, value := range metadata.Checksums {
+		w.Header().Set(checksumHeader(algorithm), value)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const compressionGzip = "gzip"
+
+// defaultCompressibleTypes are the content type prefixes compressed when
+// CompressionConfig.ContentTypes is empty.
+var defaultCompressibleTypes = []string{
+	"text/",
+	"application/json",
+	"application/xml",
+	"application/javascript",
+	"application/x-ndjson",
+	"image/svg+xml",
+}
+
+// CompressionConfig enables transparent compression of object data on disk.
+type CompressionConfig struct {
+	// Algorithm is the compression format. Only gzip is available: the
+	// server is built from the standard library alone, which has no zstd.
+	Algorithm string `json:"algorithm"`
+
+	// Level is the gzip level from 1 (fastest) to 9 (smallest); 0 uses the
+	// default level.
+	Level int `json:"level,omitempty"`
+
+	// ContentTypes lists the content type prefixes that are compressed.
+	ContentTypes []string `json:"content_types,omitempty"`
+}
+
+func (c *CompressionConfig) validate() error {
+	if c == nil {
+		return nil
+	}
+
+	switch c.Algorithm {
+	case compressionGzip:
+	case "zstd":
+		return fmt.Errorf("compression: zstd is not supported by this build; use gzip")
+	default:
+		return fmt.Errorf("compression: unknown algorithm %q", c.Algorithm)
+	}
+
+	if c.Level < 0 || c.Level > gzip.BestCompression {
+		return fmt.Errorf("compression: level must be between 1 and 9")
+	}
+	return nil
+}
+
+// applies reports whether objects of the given content type are compressed.
+func (c *CompressionConfig) applies(contentType string) bool {
+	if c == nil {
+		return false
+	}
+
+	types := c.ContentTypes
+	if len(types) == 0 {
+		types = defaultCompressibleTypes
+	}
+
+	contentType = strings.ToLower(contentType)
+	for _, prefix := range types {
+		if strings.HasPrefix(contentType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *CompressionConfig) newWriter(w io.Writer) (io.WriteCloser, error) {
+	level := c.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	return gzip.NewWriterLevel(w, level)
+}
+
+// countingWriter counts the bytes that reach the data file, which is the
+// stored size once compression and encryption have been applied.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// decoderReader closes the decoder together with the underlying file.
+type decoderReader struct {
+	io.Reader
+	decoder io.Closer
+	file    io.Closer
+}
+
+func (d *decoderReader) Close() error {
+	d.decoder.Close()
+	return d.file.Close()
+}
+
+func decompressObject(r io.ReadCloser, algorithm string) (io.ReadCloser, error) {
+	if algorithm != compressionGzip {
+		return nil, fmt.Errorf("unsupported compression %q", algorithm)
+	}
+
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read compressed object: %w", err)
+	}
+	return &decoderReader{Reader: gz, decoder: gz, file: r}, nil
+}
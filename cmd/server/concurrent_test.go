@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// write is one of the concurrent uploads to a key, with metadata that
+// tells it apart from the others.
+type write struct {
+	data        []byte
+	etag        string
+	contentType string
+	tag         string
+}
+
+// TestConcurrentPutsLeaveOneWrite races uploads to one key, with readers
+// alongside; run it with -race. The object left behind must be exactly one
+// of the writes, data and metadata alike.
+func TestConcurrentPutsLeaveOneWrite(t *testing.T) {
+	backends := map[string]func(t *testing.T) Backend{
+		"memory": func(t *testing.T) Backend {
+			backend := newMemoryBackend()
+			if err := backend.CreateBucket("it", "", BucketOwner{}, BucketSettings{}); err != nil {
+				t.Fatal(err)
+			}
+			return backend
+		},
+		"filesystem": func(t *testing.T) Backend { return newTestStorage(t) },
+	}
+
+	for name, newBackend := range backends {
+		t.Run(name, func(t *testing.T) {
+			backend := newBackend(t)
+			handler := NewStorageServer(backend, defaultConfig(), discardLogger()).Handler()
+
+			const writers = 16
+			writes := make(map[string]write, writers)
+			for i := range writers {
+				data := bytes.Repeat([]byte{byte('a' + i)}, 1000+i*100)
+				sum := md5.Sum(data)
+				w := write{
+					data:        data,
+					etag:        hex.EncodeToString(sum[:]),
+					contentType: fmt.Sprintf("application/x-writer-%d", i),
+					tag:         strconv.Itoa(i),
+				}
+				writes[w.etag] = w
+			}
+
+			// Readers run alongside the writers: every GET must return data
+			// that matches the ETag it is served with.
+			var wg sync.WaitGroup
+			errs := make(chan error, writers*2)
+			for _, w := range writes {
+				wg.Add(2)
+				go func() {
+					defer wg.Done()
+					req := httptest.NewRequest(http.MethodPut, "/objects/it/race", bytes.NewReader(w.data))
+					req.Header.Set("Content-Type", w.contentType)
+					req.Header.Set(taggingHeader, "writer="+w.tag)
+					recorder := httptest.NewRecorder()
+					handler.ServeHTTP(recorder, req)
+					if recorder.Code != http.StatusOK {
+						errs <- fmt.Errorf("PUT: %d %s", recorder.Code, recorder.Body)
+					}
+				}()
+				go func() {
+					defer wg.Done()
+					recorder := httptest.NewRecorder()
+					handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/objects/it/race", nil))
+					if recorder.Code != http.StatusOK {
+						return
+					}
+					sum := md5.Sum(recorder.Body.Bytes())
+					if etag := recorder.Header().Get("ETag"); hex.EncodeToString(sum[:]) != strings.Trim(etag, `"`) {
+						errs <- fmt.Errorf("GET returned data with MD5 %x under ETag %s", sum, etag)
+					}
+				}()
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				t.Error(err)
+			}
+
+			metadata, err := backend.StatObject("it", "race")
+			if err != nil {
+				t.Fatal(err)
+			}
+			w, ok := writes[metadata.ETag]
+			if !ok {
+				t.Fatalf("final ETag %s is not the ETag of any write", metadata.ETag)
+			}
+			if metadata.Size != int64(len(w.data)) || metadata.ContentType != w.contentType || metadata.Tags["writer"] != w.tag {
+				t.Errorf("metadata %+v does not belong to the write with ETag %s", metadata, w.etag)
+			}
+			if metadata.Generation != writers {
+				t.Errorf("generation is %d after %d writes", metadata.Generation, writers)
+			}
+
+			reader, readMetadata, err := backend.GetObject("it", "race")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer reader.Close()
+			var data bytes.Buffer
+			if _, err := data.ReadFrom(reader); err != nil {
+				t.Fatal(err)
+			}
+			if readMetadata.ETag != metadata.ETag || !bytes.Equal(data.Bytes(), w.data) {
+				t.Error("final data does not match its metadata")
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// parseConditionalHeaders reads the preconditions of an upload: If-Match
+// with the ETag the object must currently have (or "*" for any existing
+// object), and If-None-Match: * to only create the object if the key is
+// free.
+func parseConditionalHeaders(r *http.Request) (ifMatch string, ifNoneMatch bool, err error) {
+	if v := strings.TrimSpace(r.Header.Get("If-Match")); v != "" {
+		ifMatch = strings.Trim(strings.TrimPrefix(v, "W/"), `"`)
+		if ifMatch == "" || strings.Contains(ifMatch, ",") {
+			return "", false, fmt.Errorf("If-Match must be a single ETag or *")
+		}
+	}
+	if v := strings.TrimSpace(r.Header.Get("If-None-Match")); v != "" {
+		if v != "*" {
+			return "", false, fmt.Errorf("If-None-Match on uploads must be *")
+		}
+		ifNoneMatch = true
+	}
+	if ifMatch != "" && ifNoneMatch {
+		return "", false, fmt.Errorf("If-Match and If-None-Match cannot be combined")
+	}
+	return ifMatch, ifNoneMatch, nil
+}
+
+// checkPrecondition checks a write's conditions against the object it would
+// replace, nil if the key is free. Backends call it when committing, so no
+// other write can get in between.
+func (opts PutOptions) checkPrecondition(existing *ObjectMetadata) error {
+	switch {
+	case opts.IfNoneMatch && existing != nil:
+		return fmt.Errorf("%w: key exists", ErrPreconditionFailed)
+	case opts.IfMatch == "*" && existing == nil:
+		return fmt.Errorf("%w: object not found", ErrPreconditionFailed)
+	case opts.IfMatch != "" && opts.IfMatch != "*" && (existing == nil || existing.ETag != opts.IfMatch):
+		return fmt.Errorf("%w: ETag does not match", ErrPreconditionFailed)
+	}
+
+	if opts.IfGeneration != nil {
+		generation := int64(0)
+		if existing != nil {
+			generation = existing.Generation
+		}
+		if generation != *opts.IfGeneration {
+			return ErrPreconditionFailed
+		}
+	}
+	return nil
+}
@@ -0,0 +1,507 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"storage-system/internal/yaml"
+)
+
+// Duration is a time.Duration that reads and writes as a Go duration string
+// ("30s", "5m") in config files.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"30s\": %w", err)
+	}
+
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(parsed)
+	return nil
+}
+
+type TLSConfig struct {
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+
+	// ClientCAFile, when set, holds the PEM CA certificates client
+	// certificates must be signed by.
+	ClientCAFile string `json:"client_ca_file,omitempty"`
+
+	// ClientAuth is require (the default with a ClientCAFile) or optional.
+	ClientAuth string `json:"client_auth,omitempty"`
+}
+
+func (t TLSConfig) Enabled() bool {
+	return t.CertFile != "" && t.KeyFile != ""
+}
+
+// Config holds the server settings. Values are resolved in the order
+// defaults < config file < environment variables < command-line flags.
+type Config struct {
+	Listen       string    `json:"listen"`
+	Backend      string    `json:"backend"`
+	DataDir      string    `json:"data_dir"`
+	DrainTimeout Duration  `json:"drain_timeout"`
+	TLS          TLSConfig `json:"tls"`
+
+	// S3 configures the upstream bucket of the s3 backend.
+	S3 *GatewayConfig `json:"s3"`
+
+	// DataDirs are additional directories, usually on other disks, that
+	// object data is spread over. Metadata always stays in DataDir.
+	DataDirs []string `json:"data_dirs"`
+
+	// MetadataStore selects how the filesystem backend keeps metadata:
+	// "files" (one JSON file per object) or "kv" (a single log file).
+	MetadataStore string `json:"metadata_store"`
+
+	// Listeners, when set, replace Listen and TLS with several sockets.
+	Listeners []ListenerConfig `json:"listeners"`
+
+	// HTTP selects the HTTP versions served on every listener.
+	HTTP *HTTPConfig `json:"http"`
+
+	// ResponseCompression controls gzip encoding of downloads.
+	ResponseCompression *ResponseCompressionConfig `json:"response_compression"`
+
+	// ImageTransforms controls resized and converted image downloads.
+	ImageTransforms *ImageTransformConfig `json:"image_transforms"`
+
+	LogLevel     string `json:"log_level"`
+	LogFormat    string `json:"log_format"`
+	LogFile      string `json:"log_file"`
+	MinFreeBytes uint64 `json:"min_free_bytes"`
+
+	// LifecycleInterval is how often bucket lifecycle rules are evaluated.
+	LifecycleInterval Duration `json:"lifecycle_interval"`
+
+	// GCSafetyWindow is how long an orphaned file must sit untouched before
+	// garbage collection may remove it.
+	GCSafetyWindow Duration `json:"gc_safety_window"`
+
+	// JanitorInterval is how often upload temp files older than
+	// TempFileMaxAge are removed.
+	JanitorInterval Duration `json:"janitor_interval"`
+	TempFileMaxAge  Duration `json:"temp_file_max_age"`
+
+	// MultipartMaxAge is how long a multipart upload may go without a new
+	// part before the janitor aborts it.
+	MultipartMaxAge Duration `json:"multipart_max_age"`
+
+	// BucketTemplates maps a template name to the settings applied by
+	// PUT /buckets/{name}?template={template}.
+	BucketTemplates map[string]BucketSettings `json:"bucket_templates"`
+
+	// KMS, when set, enables envelope encryption of object data at rest.
+	KMS *KMSConfig `json:"kms"`
+
+	// Compression, when set, compresses compressible objects on disk.
+	Compression *CompressionConfig `json:"compression"`
+
+	// Dedup, when set, stores object data in a content-addressable chunk
+	// store instead of one file per object.
+	Dedup *DedupConfig `json:"dedup"`
+
+	// Erasure, when set, stores object data erasure coded across disks.
+	Erasure *ErasureConfig `json:"erasure"`
+
+	// Mirror, when set, makes the server a read-only mirror of an upstream.
+	Mirror *MirrorConfig `json:"mirror"`
+
+	// SigningKey is the secret that signs time-limited links. When empty a
+	// random key is generated at startup.
+	SigningKey string `json:"signing_key"`
+
+	// WebhookSecret signs the payloads of bucket webhook notifications.
+	WebhookSecret string `json:"webhook_secret"`
+
+	// WebsiteDomain, when set, serves the website of bucket {name} on the
+	// host {name}.{WebsiteDomain}.
+	WebsiteDomain string `json:"website_domain"`
+
+	// Auth, when set, requires credentials for the API except where bucket
+	// policies allow anonymous access.
+	Auth *AuthConfig `json:"auth"`
+
+	// IPAccess, when set, restricts the client addresses that may use the
+	// server. Buckets can restrict them further.
+	IPAccess *IPAccessConfig `json:"ip_access"`
+
+	// TrustedProxies lists the addresses of reverse proxies whose
+	// X-Forwarded-For header names the real client. validate parses them
+	// into trustedProxies.
+	TrustedProxies []string `json:"trusted_proxies"`
+	trustedProxies []netip.Prefix
+
+	// AuditLog, when set, is the file every mutating request is recorded
+	// in.
+	AuditLog string `json:"audit_log"`
+
+	// Bandwidth, when set, limits the upload and download rate of each
+	// client.
+	Bandwidth *BandwidthConfig `json:"bandwidth"`
+
+	// MaxObjectSize, when positive, is the largest object in bytes that can
+	// be uploaded. Buckets can set their own.
+	MaxObjectSize int64 `json:"max_object_size"`
+
+	// MaxRequestBody, when positive, is the largest request body in bytes
+	// the server reads.
+	MaxRequestBody int64 `json:"max_request_body"`
+
+	// UploadValidators check every upload, in order, before it is stored.
+	UploadValidators []UploadValidatorConfig `json:"upload_validators"`
+
+	// Antivirus, when set, scans uploads for malware.
+	Antivirus *AntivirusConfig `json:"antivirus"`
+
+	// AdminToken, when set, must be sent as a bearer token with every
+	// request to /admin/.
+	AdminToken string `json:"admin_token"`
+
+	// EventBus, when set, streams every object change to NATS or Kafka.
+	EventBus *EventBusConfig `json:"event_bus"`
+
+	// Replication, when set, mirrors object writes and deletes to peers.
+	Replication *ReplicationConfig `json:"replication"`
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		Listen:       ":8080",
+		Backend:      backendFilesystem,
+		DataDir:      "./storage",
+		DrainTimeout: Duration(30 * time.Second),
+		LogLevel:     "info",
+		LogFormat:    "text",
+		MinFreeBytes: 100 << 20,
+
+		MetadataStore: metadataStoreFiles,
+
+		LifecycleInterval: Duration(time.Hour),
+		GCSafetyWindow:    Duration(24 * time.Hour),
+		JanitorInterval:   Duration(15 * time.Minute),
+		TempFileMaxAge:    Duration(time.Hour),
+		MultipartMaxAge:   Duration(7 * 24 * time.Hour),
+	}
+}
+
+// LoadConfig builds the server configuration from the given command-line
+// arguments, the optional --config file and STORAGE_* environment variables.
+func LoadConfig(args []string) (*Config, error) {
+	fs := flag.NewFlagSet("storage-server", flag.ContinueOnError)
+	configPath := fs.String("config", os.Getenv("STORAGE_CONFIG"), "Path to a JSON or YAML (.yaml, .yml) config file")
+	listen := fs.String("listen", "", "Address to listen on (default :8080)")
+	backend := fs.String("backend", "", "Storage backend: filesystem, memory or s3 (default filesystem)")
+	dataDir := fs.String("data-dir", "", "Directory for object data and metadata (default ./storage)")
+	drainTimeout := fs.Duration("drain-timeout", 0, "Time to wait for in-flight requests on shutdown (default 30s)")
+	tlsCert := fs.String("tls-cert", "", "TLS certificate file")
+	tlsKey := fs.String("tls-key", "", "TLS private key file")
+	tlsClientCA := fs.String("tls-client-ca", "", "CA certificates that client certificates must be signed by")
+	logLevel := fs.String("log-level", "", "Log level: debug, info, warn or error (default info)")
+	logFormat := fs.String("log-format", "", "Log format: text or json (default text)")
+	logFile := fs.String("log-file", "", "Append logs to this file instead of stdout")
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	config := defaultConfig()
+
+	if *configPath != "" {
+		if err := config.loadFile(*configPath); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := config.applyEnv(); err != nil {
+		return nil, err
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "listen":
+			config.Listen = *listen
+		case "backend":
+			config.Backend = *backend
+		case "data-dir":
+			config.DataDir = *dataDir
+		case "drain-timeout":
+			config.DrainTimeout = Duration(*drainTimeout)
+		case "tls-cert":
+			config.TLS.CertFile = *tlsCert
+		case "tls-key":
+			config.TLS.KeyFile = *tlsKey
+		case "tls-client-ca":
+			config.TLS.ClientCAFile = *tlsClientCA
+		case "log-level":
+			config.LogLevel = *logLevel
+		case "log-format":
+			config.LogFormat = *logFormat
+		case "log-file":
+			config.LogFile = *logFile
+		}
+	})
+
+	return config, config.validate()
+}
+
+func (config *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	// YAML files are converted to JSON first, so both formats share the
+	// field names and checks of the JSON decoder.
+	if ext := strings.ToLower(filepath.Ext(path)); ext == ".yaml" || ext == ".yml" {
+		if data, err = yaml.ToJSON(data); err != nil {
+			return fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+	}
+
+	if err := json.Unmarshal(data, config); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return nil
+}
+
+func (config *Config) applyEnv() error {
+	if v := os.Getenv("STORAGE_LISTEN"); v != "" {
+		config.Listen = v
+	}
+	if v := os.Getenv("STORAGE_BACKEND"); v != "" {
+		config.Backend = v
+	}
+	if v := os.Getenv("STORAGE_DATA_DIR"); v != "" {
+		config.DataDir = v
+	}
+	if v := os.Getenv("STORAGE_DATA_DIRS"); v != "" {
+		config.DataDirs = strings.Split(v, ",")
+	}
+	if v := os.Getenv("STORAGE_METADATA_STORE"); v != "" {
+		config.MetadataStore = v
+	}
+	if v := os.Getenv("STORAGE_DRAIN_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid STORAGE_DRAIN_TIMEOUT: %w", err)
+		}
+		config.DrainTimeout = Duration(d)
+	}
+	if v := os.Getenv("STORAGE_TLS_CERT"); v != "" {
+		config.TLS.CertFile = v
+	}
+	if v := os.Getenv("STORAGE_TLS_KEY"); v != "" {
+		config.TLS.KeyFile = v
+	}
+	if v := os.Getenv("STORAGE_TLS_CLIENT_CA"); v != "" {
+		config.TLS.ClientCAFile = v
+	}
+	if v := os.Getenv("STORAGE_LOG_LEVEL"); v != "" {
+		config.LogLevel = v
+	}
+	if v := os.Getenv("STORAGE_LOG_FORMAT"); v != "" {
+		config.LogFormat = v
+	}
+	if v := os.Getenv("STORAGE_LOG_FILE"); v != "" {
+		config.LogFile = v
+	}
+	if v := os.Getenv("STORAGE_SIGNING_KEY"); v != "" {
+		config.SigningKey = v
+	}
+	if v := os.Getenv("STORAGE_WEBHOOK_SECRET"); v != "" {
+		config.WebhookSecret = v
+	}
+	if v := os.Getenv("STORAGE_WEBSITE_DOMAIN"); v != "" {
+		config.WebsiteDomain = v
+	}
+	if v := os.Getenv("STORAGE_AUDIT_LOG"); v != "" {
+		config.AuditLog = v
+	}
+	if v := os.Getenv("STORAGE_MAX_OBJECT_SIZE"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid STORAGE_MAX_OBJECT_SIZE: %w", err)
+		}
+		config.MaxObjectSize = n
+	}
+	if v := os.Getenv("STORAGE_MAX_REQUEST_BODY"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid STORAGE_MAX_REQUEST_BODY: %w", err)
+		}
+		config.MaxRequestBody = n
+	}
+	if v := os.Getenv("STORAGE_AUTH_TOKENS"); v != "" {
+		if config.Auth == nil {
+			config.Auth = &AuthConfig{}
+		}
+		config.Auth.Tokens = strings.Split(v, ",")
+	}
+	if v := os.Getenv("STORAGE_ADMIN_TOKEN"); v != "" {
+		config.AdminToken = v
+	}
+	return nil
+}
+
+func (config *Config) validate() error {
+	if config.Listen == "" {
+		return fmt.Errorf("listen address must not be empty")
+	}
+	for _, l := range config.ListenerConfigs() {
+		if err := l.validate(); err != nil {
+			return err
+		}
+	}
+	if err := config.validateBackend(); err != nil {
+		return err
+	}
+	if config.DataDir == "" {
+		return fmt.Errorf("data directory must not be empty")
+	}
+	for _, dir := range config.DataDirs {
+		if dir == "" {
+			return fmt.Errorf("data_dirs entries must not be empty")
+		}
+	}
+	if err := validateMetadataStore(config.MetadataStore); err != nil {
+		return err
+	}
+	if config.LifecycleInterval <= 0 {
+		return fmt.Errorf("lifecycle_interval must be positive")
+	}
+	if config.JanitorInterval <= 0 {
+		return fmt.Errorf("janitor_interval must be positive")
+	}
+	if config.TempFileMaxAge < 0 {
+		return fmt.Errorf("temp_file_max_age must not be negative")
+	}
+	if config.MultipartMaxAge <= 0 {
+		return fmt.Errorf("multipart_max_age must be positive")
+	}
+	for name, template := range config.BucketTemplates {
+		if err := validateLifecycle(template.Lifecycle); err != nil {
+			return fmt.Errorf("bucket template %s: %w", name, err)
+		}
+		if err := template.Quota.validate(); err != nil {
+			return fmt.Errorf("bucket template %s: %w", name, err)
+		}
+		if err := template.ObjectLock.validate(); err != nil {
+			return fmt.Errorf("bucket template %s: %w", name, err)
+		}
+		if err := template.Trash.validate(); err != nil {
+			return fmt.Errorf("bucket template %s: %w", name, err)
+		}
+		if err := validateNotifications(template.Notifications); err != nil {
+			return fmt.Errorf("bucket template %s: %w", name, err)
+		}
+		if err := template.Website.validate(); err != nil {
+			return fmt.Errorf("bucket template %s: %w", name, err)
+		}
+		if err := template.Policy.validate(); err != nil {
+			return fmt.Errorf("bucket template %s: %w", name, err)
+		}
+		if err := validateCacheControl(template.CacheControl); err != nil {
+			return fmt.Errorf("bucket template %s: %w", name, err)
+		}
+		if err := template.IPAccess.validate(); err != nil {
+			return fmt.Errorf("bucket template %s: %w", name, err)
+		}
+		if err := validateMaxObjectSize(template.MaxObjectSize); err != nil {
+			return fmt.Errorf("bucket template %s: %w", name, err)
+		}
+		if err := validateLocation(template.Location); err != nil {
+			return fmt.Errorf("bucket template %s: %w", name, err)
+		}
+	}
+	if err := config.KMS.validate(); err != nil {
+		return err
+	}
+	if err := config.Auth.validate(); err != nil {
+		return err
+	}
+	if err := config.HTTP.validate(); err != nil {
+		return err
+	}
+	if err := config.ResponseCompression.validate(); err != nil {
+		return err
+	}
+	if err := config.ImageTransforms.validate(); err != nil {
+		return err
+	}
+	if err := config.IPAccess.validate(); err != nil {
+		return err
+	}
+	if err := config.Bandwidth.validate(); err != nil {
+		return err
+	}
+	if err := validateMaxObjectSize(config.MaxObjectSize); err != nil {
+		return err
+	}
+	if config.MaxRequestBody < 0 {
+		return fmt.Errorf("max_request_body must not be negative")
+	}
+	for _, validator := range config.UploadValidators {
+		if err := validator.validate(); err != nil {
+			return err
+		}
+	}
+	if err := config.Antivirus.validate(config); err != nil {
+		return err
+	}
+	trustedProxies, err := parsePrefixes(config.TrustedProxies)
+	if err != nil {
+		return fmt.Errorf("trusted_proxies: %w", err)
+	}
+	config.trustedProxies = trustedProxies
+	if err := config.Compression.validate(); err != nil {
+		return err
+	}
+	if err := config.EventBus.validate(); err != nil {
+		return err
+	}
+	if err := config.Dedup.validate(config); err != nil {
+		return err
+	}
+	if err := config.Erasure.validate(config); err != nil {
+		return err
+	}
+	if err := config.Mirror.validate(); err != nil {
+		return err
+	}
+	if err := config.Replication.validate(); err != nil {
+		return err
+	}
+	if (config.TLS.CertFile == "") != (config.TLS.KeyFile == "") {
+		return fmt.Errorf("both tls cert_file and key_file must be set to enable TLS")
+	}
+	if err := config.TLS.validateClientAuth(); err != nil {
+		return err
+	}
+	if config.Auth != nil && config.Auth.ClientCerts != nil && !slices.ContainsFunc(config.ListenerConfigs(), func(l ListenerConfig) bool {
+		return l.TLS.ClientCAFile != ""
+	}) {
+		return fmt.Errorf("auth client_certs needs a listener with a tls client_ca_file")
+	}
+	return nil
+}
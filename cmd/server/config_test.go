@@ -0,0 +1,44 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLoadYAMLConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.yaml")
+	yaml := `
+listen: ":8443"
+data_dir: /var/lib/storage
+drain_timeout: 1m
+max_object_size: 1073741824
+auth:
+  tokens: [ci-token]
+listeners:
+  - address: ":9000"
+    routes: admin
+`
+	if err := os.WriteFile(path, []byte(yaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config := defaultConfig()
+	if err := config.loadFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if config.Listen != ":8443" || config.DataDir != "/var/lib/storage" || time.Duration(config.DrainTimeout) != time.Minute || config.MaxObjectSize != 1<<30 {
+		t.Errorf("settings not loaded: %+v", config)
+	}
+	if config.Auth == nil || !reflect.DeepEqual(config.Auth.Tokens, []string{"ci-token"}) {
+		t.Errorf("auth.tokens not loaded: %+v", config.Auth)
+	}
+	if len(config.Listeners) != 1 || config.Listeners[0].Address != ":9000" || config.Listeners[0].Routes != "admin" {
+		t.Errorf("listeners not loaded: %+v", config.Listeners)
+	}
+	if config.LogLevel != "info" {
+		t.Errorf("unset settings should keep their defaults, log_level is %q", config.LogLevel)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// ErrContentNotFound is returned when an upload by reference names content
+// the server does not store.
+var ErrContentNotFound = errors.New("content not found")
+
+// contentReferenceHeader completes a PUT from content the server already
+// stores instead of a request body. The value is "sha256:{hex}".
+const contentReferenceHeader = "X-Content-Reference"
+
+// contentIndex maps the SHA-256 of object data to the objects holding it.
+// It is kept in memory and rebuilt from metadata at startup. Only objects
+// uploaded with a sha256 checksum are indexed.
+type contentIndex struct {
+	mu      sync.Mutex
+	objects map[string]map[string]bool
+}
+
+func newContentIndex() *contentIndex {
+	return &contentIndex{objects: make(map[string]map[string]bool)}
+}
+
+func (idx *contentIndex) add(bucketName string, metadata *ObjectMetadata) {
+	hash := metadata.Checksums[ChecksumSHA256]
+	if hash == "" {
+		return
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if idx.objects[hash] == nil {
+		idx.objects[hash] = make(map[string]bool)
+	}
+	idx.objects[hash][bucketName+"/"+metadata.Key] = true
+}
+
+func (idx *contentIndex) remove(bucketName string, metadata *ObjectMetadata) {
+	hash := metadata.Checksums[ChecksumSHA256]
+	if hash == "" {
+		return
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	delete(idx.objects[hash], bucketName+"/"+metadata.Key)
+	if len(idx.objects[hash]) == 0 {
+		delete(idx.objects, hash)
+	}
+}
+
+func (idx *contentIndex) lookup(hash string) []string {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	refs := make([]string, 0, len(idx.objects[hash]))
+	for ref := range idx.objects[hash] {
+		refs = append(refs, ref)
+	}
+	return refs
+}
+
+// loadIndexes rebuilds the in-memory indexes derived from object metadata.
+func (storage *ObjectStorage) loadIndexes() error {
+	buckets, err := storage.ListBuckets()
+	if err != nil {
+		return err
+	}
+
+	for _, bucket := range buckets {
+		err := storage.WalkObjects(bucket.Name, func(object ObjectMetadata) error {
+			storage.content.add(bucket.Name, &object)
+			if storage.chunks != nil {
+				storage.chunks.addRefs(object.Chunks)
+			}
+			if storage.erasure != nil {
+				storage.erasure.addRef(object.Erasure)
+			}
+			return nil
+		})
+		if err != nil && !strings.Contains(err.Error(), "not found") {
+			return err
+		}
+	}
+
+	if storage.chunks != nil || storage.erasure != nil {
+		trashed, err := storage.trashedObjects()
+		if err != nil {
+			return err
+		}
+		for _, object := range trashed {
+			if storage.chunks != nil {
+				storage.chunks.addRefs(object.Chunks)
+			}
+			if storage.erasure != nil {
+				storage.erasure.addRef(object.Erasure)
+			}
+		}
+	}
+	return nil
+}
+
+// FindContent returns an object whose data has the given SHA-256, among
+// the objects readable reports the caller may read.
+func (storage *ObjectStorage) FindContent(hash string, readable func(bucketName, objectKey string) bool) (string, *ObjectMetadata, error) {
+	for _, ref := range storage.content.lookup(hash) {
+		bucketName, objectKey, _ := strings.Cut(ref, "/")
+		if !readable(bucketName, objectKey) {
+			continue
+		}
+		metadata, err := storage.loadObjectMetadata(bucketName, objectKey)
+		if err == nil && metadata.Checksums[ChecksumSHA256] == hash {
+			return bucketName, metadata, nil
+		}
+	}
+	return "", nil, ErrContentNotFound
+}
+
+// PutObjectFromContent writes an object by copying content the server
+// already stores, so the client does not have to send the data again. Only
+// objects readable reports the caller may read are copied from.
+func (storage *ObjectStorage) PutObjectFromContent(bucketName, objectKey, hash string, readable func(bucketName, objectKey string) bool, opts PutOptions) (*ObjectMetadata, error) {
+	sourceBucket, source, err := storage.FindContent(hash, readable)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, _, err := storage.GetObject(sourceBucket, source.Key)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	if opts.ChecksumAlgorithm == "" {
+		opts.ChecksumAlgorithm = ChecksumSHA256
+	}
+	if opts.ChecksumAlgorithm == ChecksumSHA256 && opts.ExpectedChecksum == "" {
+		opts.ExpectedChecksum = hash
+	}
+	return storage.PutObject(bucketName, objectKey, reader, opts)
+}
+
+// parseContentReference parses the value of X-Content-Reference.
+func parseContentReference(value string) (string, error) {
+	hash, ok := strings.CutPrefix(value, ChecksumSHA256+":")
+	if !ok {
+		return "", fmt.Errorf("%s must have the form sha256:{hex}", contentReferenceHeader)
+	}
+	if decoded, err := hex.DecodeString(hash); err != nil || len(decoded) != 32 {
+		return "", fmt.Errorf("%s must carry a hex SHA-256 digest", contentReferenceHeader)
+	}
+	return strings.ToLower(hash), nil
+}
+
+// handleContent serves GET and HEAD on /content/sha256/{hex}: it reports
+// whether the server stores data with that hash in an object the caller may
+// read, so clients can complete an upload by reference instead of sending
+// the body.
+func (s *StorageServer) handleContent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		s.writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	hash, err := parseContentReference(strings.Replace(strings.TrimPrefix(r.URL.Path, "/content/"), "/", ":", 1))
+	if err != nil {
+		s.writeError(w, r, http.StatusBadRequest, "Path must be /content/sha256/{hex}")
+		return
+	}
+
+	_, metadata, err := s.storage.FindContent(hash, s.readableBy(r))
+	if err != nil {
+		s.writeErrorCode(w, r, http.StatusNotFound, "ContentNotFound", err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"sha256": hash,
+		"size":   metadata.Size,
+	})
+}
+
+// readableBy returns a function reporting whether the caller of r could
+// download an object, by putting a GET of it through the same checks the
+// middleware makes. Uploads by reference and content lookups use it so
+// that a hash cannot be used to copy or probe data in other buckets.
+func (s *StorageServer) readableBy(r *http.Request) func(bucketName, objectKey string) bool {
+	return func(bucketName, objectKey string) bool {
+		probe := r.Clone(r.Context())
+		probe.Method = http.MethodGet
+		probe.URL = &url.URL{Path: "/objects/" + bucketName + "/" + objectKey}
+		probe.Body = http.NoBody
+
+		if addr, _ := s.clientAddr(probe); !s.bucketAllowsAddr(probe, addr) {
+			return false
+		}
+		if s.config.Auth == nil || s.authenticated(probe) || s.anonymousAllowed(probe) {
+			return true
+		}
+		if identity := s.roleIdentity(probe); identity != nil {
+			return identity.allows(probe)
+		}
+		if scope := s.scopedToken(probe); scope != nil {
+			return scope.allows(probe)
+		}
+		return false
+	}
+}
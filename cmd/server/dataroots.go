@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"storage-system/internal/ids"
+)
+
+// rootIDFile holds the ID of an additional data root. Object metadata
+// records the ID rather than the path, so a disk can be mounted elsewhere
+// and listed under its new path.
+const rootIDFile = ".storage-root"
+
+// dataRoot is a directory holding object data, usually on its own disk.
+// The primary root is data_dir itself and has an empty ID; additional roots
+// come from data_dirs and have their own data and trash directories.
+type dataRoot struct {
+	id       string
+	path     string
+	dataDir  string
+	trashDir string
+}
+
+func (root *dataRoot) objectPath(bucketName, objectKey string) string {
+	return filepath.Join(root.dataDir, bucketName, encodeKeyPath(objectKey))
+}
+
+// AddDataRoot adds a directory that new objects may be placed on. The
+// directory gets an ID on first use.
+func (storage *ObjectStorage) AddDataRoot(path string) error {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	if err := os.MkdirAll(filepath.Join(path, "data"), 0755); err != nil {
+		return fmt.Errorf("failed to create data directory %s: %w", path, err)
+	}
+
+	idPath := filepath.Join(path, rootIDFile)
+	data, err := os.ReadFile(idPath)
+	id := strings.TrimSpace(string(data))
+	if os.IsNotExist(err) {
+		id = ids.New()
+		err = os.WriteFile(idPath, []byte(id+"\n"), 0644)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read data directory id of %s: %w", path, err)
+	}
+
+	for _, root := range storage.roots {
+		if root.id == id || root.path == path {
+			return fmt.Errorf("data directory %s is configured twice", path)
+		}
+	}
+
+	storage.roots = append(storage.roots, &dataRoot{
+		id:       id,
+		path:     path,
+		dataDir:  filepath.Join(path, "data"),
+		trashDir: filepath.Join(path, "trash"),
+	})
+	return nil
+}
+
+// dataRoot returns the root an object's metadata points to.
+func (storage *ObjectStorage) dataRoot(location string) (*dataRoot, error) {
+	for _, root := range storage.roots {
+		if root.id == location {
+			return root, nil
+		}
+	}
+	return nil, fmt.Errorf("data location %s is not available", location)
+}
+
+// placeObject chooses the root for new object data: the one with the most
+// free space. Roots whose free space cannot be determined are skipped.
+func (storage *ObjectStorage) placeObject() *dataRoot {
+	best := storage.roots[0]
+	if len(storage.roots) == 1 {
+		return best
+	}
+
+	var bestFree uint64
+	for _, root := range storage.roots {
+		free, _, err := diskUsage(root.dataDir)
+		if err == nil && free > bestFree {
+			best, bestFree = root, free
+		}
+	}
+	return best
+}
+
+// objectDataPath returns the data file of an object stored at location.
+func (storage *ObjectStorage) objectDataPath(bucketName, objectKey, location string) (string, error) {
+	if err := validateObjectKey(objectKey); err != nil {
+		return "", err
+	}
+	root, err := storage.dataRoot(location)
+	if err != nil {
+		return "", err
+	}
+	return root.objectPath(bucketName, objectKey), nil
+}
+
+// trashDataPath returns where the data of a trash entry is kept: in the
+// trash directory of the root holding the object, so moving it to and from
+// the trash never crosses disks.
+func (storage *ObjectStorage) trashDataPath(bucketName, id, location string) (string, error) {
+	root, err := storage.dataRoot(location)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(root.trashDir, bucketName, id), nil
+}
+
+// dataFileExists reports whether any root has a data file at relPath in a
+// bucket.
+func (storage *ObjectStorage) dataFileExists(bucketName, relPath string) bool {
+	for _, root := range storage.roots {
+		if _, err := storage.Stat(filepath.Join(root.dataDir, bucketName, relPath)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// DataDirStats is the free space of one data directory.
+type DataDirStats struct {
+	ID   string `json:"id,omitempty"`
+	Path string `json:"path"`
+	DiskStats
+}
+
+// DataDirStats reports the free space of every data directory, data_dir
+// first. Directories whose usage cannot be read report zero.
+func (storage *ObjectStorage) DataDirStats() []DataDirStats {
+	stats := make([]DataDirStats, 0, len(storage.roots))
+	for _, root := range storage.roots {
+		stat := DataDirStats{ID: root.id, Path: root.path}
+		if disk, err := diskStats(root.dataDir); err == nil {
+			stat.DiskStats = disk
+		}
+		stats = append(stats, stat)
+	}
+	return stats
+}
+
+// maxFreeSpace returns the free space of the data directory with the most
+// room, which is where the next object goes.
+func (storage *ObjectStorage) maxFreeSpace() (uint64, error) {
+	var best uint64
+	var lastErr error
+	readable := false
+	for _, root := range storage.roots {
+		free, _, err := diskUsage(root.dataDir)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		readable = true
+		best = max(best, free)
+	}
+	if !readable {
+		return 0, lastErr
+	}
+	return best, nil
+}
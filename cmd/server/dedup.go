@@ -0,0 +1,256 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const defaultChunkSize = 4 << 20
+
+// DedupConfig enables the content-addressable backend: object data is split
+// into fixed-size chunks stored once by SHA-256, so identical content under
+// many keys or buckets uses disk space once.
+type DedupConfig struct {
+	ChunkSize int64 `json:"chunk_size,omitempty"`
+}
+
+func (d *DedupConfig) validate(config *Config) error {
+	if d == nil {
+		return nil
+	}
+	if d.ChunkSize < 0 {
+		return fmt.Errorf("dedup: chunk_size must not be negative")
+	}
+	// Per-object data keys and per-object compression would make identical
+	// content produce different chunks.
+	if config.KMS != nil {
+		return fmt.Errorf("dedup cannot be combined with kms encryption")
+	}
+	if config.Compression != nil {
+		return fmt.Errorf("dedup cannot be combined with compression")
+	}
+	return nil
+}
+
+// chunkStore keeps chunks under {dir}/{hash[:2]}/{hash} and counts how many
+// object versions reference each one. Counts live in memory and are rebuilt
+// from object metadata at startup; chunks whose count drops to zero are
+// removed by garbage collection once they are older than the safety window.
+type chunkStore struct {
+	dir       string
+	chunkSize int64
+
+	mu   sync.Mutex
+	refs map[string]int
+}
+
+func newChunkStore(dir string, chunkSize int64) *chunkStore {
+	if chunkSize <= 0 {
+		chunkSize = defaultChunkSize
+	}
+	os.MkdirAll(dir, 0755)
+	return &chunkStore{dir: dir, chunkSize: chunkSize, refs: make(map[string]int)}
+}
+
+func (c *chunkStore) path(hash string) string {
+	return filepath.Join(c.dir, hash[:2], hash)
+}
+
+// store writes a chunk unless it is already present. An existing chunk is
+// touched so garbage collection treats it as recently used until the
+// upload referencing it commits.
+func (c *chunkStore) store(data []byte) (string, error) {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	path := c.path(hash)
+
+	c.mu.Lock()
+	now := time.Now()
+	err := os.Chtimes(path, now, now)
+	c.mu.Unlock()
+	if err == nil {
+		return hash, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", fmt.Errorf("failed to create chunk directory: %w", err)
+	}
+
+	tempFile, err := os.CreateTemp(filepath.Dir(path), "upload-*.tmp")
+	if err != nil {
+		return "", fmt.Errorf("failed to create chunk: %w", err)
+	}
+	_, err = tempFile.Write(data)
+	tempFile.Close()
+	if err != nil {
+		os.Remove(tempFile.Name())
+		return "", fmt.Errorf("failed to write chunk: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := os.Rename(tempFile.Name(), path); err != nil {
+		os.Remove(tempFile.Name())
+		return "", fmt.Errorf("failed to finalize chunk: %w", err)
+	}
+	return hash, nil
+}
+
+func (c *chunkStore) addRefs(hashes []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, hash := range hashes {
+		c.refs[hash]++
+	}
+}
+
+func (c *chunkStore) release(hashes []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, hash := range hashes {
+		if c.refs[hash]--; c.refs[hash] <= 0 {
+			delete(c.refs, hash)
+		}
+	}
+}
+
+// orphans lists chunks that no object references.
+func (c *chunkStore) orphans(cutoff time.Time) ([]OrphanEntry, error) {
+	var orphans []OrphanEntry
+	err := filepath.Walk(c.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || isInternalDataFile(info.Name()) {
+			return nil
+		}
+
+		c.mu.Lock()
+		referenced := c.refs[info.Name()] > 0
+		c.mu.Unlock()
+
+		if !referenced {
+			orphans = append(orphans, OrphanEntry{
+				Kind:     "chunk",
+				Key:      info.Name(),
+				Size:     info.Size(),
+				Modified: info.ModTime(),
+				Eligible: info.ModTime().Before(cutoff),
+			})
+		}
+		return nil
+	})
+	return orphans, err
+}
+
+// remove deletes an unreferenced chunk. The reference count and age are
+// checked again under the lock so a chunk reused since the scan survives.
+func (c *chunkStore) remove(hash string, cutoff time.Time) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.refs[hash] > 0 {
+		return false, nil
+	}
+
+	path := c.path(hash)
+	info, err := os.Stat(path)
+	if err != nil || !info.ModTime().Before(cutoff) {
+		return false, nil
+	}
+	return true, os.Remove(path)
+}
+
+// chunkWriter splits written data into chunks and stores each of them.
+type chunkWriter struct {
+	store  *chunkStore
+	buf    []byte
+	hashes []string
+}
+
+func newChunkWriter(store *chunkStore) *chunkWriter {
+	return &chunkWriter{store: store, buf: make([]byte, 0, store.chunkSize)}
+}
+
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), cap(w.buf)-len(w.buf))
+		w.buf = append(w.buf, p[:n]...)
+		p = p[n:]
+		written += n
+
+		if len(w.buf) == cap(w.buf) {
+			if err := w.flush(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+func (w *chunkWriter) flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+
+	hash, err := w.store.store(w.buf)
+	if err != nil {
+		return err
+	}
+	w.hashes = append(w.hashes, hash)
+	w.buf = w.buf[:0]
+	return nil
+}
+
+func (w *chunkWriter) Close() error {
+	return w.flush()
+}
+
+// chunkReader reads an object's chunks in order.
+type chunkReader struct {
+	store   *chunkStore
+	hashes  []string
+	current *os.File
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if len(r.hashes) == 0 {
+				return 0, io.EOF
+			}
+
+			file, err := os.Open(r.store.path(r.hashes[0]))
+			if err != nil {
+				return 0, fmt.Errorf("failed to open chunk: %w", err)
+			}
+			r.current = file
+			r.hashes = r.hashes[1:]
+		}
+
+		n, err := r.current.Read(p)
+		if err == io.EOF {
+			r.current.Close()
+			r.current = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (r *chunkReader) Close() error {
+	if r.current != nil {
+		return r.current.Close()
+	}
+	return nil
+}
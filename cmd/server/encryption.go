@@ -0,0 +1,296 @@
+package main
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// encryptionAlgorithm names the on-disk format written by encryptingWriter:
+// the plaintext is split into 64 KiB segments, each sealed with AES-256-GCM
+// under the object's data key.
+const encryptionAlgorithm = "AES256-GCM-64K"
+
+const encryptionSegmentSize = 64 << 10
+
+// encryptionKeyHeader reports the master key that wraps an object's data key.
+const encryptionKeyHeader = "X-Encryption-Key-Id"
+
+// ObjectEncryption records how an object is encrypted at rest. The data key
+// is stored only in wrapped form.
+type ObjectEncryption struct {
+	Algorithm  string `json:"algorithm"`
+	KeyID      string `json:"key_id"`
+	WrappedKey []byte `json:"wrapped_key"`
+}
+
+// newDataKey generates a data key for one object and wraps it with the KMS.
+func newDataKey(kms KMS) ([]byte, *ObjectEncryption, error) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, nil, err
+	}
+
+	keyID, wrapped, err := kms.WrapKey(dataKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to wrap data key: %w", err)
+	}
+
+	return dataKey, &ObjectEncryption{
+		Algorithm:  encryptionAlgorithm,
+		KeyID:      keyID,
+		WrappedKey: wrapped,
+	}, nil
+}
+
+// segmentNonce derives the nonce of a segment from its index. Every object
+// has its own data key, so the counter never repeats under one key. The
+// final segment is authenticated as such so truncation is detected.
+func segmentNonce(aead cipher.AEAD, index uint64) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], index)
+	return nonce
+}
+
+func segmentAD(final bool) []byte {
+	if final {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+// encryptingWriter encrypts everything written to it into w. Close must be
+// called to write the final segment; it does not close w.
+type encryptingWriter struct {
+	w     io.Writer
+	aead  cipher.AEAD
+	buf   []byte
+	index uint64
+}
+
+func newEncryptingWriter(w io.Writer, dataKey []byte) (*encryptingWriter, error) {
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptingWriter{w: w, aead: aead, buf: make([]byte, 0, encryptionSegmentSize)}, nil
+}
+
+func (e *encryptingWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), encryptionSegmentSize-len(e.buf))
+		e.buf = append(e.buf, p[:n]...)
+		p = p[n:]
+		written += n
+
+		// A full segment is only flushed once more data arrives, so the
+		// final segment is always shorter than a full one.
+		if len(e.buf) == encryptionSegmentSize && len(p) > 0 {
+			if err := e.flush(false); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+func (e *encryptingWriter) flush(final bool) error {
+	if final && len(e.buf) == encryptionSegmentSize {
+		if err := e.flush(false); err != nil {
+			return err
+		}
+	}
+
+	sealed := e.aead.Seal(nil, segmentNonce(e.aead, e.index), e.buf, segmentAD(final))
+	e.index++
+	e.buf = e.buf[:0]
+
+	_, err := e.w.Write(sealed)
+	return err
+}
+
+func (e *encryptingWriter) Close() error {
+	return e.flush(true)
+}
+
+// decryptingReader reverses encryptingWriter.
+type decryptingReader struct {
+	r       io.ReadCloser
+	aead    cipher.AEAD
+	segment []byte
+	plain   []byte
+	index   uint64
+	done    bool
+}
+
+func newDecryptingReader(r io.ReadCloser, dataKey []byte) (*decryptingReader, error) {
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptingReader{
+		r:       r,
+		aead:    aead,
+		segment: make([]byte, encryptionSegmentSize+aead.Overhead()),
+	}, nil
+}
+
+func (d *decryptingReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.next(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+func (d *decryptingReader) next() error {
+	n, err := io.ReadFull(d.r, d.segment)
+	final := false
+	switch {
+	case errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.EOF):
+		final = true
+	case err != nil:
+		return err
+	}
+
+	plain, err := d.aead.Open(d.segment[:0], segmentNonce(d.aead, d.index), d.segment[:n], segmentAD(final))
+	if err != nil {
+		return fmt.Errorf("encrypted object is corrupt or truncated")
+	}
+
+	d.index++
+	d.plain = plain
+	d.done = final
+	return nil
+}
+
+func (d *decryptingReader) Close() error {
+	return d.r.Close()
+}
+
+// decryptObject wraps the stored data of an encrypted object so reads return
+// the plaintext.
+func (storage *ObjectStorage) decryptObject(file io.ReadCloser, enc *ObjectEncryption) (io.ReadCloser, error) {
+	if enc.Algorithm != encryptionAlgorithm {
+		return nil, fmt.Errorf("unsupported encryption algorithm %q", enc.Algorithm)
+	}
+	if storage.kms == nil {
+		return nil, fmt.Errorf("object is encrypted but no KMS is configured")
+	}
+
+	dataKey, err := storage.kms.UnwrapKey(enc.KeyID, enc.WrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+	return newDecryptingReader(file, dataKey)
+}
+
+// RewrapReport summarizes a data key re-wrap pass.
+type RewrapReport struct {
+	KeyID     string `json:"key_id"`
+	Scanned   int    `json:"scanned"`
+	Rewrapped int    `json:"rewrapped"`
+	Failed    int    `json:"failed"`
+}
+
+// RewrapKeys re-wraps the data key of every encrypted object that is not
+// wrapped by the current master key. Object data is not touched, which is
+// what makes master key rotation cheap.
+func (storage *ObjectStorage) RewrapKeys() (*RewrapReport, error) {
+	if storage.kms == nil {
+		return nil, fmt.Errorf("no KMS is configured")
+	}
+
+	current, err := storage.kms.CurrentKeyID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current key: %w", err)
+	}
+
+	buckets, err := storage.ListBuckets()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &RewrapReport{KeyID: current}
+	for _, bucket := range buckets {
+		err := storage.WalkObjects(bucket.Name, func(object ObjectMetadata) error {
+			if object.Encryption == nil {
+				return nil
+			}
+			report.Scanned++
+			if object.Encryption.KeyID == current {
+				return nil
+			}
+
+			if err := storage.rewrapObject(bucket.Name, object); err != nil {
+				storage.logger.Error("failed to re-wrap data key", "bucket", bucket.Name, "key", object.Key, "error", err)
+				report.Failed++
+				return nil
+			}
+			report.Rewrapped++
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+func (storage *ObjectStorage) rewrapObject(bucketName string, object ObjectMetadata) error {
+	dataKey, err := storage.kms.UnwrapKey(object.Encryption.KeyID, object.Encryption.WrappedKey)
+	if err != nil {
+		return err
+	}
+
+	keyID, wrapped, err := storage.kms.WrapKey(dataKey)
+	if err != nil {
+		return err
+	}
+
+	storage.bucketMu.Lock()
+	defer storage.bucketMu.Unlock()
+
+	// Skip the update if the object was rewritten in the meantime; the new
+	// version already has a freshly wrapped key.
+	metadata, err := storage.loadObjectMetadata(bucketName, object.Key)
+	if err != nil || metadata.Generation != object.Generation {
+		return nil
+	}
+
+	metadata.Encryption.KeyID = keyID
+	metadata.Encryption.WrappedKey = wrapped
+	return storage.saveObjectMetaData(bucketName, metadata)
+}
+
+// handleKMSRewrap serves POST /admin/kms/rewrap.
+func (s *StorageServer) handleKMSRewrap(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	report, err := s.storage.RewrapKeys()
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	s.logger.Info("data keys re-wrapped", "key_id", report.KeyID, "rewrapped", report.Rewrapped, "failed", report.Failed)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
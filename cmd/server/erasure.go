@@ -0,0 +1,558 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"storage-system/internal/ids"
+)
+
+const (
+	defaultErasureDataShards   = 4
+	defaultErasureParityShards = 2
+	defaultErasureBlockSize    = 1 << 20
+)
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// ErasureConfig enables the erasure-coded backend: object data is cut into
+// blocks, each block is split into DataShards shards plus ParityShards
+// parity shards, and the shards are spread over Disks. Any DataShards of the
+// shards are enough to read an object, so it survives the loss of up to
+// ParityShards disks.
+type ErasureConfig struct {
+	Disks        []string `json:"disks"`
+	DataShards   int      `json:"data_shards,omitempty"`
+	ParityShards int      `json:"parity_shards,omitempty"`
+	BlockSize    int64    `json:"block_size,omitempty"`
+}
+
+func (e *ErasureConfig) dataShards() int {
+	if e.DataShards == 0 {
+		return defaultErasureDataShards
+	}
+	return e.DataShards
+}
+
+func (e *ErasureConfig) parityShards() int {
+	if e.ParityShards == 0 {
+		return defaultErasureParityShards
+	}
+	return e.ParityShards
+}
+
+func (e *ErasureConfig) blockSize() int64 {
+	if e.BlockSize == 0 {
+		return defaultErasureBlockSize
+	}
+	return e.BlockSize
+}
+
+func (e *ErasureConfig) validate(config *Config) error {
+	if e == nil {
+		return nil
+	}
+	if e.DataShards < 0 || e.ParityShards < 0 || e.BlockSize < 0 {
+		return fmt.Errorf("erasure: data_shards, parity_shards and block_size must not be negative")
+	}
+	shards := e.dataShards() + e.parityShards()
+	if shards > 256 {
+		return fmt.Errorf("erasure: at most 256 shards are supported")
+	}
+	if len(e.Disks) < shards {
+		return fmt.Errorf("erasure: %d shards need at least %d disks, got %d", shards, shards, len(e.Disks))
+	}
+	// Both replace the layout of object data on disk.
+	if config.Dedup != nil {
+		return fmt.Errorf("erasure cannot be combined with dedup")
+	}
+	return nil
+}
+
+// ObjectErasure records how an object's data is erasure coded. Shard i of
+// the object is the file {id}.{i} on one of the disks.
+type ObjectErasure struct {
+	ID           string `json:"id"`
+	DataShards   int    `json:"data_shards"`
+	ParityShards int    `json:"parity_shards"`
+	BlockSize    int64  `json:"block_size"`
+
+	// Size is the number of bytes encoded, after compression and
+	// encryption.
+	Size int64 `json:"size"`
+}
+
+// erasureStore writes and reads shard sets and counts how many object
+// versions reference each one. As with dedup chunks, counts are rebuilt from
+// object metadata at startup and unreferenced shard sets are removed by
+// garbage collection.
+type erasureStore struct {
+	disks        []string
+	dataShards   int
+	parityShards int
+	blockSize    int64
+	codec        *reedSolomon
+	logger       *slog.Logger
+
+	mu   sync.Mutex
+	refs map[string]int
+}
+
+func newErasureStore(config *ErasureConfig, logger *slog.Logger) (*erasureStore, error) {
+	codec, err := newReedSolomon(config.dataShards(), config.parityShards())
+	if err != nil {
+		return nil, err
+	}
+
+	disks := make([]string, len(config.Disks))
+	for i, disk := range config.Disks {
+		if abs, err := filepath.Abs(disk); err == nil {
+			disk = abs
+		}
+		if err := os.MkdirAll(disk, 0755); err != nil {
+			logger.Warn("erasure disk unavailable", "disk", disk, "error", err)
+		}
+		disks[i] = disk
+	}
+
+	return &erasureStore{
+		disks:        disks,
+		dataShards:   config.dataShards(),
+		parityShards: config.parityShards(),
+		blockSize:    config.blockSize(),
+		codec:        codec,
+		logger:       logger,
+		refs:         make(map[string]int),
+	}, nil
+}
+
+// shardDisk returns the disk shard i of a set is written to. Sets start on
+// different disks so shards spread evenly when there are more disks than
+// shards.
+func (e *erasureStore) shardDisk(id string, shard int) string {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return e.disks[(int(h.Sum32()%uint32(len(e.disks)))+shard)%len(e.disks)]
+}
+
+// shardPath returns the path of a shard on a disk. ULIDs start with a
+// timestamp, so their random tail spreads sets over directories.
+func shardPath(disk, id string, shard int) string {
+	return filepath.Join(disk, id[len(id)-2:], id+"."+strconv.Itoa(shard))
+}
+
+// findShard returns the path of a shard, looking on the disk it was
+// written to first and then on every other disk, so shards can be found
+// after disks are reordered or replaced.
+func (e *erasureStore) findShard(id string, shard int) (string, bool) {
+	preferred := e.shardDisk(id, shard)
+	for _, disk := range append([]string{preferred}, e.disks...) {
+		path := shardPath(disk, id, shard)
+		if _, err := os.Stat(path); err == nil {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+func (e *erasureStore) addRef(info *ObjectErasure) {
+	if info == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.refs[info.ID]++
+}
+
+func (e *erasureStore) release(info *ObjectErasure) {
+	if info == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.refs[info.ID]--; e.refs[info.ID] <= 0 {
+		delete(e.refs, info.ID)
+	}
+}
+
+// parseShardName splits a shard file name into its set ID and shard index.
+func parseShardName(name string) (string, bool) {
+	id, index, ok := strings.Cut(name, ".")
+	if !ok || len(id) != 26 {
+		return "", false
+	}
+	if _, err := strconv.Atoi(index); err != nil {
+		return "", false
+	}
+	return id, true
+}
+
+// orphans lists shards of sets that no object references. Key is the
+// shard's path.
+func (e *erasureStore) orphans(cutoff time.Time) ([]OrphanEntry, error) {
+	var orphans []OrphanEntry
+	for _, disk := range e.disks {
+		err := filepath.Walk(disk, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) && path == disk {
+					return filepath.SkipDir
+				}
+				return err
+			}
+			if info.IsDir() {
+				return nil
+			}
+			id, ok := parseShardName(info.Name())
+			if !ok {
+				return nil
+			}
+
+			e.mu.Lock()
+			referenced := e.refs[id] > 0
+			e.mu.Unlock()
+
+			if !referenced {
+				orphans = append(orphans, OrphanEntry{
+					Kind:     "shard",
+					Key:      path,
+					Size:     info.Size(),
+					Modified: info.ModTime(),
+					Eligible: info.ModTime().Before(cutoff),
+				})
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return orphans, nil
+}
+
+// remove deletes an unreferenced shard. The reference count and age are
+// checked again under the lock so a set committed since the scan survives.
+func (e *erasureStore) remove(path string, cutoff time.Time) (bool, error) {
+	id, ok := parseShardName(filepath.Base(path))
+	if !ok {
+		return false, nil
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.refs[id] > 0 {
+		return false, nil
+	}
+	info, err := os.Stat(path)
+	if err != nil || !info.ModTime().Before(cutoff) {
+		return false, nil
+	}
+	return true, os.Remove(path)
+}
+
+// CheckDisks reports how many disks are writable. Writes need at least
+// dataShards of them.
+func (e *erasureStore) CheckDisks() (int, error) {
+	writable := 0
+	for _, disk := range e.disks {
+		probe, err := os.CreateTemp(disk, ".readyz-*")
+		if err != nil {
+			continue
+		}
+		probe.Close()
+		os.Remove(probe.Name())
+		writable++
+	}
+	if writable < e.dataShards {
+		return writable, fmt.Errorf("only %d of %d erasure disks writable, need %d", writable, len(e.disks), e.dataShards)
+	}
+	return writable, nil
+}
+
+// shardSegmentSize is the size of the piece of a block stored in each
+// shard; every segment is followed by its CRC-32C.
+func shardSegmentSize(blockLen int64, dataShards int) int64 {
+	return (blockLen + int64(dataShards) - 1) / int64(dataShards)
+}
+
+// erasureWriter encodes written data block by block into a new shard set.
+// A shard whose disk fails is dropped; the write fails only when fewer than
+// dataShards shards remain.
+type erasureWriter struct {
+	store *erasureStore
+	id    string
+	files []*os.File
+	buf   []byte
+	size  int64
+}
+
+func (e *erasureStore) newWriter() (*erasureWriter, error) {
+	w := &erasureWriter{
+		store: e,
+		id:    ids.New(),
+		files: make([]*os.File, e.dataShards+e.parityShards),
+		buf:   make([]byte, 0, e.blockSize),
+	}
+
+	for i := range w.files {
+		// Disks are created at startup only, so a disk that is not mounted
+		// is not silently replaced by a directory on another filesystem.
+		path := shardPath(e.shardDisk(w.id, i), w.id, i)
+		err := os.Mkdir(filepath.Dir(path), 0755)
+		if err == nil || os.IsExist(err) {
+			w.files[i], err = os.Create(path)
+		}
+		if err != nil {
+			e.logger.Warn("erasure shard unavailable, writing degraded", "shard", i, "path", path, "error", err)
+		}
+	}
+	if err := w.checkQuorum(); err != nil {
+		w.abort()
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *erasureWriter) checkQuorum() error {
+	healthy := 0
+	for _, file := range w.files {
+		if file != nil {
+			healthy++
+		}
+	}
+	if healthy < w.store.dataShards {
+		return fmt.Errorf("only %d erasure shards writable, need %d", healthy, w.store.dataShards)
+	}
+	return nil
+}
+
+func (w *erasureWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), cap(w.buf)-len(w.buf))
+		w.buf = append(w.buf, p[:n]...)
+		p = p[n:]
+		written += n
+
+		if len(w.buf) == cap(w.buf) {
+			if err := w.flush(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+func (w *erasureWriter) flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+
+	store := w.store
+	segment := int(shardSegmentSize(int64(len(w.buf)), store.dataShards))
+	shards := make([][]byte, store.dataShards+store.parityShards)
+	for i := range shards {
+		shards[i] = make([]byte, segment, segment+4)
+	}
+	for d := 0; d < store.dataShards; d++ {
+		start := min(d*segment, len(w.buf))
+		copy(shards[d], w.buf[start:min(start+segment, len(w.buf))])
+	}
+	store.codec.encode(shards)
+
+	for i, file := range w.files {
+		if file == nil {
+			continue
+		}
+		data := binary.BigEndian.AppendUint32(shards[i], crc32.Checksum(shards[i], crc32c))
+		if _, err := file.Write(data); err != nil {
+			store.logger.Warn("erasure shard write failed, writing degraded", "shard", i, "path", file.Name(), "error", err)
+			file.Close()
+			os.Remove(file.Name())
+			w.files[i] = nil
+		}
+	}
+	if err := w.checkQuorum(); err != nil {
+		return err
+	}
+
+	w.size += int64(len(w.buf))
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// Close writes the final partial block and closes the shard files.
+func (w *erasureWriter) Close() error {
+	if err := w.flush(); err != nil {
+		w.abort()
+		return err
+	}
+	for i, file := range w.files {
+		if file == nil {
+			continue
+		}
+		if err := file.Close(); err != nil {
+			w.store.logger.Warn("erasure shard write failed, writing degraded", "shard", i, "path", file.Name(), "error", err)
+			os.Remove(file.Name())
+			w.files[i] = nil
+		}
+	}
+	return w.checkQuorum()
+}
+
+// abort removes the shards written so far.
+func (w *erasureWriter) abort() {
+	for i, file := range w.files {
+		if file != nil {
+			file.Close()
+			os.Remove(file.Name())
+			w.files[i] = nil
+		}
+	}
+}
+
+func (w *erasureWriter) info() *ObjectErasure {
+	return &ObjectErasure{
+		ID:           w.id,
+		DataShards:   w.store.dataShards,
+		ParityShards: w.store.parityShards,
+		BlockSize:    w.store.blockSize,
+		Size:         w.size,
+	}
+}
+
+// erasureReader decodes a shard set block by block. Shards that are missing
+// or fail their checksum are rebuilt from the others.
+type erasureReader struct {
+	store *erasureStore
+	info  *ObjectErasure
+	codec *reedSolomon
+	files []*os.File
+
+	offset   int64
+	block    []byte
+	degraded bool
+}
+
+func (e *erasureStore) open(info *ObjectErasure) (*erasureReader, error) {
+	codec := e.codec
+	if info.DataShards != e.dataShards || info.ParityShards != e.parityShards {
+		var err error
+		codec, err = newReedSolomon(info.DataShards, info.ParityShards)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	r := &erasureReader{
+		store: e,
+		info:  info,
+		codec: codec,
+		files: make([]*os.File, info.DataShards+info.ParityShards),
+	}
+	found := 0
+	for i := range r.files {
+		if path, ok := e.findShard(info.ID, i); ok {
+			r.files[i], _ = os.Open(path)
+		}
+		if r.files[i] == nil {
+			r.markDegraded(i, "missing")
+		} else {
+			found++
+		}
+	}
+	if found < info.DataShards {
+		r.Close()
+		return nil, fmt.Errorf("failed to read erasure set %s: only %d of %d shards found, need %d", info.ID, found, len(r.files), info.DataShards)
+	}
+
+	// Decode the first block now, so an unreadable object fails before a
+	// response is started.
+	if info.Size > 0 {
+		if err := r.readBlock(); err != nil {
+			r.Close()
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+func (r *erasureReader) markDegraded(shard int, reason string) {
+	if !r.degraded {
+		r.store.logger.Warn("erasure shard unreadable, reconstructing", "set", r.info.ID, "shard", shard, "reason", reason)
+	}
+	r.degraded = true
+}
+
+func (r *erasureReader) Read(p []byte) (int, error) {
+	if len(r.block) == 0 {
+		if r.offset >= r.info.Size {
+			return 0, io.EOF
+		}
+		if err := r.readBlock(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.block)
+	r.block = r.block[n:]
+	return n, nil
+}
+
+// readBlock decodes the block at r.offset.
+func (r *erasureReader) readBlock() error {
+	blockLen := min(r.info.BlockSize, r.info.Size-r.offset)
+	segment := shardSegmentSize(blockLen, r.info.DataShards)
+	position := (r.offset / r.info.BlockSize) * (shardSegmentSize(r.info.BlockSize, r.info.DataShards) + 4)
+
+	shards := make([][]byte, len(r.files))
+	available := 0
+	for i, file := range r.files {
+		// Data shards come first, so parity is only read when needed.
+		if file == nil || available == r.info.DataShards {
+			continue
+		}
+		buf := make([]byte, segment+4)
+		if _, err := file.ReadAt(buf, position); err != nil {
+			r.markDegraded(i, err.Error())
+			continue
+		}
+		data := buf[:segment]
+		if binary.BigEndian.Uint32(buf[segment:]) != crc32.Checksum(data, crc32c) {
+			r.markDegraded(i, "checksum mismatch")
+			continue
+		}
+		shards[i] = data
+		available++
+	}
+
+	if err := r.codec.reconstructData(shards, int(segment)); err != nil {
+		return fmt.Errorf("failed to read erasure set %s: %w", r.info.ID, err)
+	}
+
+	block := make([]byte, 0, segment*int64(r.info.DataShards))
+	for d := 0; d < r.info.DataShards; d++ {
+		block = append(block, shards[d]...)
+	}
+	r.block = block[:blockLen]
+	r.offset += blockLen
+	return nil
+}
+
+func (r *erasureReader) Close() error {
+	for _, file := range r.files {
+		if file != nil {
+			file.Close()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+const kafkaDefaultTopic = "storage-events"
+
+// kafkaPublisher produces events through the Kafka REST Proxy (v2 API), so
+// the server needs no Kafka client library.
+type kafkaPublisher struct {
+	endpoint string
+	client   *http.Client
+}
+
+func newKafkaPublisher(config *EventBusConfig) *kafkaPublisher {
+	topic := config.Topic
+	if topic == "" {
+		topic = kafkaDefaultTopic
+	}
+	endpoint, _ := url.JoinPath(config.URL, "topics", topic)
+	return &kafkaPublisher{endpoint: endpoint, client: &http.Client{}}
+}
+
+func (p *kafkaPublisher) Publish(ctx context.Context, event Event) error {
+	body, err := json.Marshal(map[string]any{
+		"records": []map[string]any{{
+			"key":   event.Bucket + "/" + event.Key,
+			"value": event,
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.kafka.json.v2+json")
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("kafka rest proxy returned %s: %s", resp.Status, bytes.TrimSpace(message))
+	}
+
+	// The proxy answers 200 even when a record failed; errors are per offset.
+	var result struct {
+		Offsets []struct {
+			ErrorCode *int   `json:"error_code"`
+			Error     string `json:"error"`
+		} `json:"offsets"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("failed to decode kafka rest proxy response: %w", err)
+	}
+	for _, offset := range result.Offsets {
+		if offset.ErrorCode != nil {
+			return fmt.Errorf("kafka error %d: %s", *offset.ErrorCode, offset.Error)
+		}
+	}
+	return nil
+}
+
+func (p *kafkaPublisher) Close() error {
+	p.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"storage-system/internal/ids"
+)
+
+const natsDefaultSubject = "storage.events"
+
+// natsPublisher speaks the NATS client protocol over a single connection.
+// It is used from one goroutine only.
+type natsPublisher struct {
+	url       *url.URL
+	subject   string
+	jetstream bool
+
+	conn   net.Conn
+	reader *bufio.Reader
+	inbox  string
+	seq    int
+}
+
+func newNATSPublisher(config *EventBusConfig) *natsPublisher {
+	u, _ := url.Parse(config.URL)
+	subject := config.Subject
+	if subject == "" {
+		subject = natsDefaultSubject
+	}
+	return &natsPublisher{url: u, subject: subject, jetstream: config.JetStream}
+}
+
+func (p *natsPublisher) connect(ctx context.Context) error {
+	host := p.url.Host
+	if p.url.Port() == "" {
+		host = net.JoinHostPort(p.url.Hostname(), "4222")
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return err
+	}
+	p.conn = conn
+	p.reader = bufio.NewReader(conn)
+	p.setDeadline(ctx)
+
+	// The server greets with INFO before anything else.
+	line, err := p.readLine()
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		return fmt.Errorf("unexpected NATS greeting: %q", line)
+	}
+
+	options := map[string]any{
+		"verbose":       false,
+		"pedantic":      false,
+		"name":          "storage-server",
+		"lang":          "go",
+		"headers":       true,
+		"no_responders": true,
+	}
+	if user := p.url.User; user != nil {
+		if pass, ok := user.Password(); ok {
+			options["user"] = user.Username()
+			options["pass"] = pass
+		} else {
+			options["auth_token"] = user.Username()
+		}
+	}
+	connect, err := json.Marshal(options)
+	if err != nil {
+		return err
+	}
+
+	commands := "CONNECT " + string(connect) + "\r\n"
+	if p.jetstream {
+		p.inbox = "_INBOX." + ids.New()
+		commands += "SUB " + p.inbox + ".* 1\r\n"
+	}
+	if _, err := p.conn.Write([]byte(commands)); err != nil {
+		return err
+	}
+	return p.flush()
+}
+
+func (p *natsPublisher) setDeadline(ctx context.Context) {
+	if deadline, ok := ctx.Deadline(); ok {
+		p.conn.SetDeadline(deadline)
+	} else {
+		p.conn.SetDeadline(time.Time{})
+	}
+}
+
+func (p *natsPublisher) readLine() (string, error) {
+	line, err := p.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// flush sends PING and waits for the PONG, which tells us the server has
+// processed everything sent before it.
+func (p *natsPublisher) flush() error {
+	if _, err := p.conn.Write([]byte("PING\r\n")); err != nil {
+		return err
+	}
+	for {
+		line, err := p.readLine()
+		if err != nil {
+			return err
+		}
+		if done, err := p.handleControl(line); done || err != nil {
+			return err
+		}
+	}
+}
+
+// handleControl processes a protocol line that is not a message. It reports
+// done when the line is the PONG a flush waits for.
+func (p *natsPublisher) handleControl(line string) (done bool, err error) {
+	switch {
+	case line == "PONG":
+		return true, nil
+	case line == "PING":
+		_, err := p.conn.Write([]byte("PONG\r\n"))
+		return false, err
+	case strings.HasPrefix(line, "-ERR"):
+		return false, fmt.Errorf("NATS error: %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+	}
+	// +OK, INFO updates and anything else are ignored.
+	return false, nil
+}
+
+func (p *natsPublisher) Publish(ctx context.Context, event Event) error {
+	if p.conn == nil {
+		if err := p.connect(ctx); err != nil {
+			p.Close()
+			return fmt.Errorf("failed to connect to NATS: %w", err)
+		}
+	}
+	p.setDeadline(ctx)
+
+	err := p.publish(event)
+	if err != nil {
+		// Start from a fresh connection on the next attempt.
+		p.Close()
+	}
+	return err
+}
+
+func (p *natsPublisher) publish(event Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	subject := p.subject + "." + event.Type
+
+	if !p.jetstream {
+		if _, err := fmt.Fprintf(p.conn, "PUB %s %d\r\n%s\r\n", subject, len(data), data); err != nil {
+			return err
+		}
+		return p.flush()
+	}
+
+	// The event ID doubles as the JetStream message ID, so a retry after a
+	// lost ack is not stored twice.
+	p.seq++
+	reply := p.inbox + "." + strconv.Itoa(p.seq)
+	header := "NATS/1.0\r\nNats-Msg-Id: " + event.ID + "\r\n\r\n"
+	if _, err := fmt.Fprintf(p.conn, "HPUB %s %s %d %d\r\n%s%s\r\n", subject, reply, len(header), len(header)+len(data), header, data); err != nil {
+		return err
+	}
+	return p.waitAck(reply)
+}
+
+// waitAck reads until JetStream answers on reply.
+func (p *natsPublisher) waitAck(reply string) error {
+	for {
+		line, err := p.readLine()
+		if err != nil {
+			return err
+		}
+
+		// MSG <subject> <sid> <bytes> or HMSG <subject> <sid> <header bytes> <total bytes>
+		fields := strings.Fields(line)
+		if len(fields) == 0 || (fields[0] != "MSG" && fields[0] != "HMSG") {
+			if _, err := p.handleControl(line); err != nil {
+				return err
+			}
+			continue
+		}
+
+		size, err := strconv.Atoi(fields[len(fields)-1])
+		if err != nil {
+			return fmt.Errorf("malformed NATS message: %q", line)
+		}
+		payload := make([]byte, size+2)
+		if _, err := io.ReadFull(p.reader, payload); err != nil {
+			return err
+		}
+		payload = payload[:size]
+
+		if fields[1] != reply {
+			// An ack for an earlier attempt that timed out.
+			continue
+		}
+
+		if fields[0] == "HMSG" {
+			// A status-only message: 503 means no stream covers the subject.
+			status := strings.SplitN(string(payload), "\r\n", 2)[0]
+			return fmt.Errorf("JetStream did not accept the event: %s", status)
+		}
+
+		var ack struct {
+			Stream string `json:"stream"`
+			Error  *struct {
+				Code        int    `json:"code"`
+				Description string `json:"description"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal(payload, &ack); err != nil {
+			return fmt.Errorf("malformed JetStream ack: %w", err)
+		}
+		if ack.Error != nil {
+			return fmt.Errorf("JetStream error %d: %s", ack.Error.Code, ack.Error.Description)
+		}
+		return nil
+	}
+}
+
+func (p *natsPublisher) Close() error {
+	if p.conn == nil {
+		return nil
+	}
+	err := p.conn.Close()
+	p.conn = nil
+	return err
+}
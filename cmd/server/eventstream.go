@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"storage-system/internal/ids"
+)
+
+const (
+	// eventStreamBuffer is how many events a slow stream client can fall
+	// behind before further events are dropped for it.
+	eventStreamBuffer = 256
+
+	// eventStreamKeepalive is how often an idle stream sends a comment, so
+	// proxies and clients do not time the connection out.
+	eventStreamKeepalive = 30 * time.Second
+)
+
+// eventStreams fans object events out to the clients of GET
+// /buckets/{name}?events.
+type eventStreams struct {
+	mu          sync.Mutex
+	subscribers map[*eventSubscriber]struct{}
+	done        chan struct{}
+	closed      bool
+}
+
+// eventSubscriber is one open event stream. filter selects its events the
+// way a bucket notification does.
+type eventSubscriber struct {
+	bucket string
+	filter NotificationConfig
+	events chan Event
+}
+
+func newEventStreams() *eventStreams {
+	return &eventStreams{
+		subscribers: make(map[*eventSubscriber]struct{}),
+		done:        make(chan struct{}),
+	}
+}
+
+func (e *eventStreams) subscribe(bucketName string, filter NotificationConfig) *eventSubscriber {
+	sub := &eventSubscriber{bucket: bucketName, filter: filter, events: make(chan Event, eventStreamBuffer)}
+	e.mu.Lock()
+	e.subscribers[sub] = struct{}{}
+	e.mu.Unlock()
+	return sub
+}
+
+func (e *eventStreams) unsubscribe(sub *eventSubscriber) {
+	e.mu.Lock()
+	delete(e.subscribers, sub)
+	e.mu.Unlock()
+}
+
+// publish sends an event to every matching stream. It never blocks: a
+// stream whose buffer is full misses the event.
+func (e *eventStreams) publish(event Event) (dropped int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for sub := range e.subscribers {
+		if sub.bucket != event.Bucket || !sub.filter.Matches(event.Type, event.Key) {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			dropped++
+		}
+	}
+	return dropped
+}
+
+// close ends every open stream, so a shutdown does not wait for clients
+// that would otherwise stay connected indefinitely.
+func (e *eventStreams) close() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.closed {
+		e.closed = true
+		close(e.done)
+	}
+}
+
+// streamEvent sends an event to the open event streams of its bucket.
+func (s *StorageServer) streamEvent(event Event) {
+	event.ID = ids.New()
+	if dropped := s.streams.publish(event); dropped > 0 {
+		s.logger.Warn("event stream client too slow, dropping event", "bucket", event.Bucket, "key", event.Key, "event", event.Type, "clients", dropped)
+	}
+}
+
+// handleBucketEvents serves GET /buckets/{name}?events as a stream of
+// server-sent events, one per object change from then on. The prefix,
+// suffix and types query parameters filter the events as the fields of a
+// bucket notification do; types is a comma-separated list.
+func (s *StorageServer) handleBucketEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	bucketName := strings.TrimPrefix(r.URL.Path, "/buckets/")
+	if _, err := s.backend.GetBucket(bucketName); err != nil {
+		s.writeStorageError(w, r, err)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := NotificationConfig{Prefix: query.Get("prefix"), Suffix: query.Get("suffix")}
+	if types := query.Get("types"); types != "" {
+		filter.Events = strings.Split(types, ",")
+		for _, eventType := range filter.Events {
+			if !slices.Contains(eventTypes, eventType) {
+				s.writeError(w, r, http.StatusBadRequest, fmt.Sprintf("Unknown event type %q (valid: %s)", eventType, strings.Join(eventTypes, ", ")))
+				return
+			}
+		}
+	}
+
+	sub := s.streams.subscribe(bucketName, filter)
+	defer s.streams.unsubscribe(sub)
+
+	controller := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": subscribed\n\n")
+	if err := controller.Flush(); err != nil {
+		return
+	}
+
+	keepalive := time.NewTicker(eventStreamKeepalive)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.streams.done:
+			return
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		case event := <-sub.events:
+			data, _ := json.Marshal(event)
+			fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+		}
+		if err := controller.Flush(); err != nil {
+			return
+		}
+	}
+}
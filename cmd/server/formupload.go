@@ -0,0 +1,313 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxFormFieldSize limits the form fields sent before the file of a
+// browser upload.
+const maxFormFieldSize = 64 << 10
+
+// PostPolicy constrains a browser form upload. It is issued by
+// POST /admin/presign/post and sent back, base64-encoded and signed, in the
+// policy and signature form fields.
+type PostPolicy struct {
+	Bucket    string    `json:"bucket"`
+	KeyPrefix string    `json:"key_prefix,omitempty"`
+	Expires   time.Time `json:"expires"`
+
+	// MaxSize is the largest file in bytes that may be uploaded; 0 allows
+	// any size.
+	MaxSize int64 `json:"max_size,omitempty"`
+
+	// ContentTypes lists the content type prefixes the file may have; empty
+	// allows any.
+	ContentTypes []string `json:"content_types,omitempty"`
+
+	// SuccessRedirect, when set, is where the browser is sent with 303 after
+	// the upload, with bucket, key and etag query parameters added.
+	SuccessRedirect string `json:"success_redirect,omitempty"`
+}
+
+// PostPolicyRequest is the body of POST /admin/presign/post.
+type PostPolicyRequest struct {
+	Bucket          string   `json:"bucket"`
+	KeyPrefix       string   `json:"key_prefix"`
+	MaxSize         int64    `json:"max_size"`
+	ContentTypes    []string `json:"content_types"`
+	SuccessRedirect string   `json:"success_redirect"`
+	ExpiresIn       Duration `json:"expires_in"`
+}
+
+// PostPolicyResponse carries the form action and the hidden fields a form
+// must include.
+type PostPolicyResponse struct {
+	URL     string            `json:"url"`
+	Fields  map[string]string `json:"fields"`
+	Expires time.Time         `json:"expires"`
+}
+
+// postPolicySignature signs an encoded policy. The method prefix keeps it
+// from ever matching a listing signature.
+func (s *StorageServer) postPolicySignature(encodedPolicy string) string {
+	mac := hmac.New(sha256.New, s.signingKey)
+	fmt.Fprintf(mac, "POST\n%s", encodedPolicy)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// handlePresignPost serves POST /admin/presign/post, which issues a signed
+// policy for uploads from an HTML form.
+func (s *StorageServer) handlePresignPost(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req PostPolicyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeError(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid request body: %v", err))
+		return
+	}
+
+	if req.Bucket == "" || strings.Contains(req.Bucket, "/") {
+		s.writeError(w, r, http.StatusBadRequest, "A bucket name is required")
+		return
+	}
+	if req.MaxSize < 0 {
+		s.writeError(w, r, http.StatusBadRequest, "max_size must not be negative")
+		return
+	}
+	if req.SuccessRedirect != "" {
+		if u, err := url.Parse(req.SuccessRedirect); err != nil || u.Scheme != "http" && u.Scheme != "https" {
+			s.writeError(w, r, http.StatusBadRequest, "success_redirect must be an http or https URL")
+			return
+		}
+	}
+
+	expiresIn := time.Duration(req.ExpiresIn)
+	if expiresIn <= 0 {
+		expiresIn = time.Hour
+	}
+	if expiresIn > maxLinkExpiry {
+		s.writeError(w, r, http.StatusBadRequest, fmt.Sprintf("expires_in must not exceed %s", maxLinkExpiry))
+		return
+	}
+
+	if _, err := s.backend.GetBucket(req.Bucket); err != nil {
+		s.writeStorageError(w, r, err)
+		return
+	}
+
+	policy := PostPolicy{
+		Bucket:          req.Bucket,
+		KeyPrefix:       req.KeyPrefix,
+		Expires:         time.Now().Add(expiresIn).Truncate(time.Second).UTC(),
+		MaxSize:         req.MaxSize,
+		ContentTypes:    req.ContentTypes,
+		SuccessRedirect: req.SuccessRedirect,
+	}
+	data, _ := json.Marshal(policy)
+	encoded := base64.StdEncoding.EncodeToString(data)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(PostPolicyResponse{
+		URL: "/objects/" + req.Bucket,
+		Fields: map[string]string{
+			"policy":    encoded,
+			"signature": s.postPolicySignature(encoded),
+		},
+		Expires: policy.Expires,
+	})
+}
+
+// isFormUpload reports whether r is a browser upload to POST /objects/{bucket}.
+// Forms post to the bucket without a query, so a multipart POST with one,
+// such as ?stat or ?etags, is not an upload and is not let through on the
+// strength of a policy it is never checked against.
+func isFormUpload(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return r.Method == http.MethodPost && mediaType == "multipart/form-data" && r.URL.RawQuery == ""
+}
+
+// handleFormUpload serves POST /objects/{bucket} with a multipart/form-data
+// body: the key, policy and signature fields, optionally Content-Type, and
+// the file last. A key of "uploads/${filename}" takes the name of the
+// uploaded file.
+func (s *StorageServer) handleFormUpload(w http.ResponseWriter, r *http.Request) {
+	bucketName := strings.TrimPrefix(r.URL.Path, "/objects/")
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		s.writeError(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid form: %v", err))
+		return
+	}
+
+	// Fields are read until the file, which is streamed to storage.
+	fields := map[string]string{}
+	var file io.Reader
+	var fileName string
+	for file == nil {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			s.writeError(w, r, http.StatusBadRequest, "Form has no file field")
+			return
+		}
+		if err != nil {
+			s.writeError(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid form: %v", err))
+			return
+		}
+
+		name := strings.ToLower(part.FormName())
+		if name == "file" {
+			file, fileName = part, part.FileName()
+			continue
+		}
+		value, err := io.ReadAll(io.LimitReader(part, maxFormFieldSize+1))
+		if err != nil || len(value) > maxFormFieldSize {
+			s.writeError(w, r, http.StatusBadRequest, fmt.Sprintf("Form field %s is too large or unreadable", name))
+			return
+		}
+		fields[name] = string(value)
+	}
+
+	policy, err := s.verifyPostPolicy(fields["policy"], fields["signature"])
+	if err != nil {
+		s.writeErrorCode(w, r, http.StatusForbidden, "PolicyInvalid", err.Error())
+		return
+	}
+
+	// The key is chosen by whoever fills in the form, so it is checked for
+	// traversal and cleaned before it is compared with the policy's prefix.
+	objectKey := strings.ReplaceAll(fields["key"], "${filename}", path.Base("/"+fileName))
+	if objectKey != "" {
+		if err := validateObjectKey(objectKey); err != nil {
+			s.writeStorageError(w, r, err)
+			return
+		}
+		objectKey = path.Clean(objectKey)
+	}
+	contentType := fields["content-type"]
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	if err := policy.allows(bucketName, objectKey, contentType, time.Now()); err != nil {
+		s.writeErrorCode(w, r, http.StatusForbidden, "PolicyViolation", err.Error())
+		return
+	}
+
+	limited := &sizeLimitReader{r: file, remaining: policy.MaxSize}
+	if policy.MaxSize == 0 {
+		limited.remaining = -1
+	}
+	metadata, err := s.backend.PutObject(bucketName, objectKey, limited, PutOptions{ContentType: contentType, MaxSize: s.maxObjectSize(bucketName), Validate: s.validateUpload})
+	if limited.exceeded {
+		s.writeErrorCode(w, r, http.StatusRequestEntityTooLarge, "EntityTooLarge", fmt.Sprintf("File exceeds the policy's max_size of %d bytes", policy.MaxSize))
+		return
+	}
+	if err != nil {
+		s.writeStorageError(w, r, err)
+		return
+	}
+
+	s.notify(EventPut, bucketName, objectKey, metadata)
+	s.replicate(r, replicationPut, bucketName, objectKey)
+
+	w.Header().Set("ETag", metadata.ETag)
+	w.Header().Set(generationHeader, strconv.FormatInt(metadata.Generation, 10))
+	if policy.SuccessRedirect != "" {
+		target, _ := url.Parse(policy.SuccessRedirect)
+		query := target.Query()
+		query.Set("bucket", bucketName)
+		query.Set("key", objectKey)
+		query.Set("etag", metadata.ETag)
+		target.RawQuery = query.Encode()
+		http.Redirect(w, r, target.String(), http.StatusSeeOther)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(metadata)
+}
+
+// verifyPostPolicy checks the signature of an encoded policy and decodes
+// it.
+func (s *StorageServer) verifyPostPolicy(encoded, signature string) (*PostPolicy, error) {
+	if encoded == "" || signature == "" {
+		return nil, fmt.Errorf("form must include policy and signature fields")
+	}
+	if !hmac.Equal([]byte(s.postPolicySignature(encoded)), []byte(signature)) {
+		return nil, fmt.Errorf("policy signature does not match")
+	}
+
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("policy is not valid base64")
+	}
+	var policy PostPolicy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return nil, fmt.Errorf("policy is not valid JSON")
+	}
+	return &policy, nil
+}
+
+// allows checks an upload against the policy's conditions other than size,
+// which is enforced while the file is read.
+func (policy *PostPolicy) allows(bucketName, objectKey, contentType string, now time.Time) error {
+	if now.After(policy.Expires) {
+		return fmt.Errorf("policy has expired")
+	}
+	if bucketName != policy.Bucket {
+		return fmt.Errorf("policy is for bucket %s", policy.Bucket)
+	}
+	if objectKey == "" {
+		return fmt.Errorf("form must include a key field")
+	}
+	if !strings.HasPrefix(objectKey, policy.KeyPrefix) {
+		return fmt.Errorf("key must start with %q", policy.KeyPrefix)
+	}
+	allowed := func(prefix string) bool { return strings.HasPrefix(contentType, prefix) }
+	if len(policy.ContentTypes) > 0 && !slices.ContainsFunc(policy.ContentTypes, allowed) {
+		return fmt.Errorf("content type %s is not allowed", contentType)
+	}
+	return nil
+}
+
+// sizeLimitReader fails reads once more than remaining bytes have been
+// read, which aborts the upload. A negative remaining is unlimited.
+type sizeLimitReader struct {
+	r         io.Reader
+	remaining int64
+	exceeded  bool
+}
+
+func (l *sizeLimitReader) Read(p []byte) (int, error) {
+	if l.remaining < 0 {
+		return l.r.Read(p)
+	}
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.r.Read(p)
+	if int64(n) > l.remaining {
+		l.exceeded = true
+		return 0, ErrEntityTooLarge
+	}
+	l.remaining -= int64(n)
+	return n, err
+}
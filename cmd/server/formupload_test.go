@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newAuthServer serves a storage with bucket "it" that needs the token
+// "tok".
+func newAuthServer(t *testing.T) (*StorageServer, *ObjectStorage) {
+	t.Helper()
+	storage := newTestStorage(t)
+	config := defaultConfig()
+	config.Auth = &AuthConfig{Tokens: []string{"tok"}}
+	return NewStorageServer(storage, config, discardLogger()), storage
+}
+
+// signedPolicy encodes and signs a policy the way POST /admin/presign/post
+// does.
+func signedPolicy(s *StorageServer, policy PostPolicy) (string, string) {
+	data, _ := json.Marshal(policy)
+	encoded := base64.StdEncoding.EncodeToString(data)
+	return encoded, s.postPolicySignature(encoded)
+}
+
+// formRequest builds an anonymous browser upload to target with the
+// fields in order and the file last.
+func formRequest(t *testing.T, target string, fields [][2]string, file string) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for _, field := range fields {
+		form.WriteField(field[0], field[1])
+	}
+	part, err := form.CreateFormFile("file", "photo.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write([]byte(file))
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, target, &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	return req
+}
+
+func TestFormUploadPolicy(t *testing.T) {
+	s, storage := newAuthServer(t)
+	handler := s.Handler()
+
+	valid := PostPolicy{
+		Bucket:       "it",
+		KeyPrefix:    "uploads/",
+		Expires:      time.Now().Add(time.Hour),
+		MaxSize:      10,
+		ContentTypes: []string{"image/"},
+	}
+	policy, signature := signedPolicy(s, valid)
+
+	expired := valid
+	expired.Expires = time.Now().Add(-time.Minute)
+	expiredPolicy, expiredSignature := signedPolicy(s, expired)
+
+	otherBucket := valid
+	otherBucket.Bucket = "other"
+	otherPolicy, otherSignature := signedPolicy(s, otherBucket)
+
+	tests := []struct {
+		name        string
+		key         string
+		policy      string
+		signature   string
+		contentType string
+		file        string
+		status      int
+		code        string
+	}{
+		{"allowed", "uploads/${filename}", policy, signature, "image/jpeg", "jpeg", http.StatusCreated, ""},
+		{"missing signature", "uploads/a.jpg", policy, "", "image/jpeg", "jpeg", http.StatusForbidden, "PolicyInvalid"},
+		{"bad signature", "uploads/a.jpg", policy, strings.Repeat("0", 64), "image/jpeg", "jpeg", http.StatusForbidden, "PolicyInvalid"},
+		{"expired", "uploads/a.jpg", expiredPolicy, expiredSignature, "image/jpeg", "jpeg", http.StatusForbidden, "PolicyViolation"},
+		{"other bucket", "uploads/a.jpg", otherPolicy, otherSignature, "image/jpeg", "jpeg", http.StatusForbidden, "PolicyViolation"},
+		{"outside the key prefix", "private/a.jpg", policy, signature, "image/jpeg", "jpeg", http.StatusForbidden, "PolicyViolation"},
+		{"traversal out of the key prefix", "uploads/../private/a.jpg", policy, signature, "image/jpeg", "jpeg", http.StatusBadRequest, "InvalidKey"},
+		{"content type not allowed", "uploads/a.html", policy, signature, "text/html", "<html>", http.StatusForbidden, "PolicyViolation"},
+		{"too large", "uploads/big.jpg", policy, signature, "image/jpeg", strings.Repeat("x", 11), http.StatusRequestEntityTooLarge, "EntityTooLarge"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fields := [][2]string{{"key", tt.key}, {"policy", tt.policy}, {"signature", tt.signature}, {"Content-Type", tt.contentType}}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, formRequest(t, "/objects/it", fields, tt.file))
+			if recorder.Code != tt.status {
+				t.Fatalf("got %d %s, want %d", recorder.Code, recorder.Body, tt.status)
+			}
+			if tt.code != "" && !strings.Contains(recorder.Body.String(), `"code":"`+tt.code+`"`) {
+				t.Errorf("response %s lacks code %s", recorder.Body, tt.code)
+			}
+		})
+	}
+
+	if _, err := storage.StatObject("it", "uploads/photo.jpg"); err != nil {
+		t.Errorf("allowed upload was not stored: %v", err)
+	}
+	for _, key := range []string{"private/a.jpg", "uploads/a.html", "uploads/big.jpg"} {
+		if _, err := storage.StatObject("it", key); err == nil {
+			t.Errorf("rejected upload %s was stored", key)
+		}
+	}
+}
+
+// TestFormUploadQueryNeedsAuth checks that only a form upload itself is
+// exempt from credentials: the bulk lookups on the same path must not be
+// reachable by giving them a multipart content type.
+func TestFormUploadQueryNeedsAuth(t *testing.T) {
+	s, storage := newAuthServer(t)
+	putString(t, storage, "it", "payroll.csv", "secret")
+	handler := s.Handler()
+
+	for _, query := range []string{"stat", "etags"} {
+		req := httptest.NewRequest(http.MethodPost, "/objects/it?"+query, strings.NewReader(`{"keys":["payroll.csv"]}`))
+		req.Header.Set("Content-Type", "multipart/form-data; boundary=x")
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		if recorder.Code != http.StatusUnauthorized {
+			t.Errorf("anonymous multipart POST ?%s: got %d %s, want 401", query, recorder.Code, recorder.Body)
+		}
+	}
+}
@@ -0,0 +1,536 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	gohash "hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	backendS3 = "s3"
+
+	gatewayHeaderTimeout = 30 * time.Second
+
+	// gatewayMetadataHeader carries the base64 JSON ObjectMetadata of an
+	// object, so tags, checksums and generations survive the round trip.
+	gatewayMetadataHeader = "X-Amz-Meta-Storage-Metadata"
+)
+
+// GatewayConfig points the s3 backend at an upstream bucket. Any service
+// speaking the S3 API works: AWS S3, MinIO, or Google Cloud Storage through
+// its XML API with HMAC keys (endpoint https://storage.googleapis.com,
+// region auto). Credentials are read from the standard AWS_* environment
+// variables.
+type GatewayConfig struct {
+	Endpoint string `json:"endpoint,omitempty"`
+	Region   string `json:"region"`
+	Bucket   string `json:"bucket"`
+
+	// Prefix is prepended to every key written upstream, so several
+	// servers can share one bucket.
+	Prefix string `json:"prefix,omitempty"`
+
+	// PathStyle addresses the bucket as {endpoint}/{bucket} instead of
+	// {bucket}.{endpoint host}, as MinIO usually requires.
+	PathStyle bool `json:"path_style,omitempty"`
+}
+
+func (g *GatewayConfig) validate() error {
+	if g == nil {
+		return fmt.Errorf("the s3 backend requires s3 settings")
+	}
+	if g.Bucket == "" || g.Region == "" {
+		return fmt.Errorf("s3: bucket and region are required")
+	}
+	if g.Endpoint != "" {
+		if u, err := url.Parse(g.Endpoint); err != nil || u.Host == "" {
+			return fmt.Errorf("s3: endpoint must be an absolute URL")
+		}
+	}
+	return nil
+}
+
+// gatewayBackend stores buckets and objects in one upstream S3 bucket:
+// bucket records as {prefix}buckets/{name}.json and object data as
+// {prefix}objects/{bucket}/{key}. Nothing is kept locally.
+type gatewayBackend struct {
+	config *GatewayConfig
+	base   *url.URL
+	creds  awsCredentials
+	client *http.Client
+}
+
+func newGatewayBackend(config *GatewayConfig) (*gatewayBackend, error) {
+	endpoint := config.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", config.Region)
+	}
+	base, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if !config.PathStyle {
+		base.Host = config.Bucket + "." + base.Host
+	}
+
+	creds := awsCredentialsFromEnv()
+	if !creds.valid() {
+		return nil, fmt.Errorf("s3: AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set for the s3 backend")
+	}
+
+	// Downloads are streamed to clients, so only the wait for response
+	// headers is bounded.
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = gatewayHeaderTimeout
+
+	return &gatewayBackend{
+		config: config,
+		base:   base,
+		creds:  creds,
+		client: &http.Client{Transport: transport},
+	}, nil
+}
+
+func (g *gatewayBackend) bucketRecordKey(bucketName string) string {
+	return g.config.Prefix + "buckets/" + bucketName + ".json"
+}
+
+func (g *gatewayBackend) objectPrefix(bucketName string) string {
+	return g.config.Prefix + "objects/" + bucketName + "/"
+}
+
+// s3Error is the error document S3 returns.
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// errUpstreamNotFound is returned for 404 responses; callers replace it with
+// their own not found error.
+var errUpstreamNotFound = errors.New("upstream object not found")
+
+// do sends a signed request for key (empty for the bucket itself). body
+// must be nil or a seekable file or reader whose SHA-256 is payloadHash.
+func (g *gatewayBackend) do(method, key string, query url.Values, header http.Header, body io.Reader, size int64, payloadHash string) (*http.Response, error) {
+	u := *g.base
+	path := strings.TrimSuffix(u.Path, "/")
+	if g.config.PathStyle {
+		path += "/" + g.config.Bucket
+	}
+	if key != "" || path == "" {
+		path += "/" + key
+	}
+	u.Path = path
+	u.RawPath = awsEscape(path, true)
+	u.RawQuery = awsQuery(query)
+
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	if payloadHash == "" {
+		payloadHash = emptyPayloadHash
+	}
+	signV4(req, g.creds, g.config.Region, "s3", payloadHash, time.Now().UTC())
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("s3 %s %s failed: %w", method, key, err)
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, errUpstreamNotFound
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		var e s3Error
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		if xml.Unmarshal(data, &e) == nil && e.Code != "" {
+			return nil, fmt.Errorf("s3 %s %s failed: %s: %s", method, key, e.Code, e.Message)
+		}
+		return nil, fmt.Errorf("s3 %s %s failed: %s", method, key, resp.Status)
+	}
+	return resp, nil
+}
+
+func (g *gatewayBackend) putBytes(key, contentType string, data []byte) error {
+	sum := sha256.Sum256(data)
+	digest := md5.Sum(data)
+	header := http.Header{}
+	header.Set("Content-Type", contentType)
+	header.Set("Content-Md5", base64.StdEncoding.EncodeToString(digest[:]))
+
+	resp, err := g.do(http.MethodPut, key, nil, header, bytes.NewReader(data), int64(len(data)), hex.EncodeToString(sum[:]))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (g *gatewayBackend) CreateBucket(bucketName, template string, owner BucketOwner, settings BucketSettings) error {
+	bucket := Bucket{
+		Name:        bucketName,
+		Created:     time.Now(),
+		Template:    template,
+		BucketOwner: owner,
+		Settings:    settings,
+	}
+	if existing, err := g.GetBucket(bucketName); err == nil {
+		if bucket, err = recreateBucket(existing, template, owner, settings); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.Marshal(bucket)
+	if err != nil {
+		return err
+	}
+	if err := g.putBytes(g.bucketRecordKey(bucketName), "application/json", data); err != nil {
+		return fmt.Errorf("failed to create Bucket: %w", err)
+	}
+	return nil
+}
+
+func (g *gatewayBackend) GetBucket(bucketName string) (Bucket, error) {
+	resp, err := g.do(http.MethodGet, g.bucketRecordKey(bucketName), nil, nil, nil, 0, "")
+	if errors.Is(err, errUpstreamNotFound) {
+		return Bucket{}, fmt.Errorf("bucket not found: %s", bucketName)
+	}
+	if err != nil {
+		return Bucket{}, err
+	}
+	defer resp.Body.Close()
+
+	var bucket Bucket
+	if err := json.NewDecoder(resp.Body).Decode(&bucket); err != nil {
+		return Bucket{}, fmt.Errorf("failed to parse bucket record: %w", err)
+	}
+	return bucket, nil
+}
+
+func (g *gatewayBackend) ListBuckets() ([]Bucket, error) {
+	prefix := g.config.Prefix + "buckets/"
+
+	var buckets []Bucket
+	err := g.list(prefix, "", func(entry s3ListEntry) error {
+		name, ok := strings.CutSuffix(strings.TrimPrefix(entry.Key, prefix), ".json")
+		if !ok || strings.Contains(name, "/") {
+			return nil
+		}
+		bucket, err := g.GetBucket(name)
+		if err != nil {
+			return err
+		}
+		buckets = append(buckets, bucket)
+		return nil
+	})
+	return buckets, err
+}
+
+type s3ListEntry struct {
+	Key          string    `xml:"Key"`
+	Size         int64     `xml:"Size"`
+	ETag         string    `xml:"ETag"`
+	LastModified time.Time `xml:"LastModified"`
+}
+
+// list calls fn for every upstream key under prefix, in key order, using
+// ListObjectsV2. A non-empty startAfter skips the keys up to and including
+// it.
+func (g *gatewayBackend) list(prefix, startAfter string, fn func(s3ListEntry) error) error {
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		} else if startAfter != "" {
+			query.Set("start-after", startAfter)
+		}
+
+		resp, err := g.do(http.MethodGet, "", query, nil, nil, 0, "")
+		if err != nil {
+			return err
+		}
+
+		var result struct {
+			Contents              []s3ListEntry `xml:"Contents"`
+			IsTruncated           bool          `xml:"IsTruncated"`
+			NextContinuationToken string        `xml:"NextContinuationToken"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("failed to parse s3 listing: %w", err)
+		}
+
+		for _, entry := range result.Contents {
+			if err := fn(entry); err != nil {
+				return err
+			}
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// PutObject spools the data to a temp file so it can be verified and sent
+// with a Content-Length and payload hash, which S3 requires.
+func (g *gatewayBackend) PutObject(bucketName, objectKey string, data io.Reader, opts PutOptions) (*ObjectMetadata, error) {
+	validated, err := opts.validate(bucketName, objectKey, data)
+	if err != nil {
+		return nil, err
+	}
+	defer validated.Close()
+	data = validated
+
+	bucket, err := g.GetBucket(bucketName)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	generation := int64(1)
+	existing, err := g.StatObject(bucketName, objectKey)
+	if err == nil {
+		if err := checkRetention(existing, now); err != nil {
+			return nil, err
+		}
+		generation = existing.Generation + 1
+	}
+	// S3 has no conditional PUT, so another writer can still get in
+	// between this check and the upload.
+	if err := opts.checkPrecondition(existing); err != nil {
+		return nil, err
+	}
+
+	tempFile, err := os.CreateTemp("", "gateway-upload-*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(tempFile.Name())
+	defer tempFile.Close()
+
+	hash := md5.New()
+	payload := sha256.New()
+	writers := []io.Writer{tempFile, hash, payload}
+
+	var checksum gohash.Hash
+	if opts.ChecksumAlgorithm != "" {
+		checksum, err = newChecksumHash(opts.ChecksumAlgorithm)
+		if err != nil {
+			return nil, err
+		}
+		writers = append(writers, checksum)
+	}
+
+	size, err := io.Copy(io.MultiWriter(writers...), opts.limitSize(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to write object data: %w", err)
+	}
+
+	digest := hash.Sum(nil)
+	if opts.ContentMD5 != nil && !bytes.Equal(digest, opts.ContentMD5) {
+		return nil, fmt.Errorf("%w: got %s", ErrBadDigest, base64.StdEncoding.EncodeToString(digest))
+	}
+
+	var checksums map[string]string
+	if checksum != nil {
+		value := hex.EncodeToString(checksum.Sum(nil))
+		if opts.ExpectedChecksum != "" && value != opts.ExpectedChecksum {
+			return nil, fmt.Errorf("%w: %s is %s", ErrBadChecksum, opts.ChecksumAlgorithm, value)
+		}
+		checksums = map[string]string{opts.ChecksumAlgorithm: value}
+	}
+
+	metadata := &ObjectMetadata{
+		Key:          objectKey,
+		Size:         size,
+		ContentType:  opts.ContentType,
+		ETag:         hex.EncodeToString(digest),
+		LastModified: now,
+		Tags:         opts.Tags,
+		CacheControl: opts.CacheControl,
+		Expires:      opts.Expires,
+		Checksums:    checksums,
+		Generation:   generation,
+		StoredSize:   size,
+		RetainUntil:  laterTime(bucket.Settings.ObjectLock.retainUntil(now), opts.RetainUntil),
+		LegalHold:    opts.LegalHold,
+		Scan:         opts.Scan,
+	}
+	encoded, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tempFile.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	header := http.Header{}
+	header.Set("Content-Type", opts.ContentType)
+	header.Set("Content-Md5", base64.StdEncoding.EncodeToString(digest))
+	header.Set(gatewayMetadataHeader, base64.StdEncoding.EncodeToString(encoded))
+
+	resp, err := g.do(http.MethodPut, g.objectPrefix(bucketName)+objectKey, nil, header, tempFile, size, hex.EncodeToString(payload.Sum(nil)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to store object upstream: %w", err)
+	}
+	resp.Body.Close()
+	return metadata, nil
+}
+
+// objectMetadata rebuilds an object's metadata from upstream response
+// headers. Objects written to the bucket by other tools have no stored
+// metadata and get what S3 reports.
+func (g *gatewayBackend) objectMetadata(objectKey string, header http.Header) *ObjectMetadata {
+	if encoded := header.Get(gatewayMetadataHeader); encoded != "" {
+		if data, err := base64.StdEncoding.DecodeString(encoded); err == nil {
+			var metadata ObjectMetadata
+			if json.Unmarshal(data, &metadata) == nil {
+				metadata.Key = objectKey
+				return &metadata
+			}
+		}
+	}
+
+	metadata := &ObjectMetadata{
+		Key:         objectKey,
+		ContentType: header.Get("Content-Type"),
+		ETag:        strings.Trim(header.Get("ETag"), `"`),
+		Generation:  1,
+	}
+	metadata.Size, _ = strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	metadata.StoredSize = metadata.Size
+	metadata.LastModified, _ = http.ParseTime(header.Get("Last-Modified"))
+	return metadata
+}
+
+func (g *gatewayBackend) GetObject(bucketName, objectKey string) (io.ReadCloser, *ObjectMetadata, error) {
+	resp, err := g.do(http.MethodGet, g.objectPrefix(bucketName)+objectKey, nil, nil, nil, 0, "")
+	if errors.Is(err, errUpstreamNotFound) {
+		return nil, nil, fmt.Errorf("object not found")
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp.Body, g.objectMetadata(objectKey, resp.Header), nil
+}
+
+func (g *gatewayBackend) StatObject(bucketName, objectKey string) (*ObjectMetadata, error) {
+	resp, err := g.do(http.MethodHead, g.objectPrefix(bucketName)+objectKey, nil, nil, nil, 0, "")
+	if errors.Is(err, errUpstreamNotFound) {
+		return nil, fmt.Errorf("object not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return g.objectMetadata(objectKey, resp.Header), nil
+}
+
+func (g *gatewayBackend) DeleteObject(bucketName, objectKey string) error {
+	existing, err := g.StatObject(bucketName, objectKey)
+	if err == nil {
+		if err := checkRetention(existing, time.Now()); err != nil {
+			return err
+		}
+	}
+
+	resp, err := g.do(http.MethodDelete, g.objectPrefix(bucketName)+objectKey, nil, nil, nil, 0, "")
+	if errors.Is(err, errUpstreamNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete object: %w", err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// WalkObjects lists a bucket from the upstream. Listings carry only what S3
+// returns: key, size, ETag and modification time.
+func (g *gatewayBackend) WalkObjects(bucketName string, fn func(ObjectMetadata) error) error {
+	if _, err := g.GetBucket(bucketName); err != nil {
+		return err
+	}
+
+	prefix := g.objectPrefix(bucketName)
+	return g.list(prefix, "", func(entry s3ListEntry) error {
+		return fn(s3ObjectMetadata(prefix, entry))
+	})
+}
+
+// WalkKeyRange has the upstream start an ascending walk after keys.After.
+// S3 lists in one direction only, so a descending walk reads the range
+// first and then calls fn from its end.
+func (g *gatewayBackend) WalkKeyRange(bucketName string, keys KeyRange, fn func(ObjectMetadata) error) error {
+	if _, err := g.GetBucket(bucketName); err != nil {
+		return err
+	}
+
+	prefix := g.objectPrefix(bucketName)
+	if keys.Desc {
+		var objects []ObjectMetadata
+		err := g.list(prefix+keys.Prefix, "", func(entry s3ListEntry) error {
+			if metadata := s3ObjectMetadata(prefix, entry); keys.includes(metadata.Key) {
+				objects = append(objects, metadata)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, metadata := range slices.Backward(objects) {
+			if err := fn(metadata); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	startAfter := ""
+	if keys.After != "" {
+		startAfter = prefix + keys.After
+	}
+	return g.list(prefix+keys.Prefix, startAfter, func(entry s3ListEntry) error {
+		if metadata := s3ObjectMetadata(prefix, entry); keys.includes(metadata.Key) {
+			return fn(metadata)
+		}
+		return nil
+	})
+}
+
+// s3ObjectMetadata describes an object from its upstream listing entry.
+func s3ObjectMetadata(prefix string, entry s3ListEntry) ObjectMetadata {
+	return ObjectMetadata{
+		Key:          strings.TrimPrefix(entry.Key, prefix),
+		Size:         entry.Size,
+		ETag:         strings.Trim(entry.ETag, `"`),
+		LastModified: entry.LastModified,
+		StoredSize:   entry.Size,
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OrphanEntry is a stored file that no longer has a counterpart: data
+// without metadata, metadata whose data file is gone, or a deduplicated
+// chunk no object references.
+type OrphanEntry struct {
+	Kind     string    `json:"kind"`
+	Bucket   string    `json:"bucket"`
+	Key      string    `json:"key"`
+	Location string    `json:"location,omitempty"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+	Eligible bool      `json:"eligible"`
+}
+
+type GCReport struct {
+	GeneratedAt    time.Time     `json:"generated_at"`
+	SafetyWindow   string        `json:"safety_window"`
+	Orphans        []OrphanEntry `json:"orphans"`
+	OrphanBytes    int64         `json:"orphan_bytes"`
+	Removed        int           `json:"removed"`
+	ReclaimedBytes int64         `json:"reclaimed_bytes"`
+}
+
+type gcState struct {
+	mu      sync.Mutex
+	lastRun *GCReport
+}
+
+func isInternalDataFile(name string) bool {
+	if strings.HasPrefix(name, ".readyz-") {
+		return true
+	}
+	matched, _ := filepath.Match("upload-*.tmp", name)
+	return matched
+}
+
+// FindOrphans scans the data and metadata trees for files without a
+// counterpart. Orphans modified more recently than safetyWindow are reported
+// but not eligible for removal, so uploads in progress are never collected.
+func (storage *ObjectStorage) FindOrphans(safetyWindow time.Duration, now time.Time) ([]OrphanEntry, error) {
+	var orphans []OrphanEntry
+	cutoff := now.Add(-safetyWindow)
+
+	for _, root := range storage.roots {
+		buckets, err := storage.ReadDir(root.dataDir)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range buckets {
+			if !entry.IsDir() {
+				continue
+			}
+			bucketName := entry.Name()
+			bucketPath := filepath.Join(root.dataDir, bucketName)
+
+			err := filepath.Walk(bucketPath, func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if info.IsDir() || isInternalDataFile(info.Name()) {
+					return nil
+				}
+
+				relPath, err := filepath.Rel(bucketPath, path)
+				if err != nil {
+					return err
+				}
+				key := filepath.ToSlash(relPath)
+
+				if _, err := storage.loadObjectMetadata(bucketName, decodeKeyPath(key)); storage.IsNotExist(err) {
+					orphans = append(orphans, OrphanEntry{
+						Kind:     "data",
+						Bucket:   bucketName,
+						Key:      key,
+						Location: root.id,
+						Size:     info.Size(),
+						Modified: info.ModTime(),
+						Eligible: info.ModTime().Before(cutoff),
+					})
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	buckets, err := storage.ReadDir(storage.dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range buckets {
+		if !entry.IsDir() {
+			continue
+		}
+		bucketName := entry.Name()
+
+		err := storage.metadata.WalkObjects(bucketName, func(metadata ObjectMetadata) error {
+			if !storage.dataFileExists(bucketName, filepath.FromSlash(encodeKeyPath(metadata.Key))) {
+				orphans = append(orphans, OrphanEntry{
+					Kind:     "metadata",
+					Bucket:   bucketName,
+					Key:      metadata.Key,
+					Modified: metadata.LastModified,
+					Eligible: metadata.LastModified.Before(cutoff),
+				})
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if storage.chunks != nil {
+		chunks, err := storage.chunks.orphans(cutoff)
+		if err != nil {
+			return nil, err
+		}
+		orphans = append(orphans, chunks...)
+	}
+
+	if storage.erasure != nil {
+		shards, err := storage.erasure.orphans(cutoff)
+		if err != nil {
+			return nil, err
+		}
+		orphans = append(orphans, shards...)
+	}
+
+	return orphans, nil
+}
+
+// CollectGarbage removes every eligible orphan and reports what was found and
+// how much space was reclaimed.
+func (storage *ObjectStorage) CollectGarbage(safetyWindow time.Duration, remove bool) (*GCReport, error) {
+	now := time.Now()
+	orphans, err := storage.FindOrphans(safetyWindow, now)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &GCReport{
+		GeneratedAt:  now,
+		SafetyWindow: safetyWindow.String(),
+		Orphans:      orphans,
+	}
+
+	for _, orphan := range orphans {
+		report.OrphanBytes += orphan.Size
+		if !remove || !orphan.Eligible {
+			continue
+		}
+
+		if orphan.Kind == "chunk" || orphan.Kind == "shard" {
+			var removed bool
+			var err error
+			if orphan.Kind == "chunk" {
+				removed, err = storage.chunks.remove(orphan.Key, now.Add(-safetyWindow))
+			} else {
+				removed, err = storage.erasure.remove(orphan.Key, now.Add(-safetyWindow))
+			}
+			if err != nil {
+				storage.logger.Error("failed to remove orphan", "kind", orphan.Kind, "key", orphan.Key, "error", err)
+			}
+			if removed && err == nil {
+				report.Removed++
+				report.ReclaimedBytes += orphan.Size
+			}
+			continue
+		}
+
+		var err error
+		if orphan.Kind == "metadata" {
+			err = storage.metadata.DeleteObject(orphan.Bucket, orphan.Key)
+		} else {
+			var root *dataRoot
+			root, err = storage.dataRoot(orphan.Location)
+			if err == nil {
+				err = storage.Remove(filepath.Join(root.dataDir, orphan.Bucket, filepath.FromSlash(orphan.Key)))
+			}
+		}
+		if err != nil && !storage.IsNotExist(err) {
+			storage.logger.Error("failed to remove orphan", "kind", orphan.Kind, "bucket", orphan.Bucket, "key", orphan.Key, "error", err)
+			continue
+		}
+		report.Removed++
+		report.ReclaimedBytes += orphan.Size
+	}
+
+	if report.Orphans == nil {
+		report.Orphans = []OrphanEntry{}
+	}
+	return report, nil
+}
+
+// handleGC serves /admin/gc. GET reports current orphans together with the
+// result of the last collection; POST runs a collection.
+func (s *StorageServer) handleGC(w http.ResponseWriter, r *http.Request) {
+	window := time.Duration(s.config.GCSafetyWindow)
+
+	var response any
+	switch r.Method {
+	case http.MethodGet:
+		report, err := s.storage.CollectGarbage(window, false)
+		if err != nil {
+			s.writeError(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		s.gc.mu.Lock()
+		lastRun := s.gc.lastRun
+		s.gc.mu.Unlock()
+
+		response = struct {
+			Current *GCReport `json:"current"`
+			LastRun *GCReport `json:"last_run,omitempty"`
+		}{report, lastRun}
+	case http.MethodPost:
+		report, err := s.storage.CollectGarbage(window, true)
+		if err != nil {
+			s.writeError(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		s.gc.mu.Lock()
+		s.gc.lastRun = report
+		s.gc.mu.Unlock()
+
+		s.logger.Info("garbage collection complete", "removed", report.Removed, "reclaimed_bytes", report.ReclaimedBytes)
+		response = report
+	default:
+		s.writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+const readinessCheckTimeout = 2 * time.Second
+
+type readinessReport struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// handleLiveness reports that the process is up and serving HTTP. It never
+// touches storage so a slow disk cannot get the process restarted.
+func (s *StorageServer) handleLiveness(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
+}
+
+// handleReadiness reports whether the server can currently serve traffic:
+// the data directory is writable, the metadata store answers, and the disk
+// has at least the configured minimum of free space.
+func (s *StorageServer) handleReadiness(w http.ResponseWriter, r *http.Request) {
+	report := readinessReport{Status: "ready", Checks: map[string]string{}}
+
+	check := func(name string, err error, ok string) {
+		if err != nil {
+			report.Status = "not ready"
+			report.Checks[name] = err.Error()
+			return
+		}
+		report.Checks[name] = ok
+	}
+
+	if s.storage == nil {
+		check("backend", withTimeout(readinessCheckTimeout, func() error {
+			_, err := s.backend.ListBuckets()
+			return err
+		}), "ok")
+	} else {
+		s.checkFilesystem(check)
+	}
+
+	status := http.StatusOK
+	if report.Status != "ready" {
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
+
+// checkFilesystem runs the readiness checks of the filesystem backend.
+func (s *StorageServer) checkFilesystem(check func(name string, err error, ok string)) {
+	check("data_dir", s.storage.CheckWritable(), "ok")
+	check("metadata", withTimeout(readinessCheckTimeout, s.storage.CheckMetadata), "ok")
+
+	// New objects go to the data directory with the most free space, so
+	// one with room is enough.
+	free, err := s.storage.maxFreeSpace()
+	if err == nil && free < s.config.MinFreeBytes {
+		err = fmt.Errorf("only %d bytes free, need %d", free, s.config.MinFreeBytes)
+	}
+	check("disk", err, fmt.Sprintf("ok (%d bytes free)", free))
+
+	if s.storage.erasure != nil {
+		writable, err := s.storage.erasure.CheckDisks()
+		check("erasure_disks", err, fmt.Sprintf("ok (%d of %d writable)", writable, len(s.storage.erasure.disks)))
+	}
+}
+
+// CheckWritable verifies that a file can be created and removed in every
+// data directory.
+func (storage *ObjectStorage) CheckWritable() error {
+	for _, root := range storage.roots {
+		probe, err := os.CreateTemp(root.dataDir, ".readyz-*")
+		if err != nil {
+			return fmt.Errorf("data directory not writable: %w", err)
+		}
+		probe.Close()
+		if err := storage.Remove(probe.Name()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckMetadata verifies that the metadata store can be read.
+func (storage *ObjectStorage) CheckMetadata() error {
+	if err := storage.metadata.Check(); err != nil {
+		return fmt.Errorf("metadata store unavailable: %w", err)
+	}
+	return nil
+}
+
+func withTimeout(timeout time.Duration, fn func() error) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- fn()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		return fmt.Errorf("timed out after %s", timeout)
+	}
+}
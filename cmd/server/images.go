@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	defaultImageMaxDimension  = 2048
+	defaultImageMaxSourceSize = 20 << 20
+	defaultImageCacheSize     = 64 << 20
+
+	// maxImagePixels refuses sources that would take too much memory to
+	// decode, whatever their size on disk.
+	maxImagePixels = 50_000_000
+
+	imageJPEGQuality = 85
+)
+
+// errUnsupportedImage is returned for data that is not a JPEG, PNG or GIF
+// image that can be decoded.
+var errUnsupportedImage = errors.New("unsupported image")
+
+// Output formats of image transformations, named like image.Decode names
+// the formats it reads.
+var imageContentTypes = map[string]string{
+	"jpeg": "image/jpeg",
+	"png":  "image/png",
+	"gif":  "image/gif",
+}
+
+// ImageTransformConfig controls the resized and converted images served for
+// GET /objects/{bucket}/{key}?resize=WxH&format=F. It is on unless disabled.
+type ImageTransformConfig struct {
+	// Enabled is true unless set to false.
+	Enabled *bool `json:"enabled,omitempty"`
+
+	// MaxDimension is the largest width or height that can be requested; 0
+	// uses 2048.
+	MaxDimension int `json:"max_dimension,omitempty"`
+
+	// MaxSourceSize is the largest image in bytes that is transformed; 0
+	// uses 20 MiB.
+	MaxSourceSize int64 `json:"max_source_size,omitempty"`
+
+	// CacheSize is how many bytes of transformed images are kept in memory;
+	// 0 uses 64 MiB.
+	CacheSize int64 `json:"cache_size,omitempty"`
+}
+
+func (c *ImageTransformConfig) validate() error {
+	if c == nil {
+		return nil
+	}
+	if c.MaxDimension < 0 || c.MaxSourceSize < 0 || c.CacheSize < 0 {
+		return fmt.Errorf("image_transforms: limits must not be negative")
+	}
+	return nil
+}
+
+func (c *ImageTransformConfig) enabled() bool {
+	return c == nil || c.Enabled == nil || *c.Enabled
+}
+
+func (c *ImageTransformConfig) maxDimension() int {
+	if c == nil || c.MaxDimension == 0 {
+		return defaultImageMaxDimension
+	}
+	return c.MaxDimension
+}
+
+func (c *ImageTransformConfig) maxSourceSize() int64 {
+	if c == nil || c.MaxSourceSize == 0 {
+		return defaultImageMaxSourceSize
+	}
+	return c.MaxSourceSize
+}
+
+func (c *ImageTransformConfig) cacheSize() int64 {
+	if c == nil || c.CacheSize == 0 {
+		return defaultImageCacheSize
+	}
+	return c.CacheSize
+}
+
+// imageTransform is a requested derived image. A zero width or height is
+// derived from the other, keeping the aspect ratio.
+type imageTransform struct {
+	width, height int
+	format        string
+}
+
+// parseImageTransform reads the resize and format query parameters.
+func parseImageTransform(resize, format string, maxDimension int) (imageTransform, error) {
+	var t imageTransform
+	if resize != "" {
+		width, height, ok := strings.Cut(strings.ToLower(resize), "x")
+		if !ok || (width == "" && height == "") {
+			return t, fmt.Errorf("resize must be WIDTHxHEIGHT, WIDTHx or xHEIGHT")
+		}
+		for _, side := range []struct {
+			value string
+			dest  *int
+		}{{width, &t.width}, {height, &t.height}} {
+			if side.value == "" {
+				continue
+			}
+			n, err := strconv.Atoi(side.value)
+			if err != nil || n < 1 || n > maxDimension {
+				return t, fmt.Errorf("resize dimensions must be between 1 and %d", maxDimension)
+			}
+			*side.dest = n
+		}
+	}
+
+	switch format = strings.ToLower(format); format {
+	case "", "jpeg", "png", "gif":
+		t.format = format
+	case "jpg":
+		t.format = "jpeg"
+	case "webp":
+		return t, fmt.Errorf("webp output is not supported; use jpeg, png or gif")
+	default:
+		return t, fmt.Errorf("format must be jpeg, png or gif")
+	}
+	return t, nil
+}
+
+func (t imageTransform) String() string {
+	return fmt.Sprintf("%dx%d.%s", t.width, t.height, t.format)
+}
+
+// fit returns the size of a width x height image scaled to fit within the
+// requested box. Images are never enlarged.
+func (t imageTransform) fit(width, height int) (int, int) {
+	scale := 1.0
+	if t.width > 0 && t.width < width {
+		scale = float64(t.width) / float64(width)
+	}
+	if t.height > 0 && t.height < height {
+		scale = min(scale, float64(t.height)/float64(height))
+	}
+	return max(int(float64(width)*scale+0.5), 1), max(int(float64(height)*scale+0.5), 1)
+}
+
+// transformImage decodes a JPEG, PNG or GIF image, scales it and encodes it
+// in the requested format, or its own. Only the first frame of an animated
+// GIF is kept.
+func transformImage(data io.Reader, t imageTransform) ([]byte, string, error) {
+	source, err := io.ReadAll(data)
+	if err != nil {
+		return nil, "", err
+	}
+	config, format, err := image.DecodeConfig(bytes.NewReader(source))
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", errUnsupportedImage, err)
+	}
+	if int64(config.Width)*int64(config.Height) > maxImagePixels {
+		return nil, "", fmt.Errorf("%w: the image has more than %d pixels", errUnsupportedImage, maxImagePixels)
+	}
+	img, _, err := image.Decode(bytes.NewReader(source))
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", errUnsupportedImage, err)
+	}
+
+	bounds := img.Bounds()
+	width, height := t.fit(bounds.Dx(), bounds.Dy())
+	if width != bounds.Dx() || height != bounds.Dy() {
+		img = scaleImage(img, width, height)
+	}
+
+	if t.format != "" {
+		format = t.format
+	}
+	var out bytes.Buffer
+	switch format {
+	case "jpeg":
+		err = jpeg.Encode(&out, img, &jpeg.Options{Quality: imageJPEGQuality})
+	case "png":
+		err = png.Encode(&out, img)
+	case "gif":
+		err = gif.Encode(&out, img, nil)
+	default:
+		return nil, "", fmt.Errorf("%w: cannot encode %s", errUnsupportedImage, format)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return out.Bytes(), imageContentTypes[format], nil
+}
+
+// scaleImage shrinks img to width x height by averaging the source pixels
+// under each destination pixel, first across and then down.
+func scaleImage(img image.Image, width, height int) *image.RGBA {
+	bounds := img.Bounds()
+	src := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+
+	across := image.NewRGBA(image.Rect(0, 0, width, src.Rect.Dy()))
+	for y := range src.Rect.Dy() {
+		for x := range width {
+			from, to := scaleSpan(x, width, src.Rect.Dx())
+			var sum [4]uint32
+			for sx := from; sx < to; sx++ {
+				p := src.Pix[src.PixOffset(sx, y):]
+				for c := range sum {
+					sum[c] += uint32(p[c])
+				}
+			}
+			d := across.Pix[across.PixOffset(x, y):]
+			for c := range sum {
+				d[c] = uint8(sum[c] / uint32(to-from))
+			}
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		from, to := scaleSpan(y, height, across.Rect.Dy())
+		for x := range width {
+			var sum [4]uint32
+			for sy := from; sy < to; sy++ {
+				p := across.Pix[across.PixOffset(x, sy):]
+				for c := range sum {
+					sum[c] += uint32(p[c])
+				}
+			}
+			d := dst.Pix[dst.PixOffset(x, y):]
+			for c := range sum {
+				d[c] = uint8(sum[c] / uint32(to-from))
+			}
+		}
+	}
+	return dst
+}
+
+// scaleSpan returns the source pixels covered by destination pixel i when n
+// source pixels are scaled to size.
+func scaleSpan(i, size, n int) (int, int) {
+	from := i * n / size
+	to := (i + 1) * n / size
+	return from, max(to, from+1)
+}
+
+// imageCache keeps recently transformed images, least recently used first
+// out.
+type imageCache struct {
+	capacity int64
+
+	mu      sync.Mutex
+	size    int64
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type cachedImage struct {
+	key         string
+	data        []byte
+	contentType string
+}
+
+func newImageCache(capacity int64) *imageCache {
+	return &imageCache{capacity: capacity, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+func (c *imageCache) get(key string) (*cachedImage, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*cachedImage), true
+}
+
+func (c *imageCache) add(entry *cachedImage) {
+	if int64(len(entry.data)) > c.capacity {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[entry.key]; ok {
+		return
+	}
+	c.entries[entry.key] = c.order.PushFront(entry)
+	c.size += int64(len(entry.data))
+	for c.size > c.capacity {
+		oldest := c.order.Back()
+		evicted := c.order.Remove(oldest).(*cachedImage)
+		delete(c.entries, evicted.key)
+		c.size -= int64(len(evicted.data))
+	}
+}
+
+// handleImageTransform serves GET and HEAD on
+// /objects/{bucket}/{key}?resize=WxH&format=F with a scaled or converted
+// copy of an image. Results are cached by the object's ETag, so a new
+// version of the object is transformed again.
+func (s *StorageServer) handleImageTransform(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		s.writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if s.images == nil {
+		s.writeErrorCode(w, r, http.StatusNotImplemented, "NotImplemented", "Image transformations are disabled")
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/objects/")
+	bucketName, objectKey, _ := strings.Cut(path, "/")
+
+	query := r.URL.Query()
+	transform, err := parseImageTransform(query.Get("resize"), query.Get("format"), s.config.ImageTransforms.maxDimension())
+	if err != nil {
+		s.writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	reader, metadata, ok := s.openObject(w, r, bucketName, objectKey)
+	if !ok {
+		return
+	}
+	defer reader.Close()
+
+	if !strings.HasPrefix(metadata.ContentType, "image/") {
+		s.writeErrorCode(w, r, http.StatusUnsupportedMediaType, "UnsupportedImage", "Only images can be transformed")
+		return
+	}
+	if limit := s.config.ImageTransforms.maxSourceSize(); metadata.Size > limit {
+		s.writeErrorCode(w, r, http.StatusUnprocessableEntity, "ImageTooLarge", fmt.Sprintf("Only images of up to %d bytes can be transformed", limit))
+		return
+	}
+
+	cacheKey := bucketName + "/" + objectKey + "\x00" + metadata.ETag + "\x00" + transform.String()
+	result, ok := s.images.get(cacheKey)
+	if !ok {
+		data, contentType, err := transformImage(reader, transform)
+		if err != nil {
+			if errors.Is(err, errUnsupportedImage) {
+				s.writeErrorCode(w, r, http.StatusUnsupportedMediaType, "UnsupportedImage", err.Error())
+			} else {
+				s.writeError(w, r, http.StatusInternalServerError, err.Error())
+			}
+			return
+		}
+		result = &cachedImage{key: cacheKey, data: data, contentType: contentType}
+		s.images.add(result)
+	}
+
+	w.Header().Set("Content-Type", result.contentType)
+	w.Header().Set("ETag", metadata.ETag+"-"+transform.String())
+	w.Header().Set("Last-Modified", metadata.LastModified.Format(http.TimeFormat))
+	w.Header().Set("Content-Length", strconv.Itoa(len(result.data)))
+	w.Header().Set(generationHeader, strconv.FormatInt(metadata.Generation, 10))
+	s.setCacheHeaders(w, bucketName, metadata)
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodGet {
+		w.Write(result.data)
+	}
+}
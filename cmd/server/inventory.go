@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const inventoryFetchTimeout = 2 * time.Minute
+
+// InventoryEntry is one object in a bucket manifest. The list-objects
+// response of another server is a valid manifest.
+type InventoryEntry struct {
+	Key  string `json:"key"`
+	Size int64  `json:"size"`
+	ETag string `json:"etag"`
+}
+
+// InventoryRequest carries either an inline manifest or the URL of another
+// server's list-objects endpoint to fetch it from.
+type InventoryRequest struct {
+	Manifest []InventoryEntry `json:"manifest,omitempty"`
+	URL      string           `json:"url,omitempty"`
+}
+
+type InventoryMismatch struct {
+	Key        string `json:"key"`
+	LocalETag  string `json:"local_etag"`
+	RemoteETag string `json:"remote_etag"`
+	LocalSize  int64  `json:"local_size"`
+	RemoteSize int64  `json:"remote_size"`
+}
+
+// InventoryDiff is the difference between a manifest and this server's copy
+// of the bucket: Missing keys are only in the manifest, Extra keys only here.
+type InventoryDiff struct {
+	Bucket     string              `json:"bucket"`
+	Compared   int                 `json:"compared"`
+	Matching   int                 `json:"matching"`
+	Missing    []string            `json:"missing"`
+	Extra      []string            `json:"extra"`
+	Mismatched []InventoryMismatch `json:"mismatched"`
+}
+
+// CompareInventory diffs the bucket against manifest.
+func (storage *ObjectStorage) CompareInventory(bucketName string, manifest []InventoryEntry) (*InventoryDiff, error) {
+	remote := make(map[string]InventoryEntry, len(manifest))
+	for _, entry := range manifest {
+		remote[entry.Key] = entry
+	}
+
+	diff := &InventoryDiff{
+		Bucket:     bucketName,
+		Missing:    []string{},
+		Extra:      []string{},
+		Mismatched: []InventoryMismatch{},
+	}
+
+	seen := make(map[string]bool, len(remote))
+	err := storage.WalkObjects(bucketName, func(metadata ObjectMetadata) error {
+		diff.Compared++
+		entry, ok := remote[metadata.Key]
+		if !ok {
+			diff.Extra = append(diff.Extra, metadata.Key)
+			return nil
+		}
+
+		seen[metadata.Key] = true
+		if entry.ETag != metadata.ETag || entry.Size != metadata.Size {
+			diff.Mismatched = append(diff.Mismatched, InventoryMismatch{
+				Key:        metadata.Key,
+				LocalETag:  metadata.ETag,
+				RemoteETag: entry.ETag,
+				LocalSize:  metadata.Size,
+				RemoteSize: entry.Size,
+			})
+			return nil
+		}
+
+		diff.Matching++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for key := range remote {
+		if !seen[key] {
+			diff.Missing = append(diff.Missing, key)
+		}
+	}
+	sort.Strings(diff.Missing)
+
+	return diff, nil
+}
+
+func fetchManifest(url string) ([]InventoryEntry, error) {
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return nil, fmt.Errorf("manifest url must be http or https")
+	}
+
+	client := &http.Client{Timeout: inventoryFetchTimeout}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch manifest: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch manifest: %s", resp.Status)
+	}
+
+	var manifest []InventoryEntry
+	if err := json.NewDecoder(resp.Body).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("failed to decode manifest: %w", err)
+	}
+	return manifest, nil
+}
+
+// handleBucketCompare serves POST /buckets/{name}?compare.
+func (s *StorageServer) handleBucketCompare(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	bucketName := strings.TrimPrefix(r.URL.Path, "/buckets/")
+
+	var req InventoryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeError(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid inventory request: %v", err))
+		return
+	}
+
+	manifest := req.Manifest
+	if req.URL != "" {
+		if req.Manifest != nil {
+			s.writeError(w, r, http.StatusBadRequest, "Specify either manifest or url, not both")
+			return
+		}
+
+		var err error
+		manifest, err = fetchManifest(req.URL)
+		if err != nil {
+			s.writeError(w, r, http.StatusBadGateway, err.Error())
+			return
+		}
+	}
+
+	diff, err := s.storage.CompareInventory(bucketName, manifest)
+	if err != nil {
+		s.writeStorageError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(diff)
+}
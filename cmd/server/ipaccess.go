@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// IPAccessConfig restricts which client addresses may use the server or a
+// bucket. Entries are CIDR ranges or single addresses.
+type IPAccessConfig struct {
+	// Allow, when not empty, lists the only ranges that are let in.
+	Allow []string `json:"allow,omitempty"`
+
+	// Deny lists ranges that are refused even if Allow contains them.
+	Deny []string `json:"deny,omitempty"`
+
+	// allow and deny are Allow and Deny as parsed by validate, so the
+	// server's ranges are not parsed again for every request.
+	allow, deny []netip.Prefix
+	parsed      bool
+}
+
+// validate checks the ranges and keeps them parsed.
+func (c *IPAccessConfig) validate() error {
+	if c == nil {
+		return nil
+	}
+	allow, err := parsePrefixes(c.Allow)
+	if err != nil {
+		return fmt.Errorf("ip_access allow: %w", err)
+	}
+	deny, err := parsePrefixes(c.Deny)
+	if err != nil {
+		return fmt.Errorf("ip_access deny: %w", err)
+	}
+	c.allow, c.deny, c.parsed = allow, deny, true
+	return nil
+}
+
+// restricted reports whether any ranges are configured.
+func (c *IPAccessConfig) restricted() bool {
+	return c != nil && (len(c.Allow) > 0 || len(c.Deny) > 0)
+}
+
+// allows reports whether addr may pass. Without ranges everyone may, even
+// a client whose address is unknown; with ranges, it may not. Invalid
+// entries, which validation rejects, never match.
+func (c *IPAccessConfig) allows(addr netip.Addr) bool {
+	if !c.restricted() {
+		return true
+	}
+	if !addr.IsValid() {
+		return false
+	}
+
+	// Bucket settings are read with every request, so their ranges have
+	// not been through validate.
+	allow, deny := c.allow, c.deny
+	if !c.parsed {
+		allow, _ = parsePrefixes(c.Allow)
+		deny, _ = parsePrefixes(c.Deny)
+	}
+	if containsAddr(deny, addr) {
+		return false
+	}
+	return len(c.Allow) == 0 || containsAddr(allow, addr)
+}
+
+// parsePrefixes parses CIDR ranges and single addresses.
+func parsePrefixes(entries []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(entries))
+	for _, entry := range entries {
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an IP address or CIDR range", entry)
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// unixPeerAddr stands for clients on a unix socket listener, which have no
+// IP address but can only be on this machine.
+var unixPeerAddr = netip.AddrFrom4([4]byte{127, 0, 0, 1})
+
+// clientAddr returns the address of the client that sent r. Behind one of
+// the trusted proxies, it is the last address in X-Forwarded-For that is
+// not itself a trusted proxy. Clients on a unix socket are local, so they
+// have the loopback address.
+func (s *StorageServer) clientAddr(r *http.Request) (netip.Addr, bool) {
+	host := r.RemoteAddr
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	addr, err := netip.ParseAddr(host)
+	if local, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok && local.Network() == "unix" {
+		addr, err = unixPeerAddr, nil
+	}
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	addr = addr.Unmap()
+
+	trusted := s.config.trustedProxies
+	if !containsAddr(trusted, addr) {
+		return addr, true
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(forwarded[i]))
+		if err != nil {
+			return addr, true
+		}
+		addr = hop.Unmap()
+		if !containsAddr(trusted, addr) {
+			break
+		}
+	}
+	return addr, true
+}
+
+// restrictIPs refuses requests from addresses outside the server's
+// ip_access ranges or the ranges of the bucket they address. Health checks
+// are always answered.
+func (s *StorageServer) restrictIPs(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isHealthPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		addr, _ := s.clientAddr(r)
+		if !s.config.IPAccess.allows(addr) || !s.bucketAllowsAddr(r, addr) {
+			s.writeErrorCode(w, r, http.StatusForbidden, "AccessDenied", "Access from this address is not allowed")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// bucketAllowsAddr checks addr, which is invalid if the client's address is
+// unknown, against the ip_access of the bucket r addresses, if any.
+func (s *StorageServer) bucketAllowsAddr(r *http.Request, addr netip.Addr) bool {
+	bucketName, _ := auditTarget(r.URL.Path)
+	if rest, ok := strings.CutPrefix(r.URL.Path, "/website/"); ok {
+		bucketName, _, _ = strings.Cut(rest, "/")
+	}
+	if bucketName == "" {
+		return true
+	}
+
+	bucket, err := s.backend.GetBucket(bucketName)
+	if err != nil {
+		// Missing buckets are reported by the handler.
+		return true
+	}
+	return bucket.Settings.IPAccess.allows(addr)
+}
+
+// handleBucketIPAccess serves GET, PUT and DELETE on
+// /buckets/{name}?ip-access.
+func (s *StorageServer) handleBucketIPAccess(w http.ResponseWriter, r *http.Request) {
+	bucketName := strings.TrimPrefix(r.URL.Path, "/buckets/")
+
+	var access *IPAccessConfig
+	switch r.Method {
+	case http.MethodGet, http.MethodDelete:
+	case http.MethodPut:
+		access = &IPAccessConfig{}
+		if err := json.NewDecoder(r.Body).Decode(access); err != nil {
+			s.writeError(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid ip_access configuration: %v", err))
+			return
+		}
+		if err := access.validate(); err != nil {
+			s.writeError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+	default:
+		s.writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var bucket Bucket
+	var err error
+	if r.Method == http.MethodGet {
+		bucket, err = s.storage.GetBucket(bucketName)
+	} else {
+		bucket, err = s.storage.UpdateBucketSettings(bucketName, func(settings *BucketSettings) error {
+			settings.IPAccess = access
+			return nil
+		})
+	}
+	if err != nil {
+		s.writeStorageError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]*IPAccessConfig{"ip_access": bucket.Settings.IPAccess})
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestIPAccessAllows(t *testing.T) {
+	tests := []struct {
+		name   string
+		access *IPAccessConfig
+		addr   string
+		want   bool
+	}{
+		{"no config", nil, "192.0.2.1", true},
+		{"no ranges", &IPAccessConfig{}, "192.0.2.1", true},
+		{"no ranges, unknown address", &IPAccessConfig{}, "", true},
+		{"allowed range", &IPAccessConfig{Allow: []string{"192.0.2.0/24"}}, "192.0.2.1", true},
+		{"outside allowed range", &IPAccessConfig{Allow: []string{"192.0.2.0/24"}}, "198.51.100.1", false},
+		{"single address", &IPAccessConfig{Allow: []string{"198.51.100.7"}}, "198.51.100.7", true},
+		{"deny wins over allow", &IPAccessConfig{Allow: []string{"192.0.2.0/24"}, Deny: []string{"192.0.2.66"}}, "192.0.2.66", false},
+		{"deny only", &IPAccessConfig{Deny: []string{"192.0.2.0/24"}}, "198.51.100.1", true},
+		{"IPv4-mapped IPv6", &IPAccessConfig{Allow: []string{"192.0.2.0/24"}}, "::ffff:192.0.2.1", true},
+		{"IPv4-mapped IPv6 denied", &IPAccessConfig{Deny: []string{"192.0.2.0/24"}}, "::ffff:192.0.2.1", false},
+		{"IPv6 range", &IPAccessConfig{Allow: []string{"2001:db8::/32"}}, "2001:db8::1", true},
+		{"IPv6 outside range", &IPAccessConfig{Allow: []string{"2001:db8::/32"}}, "2001:db9::1", false},
+		{"unknown address", &IPAccessConfig{Deny: []string{"192.0.2.0/24"}}, "", false},
+		{"invalid entry never matches", &IPAccessConfig{Allow: []string{"not-an-ip"}}, "192.0.2.1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var addr netip.Addr
+			if tt.addr != "" {
+				addr = netip.MustParseAddr(tt.addr)
+			}
+			// Bucket settings are checked without validate, the server's
+			// ranges after it; both must agree.
+			if got := tt.access.allows(addr); got != tt.want {
+				t.Errorf("allows(%s) = %v, want %v", tt.addr, got, tt.want)
+			}
+			if tt.access != nil && tt.access.validate() == nil {
+				if got := tt.access.allows(addr); got != tt.want {
+					t.Errorf("after validate, allows(%s) = %v, want %v", tt.addr, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestClientAddr(t *testing.T) {
+	config := defaultConfig()
+	config.TrustedProxies = []string{"10.0.0.5", "10.1.0.0/16"}
+	if err := config.validate(); err != nil {
+		t.Fatal(err)
+	}
+	s := NewStorageServer(newMemoryBackend(), config, discardLogger())
+	unixListener := &net.UnixAddr{Name: "/run/storage.sock", Net: "unix"}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		local      net.Addr
+		want       string
+	}{
+		{"direct", "192.0.2.1:1234", nil, nil, "192.0.2.1"},
+		{"IPv6", "[2001:db8::1]:1234", nil, nil, "2001:db8::1"},
+		{"IPv4-mapped IPv6", "[::ffff:192.0.2.1]:1234", nil, nil, "192.0.2.1"},
+		{"forwarded by an untrusted client", "192.0.2.1:1234", []string{"198.51.100.1"}, nil, "192.0.2.1"},
+		{"forwarded by a trusted proxy", "10.0.0.5:1234", []string{"198.51.100.1"}, nil, "198.51.100.1"},
+		{"spoofed hop before the client", "10.0.0.5:1234", []string{"203.0.113.9, 198.51.100.1"}, nil, "198.51.100.1"},
+		{"chain of trusted proxies", "10.0.0.5:1234", []string{"198.51.100.1, 10.1.2.3"}, nil, "198.51.100.1"},
+		{"several headers", "10.0.0.5:1234", []string{"203.0.113.9", "198.51.100.1, 10.1.2.3"}, nil, "198.51.100.1"},
+		{"mapped forwarded address", "10.0.0.5:1234", []string{"::ffff:198.51.100.1"}, nil, "198.51.100.1"},
+		{"invalid forwarded address", "10.0.0.5:1234", []string{"198.51.100.1, garbage"}, nil, "10.0.0.5"},
+		{"no header from a trusted proxy", "10.0.0.5:1234", nil, nil, "10.0.0.5"},
+		{"unix socket", "@", nil, unixListener, "127.0.0.1"},
+		{"unix socket without peer name", "", nil, unixListener, "127.0.0.1"},
+		{"unknown", "@", nil, nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/objects/it/a", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwarded {
+				req.Header.Add("X-Forwarded-For", value)
+			}
+			if tt.local != nil {
+				req = req.WithContext(context.WithValue(req.Context(), http.LocalAddrContextKey, tt.local))
+			}
+
+			addr, ok := s.clientAddr(req)
+			if tt.want == "" {
+				if ok {
+					t.Errorf("got %s, want an unknown address", addr)
+				}
+				return
+			}
+			if !ok || addr != netip.MustParseAddr(tt.want) {
+				t.Errorf("got %s (ok %v), want %s", addr, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestRestrictIPs(t *testing.T) {
+	newHandler := func(t *testing.T, access *IPAccessConfig) (http.Handler, *ObjectStorage) {
+		storage := newTestStorage(t)
+		config := defaultConfig()
+		config.IPAccess = access
+		if err := config.validate(); err != nil {
+			t.Fatal(err)
+		}
+		return NewStorageServer(storage, config, discardLogger()).Handler(), storage
+	}
+	request := func(handler http.Handler, target, remoteAddr string, local net.Addr) int {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.RemoteAddr = remoteAddr
+		if local != nil {
+			req = req.WithContext(context.WithValue(req.Context(), http.LocalAddrContextKey, local))
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+	unixListener := &net.UnixAddr{Name: "/run/storage.sock", Net: "unix"}
+
+	t.Run("no ranges", func(t *testing.T) {
+		handler, _ := newHandler(t, nil)
+		if code := request(handler, "/buckets", "@", unixListener); code != http.StatusOK {
+			t.Errorf("unix socket client got %d, want 200", code)
+		}
+		if code := request(handler, "/buckets", "@", nil); code != http.StatusOK {
+			t.Errorf("client with an unknown address got %d, want 200", code)
+		}
+	})
+
+	t.Run("server and bucket ranges", func(t *testing.T) {
+		handler, storage := newHandler(t, &IPAccessConfig{Allow: []string{"192.0.2.0/24", "127.0.0.1"}})
+		putString(t, storage, "it", "a", "data")
+		_, err := storage.UpdateBucketSettings("it", func(settings *BucketSettings) error {
+			settings.IPAccess = &IPAccessConfig{Deny: []string{"192.0.2.66"}}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tests := []struct {
+			name       string
+			target     string
+			remoteAddr string
+			local      net.Addr
+			want       int
+		}{
+			{"allowed", "/objects/it/a", "192.0.2.1:1234", nil, http.StatusOK},
+			{"outside the server's ranges", "/objects/it/a", "198.51.100.1:1234", nil, http.StatusForbidden},
+			{"denied by the bucket", "/objects/it/a", "192.0.2.66:1234", nil, http.StatusForbidden},
+			{"bucket range off its paths", "/buckets", "192.0.2.66:1234", nil, http.StatusOK},
+			{"health outside the ranges", "/health", "198.51.100.1:1234", nil, http.StatusOK},
+			{"unix socket", "/objects/it/a", "@", unixListener, http.StatusOK},
+			{"unknown address", "/objects/it/a", "@", nil, http.StatusForbidden},
+		}
+		for _, tt := range tests {
+			if code := request(handler, tt.target, tt.remoteAddr, tt.local); code != tt.want {
+				t.Errorf("%s: GET %s from %q got %d, want %d", tt.name, tt.target, tt.remoteAddr, code, tt.want)
+			}
+		}
+	})
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// TempCleanup reports the temp files removed by a janitor pass.
+type TempCleanup struct {
+	Removed        int   `json:"removed"`
+	ReclaimedBytes int64 `json:"reclaimed_bytes"`
+
+	// AbortedUploads counts multipart uploads idle longer than
+	// multipart_max_age; their parts are included in ReclaimedBytes.
+	AbortedUploads int `json:"aborted_uploads"`
+}
+
+// RemoveStaleTempFiles removes upload temp files last modified before
+// cutoff from the data directories and the chunk store. Uploads in progress
+// keep writing to their temp file, so a recent cutoff never removes them.
+func (storage *ObjectStorage) RemoveStaleTempFiles(cutoff time.Time) (TempCleanup, error) {
+	var result TempCleanup
+
+	var dirs []string
+	for _, root := range storage.roots {
+		dirs = append(dirs, root.dataDir)
+	}
+	if storage.chunks != nil {
+		dirs = append(dirs, storage.chunks.dir)
+	}
+
+	for _, dir := range dirs {
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() || !info.ModTime().Before(cutoff) {
+				return nil
+			}
+
+			if matched, _ := filepath.Match("upload-*.tmp", info.Name()); matched {
+				if err := storage.Remove(path); err != nil && !storage.IsNotExist(err) {
+					return err
+				}
+				result.Removed++
+				result.ReclaimedBytes += info.Size()
+			}
+			return nil
+		})
+		if err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
+
+// cleanUp removes temp files older than maxAge and multipart uploads idle
+// for longer than multipart_max_age.
+func (s *StorageServer) cleanUp(now time.Time, maxAge time.Duration) (TempCleanup, error) {
+	result, err := s.storage.RemoveStaleTempFiles(now.Add(-maxAge))
+	if err != nil {
+		return result, err
+	}
+
+	aborted, reclaimed, err := s.storage.RemoveStaleUploads(now.Add(-time.Duration(s.config.MultipartMaxAge)))
+	result.AbortedUploads = aborted
+	result.ReclaimedBytes += reclaimed
+	return result, err
+}
+
+// runTempJanitor removes stale temp files and multipart uploads every
+// interval until ctx is cancelled.
+func (s *StorageServer) runTempJanitor(ctx context.Context, interval, maxAge time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			result, err := s.cleanUp(now, maxAge)
+			if err != nil {
+				s.logger.Error("temp file cleanup failed", "error", err)
+			}
+			if result.Removed > 0 || result.AbortedUploads > 0 {
+				s.logger.Info("removed stale temp files", "count", result.Removed, "aborted_uploads", result.AbortedUploads, "reclaimed_bytes", result.ReclaimedBytes)
+			}
+		}
+	}
+}
+
+// handleJanitor serves POST /admin/janitor, which runs a temp file cleanup
+// immediately. An optional ?max_age= overrides temp_file_max_age; it does
+// not apply to multipart uploads.
+func (s *StorageServer) handleJanitor(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	maxAge := time.Duration(s.config.TempFileMaxAge)
+	if v := r.URL.Query().Get("max_age"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			s.writeError(w, r, http.StatusBadRequest, "max_age must be a non-negative duration")
+			return
+		}
+		maxAge = d
+	}
+
+	result, err := s.cleanUp(time.Now(), maxAge)
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	s.logger.Info("temp file cleanup complete", "removed", result.Removed, "aborted_uploads", result.AbortedUploads, "reclaimed_bytes", result.ReclaimedBytes)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
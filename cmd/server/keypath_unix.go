@@ -0,0 +1,18 @@
+//go:build !windows
+
+package main
+
+// unsafeKeyChars are the characters validateObjectKey refuses in keys.
+const unsafeKeyChars = "\x00"
+
+// encodeKeyPath maps an object key to a relative file path. Any byte except
+// NUL is valid in a Unix file name, so keys are used as they are and "/"
+// separates directories.
+func encodeKeyPath(objectKey string) string {
+	return objectKey
+}
+
+// decodeKeyPath reverses encodeKeyPath for a slash-separated relative path.
+func decodeKeyPath(path string) string {
+	return path
+}
@@ -0,0 +1,69 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// reservedNames are device names Windows will not use as file names, with
+// or without an extension.
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// unsafeKeyChars are the characters validateObjectKey refuses in keys.
+// Windows treats "\\" as a path separator, so it could step out of a key's
+// directory.
+const unsafeKeyChars = "\x00\\"
+
+// encodeKeyPath maps an object key to a relative file path. "/" separates
+// directories as on other platforms; within each segment, characters that
+// are invalid in Windows file names (and "%" itself) are percent-encoded,
+// as are the first character of reserved device names and trailing dots and
+// spaces, which Windows would otherwise strip.
+func encodeKeyPath(objectKey string) string {
+	segments := strings.Split(objectKey, "/")
+	for i, segment := range segments {
+		segments[i] = encodeSegment(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+func encodeSegment(segment string) string {
+	var b strings.Builder
+	for i := 0; i < len(segment); i++ {
+		c := segment[i]
+		if c < 0x20 || strings.IndexByte(`<>:"\|?*%`, c) >= 0 {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	encoded := b.String()
+
+	base, _, _ := strings.Cut(encoded, ".")
+	if reservedNames[strings.ToUpper(base)] {
+		encoded = fmt.Sprintf("%%%02X", encoded[0]) + encoded[1:]
+	}
+
+	if last := encoded[max(len(encoded)-1, 0):]; last == "." || last == " " {
+		encoded = encoded[:len(encoded)-1] + fmt.Sprintf("%%%02X", last[0])
+	}
+	return encoded
+}
+
+// decodeKeyPath reverses encodeKeyPath for a slash-separated relative path.
+func decodeKeyPath(path string) string {
+	key, err := url.PathUnescape(path)
+	if err != nil {
+		return path
+	}
+	return key
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ErrInvalidKey is returned for object keys that cannot be stored safely.
+var ErrInvalidKey = errors.New("invalid object key")
+
+// ErrInvalidBucketName is returned for bucket names that cannot be stored
+// safely.
+var ErrInvalidBucketName = errors.New("invalid bucket name")
+
+// validateObjectKey rejects keys that would not stay inside their bucket
+// once joined into a file path: keys starting with "/", "." and ".."
+// segments, NUL, and the characters in unsafeKeyChars for the platform.
+// Every key taken from a request must pass it before it reaches the disk.
+func validateObjectKey(objectKey string) error {
+	if objectKey == "" {
+		return fmt.Errorf("%w: key is empty", ErrInvalidKey)
+	}
+	if strings.HasPrefix(objectKey, "/") {
+		return fmt.Errorf("%w: %q starts with /", ErrInvalidKey, objectKey)
+	}
+	if strings.ContainsAny(objectKey, unsafeKeyChars) {
+		return fmt.Errorf("%w: %q contains a character that is not allowed", ErrInvalidKey, objectKey)
+	}
+	for _, segment := range strings.Split(objectKey, "/") {
+		if segment == "." || segment == ".." {
+			return fmt.Errorf("%w: %q contains a %q segment", ErrInvalidKey, objectKey, segment)
+		}
+	}
+	return nil
+}
+
+// validateBucketName rejects bucket names that are not a single directory
+// name.
+func validateBucketName(bucketName string) error {
+	if bucketName == "" || bucketName == "." || bucketName == ".." || strings.Contains(bucketName, "/") || strings.ContainsAny(bucketName, unsafeKeyChars) {
+		return fmt.Errorf("%w: %q", ErrInvalidBucketName, bucketName)
+	}
+	return nil
+}
+
+// validatePathNames rejects requests under prefix whose path names an
+// unsafe bucket or key: the first segment after prefix is a bucket and the
+// rest, if any, a key. The mux cleans ".." out of paths, but not when the
+// slashes around it are escaped as %2F.
+func (s *StorageServer) validatePathNames(prefix string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		bucketName, objectKey, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, prefix), "/")
+		if bucketName != "" {
+			if err := validateBucketName(bucketName); err != nil {
+				s.writeStorageError(w, r, err)
+				return
+			}
+		}
+		if objectKey != "" {
+			if err := validateObjectKey(objectKey); err != nil {
+				s.writeStorageError(w, r, err)
+				return
+			}
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// KMS wraps and unwraps per-object data keys with a master key held outside
+// the object store. Objects are encrypted with their own data key, so
+// rotating the master key only requires re-wrapping the data keys.
+type KMS interface {
+	// WrapKey encrypts dataKey under the current master key and returns the
+	// ID of that key together with the wrapped data key.
+	WrapKey(dataKey []byte) (keyID string, wrapped []byte, err error)
+
+	// UnwrapKey recovers a data key wrapped by WrapKey.
+	UnwrapKey(keyID string, wrapped []byte) ([]byte, error)
+
+	// CurrentKeyID returns the ID WrapKey would report for a new key.
+	CurrentKeyID() (string, error)
+}
+
+const (
+	kmsLocal = "local"
+	kmsVault = "vault"
+	kmsAWS   = "awskms"
+)
+
+// KMSConfig selects and configures the key management service used to
+// encrypt objects at rest.
+type KMSConfig struct {
+	// Provider is one of local, vault or awskms.
+	Provider string `json:"provider"`
+
+	// KeyFile is the keyring used by the local provider.
+	KeyFile string `json:"key_file,omitempty"`
+
+	// VaultAddress, VaultMount and VaultKey name a Vault transit key. The
+	// token is read from VaultToken or the VAULT_TOKEN environment variable.
+	VaultAddress string `json:"vault_address,omitempty"`
+	VaultMount   string `json:"vault_mount,omitempty"`
+	VaultKey     string `json:"vault_key,omitempty"`
+	VaultToken   string `json:"vault_token,omitempty"`
+
+	// AWSKeyID is the AWS KMS key ID, ARN or alias. Credentials are read
+	// from the standard AWS_* environment variables.
+	AWSKeyID    string `json:"aws_key_id,omitempty"`
+	AWSRegion   string `json:"aws_region,omitempty"`
+	AWSEndpoint string `json:"aws_endpoint,omitempty"`
+}
+
+func (k *KMSConfig) validate() error {
+	if k == nil {
+		return nil
+	}
+
+	switch k.Provider {
+	case kmsLocal:
+		if k.KeyFile == "" {
+			return fmt.Errorf("kms: key_file is required for the local provider")
+		}
+	case kmsVault:
+		if k.VaultAddress == "" || k.VaultKey == "" {
+			return fmt.Errorf("kms: vault_address and vault_key are required for the vault provider")
+		}
+	case kmsAWS:
+		if k.AWSKeyID == "" || k.AWSRegion == "" {
+			return fmt.Errorf("kms: aws_key_id and aws_region are required for the awskms provider")
+		}
+	default:
+		return fmt.Errorf("kms: unknown provider %q (use local, vault or awskms)", k.Provider)
+	}
+	return nil
+}
+
+// NewKMS builds the configured KMS client. It returns nil when encryption
+// at rest is disabled.
+func NewKMS(config *KMSConfig) (KMS, error) {
+	if config == nil {
+		return nil, nil
+	}
+
+	switch config.Provider {
+	case kmsLocal:
+		return loadLocalKeyring(config.KeyFile)
+	case kmsVault:
+		return newVaultKMS(config), nil
+	case kmsAWS:
+		return newAWSKMS(config)
+	}
+	return nil, fmt.Errorf("kms: unknown provider %q", config.Provider)
+}
+
+// localKeyring is a KMS backed by a JSON keyring file:
+//
+//	{"active": "2024-06", "keys": {"2024-01": "<base64>", "2024-06": "<base64>"}}
+//
+// Each key is 32 random bytes. Rotating means adding a key, making it
+// active and keeping the old ones until every data key has been re-wrapped.
+type localKeyring struct {
+	active string
+	keys   map[string]cipher.AEAD
+}
+
+func loadLocalKeyring(path string) (*localKeyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read kms key file: %w", err)
+	}
+
+	var file struct {
+		Active string            `json:"active"`
+		Keys   map[string]string `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse kms key file %s: %w", path, err)
+	}
+
+	keyring := &localKeyring{active: file.Active, keys: make(map[string]cipher.AEAD)}
+	for id, encoded := range file.Keys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("kms key %s must be 32 base64-encoded bytes", id)
+		}
+
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+		keyring.keys[id] = aead
+	}
+
+	if _, ok := keyring.keys[keyring.active]; !ok {
+		return nil, fmt.Errorf("kms key file: active key %q is not in keys", keyring.active)
+	}
+	return keyring, nil
+}
+
+func (k *localKeyring) WrapKey(dataKey []byte) (string, []byte, error) {
+	aead := k.keys[k.active]
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", nil, err
+	}
+	return k.active, aead.Seal(nonce, nonce, dataKey, []byte(k.active)), nil
+}
+
+func (k *localKeyring) UnwrapKey(keyID string, wrapped []byte) ([]byte, error) {
+	aead, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("kms key %s not found in key file", keyID)
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, fmt.Errorf("wrapped key is truncated")
+	}
+
+	nonce, ciphertext := wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():]
+	dataKey, err := aead.Open(nil, nonce, ciphertext, []byte(keyID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+	return dataKey, nil
+}
+
+func (k *localKeyring) CurrentKeyID() (string, error) {
+	return k.active, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const kmsRequestTimeout = 10 * time.Second
+
+// vaultKMS wraps data keys with a HashiCorp Vault transit key. Key IDs have
+// the form "{key}:v{version}"; rotating the transit key in Vault makes new
+// objects use the new version while old versions still decrypt.
+type vaultKMS struct {
+	address string
+	mount   string
+	key     string
+	token   string
+	client  *http.Client
+}
+
+func newVaultKMS(config *KMSConfig) *vaultKMS {
+	mount := config.VaultMount
+	if mount == "" {
+		mount = "transit"
+	}
+
+	token := config.VaultToken
+	if token == "" {
+		token = os.Getenv("VAULT_TOKEN")
+	}
+
+	return &vaultKMS{
+		address: strings.TrimSuffix(config.VaultAddress, "/"),
+		mount:   mount,
+		key:     config.VaultKey,
+		token:   token,
+		client:  &http.Client{Timeout: kmsRequestTimeout},
+	}
+}
+
+func (v *vaultKMS) do(method, path string, body, out any) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, fmt.Sprintf("%s/v1/%s/%s", v.address, v.mount, path), bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("vault request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("vault request failed: %s", resp.Status)
+	}
+
+	envelope := struct {
+		Data any `json:"data"`
+	}{out}
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		return fmt.Errorf("failed to decode vault response: %w", err)
+	}
+	return nil
+}
+
+func (v *vaultKMS) WrapKey(dataKey []byte) (string, []byte, error) {
+	var result struct {
+		Ciphertext string `json:"ciphertext"`
+	}
+	body := map[string]string{"plaintext": base64.StdEncoding.EncodeToString(dataKey)}
+	if err := v.do(http.MethodPost, "encrypt/"+v.key, body, &result); err != nil {
+		return "", nil, err
+	}
+
+	// Ciphertexts look like "vault:v3:..."; the version names the key.
+	parts := strings.SplitN(result.Ciphertext, ":", 3)
+	if len(parts) != 3 {
+		return "", nil, fmt.Errorf("unexpected vault ciphertext format")
+	}
+	return v.key + ":" + parts[1], []byte(result.Ciphertext), nil
+}
+
+func (v *vaultKMS) UnwrapKey(keyID string, wrapped []byte) ([]byte, error) {
+	var result struct {
+		Plaintext string `json:"plaintext"`
+	}
+	body := map[string]string{"ciphertext": string(wrapped)}
+	if err := v.do(http.MethodPost, "decrypt/"+v.key, body, &result); err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(result.Plaintext)
+}
+
+func (v *vaultKMS) CurrentKeyID() (string, error) {
+	var result struct {
+		LatestVersion int `json:"latest_version"`
+	}
+	if err := v.do(http.MethodGet, "keys/"+v.key, nil, &result); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s:v%d", v.key, result.LatestVersion), nil
+}
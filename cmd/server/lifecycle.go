@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// LifecycleRule expires objects whose key starts with Prefix and that carry
+// all of Tags once they are older than ExpirationDays.
+type LifecycleRule struct {
+	ID             string            `json:"id"`
+	Prefix         string            `json:"prefix,omitempty"`
+	Tags           map[string]string `json:"tags,omitempty"`
+	ExpirationDays int               `json:"expiration_days"`
+}
+
+func (rule LifecycleRule) validate() error {
+	if rule.ID == "" {
+		return fmt.Errorf("lifecycle rule id required")
+	}
+	if rule.ExpirationDays < 1 {
+		return fmt.Errorf("lifecycle rule %s: expiration_days must be at least 1", rule.ID)
+	}
+	return nil
+}
+
+// Matches reports whether the rule applies to the object at time now.
+func (rule LifecycleRule) Matches(metadata ObjectMetadata, now time.Time) bool {
+	if !strings.HasPrefix(metadata.Key, rule.Prefix) {
+		return false
+	}
+
+	for key, value := range rule.Tags {
+		if metadata.Tags[key] != value {
+			return false
+		}
+	}
+
+	expiresAt := metadata.LastModified.Add(time.Duration(rule.ExpirationDays) * 24 * time.Hour)
+	return !now.Before(expiresAt)
+}
+
+func validateLifecycle(rules []LifecycleRule) error {
+	seen := make(map[string]bool)
+	for _, rule := range rules {
+		if err := rule.validate(); err != nil {
+			return err
+		}
+		if seen[rule.ID] {
+			return fmt.Errorf("duplicate lifecycle rule id: %s", rule.ID)
+		}
+		seen[rule.ID] = true
+	}
+	return nil
+}
+
+// ExpireObjects deletes every object matched by a lifecycle rule of its
+// bucket and returns the number of objects deleted.
+func (storage *ObjectStorage) ExpireObjects(ctx context.Context, now time.Time) (int, error) {
+	buckets, err := storage.ListBuckets()
+	if err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	for _, bucket := range buckets {
+		rules := bucket.Settings.Lifecycle
+		if len(rules) == 0 {
+			continue
+		}
+
+		var keys []string
+		err := storage.WalkObjects(bucket.Name, func(metadata ObjectMetadata) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			for _, rule := range rules {
+				if rule.Matches(metadata, now) {
+					keys = append(keys, metadata.Key)
+					break
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return expired, err
+		}
+
+		for _, key := range keys {
+			if err := storage.DeleteObject(bucket.Name, key); err != nil {
+				if errors.Is(err, ErrObjectLocked) {
+					storage.logger.Debug("lifecycle expiration deferred by object lock", "bucket", bucket.Name, "key", key)
+					continue
+				}
+				storage.logger.Error("lifecycle expiration failed", "bucket", bucket.Name, "key", key, "error", err)
+				continue
+			}
+			storage.logger.Info("object expired by lifecycle rule", "bucket", bucket.Name, "key", key)
+			expired++
+		}
+	}
+
+	return expired, nil
+}
+
+// runLifecycleWorker evaluates lifecycle rules and purges expired trash
+// every interval until ctx is cancelled.
+func (s *StorageServer) runLifecycleWorker(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			expired, err := s.storage.ExpireObjects(ctx, now)
+			if err != nil && ctx.Err() == nil {
+				s.logger.Error("lifecycle run failed", "error", err)
+			}
+			if expired > 0 {
+				s.logger.Info("lifecycle run complete", "expired", expired)
+			}
+
+			purged, err := s.storage.PurgeExpiredTrash(ctx, now)
+			if err != nil && ctx.Err() == nil {
+				s.logger.Error("trash purge failed", "error", err)
+			}
+			if purged > 0 {
+				s.logger.Info("expired trash purged", "purged", purged)
+			}
+		}
+	}
+}
+
+// handleBucketLifecycle serves GET, PUT and DELETE on
+// /buckets/{name}?lifecycle. PUT replaces the bucket's rules with the JSON
+// array in the request body.
+func (s *StorageServer) handleBucketLifecycle(w http.ResponseWriter, r *http.Request) {
+	bucketName := strings.TrimPrefix(r.URL.Path, "/buckets/")
+
+	var rules []LifecycleRule
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		if err := json.NewDecoder(r.Body).Decode(&rules); err != nil {
+			s.writeError(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid lifecycle configuration: %v", err))
+			return
+		}
+		if err := validateLifecycle(rules); err != nil {
+			s.writeError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+	case http.MethodDelete:
+		rules = nil
+	default:
+		s.writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var bucket Bucket
+	var err error
+	if r.Method == http.MethodGet {
+		bucket, err = s.storage.GetBucket(bucketName)
+	} else {
+		bucket, err = s.storage.UpdateBucketSettings(bucketName, func(settings *BucketSettings) error {
+			settings.Lifecycle = rules
+			return nil
+		})
+	}
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			s.writeError(w, r, http.StatusNotFound, "Bucket not found")
+		} else {
+			s.writeError(w, r, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	rules = bucket.Settings.Lifecycle
+	if rules == nil {
+		rules = []LifecycleRule{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rules)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ErrEntityTooLarge is returned when an object would exceed the maximum
+// object size.
+var ErrEntityTooLarge = errors.New("object exceeds the maximum object size")
+
+// limitSize returns data limited to opts.MaxSize bytes; reading more fails
+// with ErrEntityTooLarge.
+func (opts PutOptions) limitSize(data io.Reader) io.Reader {
+	if opts.MaxSize <= 0 {
+		return data
+	}
+	return &sizeLimitReader{r: data, remaining: opts.MaxSize}
+}
+
+// maxObjectSize returns the largest object the bucket accepts: its own
+// max_object_size or the server's. 0 is unlimited.
+func (s *StorageServer) maxObjectSize(bucketName string) int64 {
+	if bucket, err := s.backend.GetBucket(bucketName); err == nil && bucket.Settings.MaxObjectSize > 0 {
+		return bucket.Settings.MaxObjectSize
+	}
+	return s.config.MaxObjectSize
+}
+
+// checkObjectSize rejects an upload whose Content-Length already exceeds
+// limit, before any of it is read, and limits the body to it otherwise.
+func (s *StorageServer) checkObjectSize(w http.ResponseWriter, r *http.Request, limit int64) bool {
+	if limit <= 0 {
+		return true
+	}
+	if r.ContentLength > limit {
+		s.writeErrorCode(w, r, http.StatusRequestEntityTooLarge, "EntityTooLarge", fmt.Sprintf("Objects in this bucket may be at most %d bytes", limit))
+		return false
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+	return true
+}
+
+// limitRequestBody rejects requests whose Content-Length exceeds
+// max_request_body with 413 before reading them, and stops reading bodies
+// sent without a length once they do.
+func (s *StorageServer) limitRequestBody(next http.Handler) http.Handler {
+	limit := s.config.MaxRequestBody
+	if limit <= 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > limit {
+			s.writeErrorCode(w, r, http.StatusRequestEntityTooLarge, "EntityTooLarge", fmt.Sprintf("Request bodies may be at most %d bytes", limit))
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleBucketMaxObjectSize serves GET, PUT and DELETE on
+// /buckets/{name}?max-object-size, which replaces the server's
+// max_object_size for the bucket.
+func (s *StorageServer) handleBucketMaxObjectSize(w http.ResponseWriter, r *http.Request) {
+	bucketName := strings.TrimPrefix(r.URL.Path, "/buckets/")
+
+	var req struct {
+		MaxObjectSize int64 `json:"max_object_size"`
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodDelete:
+	case http.MethodPut:
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			s.writeError(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid max object size setting: %v", err))
+			return
+		}
+		if err := validateMaxObjectSize(req.MaxObjectSize); err != nil {
+			s.writeError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+	default:
+		s.writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var bucket Bucket
+	var err error
+	if r.Method == http.MethodGet {
+		bucket, err = s.storage.GetBucket(bucketName)
+	} else {
+		bucket, err = s.storage.UpdateBucketSettings(bucketName, func(settings *BucketSettings) error {
+			settings.MaxObjectSize = req.MaxObjectSize
+			return nil
+		})
+	}
+	if err != nil {
+		s.writeStorageError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int64{
+		"max_object_size":           bucket.Settings.MaxObjectSize,
+		"effective_max_object_size": s.maxObjectSize(bucketName),
+	})
+}
+
+func validateMaxObjectSize(size int64) error {
+	if size < 0 {
+		return fmt.Errorf("max_object_size must not be negative")
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const (
+	routesAll   = "all"
+	routesAPI   = "api"
+	routesAdmin = "admin"
+)
+
+// ListenerConfig describes one socket the server accepts connections on.
+// Each listener gets its own middleware chain built from these settings.
+type ListenerConfig struct {
+	// Network is tcp, tcp4, tcp6 or unix.
+	Network string    `json:"network"`
+	Address string    `json:"address"`
+	TLS     TLSConfig `json:"tls"`
+
+	// Routes selects what the listener serves: all (default), api (everything
+	// except /admin/) or admin (/admin/ and health endpoints only).
+	Routes string `json:"routes"`
+
+	// AccessLog disables per-request logging when set to false.
+	AccessLog *bool `json:"access_log,omitempty"`
+}
+
+func (l ListenerConfig) String() string {
+	return l.Network + "://" + l.Address
+}
+
+func (l ListenerConfig) validate() error {
+	switch l.Network {
+	case "tcp", "tcp4", "tcp6", "unix":
+	default:
+		return fmt.Errorf("listener %s: network must be tcp, tcp4, tcp6 or unix", l.Address)
+	}
+	if l.Address == "" {
+		return fmt.Errorf("listener address must not be empty")
+	}
+	switch l.Routes {
+	case routesAll, routesAPI, routesAdmin:
+	default:
+		return fmt.Errorf("listener %s: routes must be all, api or admin", l)
+	}
+	if (l.TLS.CertFile == "") != (l.TLS.KeyFile == "") {
+		return fmt.Errorf("listener %s: both tls cert_file and key_file must be set", l)
+	}
+	if err := l.TLS.validateClientAuth(); err != nil {
+		return fmt.Errorf("listener %s: %w", l, err)
+	}
+	return nil
+}
+
+// ListenerConfigs returns the configured listeners, or a single listener
+// built from Listen and TLS when none are configured.
+func (config *Config) ListenerConfigs() []ListenerConfig {
+	if len(config.Listeners) == 0 {
+		return []ListenerConfig{{
+			Network: "tcp",
+			Address: config.Listen,
+			TLS:     config.TLS,
+			Routes:  routesAll,
+		}}
+	}
+
+	listeners := make([]ListenerConfig, len(config.Listeners))
+	for i, l := range config.Listeners {
+		if l.Network == "" {
+			l.Network = "tcp"
+		}
+		if l.Routes == "" {
+			l.Routes = routesAll
+		}
+		listeners[i] = l
+	}
+	return listeners
+}
+
+// listen opens the socket for l, replacing a stale unix socket file.
+func listen(l ListenerConfig) (net.Listener, error) {
+	if l.Network == "unix" {
+		if err := os.Remove(l.Address); err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to remove stale socket %s: %w", l.Address, err)
+		}
+	}
+	return net.Listen(l.Network, l.Address)
+}
+
+func isAdminPath(path string) bool {
+	return strings.HasPrefix(path, "/admin/")
+}
+
+func isHealthPath(path string) bool {
+	return path == "/health" || path == "/healthz" || path == "/readyz"
+}
+
+// restrictRoutes limits a handler to the routes selected for a listener.
+func (s *StorageServer) restrictRoutes(routes string, next http.Handler) http.Handler {
+	if routes == routesAll {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		admin := isAdminPath(r.URL.Path)
+		if (routes == routesAPI && admin) || (routes == routesAdmin && !admin && !isHealthPath(r.URL.Path)) {
+			s.writeError(w, r, http.StatusNotFound, "Not found")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireAdminToken rejects requests to /admin/ that do not carry the
+// configured admin token as a bearer token, or an OIDC token or client
+// certificate with the admin role.
+func (s *StorageServer) requireAdminToken(next http.Handler) http.Handler {
+	if s.config.AdminToken == "" {
+		return next
+	}
+
+	want := []byte("Bearer " + s.config.AdminToken)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isAdminPath(r.URL.Path) && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 && !s.roleAdmin(r) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			s.writeErrorCode(w, r, http.StatusUnauthorized, "Unauthorized", "Admin token required")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// HandlerFor builds the middleware chain for a listener around the shared
+// API routes.
+func (s *StorageServer) HandlerFor(l ListenerConfig) http.Handler {
+	var handler http.Handler = s.routes()
+	if s.config.Mirror != nil {
+		handler = s.mirrorOnly(handler)
+	}
+	handler = s.websiteHosts(s.countRequests(s.auditRequests(s.restrictIPs(s.restrictRoutes(l.Routes, s.limitRequestBody(s.requireAuth(s.requireAdminToken(s.throttleBandwidth(s.usageHeaders(handler))))))))))
+	if l.AccessLog == nil || *l.AccessLog {
+		handler = s.logRequests(handler)
+	}
+	return withRequestID(handler)
+}
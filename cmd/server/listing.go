@@ -0,0 +1,310 @@
+package main
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// continuationTokenHeader carries the token for the next page of a
+	// listing; it is absent on the last page.
+	continuationTokenHeader = "X-Next-Continuation-Token"
+
+	maxListKeys = 1000
+)
+
+// Sort orders accepted by the sort query parameter of listings.
+const (
+	listSortKey      = "key"
+	listSortSize     = "size"
+	listSortModified = "modified"
+)
+
+var errInvalidContinuationToken = errors.New("continuation token is invalid or belongs to a different listing")
+
+// errPageFull and errRolledUp stop key-range walks: the first once a page
+// has found the entry after its last, the second once a delimited listing
+// has rolled a key up into a common prefix and the walk can skip past it.
+var (
+	errPageFull = errors.New("listing page is full")
+	errRolledUp = errors.New("key rolled up into a common prefix")
+)
+
+// listCursor is the position a continuation token encodes: the sort fields
+// of the last object or bucket returned and the listing it came from.
+// Because the next page starts after that position rather than at an
+// offset, entries added or deleted between requests never shift others
+// across pages.
+type listCursor struct {
+	After     string    `json:"after"`
+	Size      int64     `json:"size,omitempty"`
+	Modified  time.Time `json:"modified,omitzero"`
+	Prefix    string    `json:"prefix"`
+	Match     string    `json:"match,omitempty"`
+	Delimiter string    `json:"delimiter,omitempty"`
+	Owner     string    `json:"owner,omitempty"`
+	Tenant    string    `json:"tenant,omitempty"`
+	Sort      string    `json:"sort,omitempty"`
+	Desc      bool      `json:"desc,omitempty"`
+}
+
+func encodeContinuationToken(cursor listCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeContinuationToken(token string) (*listCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, errInvalidContinuationToken
+	}
+	var cursor listCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, errInvalidContinuationToken
+	}
+	return &cursor, nil
+}
+
+// listRequest is a page of a bucket listing as selected by the prefix,
+// match, delimiter, sort, order, max-keys and continuation-token query
+// parameters.
+type listRequest struct {
+	prefix    string
+	match     string
+	delimiter string
+	sort      string
+	desc      bool
+	after     *listCursor
+	maxKeys   int // 0 returns every remaining object
+}
+
+func parseListRequest(query url.Values) (listRequest, error) {
+	req := listRequest{prefix: query.Get("prefix"), match: query.Get("match"), delimiter: query.Get("delimiter"), sort: listSortKey}
+	if _, err := path.Match(req.match, ""); err != nil {
+		return req, fmt.Errorf("match is not a valid glob pattern")
+	}
+
+	if v := query.Get("sort"); v != "" {
+		switch v {
+		case listSortKey, listSortSize, listSortModified:
+			req.sort = v
+		default:
+			return req, fmt.Errorf("sort must be key, size or modified")
+		}
+	}
+	if req.delimiter != "" && req.sort != listSortKey {
+		return req, fmt.Errorf("delimiter can only be used with sort=key")
+	}
+	switch query.Get("order") {
+	case "", "asc":
+	case "desc":
+		req.desc = true
+	default:
+		return req, fmt.Errorf("order must be asc or desc")
+	}
+
+	if v := query.Get("max-keys"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxListKeys {
+			return req, fmt.Errorf("max-keys must be between 1 and %d", maxListKeys)
+		}
+		req.maxKeys = n
+	}
+
+	if token := query.Get("continuation-token"); token != "" {
+		cursor, err := decodeContinuationToken(token)
+		if err != nil {
+			return req, err
+		}
+		// Key-ordered listings leave sort out of their tokens.
+		if cursor.Sort == "" {
+			cursor.Sort = listSortKey
+		}
+		if cursor.Prefix != req.prefix || cursor.Match != req.match || cursor.Delimiter != req.delimiter || cursor.Sort != req.sort || cursor.Desc != req.desc {
+			return req, errInvalidContinuationToken
+		}
+		req.after = cursor
+	}
+	return req, nil
+}
+
+// matches reports whether key is selected by the match pattern. A pattern
+// without a "/" is matched against the last segment of the key, as with
+// find -name, so "*.log" finds logs at any depth; otherwise it is matched
+// against the whole key, and "*" does not cross "/".
+func (req listRequest) matches(key string) bool {
+	if req.match == "" {
+		return true
+	}
+	name := key
+	if !strings.Contains(req.match, "/") {
+		name = path.Base(key)
+	}
+	matched, _ := path.Match(req.match, name)
+	return matched
+}
+
+// compare orders two objects by the requested field, then by key so the
+// order is total and a cursor identifies one position.
+func (req listRequest) compare(a, b ObjectMetadata) int {
+	var c int
+	switch req.sort {
+	case listSortSize:
+		c = cmp.Compare(a.Size, b.Size)
+	case listSortModified:
+		c = a.LastModified.Compare(b.LastModified)
+	}
+	if c == 0 {
+		c = strings.Compare(a.Key, b.Key)
+	}
+	if req.desc {
+		return -c
+	}
+	return c
+}
+
+// keyRange is the range of keys a key-ordered listing walks.
+func (req listRequest) keyRange() KeyRange {
+	keys := KeyRange{Prefix: req.prefix, Desc: req.desc}
+	if req.after != nil {
+		keys.After = req.after.After
+		// A token ending in the delimiter follows a common prefix, whose
+		// keys were all on earlier pages.
+		keys.Past = req.delimiter != "" && strings.HasSuffix(keys.After, req.delimiter)
+	}
+	return keys
+}
+
+// listObjects returns one page of a bucket's objects in the requested order
+// and the token for the next page, which is empty on the last one.
+func (s *StorageServer) listObjects(bucketName string, req listRequest) ([]ObjectMetadata, string, error) {
+	objects := []ObjectMetadata{}
+	var err error
+	if req.sort == listSortKey {
+		// The walk starts at the cursor and stops one object past the page,
+		// which is enough to tell whether another page follows.
+		err = s.backend.WalkKeyRange(bucketName, req.keyRange(), func(metadata ObjectMetadata) error {
+			if !req.matches(metadata.Key) {
+				return nil
+			}
+			objects = append(objects, metadata)
+			if req.maxKeys > 0 && len(objects) > req.maxKeys {
+				return errPageFull
+			}
+			return nil
+		})
+		if errors.Is(err, errPageFull) {
+			err = nil
+		}
+	} else {
+		objects, err = s.sortObjects(bucketName, req)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	if req.maxKeys == 0 || len(objects) <= req.maxKeys {
+		return objects, "", nil
+	}
+	objects = objects[:req.maxKeys]
+	last := objects[len(objects)-1]
+	cursor := listCursor{After: last.Key, Prefix: req.prefix, Match: req.match, Desc: req.desc}
+	if req.sort != listSortKey {
+		cursor.Sort = req.sort
+		cursor.Size = last.Size
+		cursor.Modified = last.LastModified
+	}
+	return objects, encodeContinuationToken(cursor), nil
+}
+
+// sortObjects returns the objects after the cursor in size or modification
+// order. Neither is the order of the index, so every object under the
+// prefix has to be read and sorted.
+func (s *StorageServer) sortObjects(bucketName string, req listRequest) ([]ObjectMetadata, error) {
+	var after ObjectMetadata
+	if req.after != nil {
+		after = ObjectMetadata{Key: req.after.After, Size: req.after.Size, LastModified: req.after.Modified}
+	}
+
+	objects := []ObjectMetadata{}
+	err := s.backend.WalkKeyRange(bucketName, KeyRange{Prefix: req.prefix}, func(metadata ObjectMetadata) error {
+		if req.matches(metadata.Key) && (req.after == nil || req.compare(metadata, after) > 0) {
+			objects = append(objects, metadata)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(objects, req.compare)
+	return objects, nil
+}
+
+// objectListing answers a listing with a delimiter: the objects directly
+// under the prefix, and the common prefixes the keys below them roll up
+// into, each ending in the delimiter.
+type objectListing struct {
+	Objects        []ObjectMetadata `json:"objects"`
+	CommonPrefixes []string         `json:"common_prefixes"`
+}
+
+// listDelimited returns one page of a listing with a delimiter. A common
+// prefix takes one place in the page however many keys it stands for, and
+// both the walk and the continuation token after it skip all of them.
+func (s *StorageServer) listDelimited(bucketName string, req listRequest) (objectListing, string, error) {
+	listing := objectListing{Objects: []ObjectMetadata{}, CommonPrefixes: []string{}}
+	keys := req.keyRange()
+	var last string
+	count := 0
+	for {
+		err := s.backend.WalkKeyRange(bucketName, keys, func(metadata ObjectMetadata) error {
+			if !req.matches(metadata.Key) {
+				return nil
+			}
+			name := metadata.Key
+			if i := strings.Index(metadata.Key[len(req.prefix):], req.delimiter); i >= 0 {
+				name = metadata.Key[:len(req.prefix)+i+len(req.delimiter)]
+				// Keys under one common prefix are next to each other in
+				// key order, whichever the direction; an object whose key
+				// is the common prefix has already stood for them.
+				if count > 0 && name == last {
+					return errRolledUp
+				}
+			}
+			if req.maxKeys > 0 && count == req.maxKeys {
+				return errPageFull
+			}
+
+			last = name
+			count++
+			if name != metadata.Key {
+				listing.CommonPrefixes = append(listing.CommonPrefixes, name)
+				return errRolledUp
+			}
+			listing.Objects = append(listing.Objects, metadata)
+			return nil
+		})
+		switch {
+		case errors.Is(err, errRolledUp):
+			// Start again past the common prefix rather than walking
+			// every key under it.
+			keys.After, keys.Past = last, true
+		case errors.Is(err, errPageFull):
+			cursor := listCursor{After: last, Prefix: req.prefix, Match: req.match, Delimiter: req.delimiter, Desc: req.desc}
+			return listing, encodeContinuationToken(cursor), nil
+		case err != nil:
+			return objectListing{}, "", err
+		default:
+			return listing, "", nil
+		}
+	}
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+func newTestStorage(t *testing.T) *ObjectStorage {
+	t.Helper()
+	storage := NewObjectStorage(t.TempDir(), discardLogger())
+	if err := storage.CreateBucket("it", "", BucketOwner{}, BucketSettings{}); err != nil {
+		t.Fatal(err)
+	}
+	return storage
+}
+
+func putString(t *testing.T, backend Backend, bucketName, objectKey, data string) *ObjectMetadata {
+	t.Helper()
+	metadata, err := backend.PutObject(bucketName, objectKey, strings.NewReader(data), PutOptions{})
+	if err != nil {
+		t.Fatalf("PutObject %s: %v", objectKey, err)
+	}
+	return metadata
+}
+
+func writeTestFile(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// listKeys lists a bucket through the HTTP API.
+func listKeys(t *testing.T, handler http.Handler, query string) []string {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/objects/it"+query, nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("GET /objects/it%s: %d %s", query, recorder.Code, recorder.Body)
+	}
+	var objects []ObjectMetadata
+	if err := json.Unmarshal(recorder.Body.Bytes(), &objects); err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, object := range objects {
+		keys = append(keys, object.Key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func TestListingSkipsSidecarAndTempFiles(t *testing.T) {
+	storage := newTestStorage(t)
+	objects := []string{"a.txt", "data.json", "dir/nested/b.txt", "dir/nested/deeper/c"}
+	for _, key := range objects {
+		putString(t, storage, "it", key, "contents of "+key)
+	}
+
+	// Files that are not objects: metadata-looking sidecars and upload temp
+	// files among the data, data without metadata, and temp and unreadable
+	// files among the metadata.
+	strays := map[string]string{
+		filepath.Join(storage.dataDir, "it", "a.txt.json"):                      `{"key":"a.txt.json"}`,
+		filepath.Join(storage.dataDir, "it", "dir", "nested", "b.txt.json"):     `{"key":"dir/nested/b.txt.json"}`,
+		filepath.Join(storage.dataDir, "it", "upload-123.tmp"):                  "partial upload",
+		filepath.Join(storage.dataDir, "it", "dir", "nested", "upload-456.tmp"): "partial upload",
+		filepath.Join(storage.dataDir, "it", "orphan.bin"):                      "data without metadata",
+		filepath.Join(storage.metadataDir, "it", "upload-789.tmp"):              "partial metadata",
+		filepath.Join(storage.metadataDir, "it", "dir", "broken.json"):          "not json",
+	}
+	for path, data := range strays {
+		writeTestFile(t, path, data)
+	}
+
+	var walked []string
+	err := storage.WalkObjects("it", func(object ObjectMetadata) error {
+		walked = append(walked, object.Key)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(walked)
+	if !slices.Equal(walked, objects) {
+		t.Errorf("WalkObjects returned %q, want %q", walked, objects)
+	}
+
+	handler := NewStorageServer(storage, defaultConfig(), storage.logger).Handler()
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", objects},
+		{"?prefix=dir/", []string{"dir/nested/b.txt", "dir/nested/deeper/c"}},
+		{"?prefix=a.txt", []string{"a.txt"}},
+		{"?prefix=orphan", nil},
+	}
+	for _, tt := range tests {
+		if got := listKeys(t, handler, tt.query); !slices.Equal(got, tt.want) {
+			t.Errorf("GET /objects/it%s listed %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+// listPages follows continuation tokens through a listing and returns the
+// objects and common prefixes of every page, in the listing's order.
+func listPages(t *testing.T, handler http.Handler, query string) []string {
+	t.Helper()
+	var entries []string
+	token := ""
+	for page := 0; ; page++ {
+		if page > 100 {
+			t.Fatalf("GET /objects/it%s does not end", query)
+		}
+		target := "/objects/it" + query
+		if token != "" {
+			target += "&continuation-token=" + token
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, target, nil))
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("GET %s: %d %s", target, recorder.Code, recorder.Body)
+		}
+
+		var listing objectListing
+		if strings.Contains(query, "delimiter=") {
+			if err := json.Unmarshal(recorder.Body.Bytes(), &listing); err != nil {
+				t.Fatal(err)
+			}
+		} else if err := json.Unmarshal(recorder.Body.Bytes(), &listing.Objects); err != nil {
+			t.Fatal(err)
+		}
+		var keys []string
+		for _, object := range listing.Objects {
+			keys = append(keys, object.Key)
+		}
+		keys = append(keys, listing.CommonPrefixes...)
+		slices.Sort(keys)
+		if strings.Contains(query, "order=desc") {
+			slices.Reverse(keys)
+		}
+		entries = append(entries, keys...)
+
+		if token = recorder.Header().Get(continuationTokenHeader); token == "" {
+			return entries
+		}
+	}
+}
+
+// TestListingPages pages through key-ordered listings, which seek into the
+// metadata index rather than reading the whole bucket, on every store.
+// "a-c" and "a.txt" sort before "a/b" byte-wise, and "b0" after "b/x", but
+// not in a directory walk.
+func TestListingPages(t *testing.T) {
+	stores := map[string]func(t *testing.T) Backend{
+		"files": func(t *testing.T) Backend { return newTestStorage(t) },
+		"kv": func(t *testing.T) Backend {
+			storage := NewObjectStorage(t.TempDir(), discardLogger())
+			store, err := openKVMetadataStore(filepath.Join(storage.metadataDir, kvMetadataFile), storage.logger)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { store.Close() })
+			storage.metadata = store
+			if err := storage.CreateBucket("it", "", BucketOwner{}, BucketSettings{}); err != nil {
+				t.Fatal(err)
+			}
+			return storage
+		},
+		"memory": func(t *testing.T) Backend {
+			backend := newMemoryBackend()
+			if err := backend.CreateBucket("it", "", BucketOwner{}, BucketSettings{}); err != nil {
+				t.Fatal(err)
+			}
+			return backend
+		},
+	}
+	keys := []string{"a-c", "a.txt", "a/b", "a/c/d", "a/c/e", "a/c/f.log", "b/x", "b0", "c"}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"?sort=key", keys},
+		{"?order=desc", []string{"c", "b0", "b/x", "a/c/f.log", "a/c/e", "a/c/d", "a/b", "a.txt", "a-c"}},
+		{"?prefix=a/", []string{"a/b", "a/c/d", "a/c/e", "a/c/f.log"}},
+		{"?prefix=a/c&order=desc", []string{"a/c/f.log", "a/c/e", "a/c/d"}},
+		{"?match=*.log", []string{"a/c/f.log"}},
+		{"?delimiter=/", []string{"a-c", "a.txt", "a/", "b/", "b0", "c"}},
+		{"?delimiter=/&order=desc", []string{"c", "b0", "b/", "a/", "a.txt", "a-c"}},
+		{"?delimiter=/&prefix=a/", []string{"a/b", "a/c/"}},
+		{"?delimiter=/&prefix=a/&order=desc", []string{"a/c/", "a/b"}},
+		{"?delimiter=/&match=*.log", []string{"a/"}},
+		{"?sort=size", keys},
+	}
+	for name, newBackend := range stores {
+		t.Run(name, func(t *testing.T) {
+			backend := newBackend(t)
+			for _, key := range keys {
+				putString(t, backend, "it", key, "x")
+			}
+			handler := NewStorageServer(backend, defaultConfig(), discardLogger()).Handler()
+			for _, tt := range tests {
+				for _, maxKeys := range []string{"", "1", "2", "3"} {
+					query := tt.query
+					if maxKeys != "" {
+						query += "&max-keys=" + maxKeys
+					}
+					if got := listPages(t, handler, query); !slices.Equal(got, tt.want) {
+						t.Errorf("GET /objects/it%s listed %q, want %q", query, got, tt.want)
+					}
+				}
+			}
+		})
+	}
+}
+
+// TestWalkKeyRange walks ranges of a bucket large enough that the kv store
+// reads its keys in several batches.
+func TestWalkKeyRange(t *testing.T) {
+	keys := []string{"j", "k-"}
+	for i := range 300 {
+		keys = append(keys, fmt.Sprintf("k/%03d", i))
+	}
+	keys = append(keys, "k0")
+
+	stores := map[string]func(t *testing.T, dir string) MetadataStore{
+		"files": func(t *testing.T, dir string) MetadataStore {
+			return &fileMetadataStore{dir: dir, logger: discardLogger()}
+		},
+		"kv": func(t *testing.T, dir string) MetadataStore {
+			store, err := openKVMetadataStore(filepath.Join(dir, kvMetadataFile), discardLogger())
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { store.Close() })
+			return store
+		},
+	}
+	tests := []struct {
+		name        string
+		keys        KeyRange
+		first, last string
+		count       int
+	}{
+		{"everything", KeyRange{}, "j", "k0", 303},
+		{"everything descending", KeyRange{Desc: true}, "k0", "j", 303},
+		{"prefix after", KeyRange{Prefix: "k/", After: "k/100"}, "k/101", "k/299", 199},
+		{"prefix before", KeyRange{Prefix: "k/", After: "k/100", Desc: true}, "k/099", "k/000", 100},
+		{"after a key that prefixes others", KeyRange{After: "k-"}, "k/000", "k0", 301},
+		{"past a common prefix", KeyRange{After: "k/", Past: true}, "k0", "k0", 1},
+		{"before a key", KeyRange{After: "k0", Desc: true}, "k/299", "j", 302},
+		{"after the end", KeyRange{After: "k0"}, "", "", 0},
+		{"no match", KeyRange{Prefix: "x"}, "", "", 0},
+	}
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			store := newStore(t, t.TempDir())
+			for _, key := range keys {
+				if err := store.SaveObject("it", &ObjectMetadata{Key: key}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, tt := range tests {
+				var walked []string
+				err := store.WalkKeyRange("it", tt.keys, func(metadata ObjectMetadata) error {
+					walked = append(walked, metadata.Key)
+					return nil
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !slices.IsSortedFunc(walked, tt.keys.compare) {
+					t.Errorf("%s: walked out of order: %q", tt.name, walked)
+				}
+				if len(walked) != tt.count || tt.count > 0 && (walked[0] != tt.first || walked[len(walked)-1] != tt.last) {
+					t.Errorf("%s: walked %d keys, want %d from %q to %q", tt.name, len(walked), tt.count, tt.first, tt.last)
+				}
+			}
+
+			stop := errors.New("stop")
+			calls := 0
+			err := store.WalkKeyRange("it", KeyRange{}, func(ObjectMetadata) error {
+				if calls++; calls == 5 {
+					return stop
+				}
+				return nil
+			})
+			if err != stop || calls != 5 {
+				t.Errorf("walk stopped with %v after %d calls, want stop after 5", err, calls)
+			}
+		})
+	}
+}
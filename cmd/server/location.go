@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// bucketLocationHeader sets the location of a bucket on creation, as an
+// alternative to {"location": ...} in the request body.
+const bucketLocationHeader = "X-Bucket-Location"
+
+// ErrBucketLocationImmutable is returned when a change would move an
+// existing bucket to another location.
+var ErrBucketLocationImmutable = errors.New("bucket location cannot be changed")
+
+// validateLocation accepts region-style names such as "eu-west-1".
+func validateLocation(location string) error {
+	if len(location) > 63 {
+		return fmt.Errorf("location must be at most 63 characters")
+	}
+	for _, c := range location {
+		if !('a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-') {
+			return fmt.Errorf("location may only contain lowercase letters, digits and hyphens")
+		}
+	}
+	return nil
+}
+
+// keepLocation carries the location of an existing bucket over into next,
+// which may leave it out, and rejects changing it: objects are never moved
+// between locations.
+func keepLocation(current string, next *BucketSettings) error {
+	switch {
+	case next.Location == "":
+		next.Location = current
+	case current != "" && next.Location != current:
+		return fmt.Errorf("%w: bucket is in %s", ErrBucketLocationImmutable, current)
+	}
+	return nil
+}
+
+// requestedLocation reads the location of a bucket creation request from
+// the X-Bucket-Location header or a {"location": ...} body.
+func requestedLocation(r *http.Request) (string, error) {
+	location := r.Header.Get(bucketLocationHeader)
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 64<<10))
+	if err != nil {
+		return "", fmt.Errorf("failed to read request body: %w", err)
+	}
+	if len(strings.TrimSpace(string(body))) > 0 {
+		var req struct {
+			Location string `json:"location"`
+		}
+		if err := json.Unmarshal(body, &req); err != nil {
+			return "", fmt.Errorf("invalid request body: %w", err)
+		}
+		if location != "" && req.Location != "" && req.Location != location {
+			return "", fmt.Errorf("%s header and body location differ", bucketLocationHeader)
+		}
+		if req.Location != "" {
+			location = req.Location
+		}
+	}
+
+	return location, validateLocation(location)
+}
+
+// handleBucketLocation serves GET /buckets/{bucket}?location.
+func (s *StorageServer) handleBucketLocation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	bucket, err := s.backend.GetBucket(strings.TrimPrefix(r.URL.Path, "/buckets/"))
+	if err != nil {
+		s.writeStorageError(w, r, err)
+		return
+	}
+
+	response := struct {
+		Location string `json:"location"`
+	}{bucket.Settings.Location}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	gohash "hash"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const backendMemory = "memory"
+
+// memoryBackend keeps all buckets and objects in RAM. Everything is lost
+// when the process exits, which is what CI runs and demo sandboxes want.
+type memoryBackend struct {
+	mu      sync.RWMutex
+	buckets map[string]*memoryBucket
+}
+
+type memoryBucket struct {
+	bucket  Bucket
+	objects map[string]*memoryObject
+}
+
+// memoryObject is never modified once stored; a write replaces it, so
+// readers can keep using the data they were given.
+type memoryObject struct {
+	metadata ObjectMetadata
+	data     []byte
+}
+
+func newMemoryBackend() *memoryBackend {
+	return &memoryBackend{buckets: make(map[string]*memoryBucket)}
+}
+
+func (m *memoryBackend) CreateBucket(bucketName, template string, owner BucketOwner, settings BucketSettings) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	bucket := Bucket{
+		Name:        bucketName,
+		Created:     time.Now(),
+		Template:    template,
+		BucketOwner: owner,
+		Settings:    settings,
+	}
+
+	// Like the filesystem backend, creating an existing bucket updates the
+	// settings given and keeps its objects, usage and the rest.
+	if existing, ok := m.buckets[bucketName]; ok {
+		bucket, err := recreateBucket(existing.bucket, template, owner, settings)
+		if err != nil {
+			return err
+		}
+		existing.bucket = bucket
+		return nil
+	}
+
+	m.buckets[bucketName] = &memoryBucket{bucket: bucket, objects: make(map[string]*memoryObject)}
+	return nil
+}
+
+func (m *memoryBackend) GetBucket(bucketName string) (Bucket, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	b, ok := m.buckets[bucketName]
+	if !ok {
+		return Bucket{}, fmt.Errorf("bucket not found: %s", bucketName)
+	}
+	return b.bucket, nil
+}
+
+func (m *memoryBackend) ListBuckets() ([]Bucket, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var buckets []Bucket
+	for _, b := range m.buckets {
+		buckets = append(buckets, b.bucket)
+	}
+	slices.SortFunc(buckets, func(a, b Bucket) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return buckets, nil
+}
+
+func (m *memoryBackend) PutObject(bucketName, objectKey string, data io.Reader, opts PutOptions) (*ObjectMetadata, error) {
+	validated, err := opts.validate(bucketName, objectKey, data)
+	if err != nil {
+		return nil, err
+	}
+	defer validated.Close()
+	data = validated
+
+	hash := md5.New()
+	writers := []io.Writer{hash}
+
+	var checksum gohash.Hash
+	if opts.ChecksumAlgorithm != "" {
+		var err error
+		checksum, err = newChecksumHash(opts.ChecksumAlgorithm)
+		if err != nil {
+			return nil, err
+		}
+		writers = append(writers, checksum)
+	}
+
+	var buf bytes.Buffer
+	writers = append(writers, &buf)
+	if _, err := io.Copy(io.MultiWriter(writers...), opts.limitSize(data)); err != nil {
+		return nil, fmt.Errorf("failed to read object data: %w", err)
+	}
+
+	digest := hash.Sum(nil)
+	if opts.ContentMD5 != nil && !bytes.Equal(digest, opts.ContentMD5) {
+		return nil, fmt.Errorf("%w: got %s", ErrBadDigest, base64.StdEncoding.EncodeToString(digest))
+	}
+
+	var checksums map[string]string
+	if checksum != nil {
+		value := hex.EncodeToString(checksum.Sum(nil))
+		if opts.ExpectedChecksum != "" && value != opts.ExpectedChecksum {
+			return nil, fmt.Errorf("%w: %s is %s", ErrBadChecksum, opts.ChecksumAlgorithm, value)
+		}
+		checksums = map[string]string{opts.ChecksumAlgorithm: value}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b, ok := m.buckets[bucketName]
+	if !ok {
+		return nil, fmt.Errorf("bucket not found: %s", bucketName)
+	}
+
+	now := time.Now()
+	generation := int64(1)
+	var previous *ObjectMetadata
+	if existing, ok := b.objects[objectKey]; ok {
+		if err := checkRetention(&existing.metadata, now); err != nil {
+			return nil, err
+		}
+		generation = existing.metadata.Generation + 1
+		previous = &existing.metadata
+	}
+	if err := opts.checkPrecondition(previous); err != nil {
+		return nil, err
+	}
+
+	object := &memoryObject{
+		metadata: ObjectMetadata{
+			Key:          objectKey,
+			Size:         int64(buf.Len()),
+			ContentType:  opts.ContentType,
+			ETag:         hex.EncodeToString(digest),
+			LastModified: now,
+			Tags:         opts.Tags,
+			CacheControl: opts.CacheControl,
+			Expires:      opts.Expires,
+			Checksums:    checksums,
+			Generation:   generation,
+			StoredSize:   int64(buf.Len()),
+			RetainUntil:  laterTime(b.bucket.Settings.ObjectLock.retainUntil(now), opts.RetainUntil),
+			LegalHold:    opts.LegalHold,
+			Scan:         opts.Scan,
+		},
+		data: buf.Bytes(),
+	}
+	b.objects[objectKey] = object
+
+	metadata := object.metadata
+	return &metadata, nil
+}
+
+func (m *memoryBackend) object(bucketName, objectKey string) (*memoryObject, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	b, ok := m.buckets[bucketName]
+	if !ok {
+		return nil, fmt.Errorf("bucket not found: %s", bucketName)
+	}
+	object, ok := b.objects[objectKey]
+	if !ok {
+		return nil, fmt.Errorf("object not found")
+	}
+	return object, nil
+}
+
+func (m *memoryBackend) GetObject(bucketName, objectKey string) (io.ReadCloser, *ObjectMetadata, error) {
+	object, err := m.object(bucketName, objectKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	metadata := object.metadata
+	return io.NopCloser(bytes.NewReader(object.data)), &metadata, nil
+}
+
+func (m *memoryBackend) StatObject(bucketName, objectKey string) (*ObjectMetadata, error) {
+	object, err := m.object(bucketName, objectKey)
+	if err != nil {
+		return nil, err
+	}
+	metadata := object.metadata
+	return &metadata, nil
+}
+
+func (m *memoryBackend) DeleteObject(bucketName, objectKey string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b, ok := m.buckets[bucketName]
+	if !ok {
+		return fmt.Errorf("bucket not found: %s", bucketName)
+	}
+	if existing, ok := b.objects[objectKey]; ok {
+		if err := checkRetention(&existing.metadata, time.Now()); err != nil {
+			return err
+		}
+	}
+	delete(b.objects, objectKey)
+	return nil
+}
+
+// WalkObjects calls fn on a snapshot of the bucket, so fn may write to the
+// backend.
+func (m *memoryBackend) WalkObjects(bucketName string, fn func(ObjectMetadata) error) error {
+	m.mu.RLock()
+	b, ok := m.buckets[bucketName]
+	if !ok {
+		m.mu.RUnlock()
+		return fmt.Errorf("bucket not found: %s", bucketName)
+	}
+	objects := make([]ObjectMetadata, 0, len(b.objects))
+	for _, object := range b.objects {
+		objects = append(objects, object.metadata)
+	}
+	m.mu.RUnlock()
+
+	slices.SortFunc(objects, func(a, b ObjectMetadata) int {
+		return strings.Compare(a.Key, b.Key)
+	})
+	for _, metadata := range objects {
+		if err := fn(metadata); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WalkKeyRange calls fn on a snapshot of the range.
+func (m *memoryBackend) WalkKeyRange(bucketName string, keys KeyRange, fn func(ObjectMetadata) error) error {
+	m.mu.RLock()
+	b, ok := m.buckets[bucketName]
+	if !ok {
+		m.mu.RUnlock()
+		return fmt.Errorf("bucket not found: %s", bucketName)
+	}
+	var objects []ObjectMetadata
+	for key, object := range b.objects {
+		if keys.includes(key) {
+			objects = append(objects, object.metadata)
+		}
+	}
+	m.mu.RUnlock()
+
+	slices.SortFunc(objects, func(a, b ObjectMetadata) int {
+		return keys.compare(a.Key, b.Key)
+	})
+	for _, metadata := range objects {
+		if err := fn(metadata); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const (
+	metadataStoreFiles = "files"
+	metadataStoreKV    = "kv"
+)
+
+// MetadataStore persists the bucket records and object metadata of the
+// filesystem backend. Load methods return an error satisfying os.IsNotExist
+// when the record does not exist.
+type MetadataStore interface {
+	LoadBucket(bucketName string) (*Bucket, error)
+	SaveBucket(bucket Bucket) error
+
+	LoadObject(bucketName, objectKey string) (*ObjectMetadata, error)
+	SaveObject(bucketName string, metadata *ObjectMetadata) error
+
+	// DeleteObject removes an object's metadata; a missing record is not an
+	// error.
+	DeleteObject(bucketName, objectKey string) error
+
+	// WalkObjects calls fn for every object recorded in a bucket, in key
+	// order. Unreadable records are skipped.
+	WalkObjects(bucketName string, fn func(ObjectMetadata) error) error
+
+	// WalkKeyRange calls fn for the objects of a bucket in keys, in byte-wise
+	// key order, starting at the range's position rather than at the first
+	// key, so reading one page of a listing costs about the size of the
+	// page. Returning an error from fn stops the walk and returns that error.
+	WalkKeyRange(bucketName string, keys KeyRange, fn func(ObjectMetadata) error) error
+
+	// Check verifies that the store can be read.
+	Check() error
+
+	Close() error
+}
+
+// KeyRange selects the keys a WalkKeyRange visits: those that start with
+// Prefix and, when After is set, sort after it, or before it with Desc.
+// With Past set an ascending walk also skips the keys that start with
+// After, which a delimited listing has rolled up into one common prefix.
+type KeyRange struct {
+	Prefix string
+	After  string
+	Past   bool
+	Desc   bool
+}
+
+// includes reports whether key is in the range.
+func (keys KeyRange) includes(key string) bool {
+	if !strings.HasPrefix(key, keys.Prefix) {
+		return false
+	}
+	switch {
+	case keys.After == "":
+		return true
+	case keys.Desc:
+		return key < keys.After
+	}
+	return key > keys.After && !(keys.Past && strings.HasPrefix(key, keys.After))
+}
+
+// mayInclude reports whether any key that starts with p may be in the
+// range, so a walk can skip whole directories.
+func (keys KeyRange) mayInclude(p string) bool {
+	if !strings.HasPrefix(p, keys.Prefix) && !strings.HasPrefix(keys.Prefix, p) {
+		return false
+	}
+	switch {
+	case keys.After == "":
+		return true
+	case keys.Desc:
+		// Every key that starts with p sorts at or after p.
+		return p < keys.After
+	case keys.Past && strings.HasPrefix(p, keys.After):
+		return false
+	}
+	// Unless After starts with p, it sorts either before every key that
+	// starts with p or after all of them.
+	return strings.HasPrefix(keys.After, p) || p > keys.After
+}
+
+// compare orders keys in the range's direction.
+func (keys KeyRange) compare(a, b string) int {
+	if keys.Desc {
+		return strings.Compare(b, a)
+	}
+	return strings.Compare(a, b)
+}
+
+func validateMetadataStore(name string) error {
+	switch name {
+	case metadataStoreFiles, metadataStoreKV:
+		return nil
+	}
+	return fmt.Errorf("unknown metadata_store %q (use files or kv)", name)
+}
+
+// fileMetadataStore keeps every record as a JSON file:
+// {dir}/{bucket}.json for buckets and {dir}/{bucket}/{key}.json for
+// objects.
+type fileMetadataStore struct {
+	dir    string
+	logger *slog.Logger
+}
+
+func (store *fileMetadataStore) bucketPath(bucketName string) string {
+	return filepath.Join(store.dir, bucketName+".json")
+}
+
+func (store *fileMetadataStore) objectPath(bucketName, objectKey string) string {
+	return filepath.Join(store.dir, bucketName, encodeKeyPath(objectKey)+".json")
+}
+
+func (store *fileMetadataStore) LoadBucket(bucketName string) (*Bucket, error) {
+	data, err := os.ReadFile(store.bucketPath(bucketName))
+	if err != nil {
+		return nil, err
+	}
+
+	var bucket Bucket
+	if err := json.Unmarshal(data, &bucket); err != nil {
+		return nil, err
+	}
+	return &bucket, nil
+}
+
+func (store *fileMetadataStore) SaveBucket(bucket Bucket) error {
+	path := store.bucketPath(bucket.Name)
+	os.MkdirAll(filepath.Dir(path), 0755)
+
+	data, err := json.MarshalIndent(bucket, "", "	")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+func (store *fileMetadataStore) LoadObject(bucketName, objectKey string) (*ObjectMetadata, error) {
+	data, err := os.ReadFile(store.objectPath(bucketName, objectKey))
+	if err != nil {
+		return nil, err
+	}
+
+	var metadata ObjectMetadata
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return nil, err
+	}
+	return &metadata, nil
+}
+
+func (store *fileMetadataStore) SaveObject(bucketName string, metadata *ObjectMetadata) error {
+	path := store.objectPath(bucketName, metadata.Key)
+	os.MkdirAll(filepath.Dir(path), 0755)
+
+	data, err := json.MarshalIndent(metadata, "", "	")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+func (store *fileMetadataStore) DeleteObject(bucketName, objectKey string) error {
+	if err := os.Remove(store.objectPath(bucketName, objectKey)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (store *fileMetadataStore) WalkObjects(bucketName string, fn func(ObjectMetadata) error) error {
+	bucketPath := filepath.Join(store.dir, bucketName)
+
+	return filepath.Walk(bucketPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == bucketPath {
+				return filepath.SkipDir
+			}
+			return err
+		}
+
+		if info.IsDir() || !strings.HasSuffix(info.Name(), ".json") {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(bucketPath, path)
+		if err != nil {
+			return err
+		}
+
+		objectKey := decodeKeyPath(filepath.ToSlash(strings.TrimSuffix(relPath, ".json")))
+		metadata, err := store.LoadObject(bucketName, objectKey)
+		if err != nil {
+			store.logger.Warn("skipping unreadable object metadata", "bucket", bucketName, "key", objectKey, "error", err)
+			return nil
+		}
+
+		return fn(*metadata)
+	})
+}
+
+// WalkKeyRange reads only the directories that may hold keys in the range,
+// starting at the deepest one Prefix names.
+func (store *fileMetadataStore) WalkKeyRange(bucketName string, keys KeyRange, fn func(ObjectMetadata) error) error {
+	base := keys.Prefix[:strings.LastIndex(keys.Prefix, "/")+1]
+	dir := filepath.Join(store.dir, bucketName, encodeKeyPath(base))
+	return store.walkKeyRange(bucketName, dir, base, keys, fn)
+}
+
+// walkKeyRange visits the objects under dir, whose keys start with base.
+// A file stands for its key and a directory for every key that starts with
+// its name and a slash; as no file key starts with a directory's, ordering
+// them by those strings orders all their keys.
+func (store *fileMetadataStore) walkKeyRange(bucketName, dir, base string, keys KeyRange, fn func(ObjectMetadata) error) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	type keyEntry struct {
+		name  string
+		key   string
+		isDir bool
+	}
+	var selected []keyEntry
+	for _, entry := range entries {
+		if entry.IsDir() {
+			if p := base + decodeKeyPath(entry.Name()) + "/"; keys.mayInclude(p) {
+				selected = append(selected, keyEntry{name: entry.Name(), key: p, isDir: true})
+			}
+		} else if name, ok := strings.CutSuffix(entry.Name(), ".json"); ok {
+			if key := base + decodeKeyPath(name); keys.includes(key) {
+				selected = append(selected, keyEntry{name: entry.Name(), key: key})
+			}
+		}
+	}
+	slices.SortFunc(selected, func(a, b keyEntry) int { return keys.compare(a.key, b.key) })
+
+	for _, entry := range selected {
+		if entry.isDir {
+			if err := store.walkKeyRange(bucketName, filepath.Join(dir, entry.name), entry.key, keys, fn); err != nil {
+				return err
+			}
+			continue
+		}
+
+		metadata, err := store.LoadObject(bucketName, entry.key)
+		if err != nil {
+			store.logger.Warn("skipping unreadable object metadata", "bucket", bucketName, "key", entry.key, "error", err)
+			continue
+		}
+		if err := fn(*metadata); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (store *fileMetadataStore) Check() error {
+	_, err := os.ReadDir(store.dir)
+	return err
+}
+
+func (store *fileMetadataStore) Close() error {
+	return nil
+}
@@ -0,0 +1,452 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// kvMetadataFile is the name of the KV store's file in the metadata
+// directory.
+const kvMetadataFile = "metadata.kv"
+
+// kvRecordHeader is the size of a record header: CRC-32 of the rest of the
+// record, operation, key length and value length.
+const kvRecordHeader = 4 + 1 + 4 + 4
+
+const (
+	kvOpPut    byte = 1
+	kvOpDelete byte = 2
+)
+
+// kvCompactMinGarbage keeps small stores from being rewritten over and over.
+const kvCompactMinGarbage = 4 << 20
+
+// kvMetadataStore keeps all metadata in a single append-only log file, so it
+// needs neither CGO nor one file per object. Every write appends a record and
+// is synced before returning; an in-memory index maps each key to the
+// offset of its latest value. When overwritten records take more space than
+// live ones, the log is rewritten.
+//
+// Buckets are stored under "b/{bucket}" and objects under
+// "o/{bucket}/{key}".
+type kvMetadataStore struct {
+	mu    sync.RWMutex
+	path  string
+	file  *os.File
+	size  int64
+	index map[string]kvValue
+	live  int64
+
+	// keys holds the keys of index in order, so listings can seek to where
+	// a page starts.
+	keys []string
+
+	logger *slog.Logger
+}
+
+// kvValue locates a value in the log.
+type kvValue struct {
+	offset int64
+	length int64
+}
+
+func openKVMetadataStore(path string, logger *slog.Logger) (*kvMetadataStore, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	store := &kvMetadataStore{
+		path:   path,
+		file:   file,
+		index:  make(map[string]kvValue),
+		logger: logger,
+	}
+	if err := store.replay(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return store, nil
+}
+
+// replay rebuilds the index from the log. A torn or corrupt record at the
+// end, left by a crash mid-write, is truncated away.
+func (store *kvMetadataStore) replay() error {
+	reader := bufio.NewReader(io.NewSectionReader(store.file, 0, 1<<62))
+	var offset int64
+	for {
+		op, key, value, err := readKVRecord(reader)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			store.logger.Warn("truncating corrupt metadata log tail", "path", store.path, "offset", offset, "error", err)
+			if err := store.file.Truncate(offset); err != nil {
+				return err
+			}
+			break
+		}
+
+		length := int64(kvRecordHeader + len(key) + len(value))
+		store.apply(op, key, kvValue{offset: offset + length - int64(len(value)), length: int64(len(value))})
+		offset += length
+	}
+	store.size = offset
+	store.keys = slices.Sorted(maps.Keys(store.index))
+	return nil
+}
+
+func readKVRecord(reader io.Reader) (op byte, key string, value []byte, err error) {
+	header := make([]byte, kvRecordHeader)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return 0, "", nil, errors.New("truncated record header")
+		}
+		return 0, "", nil, err
+	}
+
+	op = header[4]
+	keyLength := binary.BigEndian.Uint32(header[5:9])
+	valueLength := binary.BigEndian.Uint32(header[9:13])
+	if op != kvOpPut && op != kvOpDelete || keyLength > 1<<20 || valueLength > 1<<30 {
+		return 0, "", nil, errors.New("invalid record header")
+	}
+
+	body := make([]byte, int(keyLength)+int(valueLength))
+	if _, err := io.ReadFull(reader, body); err != nil {
+		return 0, "", nil, errors.New("truncated record")
+	}
+
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(body)
+	if crc.Sum32() != binary.BigEndian.Uint32(header[:4]) {
+		return 0, "", nil, errors.New("checksum mismatch")
+	}
+	return op, string(body[:keyLength]), body[keyLength:], nil
+}
+
+func encodeKVRecord(op byte, key string, value []byte) []byte {
+	record := make([]byte, kvRecordHeader, kvRecordHeader+len(key)+len(value))
+	record[4] = op
+	binary.BigEndian.PutUint32(record[5:9], uint32(len(key)))
+	binary.BigEndian.PutUint32(record[9:13], uint32(len(value)))
+	record = append(record, key...)
+	record = append(record, value...)
+	binary.BigEndian.PutUint32(record[:4], crc32.ChecksumIEEE(record[4:]))
+	return record
+}
+
+// apply updates the index for a record and reports whether the set of keys
+// changed. Callers hold the write lock.
+func (store *kvMetadataStore) apply(op byte, key string, value kvValue) bool {
+	old, existed := store.index[key]
+	if existed {
+		store.live -= kvRecordHeader + int64(len(key)) + old.length
+		delete(store.index, key)
+	}
+	if op == kvOpPut {
+		store.index[key] = value
+		store.live += kvRecordHeader + int64(len(key)) + value.length
+	}
+	return existed != (op == kvOpPut)
+}
+
+func (store *kvMetadataStore) write(op byte, key string, value []byte) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if op == kvOpDelete {
+		if _, ok := store.index[key]; !ok {
+			return nil
+		}
+	}
+
+	record := encodeKVRecord(op, key, value)
+	if _, err := store.file.WriteAt(record, store.size); err != nil {
+		// Drop whatever part of the record made it to disk.
+		store.file.Truncate(store.size)
+		return err
+	}
+	if err := store.file.Sync(); err != nil {
+		return err
+	}
+
+	length := int64(len(record))
+	if store.apply(op, key, kvValue{offset: store.size + length - int64(len(value)), length: int64(len(value))}) {
+		i, found := slices.BinarySearch(store.keys, key)
+		if found {
+			store.keys = slices.Delete(store.keys, i, i+1)
+		} else {
+			store.keys = slices.Insert(store.keys, i, key)
+		}
+	}
+	store.size += length
+
+	if garbage := store.size - store.live; garbage > kvCompactMinGarbage && garbage > store.live {
+		if err := store.compact(); err != nil {
+			store.logger.Error("failed to compact metadata log", "path", store.path, "error", err)
+		}
+	}
+	return nil
+}
+
+// compact rewrites the log with only the latest value of every key. Callers
+// hold the write lock.
+func (store *kvMetadataStore) compact() error {
+	tempPath := store.path + ".compact"
+	temp, err := os.OpenFile(tempPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempPath)
+
+	writer := bufio.NewWriter(temp)
+	index := make(map[string]kvValue, len(store.keys))
+	var offset int64
+	for _, key := range store.keys {
+		value, err := store.read(store.index[key])
+		if err != nil {
+			temp.Close()
+			return err
+		}
+		record := encodeKVRecord(kvOpPut, key, value)
+		if _, err := writer.Write(record); err != nil {
+			temp.Close()
+			return err
+		}
+		offset += int64(len(record))
+		index[key] = kvValue{offset: offset - int64(len(value)), length: int64(len(value))}
+	}
+	if err := writer.Flush(); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Sync(); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := os.Rename(tempPath, store.path); err != nil {
+		temp.Close()
+		return err
+	}
+
+	store.file.Close()
+	store.file = temp
+	store.index = index
+	store.size = offset
+	store.live = offset
+	return nil
+}
+
+func (store *kvMetadataStore) read(value kvValue) ([]byte, error) {
+	data := make([]byte, value.length)
+	if _, err := store.file.ReadAt(data, value.offset); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (store *kvMetadataStore) get(key string, v any) error {
+	store.mu.RLock()
+	value, ok := store.index[key]
+	if !ok {
+		store.mu.RUnlock()
+		return &fs.PathError{Op: "get", Path: key, Err: fs.ErrNotExist}
+	}
+	data, err := store.read(value)
+	store.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (store *kvMetadataStore) put(key string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return store.write(kvOpPut, key, data)
+}
+
+func kvBucketKey(bucketName string) string {
+	return "b/" + bucketName
+}
+
+func kvObjectKey(bucketName, objectKey string) string {
+	return "o/" + bucketName + "/" + objectKey
+}
+
+func (store *kvMetadataStore) LoadBucket(bucketName string) (*Bucket, error) {
+	var bucket Bucket
+	if err := store.get(kvBucketKey(bucketName), &bucket); err != nil {
+		return nil, err
+	}
+	return &bucket, nil
+}
+
+func (store *kvMetadataStore) SaveBucket(bucket Bucket) error {
+	return store.put(kvBucketKey(bucket.Name), bucket)
+}
+
+func (store *kvMetadataStore) LoadObject(bucketName, objectKey string) (*ObjectMetadata, error) {
+	var metadata ObjectMetadata
+	if err := store.get(kvObjectKey(bucketName, objectKey), &metadata); err != nil {
+		return nil, err
+	}
+	return &metadata, nil
+}
+
+func (store *kvMetadataStore) SaveObject(bucketName string, metadata *ObjectMetadata) error {
+	return store.put(kvObjectKey(bucketName, metadata.Key), metadata)
+}
+
+func (store *kvMetadataStore) DeleteObject(bucketName, objectKey string) error {
+	return store.write(kvOpDelete, kvObjectKey(bucketName, objectKey), nil)
+}
+
+// WalkObjects works on a snapshot of the bucket's keys, so fn may write to
+// the store.
+func (store *kvMetadataStore) WalkObjects(bucketName string, fn func(ObjectMetadata) error) error {
+	prefix := kvObjectKey(bucketName, "")
+
+	store.mu.RLock()
+	var keys []string
+	for key := range store.index {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	store.mu.RUnlock()
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		var metadata ObjectMetadata
+		if err := store.get(key, &metadata); err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				store.logger.Warn("skipping unreadable object metadata", "bucket", bucketName, "key", strings.TrimPrefix(key, prefix), "error", err)
+			}
+			continue
+		}
+		if err := fn(metadata); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// kvWalkBatch is how many keys WalkKeyRange takes from the index at a
+// time; fn is called without the lock held, so it may write to the store.
+const kvWalkBatch = 256
+
+// WalkKeyRange finds where the range starts by binary search in the sorted
+// keys.
+func (store *kvMetadataStore) WalkKeyRange(bucketName string, keys KeyRange, fn func(ObjectMetadata) error) error {
+	bucketPrefix := kvObjectKey(bucketName, "")
+	prefix := bucketPrefix + keys.Prefix
+	for {
+		batch := store.keyBatch(prefix, bucketPrefix, keys)
+		for _, key := range batch {
+			var metadata ObjectMetadata
+			if err := store.get(key, &metadata); err != nil {
+				if !errors.Is(err, fs.ErrNotExist) {
+					store.logger.Warn("skipping unreadable object metadata", "bucket", bucketName, "key", strings.TrimPrefix(key, bucketPrefix), "error", err)
+				}
+				continue
+			}
+			if err := fn(metadata); err != nil {
+				return err
+			}
+		}
+		if len(batch) < kvWalkBatch {
+			return nil
+		}
+		keys.After, keys.Past = strings.TrimPrefix(batch[len(batch)-1], bucketPrefix), false
+	}
+}
+
+// keyBatch returns the next keys of the range, which start with prefix.
+func (store *kvMetadataStore) keyBatch(prefix, bucketPrefix string, keys KeyRange) []string {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	// Keys that start with prefix are those from prefix up to, but not
+	// including, the first key after all of them.
+	start, _ := slices.BinarySearch(store.keys, prefix)
+	end := len(store.keys)
+	if next, ok := prefixSuccessor(prefix); ok {
+		end, _ = slices.BinarySearch(store.keys, next)
+	}
+	if keys.After != "" {
+		after := bucketPrefix + keys.After
+		switch {
+		case keys.Desc:
+			i, _ := slices.BinarySearch(store.keys, after)
+			end = min(end, i)
+		case keys.Past:
+			if next, ok := prefixSuccessor(after); ok {
+				i, _ := slices.BinarySearch(store.keys, next)
+				start = max(start, i)
+			} else {
+				start = end
+			}
+		default:
+			i, found := slices.BinarySearch(store.keys, after)
+			if found {
+				i++
+			}
+			start = max(start, i)
+		}
+	}
+	if start >= end {
+		return nil
+	}
+
+	var batch []string
+	if keys.Desc {
+		batch = slices.Clone(store.keys[max(start, end-kvWalkBatch):end])
+		slices.Reverse(batch)
+	} else {
+		batch = slices.Clone(store.keys[start:min(end, start+kvWalkBatch)])
+	}
+	return batch
+}
+
+// prefixSuccessor returns the smallest string that sorts after every string
+// that starts with prefix, if there is one.
+func prefixSuccessor(prefix string) (string, bool) {
+	b := []byte(prefix)
+	for i := len(b) - 1; i >= 0; i-- {
+		if b[i] < 0xff {
+			b[i]++
+			return string(b[:i+1]), true
+		}
+	}
+	return "", false
+}
+
+func (store *kvMetadataStore) Check() error {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	_, err := store.file.Stat()
+	return err
+}
+
+func (store *kvMetadataStore) Close() error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return store.file.Close()
+}
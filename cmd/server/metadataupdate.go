@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// MetadataUpdate is the body of PATCH /objects/{bucket}/{key}. Fields that
+// are left out keep their value.
+type MetadataUpdate struct {
+	ContentType *string `json:"content_type,omitempty"`
+
+	// CacheControl and Expires replace the values sent with downloads; ""
+	// removes them.
+	CacheControl *string `json:"cache_control,omitempty"`
+	Expires      *string `json:"expires,omitempty"`
+
+	// Tags replaces all of the object's tags; {} removes them.
+	Tags *map[string]string `json:"tags,omitempty"`
+}
+
+// apply checks the update and applies it to metadata.
+func (u MetadataUpdate) apply(metadata *ObjectMetadata) error {
+	if u.ContentType != nil {
+		if _, _, err := mime.ParseMediaType(*u.ContentType); err != nil {
+			return fmt.Errorf("invalid content_type: %w", err)
+		}
+		metadata.ContentType = *u.ContentType
+	}
+	if u.CacheControl != nil {
+		metadata.CacheControl = *u.CacheControl
+	}
+	if u.Expires != nil {
+		metadata.Expires = nil
+		if *u.Expires != "" {
+			expires, err := time.Parse(time.RFC3339, *u.Expires)
+			if err != nil {
+				return fmt.Errorf("expires must be an RFC 3339 time")
+			}
+			expires = expires.UTC()
+			metadata.Expires = &expires
+		}
+	}
+	if u.Tags != nil {
+		tags := *u.Tags
+		if len(tags) > maxObjectTags {
+			return fmt.Errorf("at most %d tags are allowed per object", maxObjectTags)
+		}
+		if _, ok := tags[""]; ok {
+			return fmt.Errorf("tag keys must not be empty")
+		}
+		metadata.Tags = nil
+		if len(tags) > 0 {
+			metadata.Tags = tags
+		}
+	}
+	return nil
+}
+
+// UpdateObjectMetadata changes the content type, cache headers and tags of
+// an object without rewriting its data. The ETag, generation and
+// modification time are kept. Locked objects cannot be changed.
+func (storage *ObjectStorage) UpdateObjectMetadata(bucketName, objectKey string, update MetadataUpdate, ifMatch string) (*ObjectMetadata, error) {
+	return storage.updateObjectMetadata(bucketName, objectKey, func(metadata *ObjectMetadata) error {
+		if err := (PutOptions{IfMatch: ifMatch}).checkPrecondition(metadata); err != nil {
+			return err
+		}
+		if err := checkRetention(metadata, time.Now()); err != nil {
+			return err
+		}
+		return update.apply(metadata)
+	})
+}
+
+// handleUpdateObjectMetadata serves PATCH /objects/{bucket}/{key}. An
+// If-Match header makes the update conditional on the object's ETag.
+func (s *StorageServer) handleUpdateObjectMetadata(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/objects/")
+	bucketName, objectKey, ok := strings.Cut(path, "/")
+	if !ok || objectKey == "" {
+		s.writeError(w, r, http.StatusBadRequest, "Bucket and object key required")
+		return
+	}
+
+	var update MetadataUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		s.writeError(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid metadata update: %v", err))
+		return
+	}
+	if err := update.apply(&ObjectMetadata{}); err != nil {
+		s.writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	ifMatch, ifNoneMatch, err := parseConditionalHeaders(r)
+	if err == nil && ifNoneMatch {
+		err = fmt.Errorf("If-None-Match is not supported on metadata updates")
+	}
+	if err != nil {
+		s.writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	metadata, err := s.storage.UpdateObjectMetadata(bucketName, objectKey, update, ifMatch)
+	if err != nil {
+		s.writeStorageError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(metadata)
+
+	s.replicate(r, replicationPut, bucketName, objectKey)
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const mirrorRequestTimeout = 5 * time.Minute
+
+// MirrorConfig turns the server into a read-only public mirror of selected
+// buckets of an upstream server. Objects are pulled on first request and
+// revalidated against the upstream after RefreshInterval.
+type MirrorConfig struct {
+	Upstream string   `json:"upstream"`
+	Buckets  []string `json:"buckets"`
+
+	// UpstreamToken is sent as a bearer token to the upstream. It can also
+	// be set with STORAGE_MIRROR_TOKEN.
+	UpstreamToken string `json:"upstream_token,omitempty"`
+
+	// RefreshInterval is how long a pulled object is served before it is
+	// revalidated against the upstream (default 5m).
+	RefreshInterval Duration `json:"refresh_interval,omitempty"`
+}
+
+func (m *MirrorConfig) refreshInterval() time.Duration {
+	if m.RefreshInterval == 0 {
+		return 5 * time.Minute
+	}
+	return time.Duration(m.RefreshInterval)
+}
+
+func (m *MirrorConfig) validate() error {
+	if m == nil {
+		return nil
+	}
+	if !strings.HasPrefix(m.Upstream, "http://") && !strings.HasPrefix(m.Upstream, "https://") {
+		return fmt.Errorf("mirror: upstream must be an http or https URL")
+	}
+	if len(m.Buckets) == 0 {
+		return fmt.Errorf("mirror: at least one bucket is required")
+	}
+	if m.RefreshInterval < 0 {
+		return fmt.Errorf("mirror: refresh_interval must not be negative")
+	}
+	return nil
+}
+
+// mirrorState tracks pulls in progress and when each object was last
+// revalidated.
+type mirrorState struct {
+	client *http.Client
+
+	mu       sync.Mutex
+	inflight map[string]chan struct{}
+	checked  map[string]time.Time
+}
+
+// mirrorOnly restricts the API to reads of mirrored buckets. Health and
+// admin routes are left to the listener route settings.
+func (s *StorageServer) mirrorOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isHealthPath(r.URL.Path) || isAdminPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		path, ok := strings.CutPrefix(r.URL.Path, "/objects/")
+		bucketName, _, _ := strings.Cut(path, "/")
+		if !ok || !slices.Contains(s.config.Mirror.Buckets, bucketName) {
+			s.writeError(w, r, http.StatusNotFound, "Not found")
+			return
+		}
+
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			s.writeErrorCode(w, r, http.StatusForbidden, "ReadOnlyMirror", "This server is a read-only mirror")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *StorageServer) upstreamRequest(method, path string, query url.Values) (*http.Response, error) {
+	mirror := s.config.Mirror
+
+	target, err := url.Parse(mirror.Upstream)
+	if err != nil {
+		return nil, err
+	}
+	target = target.JoinPath(path)
+	target.RawQuery = query.Encode()
+
+	req, err := http.NewRequest(method, target.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	token := mirror.UpstreamToken
+	if token == "" {
+		token = os.Getenv("STORAGE_MIRROR_TOKEN")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	return s.mirror.client.Do(req)
+}
+
+// syncFromUpstream makes sure the local copy of an object is present and,
+// once it is older than the refresh interval, still matches the upstream.
+// Concurrent requests for the same object share one pull.
+func (s *StorageServer) syncFromUpstream(bucketName, objectKey string) error {
+	id := bucketName + "/" + objectKey
+
+	for {
+		s.mirror.mu.Lock()
+		wait, busy := s.mirror.inflight[id]
+		if !busy {
+			s.mirror.inflight[id] = make(chan struct{})
+			s.mirror.mu.Unlock()
+			break
+		}
+		s.mirror.mu.Unlock()
+		<-wait
+	}
+
+	defer func() {
+		s.mirror.mu.Lock()
+		close(s.mirror.inflight[id])
+		delete(s.mirror.inflight, id)
+		s.mirror.mu.Unlock()
+	}()
+
+	local, err := s.backend.StatObject(bucketName, objectKey)
+	if err == nil {
+		s.mirror.mu.Lock()
+		checked := s.mirror.checked[id]
+		s.mirror.mu.Unlock()
+
+		if time.Since(checked) < s.config.Mirror.refreshInterval() {
+			return nil
+		}
+
+		resp, err := s.upstreamRequest(http.MethodHead, "/objects/"+id, nil)
+		if err != nil {
+			// Serve the cached copy while the upstream is unreachable.
+			s.logger.Warn("mirror revalidation failed", "bucket", bucketName, "key", objectKey, "error", err)
+			return nil
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode == http.StatusOK && resp.Header.Get("ETag") == local.ETag {
+			s.markChecked(id)
+			return nil
+		}
+		if resp.StatusCode == http.StatusNotFound {
+			if err := s.backend.DeleteObject(bucketName, objectKey); err != nil {
+				return err
+			}
+			return fmt.Errorf("object not found")
+		}
+	}
+
+	return s.pullObject(bucketName, objectKey)
+}
+
+func (s *StorageServer) markChecked(id string) {
+	s.mirror.mu.Lock()
+	s.mirror.checked[id] = time.Now()
+	s.mirror.mu.Unlock()
+}
+
+// pullObject downloads an object from the upstream and stores it locally,
+// verifying the data against the upstream ETag.
+func (s *StorageServer) pullObject(bucketName, objectKey string) error {
+	id := bucketName + "/" + objectKey
+
+	resp, err := s.upstreamRequest(http.MethodGet, "/objects/"+id, nil)
+	if err != nil {
+		return fmt.Errorf("failed to fetch from upstream: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("object not found")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch from upstream: %s", resp.Status)
+	}
+
+	if _, err := s.backend.GetBucket(bucketName); err != nil {
+		if err := s.backend.CreateBucket(bucketName, "", BucketOwner{}, BucketSettings{}); err != nil {
+			return err
+		}
+	}
+
+	opts := PutOptions{ContentType: resp.Header.Get("Content-Type")}
+	if digest, err := hex.DecodeString(resp.Header.Get("ETag")); err == nil && len(digest) > 0 {
+		opts.ContentMD5 = digest
+	}
+	if tagging := resp.Header.Get(taggingHeader); tagging != "" {
+		if opts.Tags, err = parseTags(tagging); err != nil {
+			return err
+		}
+	}
+
+	opts.CacheControl = resp.Header.Get("Cache-Control")
+	if expires, err := http.ParseTime(resp.Header.Get("Expires")); err == nil {
+		opts.Expires = &expires
+	}
+
+	if _, err := s.backend.PutObject(bucketName, objectKey, resp.Body, opts); err != nil {
+		return fmt.Errorf("failed to store mirrored object: %w", err)
+	}
+
+	s.markChecked(id)
+	s.logger.Info("mirrored object from upstream", "bucket", bucketName, "key", objectKey)
+	return nil
+}
+
+// proxyUpstreamList forwards a listing of a mirrored bucket to the upstream,
+// so the mirror lists objects it has not pulled yet.
+func (s *StorageServer) proxyUpstreamList(w http.ResponseWriter, r *http.Request) {
+	query := url.Values{}
+	for _, name := range []string{"prefix", "delimiter", "sort", "order", "max-keys", "continuation-token"} {
+		if v := r.URL.Query().Get(name); v != "" {
+			query.Set(name, v)
+		}
+	}
+
+	resp, err := s.upstreamRequest(http.MethodGet, r.URL.Path, query)
+	if err != nil {
+		s.writeError(w, r, http.StatusBadGateway, fmt.Sprintf("failed to list upstream: %v", err))
+		return
+	}
+	defer resp.Body.Close()
+
+	w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+	if next := resp.Header.Get(continuationTokenHeader); next != "" {
+		w.Header().Set(continuationTokenHeader, next)
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
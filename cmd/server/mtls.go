@@ -0,0 +1,131 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// Client certificate modes of a TLS listener with a client CA.
+const (
+	clientAuthRequire  = "require"  // refuse connections without a valid certificate
+	clientAuthOptional = "optional" // verify a certificate when one is sent
+)
+
+// ClientCertConfig maps the names in verified client certificates to roles,
+// so services can authenticate with mutual TLS instead of shared tokens.
+type ClientCertConfig struct {
+	// Roles maps a certificate's subject common name, or one of its DNS,
+	// email or URI subject alternative names, to read, read-write or
+	// admin. A certificate matching several names gets the highest role.
+	Roles map[string]string `json:"roles"`
+}
+
+func (c *ClientCertConfig) validate() error {
+	if c == nil {
+		return nil
+	}
+	if len(c.Roles) == 0 {
+		return fmt.Errorf("client_certs roles must map at least one name to a role")
+	}
+	for name, role := range c.Roles {
+		if roleRank(role) == 0 {
+			return fmt.Errorf("client_certs role for %q must be read, read-write or admin", name)
+		}
+	}
+	return nil
+}
+
+// validateClientAuth checks the client certificate settings of a TLS
+// configuration.
+func (t TLSConfig) validateClientAuth() error {
+	switch t.ClientAuth {
+	case "", clientAuthRequire, clientAuthOptional:
+	default:
+		return fmt.Errorf("tls client_auth must be require or optional")
+	}
+	if t.ClientAuth != "" && t.ClientCAFile == "" {
+		return fmt.Errorf("tls client_auth needs a client_ca_file")
+	}
+	if t.ClientCAFile != "" && !t.Enabled() {
+		return fmt.Errorf("tls client_ca_file needs cert_file and key_file")
+	}
+	return nil
+}
+
+// serverConfig returns the TLS settings of a listener, which verify client
+// certificates against ClientCAFile when it is set.
+func (t TLSConfig) serverConfig() (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if t.ClientCAFile == "" {
+		return config, nil
+	}
+
+	data, err := os.ReadFile(t.ClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tls client_ca_file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("tls client_ca_file %s contains no PEM certificates", t.ClientCAFile)
+	}
+	config.ClientCAs = pool
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	if t.ClientAuth == clientAuthOptional {
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return config, nil
+}
+
+// certIdentity returns the identity of the verified client certificate of
+// r's connection, or nil. Only requests without an Authorization header are
+// identified by their certificate; a bearer token always takes precedence.
+func (s *StorageServer) certIdentity(r *http.Request) *roleIdentity {
+	if s.config.Auth == nil || s.config.Auth.ClientCerts == nil || r.Header.Get("Authorization") != "" {
+		return nil
+	}
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+
+	names := []string{cert.Subject.CommonName}
+	names = append(names, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		names = append(names, uri.String())
+	}
+
+	identity := &roleIdentity{Subject: cert.Subject.CommonName}
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+		if role := s.config.Auth.ClientCerts.Roles[name]; roleRank(role) > roleRank(identity.Role) {
+			identity.Subject = name
+			identity.Role = role
+		}
+	}
+	if identity.Subject == "" {
+		identity.Subject = cert.Subject.String()
+	}
+	return identity
+}
+
+// roleIdentity returns the identity of r's OIDC token or, without a bearer
+// token, of its client certificate.
+func (s *StorageServer) roleIdentity(r *http.Request) *roleIdentity {
+	if identity := s.oidcIdentity(r); identity != nil {
+		return identity
+	}
+	return s.certIdentity(r)
+}
+
+// roleAdmin reports whether r is made by an identity with the admin role,
+// which stands in for the admin token.
+func (s *StorageServer) roleAdmin(r *http.Request) bool {
+	identity := s.roleIdentity(r)
+	return identity != nil && identity.Role == roleAdmin
+}
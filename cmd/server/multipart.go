@@ -0,0 +1,491 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"storage-system/internal/ids"
+)
+
+// maxPartNumber is the highest part number of a multipart upload.
+const maxPartNumber = 10000
+
+var (
+	// ErrNoSuchUpload is returned for an unknown, completed or aborted
+	// multipart upload ID.
+	ErrNoSuchUpload = errors.New("multipart upload not found")
+
+	// ErrInvalidPart is returned when a completion names a part that was
+	// not uploaded or has a different ETag.
+	ErrInvalidPart = errors.New("invalid part list")
+)
+
+// MultipartUpload is an upload sent in parts, which are only assembled into
+// an object when the upload is completed. Its state lives in
+// multipart/{id}/ next to the data directory: upload.json, and a data file
+// and a JSON record for every part.
+type MultipartUpload struct {
+	ID          string    `json:"upload_id"`
+	Bucket      string    `json:"bucket"`
+	Key         string    `json:"key"`
+	ContentType string    `json:"content_type"`
+	Initiated   time.Time `json:"initiated"`
+
+	// Tags, RetainUntil and LegalHold are given when the upload starts and
+	// applied to the object it completes into.
+	Tags        map[string]string `json:"tags,omitempty"`
+	RetainUntil *time.Time        `json:"retain_until,omitempty"`
+	LegalHold   bool              `json:"legal_hold,omitempty"`
+
+	// Parts is only filled in when listing the parts of an upload.
+	Parts []MultipartPart `json:"parts,omitempty"`
+}
+
+// MultipartPart is an uploaded part. Its ETag is the MD5 of its data.
+type MultipartPart struct {
+	PartNumber int    `json:"part_number"`
+	ETag       string `json:"etag"`
+	Size       int64  `json:"size,omitempty"`
+}
+
+// completingFile replaces upload.json while an upload's parts are being
+// assembled, so that other requests no longer find the upload.
+const completingFile = "completing.json"
+
+func (storage *ObjectStorage) uploadDir(uploadID string) string {
+	return filepath.Join(storage.multipartDir, uploadID)
+}
+
+func partPath(dir string, partNumber int) string {
+	return filepath.Join(dir, fmt.Sprintf("part-%05d", partNumber))
+}
+
+// CreateMultipartUpload starts a multipart upload of bucketName/objectKey.
+// The content type, tags and lock settings of opts are kept for the object.
+func (storage *ObjectStorage) CreateMultipartUpload(bucketName, objectKey string, opts PutOptions) (*MultipartUpload, error) {
+	if _, err := storage.GetBucket(bucketName); err != nil {
+		return nil, err
+	}
+
+	upload := &MultipartUpload{
+		ID:          ids.New(),
+		Bucket:      bucketName,
+		Key:         objectKey,
+		ContentType: opts.ContentType,
+		Initiated:   time.Now().UTC(),
+		Tags:        opts.Tags,
+		RetainUntil: opts.RetainUntil,
+		LegalHold:   opts.LegalHold,
+	}
+
+	dir := storage.uploadDir(upload.ID)
+	if err := storage.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create upload directory: %w", err)
+	}
+	data, err := json.Marshal(upload)
+	if err != nil {
+		return nil, err
+	}
+	if err := storage.WriteFile(filepath.Join(dir, "upload.json"), data, 0644); err != nil {
+		return nil, err
+	}
+	return upload, nil
+}
+
+// loadUpload reads an upload's state and checks that it belongs to
+// bucketName/objectKey.
+func (storage *ObjectStorage) loadUpload(uploadID, bucketName, objectKey string) (*MultipartUpload, error) {
+	if uploadID == "" || strings.ContainsAny(uploadID, `/\.`) {
+		return nil, ErrNoSuchUpload
+	}
+
+	data, err := storage.ReadFile(filepath.Join(storage.uploadDir(uploadID), "upload.json"))
+	if storage.IsNotExist(err) {
+		return nil, ErrNoSuchUpload
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var upload MultipartUpload
+	if err := json.Unmarshal(data, &upload); err != nil {
+		return nil, err
+	}
+	if upload.Bucket != bucketName || upload.Key != objectKey {
+		return nil, ErrNoSuchUpload
+	}
+	return &upload, nil
+}
+
+// UploadPart stores one part of an upload, replacing an earlier upload of
+// the same part number. The part is written to a temp file and renamed, so
+// an interrupted part upload leaves no part behind.
+func (storage *ObjectStorage) UploadPart(uploadID, bucketName, objectKey string, partNumber int, data io.Reader) (*MultipartPart, error) {
+	if _, err := storage.loadUpload(uploadID, bucketName, objectKey); err != nil {
+		return nil, err
+	}
+
+	dir := storage.uploadDir(uploadID)
+	tempFile, err := os.CreateTemp(dir, "upload-*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(tempFile.Name())
+
+	hash := md5.New()
+	size, err := io.Copy(io.MultiWriter(tempFile, hash), data)
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to write part: %w", err)
+	}
+
+	part := &MultipartPart{PartNumber: partNumber, ETag: hex.EncodeToString(hash.Sum(nil)), Size: size}
+	record, err := json.Marshal(part)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock := storage.uploadLocks.Lock(uploadID)
+	defer unlock()
+
+	// The upload may have been completed or aborted meanwhile.
+	if _, err := storage.Stat(filepath.Join(dir, "upload.json")); err != nil {
+		return nil, ErrNoSuchUpload
+	}
+	path := partPath(dir, partNumber)
+	if err := storage.Rename(tempFile.Name(), path); err != nil {
+		return nil, err
+	}
+	if err := storage.WriteFile(path+".json", record, 0644); err != nil {
+		return nil, err
+	}
+	return part, nil
+}
+
+// ListParts returns an upload with the parts stored so far, in part number
+// order.
+func (storage *ObjectStorage) ListParts(uploadID, bucketName, objectKey string) (*MultipartUpload, error) {
+	upload, err := storage.loadUpload(uploadID, bucketName, objectKey)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := storage.ReadDir(storage.uploadDir(uploadID))
+	if err != nil {
+		return nil, err
+	}
+	upload.Parts = []MultipartPart{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, "part-") || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		data, err := storage.ReadFile(filepath.Join(storage.uploadDir(uploadID), name))
+		if err != nil {
+			return nil, err
+		}
+		var part MultipartPart
+		if err := json.Unmarshal(data, &part); err != nil {
+			return nil, err
+		}
+		upload.Parts = append(upload.Parts, part)
+	}
+	slices.SortFunc(upload.Parts, func(a, b MultipartPart) int { return a.PartNumber - b.PartNumber })
+	return upload, nil
+}
+
+// CompleteMultipartUpload assembles the listed parts, in ascending part
+// number order, into the object and removes the upload. Parts that were
+// uploaded but not listed are discarded.
+//
+// The upload's lock is only held while the upload is claimed, not while
+// the parts are written to the object, so other uploads are not held up by
+// a large one. If assembling fails the claim is released and the upload
+// can be completed again.
+func (storage *ObjectStorage) CompleteMultipartUpload(uploadID, bucketName, objectKey string, parts []MultipartPart, opts PutOptions) (*ObjectMetadata, error) {
+	dir := storage.uploadDir(uploadID)
+	unlock := storage.uploadLocks.Lock(uploadID)
+	upload, err := storage.ListParts(uploadID, bucketName, objectKey)
+	if err == nil {
+		err = storage.Rename(filepath.Join(dir, "upload.json"), filepath.Join(dir, completingFile))
+	}
+	unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	metadata, err := storage.assembleUpload(upload, parts, opts)
+	if err != nil {
+		if restoreErr := storage.Rename(filepath.Join(dir, completingFile), filepath.Join(dir, "upload.json")); restoreErr != nil {
+			storage.logger.Warn("failed to release multipart upload", "upload_id", uploadID, "error", restoreErr)
+		}
+		return nil, err
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		storage.logger.Warn("failed to remove completed upload", "upload_id", uploadID, "error", err)
+	}
+	return metadata, nil
+}
+
+// assembleUpload checks the part list of a completion against the parts of
+// a claimed upload and writes them to the object.
+func (storage *ObjectStorage) assembleUpload(upload *MultipartUpload, parts []MultipartPart, opts PutOptions) (*ObjectMetadata, error) {
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("%w: no parts listed", ErrInvalidPart)
+	}
+
+	dir := storage.uploadDir(upload.ID)
+	readers := make([]io.Reader, 0, len(parts))
+	size := int64(0)
+	for i, part := range parts {
+		if i > 0 && part.PartNumber <= parts[i-1].PartNumber {
+			return nil, fmt.Errorf("%w: parts must be in ascending order", ErrInvalidPart)
+		}
+		index := slices.IndexFunc(upload.Parts, func(p MultipartPart) bool { return p.PartNumber == part.PartNumber })
+		if index < 0 {
+			return nil, fmt.Errorf("%w: part %d was not uploaded", ErrInvalidPart, part.PartNumber)
+		}
+		if part.ETag != "" && strings.Trim(part.ETag, `"`) != upload.Parts[index].ETag {
+			return nil, fmt.Errorf("%w: part %d has ETag %s", ErrInvalidPart, part.PartNumber, upload.Parts[index].ETag)
+		}
+
+		size += upload.Parts[index].Size
+		if opts.MaxSize > 0 && size > opts.MaxSize {
+			return nil, fmt.Errorf("%w: the parts add up to more than %d bytes", ErrEntityTooLarge, opts.MaxSize)
+		}
+
+		file, err := storage.Open(partPath(dir, part.PartNumber))
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		readers = append(readers, file)
+	}
+
+	opts.ContentType = upload.ContentType
+	opts.Tags = upload.Tags
+	opts.RetainUntil = upload.RetainUntil
+	opts.LegalHold = upload.LegalHold
+	return storage.PutObject(upload.Bucket, upload.Key, io.MultiReader(readers...), opts)
+}
+
+// AbortMultipartUpload discards an upload and its parts.
+func (storage *ObjectStorage) AbortMultipartUpload(uploadID, bucketName, objectKey string) error {
+	unlock := storage.uploadLocks.Lock(uploadID)
+	defer unlock()
+
+	if _, err := storage.loadUpload(uploadID, bucketName, objectKey); err != nil {
+		return err
+	}
+	return os.RemoveAll(storage.uploadDir(uploadID))
+}
+
+// RemoveStaleUploads aborts multipart uploads that have not received a
+// part since cutoff and returns how many were removed and the bytes freed.
+func (storage *ObjectStorage) RemoveStaleUploads(cutoff time.Time) (int, int64, error) {
+	entries, err := storage.ReadDir(storage.multipartDir)
+	if storage.IsNotExist(err) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var removed int
+	var reclaimed int64
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		unlock := storage.uploadLocks.Lock(entry.Name())
+		removedUpload, size, err := storage.removeStaleUpload(filepath.Join(storage.multipartDir, entry.Name()), cutoff)
+		unlock()
+		if err != nil {
+			return removed, reclaimed, err
+		}
+		if removedUpload {
+			removed++
+			reclaimed += size
+		}
+	}
+	return removed, reclaimed, nil
+}
+
+// removeStaleUpload removes an upload directory if nothing in it changed
+// since cutoff, and returns the bytes it held. An upload being completed
+// was claimed recently, which touched the directory.
+func (storage *ObjectStorage) removeStaleUpload(dir string, cutoff time.Time) (bool, int64, error) {
+	var size int64
+	stale := true
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.ModTime().After(cutoff) {
+			stale = false
+		}
+		if !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	if !stale {
+		return false, 0, nil
+	}
+	return true, size, os.RemoveAll(dir)
+}
+
+// handleMultipart serves the multipart upload API on
+// /objects/{bucket}/{key}:
+//
+//	POST   ?uploads                          start an upload
+//	PUT    ?upload-id={id}&part-number={n}   upload a part
+//	GET    ?upload-id={id}                   list uploaded parts
+//	POST   ?upload-id={id}                   complete with {"parts": [...]}
+//	DELETE ?upload-id={id}                   abort
+func (s *StorageServer) handleMultipart(w http.ResponseWriter, r *http.Request) {
+	bucketName, objectKey, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/objects/"), "/")
+	if objectKey == "" {
+		s.writeError(w, r, http.StatusBadRequest, "Bucket and object key required")
+		return
+	}
+	query := r.URL.Query()
+	uploadID := query.Get("upload-id")
+
+	switch {
+	case query.Has("uploads") && r.Method == http.MethodPost:
+		contentType := r.Header.Get("Content-Type")
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		tags, err := parseTags(r.Header.Get(taggingHeader))
+		if err != nil {
+			s.writeError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		opts := PutOptions{ContentType: contentType, Tags: tags}
+		opts.RetainUntil, opts.LegalHold, err = parseLockHeaders(r)
+		if err != nil {
+			s.writeError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		upload, err := s.storage.CreateMultipartUpload(bucketName, objectKey, opts)
+		if err != nil {
+			s.writeStorageError(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(upload)
+
+	case uploadID != "" && r.Method == http.MethodPut:
+		partNumber, err := strconv.Atoi(query.Get("part-number"))
+		if err != nil || partNumber < 1 || partNumber > maxPartNumber {
+			s.writeError(w, r, http.StatusBadRequest, fmt.Sprintf("part-number must be between 1 and %d", maxPartNumber))
+			return
+		}
+		if !s.checkObjectSize(w, r, s.maxObjectSize(bucketName)) {
+			return
+		}
+		part, err := s.storage.UploadPart(uploadID, bucketName, objectKey, partNumber, r.Body)
+		if err != nil {
+			s.writeStorageError(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("ETag", part.ETag)
+		json.NewEncoder(w).Encode(part)
+
+	case uploadID != "" && r.Method == http.MethodGet:
+		upload, err := s.storage.ListParts(uploadID, bucketName, objectKey)
+		if err != nil {
+			s.writeStorageError(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(upload)
+
+	case uploadID != "" && r.Method == http.MethodPost:
+		s.completeMultipartUpload(w, r, uploadID, bucketName, objectKey)
+
+	case uploadID != "" && r.Method == http.MethodDelete:
+		if err := s.storage.AbortMultipartUpload(uploadID, bucketName, objectKey); err != nil {
+			s.writeStorageError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		s.writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// completeMultipartUpload assembles an upload. Content-MD5 and checksum
+// headers apply to the assembled object, as on a single PUT.
+func (s *StorageServer) completeMultipartUpload(w http.ResponseWriter, r *http.Request, uploadID, bucketName, objectKey string) {
+	var req struct {
+		Parts []MultipartPart `json:"parts"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeError(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid request body: %v", err))
+		return
+	}
+
+	var opts PutOptions
+	var err error
+	opts.ChecksumAlgorithm, opts.ExpectedChecksum, err = parseChecksumRequest(r)
+	if err != nil {
+		s.writeErrorCode(w, r, http.StatusBadRequest, "InvalidChecksum", err.Error())
+		return
+	}
+	opts.IfMatch, opts.IfNoneMatch, err = parseConditionalHeaders(r)
+	if err != nil {
+		s.writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	opts.CacheControl, opts.Expires, err = parseCacheHeaders(r)
+	if err != nil {
+		s.writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if header := r.Header.Get("Content-MD5"); header != "" {
+		digest, err := base64.StdEncoding.DecodeString(header)
+		if err != nil || len(digest) != md5.Size {
+			s.writeErrorCode(w, r, http.StatusBadRequest, "InvalidDigest", "Content-MD5 must be a base64-encoded MD5 digest")
+			return
+		}
+		opts.ContentMD5 = digest
+	}
+
+	opts.MaxSize = s.maxObjectSize(bucketName)
+	opts.Validate = s.validateUpload
+	metadata, err := s.storage.CompleteMultipartUpload(uploadID, bucketName, objectKey, req.Parts, opts)
+	if err != nil {
+		s.writeStorageError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", metadata.ETag)
+	w.Header().Set(generationHeader, strconv.FormatInt(metadata.Generation, 10))
+	setChecksumHeaders(w, metadata)
+	json.NewEncoder(w).Encode(metadata)
+
+	s.notify(EventPut, bucketName, objectKey, metadata)
+	s.replicate(r, replicationPut, bucketName, objectKey)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompleteMultipartUpload(t *testing.T) {
+	storage := newTestStorage(t)
+	retainUntil := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	upload, err := storage.CreateMultipartUpload("it", "big", PutOptions{
+		ContentType: "text/plain",
+		Tags:        map[string]string{"team": "storage"},
+		RetainUntil: &retainUntil,
+		LegalHold:   true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, data := range []string{"hello ", "world"} {
+		if _, err := storage.UploadPart(upload.ID, "it", "big", i+1, strings.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A failed completion releases the upload so it can be completed again.
+	_, err = storage.CompleteMultipartUpload(upload.ID, "it", "big", []MultipartPart{{PartNumber: 3}}, PutOptions{})
+	if !errors.Is(err, ErrInvalidPart) {
+		t.Fatalf("completing with a missing part: got %v, want ErrInvalidPart", err)
+	}
+
+	metadata, err := storage.CompleteMultipartUpload(upload.ID, "it", "big", []MultipartPart{{PartNumber: 1}, {PartNumber: 2}}, PutOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.Size != int64(len("hello world")) || metadata.ContentType != "text/plain" || metadata.Tags["team"] != "storage" {
+		t.Errorf("object metadata %+v does not match the upload", metadata)
+	}
+	if !metadata.LegalHold || metadata.RetainUntil == nil || !metadata.RetainUntil.Equal(retainUntil) {
+		t.Errorf("object lock settings not applied: legal hold %v, retain until %v", metadata.LegalHold, metadata.RetainUntil)
+	}
+
+	if _, err := storage.CompleteMultipartUpload(upload.ID, "it", "big", []MultipartPart{{PartNumber: 1}}, PutOptions{}); !errors.Is(err, ErrNoSuchUpload) {
+		t.Errorf("completing twice: got %v, want ErrNoSuchUpload", err)
+	}
+}
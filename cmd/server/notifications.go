@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"storage-system/internal/ids"
+)
+
+// Event types sent to bucket webhooks.
+const (
+	EventPut    = "put"
+	EventDelete = "delete"
+	EventCopy   = "copy"
+)
+
+var eventTypes = []string{EventPut, EventDelete, EventCopy}
+
+const (
+	webhookWorkers     = 4
+	webhookQueueSize   = 1000
+	webhookMaxAttempts = 5
+	webhookTimeout     = 10 * time.Second
+
+	// webhookSignatureHeader carries "sha256=" and the hex HMAC-SHA256 of the
+	// request body under webhook_secret.
+	webhookSignatureHeader = "X-Storage-Signature"
+	webhookEventHeader     = "X-Storage-Event"
+)
+
+// NotificationConfig sends the events of a bucket to a webhook. Events
+// selects the event types (all when empty); Prefix and Suffix filter keys.
+type NotificationConfig struct {
+	ID     string   `json:"id"`
+	URL    string   `json:"url"`
+	Events []string `json:"events,omitempty"`
+	Prefix string   `json:"prefix,omitempty"`
+	Suffix string   `json:"suffix,omitempty"`
+}
+
+func (n NotificationConfig) validate() error {
+	if n.ID == "" {
+		return fmt.Errorf("notification id required")
+	}
+	if !strings.HasPrefix(n.URL, "http://") && !strings.HasPrefix(n.URL, "https://") {
+		return fmt.Errorf("notification %s: url must be an http or https URL", n.ID)
+	}
+	for _, event := range n.Events {
+		if !slices.Contains(eventTypes, event) {
+			return fmt.Errorf("notification %s: unknown event %q (valid: %s)", n.ID, event, strings.Join(eventTypes, ", "))
+		}
+	}
+	return nil
+}
+
+// Matches reports whether the notification wants an event for key.
+func (n NotificationConfig) Matches(eventType, key string) bool {
+	if len(n.Events) > 0 && !slices.Contains(n.Events, eventType) {
+		return false
+	}
+	return strings.HasPrefix(key, n.Prefix) && strings.HasSuffix(key, n.Suffix)
+}
+
+func validateNotifications(notifications []NotificationConfig) error {
+	seen := make(map[string]bool)
+	for _, n := range notifications {
+		if err := n.validate(); err != nil {
+			return err
+		}
+		if seen[n.ID] {
+			return fmt.Errorf("duplicate notification id: %s", n.ID)
+		}
+		seen[n.ID] = true
+	}
+	return nil
+}
+
+// Event is the JSON body POSTed to webhooks.
+type Event struct {
+	ID         string    `json:"id"`
+	Type       string    `json:"type"`
+	Time       time.Time `json:"time"`
+	Bucket     string    `json:"bucket"`
+	Key        string    `json:"key"`
+	Size       int64     `json:"size,omitempty"`
+	ETag       string    `json:"etag,omitempty"`
+	Generation int64     `json:"generation,omitempty"`
+
+	// Notification is the ID of the bucket notification that matched. It
+	// is empty for events sent to the event bus.
+	Notification string `json:"notification,omitempty"`
+}
+
+type webhookDelivery struct {
+	url   string
+	event Event
+}
+
+// notifier delivers webhook events in the background so requests never
+// wait on downstream systems.
+type notifier struct {
+	client *http.Client
+	secret []byte
+	queue  chan webhookDelivery
+}
+
+func newNotifier(config *Config) *notifier {
+	return &notifier{
+		client: &http.Client{Timeout: webhookTimeout},
+		secret: []byte(config.WebhookSecret),
+		queue:  make(chan webhookDelivery, webhookQueueSize),
+	}
+}
+
+// notify publishes an event to the event bus and the bucket's event
+// streams and queues it for every matching notification of the bucket. metadata is nil for deletes. Events
+// are dropped when a queue is full.
+func (s *StorageServer) notify(eventType, bucketName, objectKey string, metadata *ObjectMetadata) {
+	event := Event{
+		Type:   eventType,
+		Time:   time.Now().UTC(),
+		Bucket: bucketName,
+		Key:    objectKey,
+	}
+	if metadata != nil {
+		event.Size = metadata.Size
+		event.ETag = metadata.ETag
+		event.Generation = metadata.Generation
+	}
+
+	s.publishEvent(event)
+	s.streamEvent(event)
+	s.queueScan(bucketName, objectKey, metadata)
+
+	bucket, err := s.backend.GetBucket(bucketName)
+	if err != nil || len(bucket.Settings.Notifications) == 0 {
+		return
+	}
+
+	for _, n := range bucket.Settings.Notifications {
+		if !n.Matches(eventType, objectKey) {
+			continue
+		}
+
+		event.ID = ids.New()
+		event.Notification = n.ID
+		select {
+		case s.notifier.queue <- webhookDelivery{url: n.URL, event: event}:
+		default:
+			s.logger.Warn("webhook queue full, dropping event", "bucket", bucketName, "key", objectKey, "event", eventType, "notification", n.ID)
+		}
+	}
+}
+
+// runNotifier delivers queued webhook events until ctx is cancelled.
+func (s *StorageServer) runNotifier(ctx context.Context) {
+	for range webhookWorkers {
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case delivery := <-s.notifier.queue:
+					s.deliverWebhook(ctx, delivery)
+				}
+			}
+		}()
+	}
+}
+
+// deliverWebhook POSTs an event, retrying with exponential backoff until the
+// webhook answers 2xx or the attempts run out.
+func (s *StorageServer) deliverWebhook(ctx context.Context, delivery webhookDelivery) {
+	body, err := json.Marshal(delivery.event)
+	if err != nil {
+		return
+	}
+
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		err := s.postWebhook(ctx, delivery.url, delivery.event.Type, body)
+		if err == nil {
+			return
+		}
+
+		if attempt == webhookMaxAttempts {
+			s.logger.Error("webhook delivery failed", "url", delivery.url, "event", delivery.event.ID, "attempts", attempt, "error", err)
+			return
+		}
+		s.logger.Debug("webhook delivery failed, retrying", "url", delivery.url, "event", delivery.event.ID, "attempt", attempt, "error", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (s *StorageServer) postWebhook(ctx context.Context, url, eventType string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookEventHeader, eventType)
+	if len(s.notifier.secret) > 0 {
+		mac := hmac.New(sha256.New, s.notifier.secret)
+		mac.Write(body)
+		req.Header.Set(webhookSignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := s.notifier.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// handleBucketNotifications serves GET, PUT and DELETE on
+// /buckets/{name}?notifications. PUT replaces the bucket's notifications
+// with the JSON array in the request body.
+func (s *StorageServer) handleBucketNotifications(w http.ResponseWriter, r *http.Request) {
+	bucketName := strings.TrimPrefix(r.URL.Path, "/buckets/")
+
+	var notifications []NotificationConfig
+	switch r.Method {
+	case http.MethodGet, http.MethodDelete:
+	case http.MethodPut:
+		if err := json.NewDecoder(r.Body).Decode(&notifications); err != nil {
+			s.writeError(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid notification configuration: %v", err))
+			return
+		}
+		if err := validateNotifications(notifications); err != nil {
+			s.writeError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+	default:
+		s.writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var bucket Bucket
+	var err error
+	if r.Method == http.MethodGet {
+		bucket, err = s.storage.GetBucket(bucketName)
+	} else {
+		bucket, err = s.storage.UpdateBucketSettings(bucketName, func(settings *BucketSettings) error {
+			settings.Notifications = notifications
+			return nil
+		})
+	}
+	if err != nil {
+		s.writeStorageError(w, r, err)
+		return
+	}
+
+	notifications = bucket.Settings.Notifications
+	if notifications == nil {
+		notifications = []NotificationConfig{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(notifications)
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ErrObjectLocked is returned when a write or delete targets an object that
+// is still under retention.
+var ErrObjectLocked = errors.New("object is locked")
+
+// ErrObjectLockImmutable is returned when a change would disable a bucket's
+// object lock or shorten its retention.
+var ErrObjectLockImmutable = errors.New("object lock cannot be disabled or shortened")
+
+// retainUntilHeader reports the retention date of a locked object.
+const retainUntilHeader = "X-Object-Lock-Retain-Until"
+
+// ObjectLockConfig puts a bucket in WORM mode: every object written to it
+// is retained for RetentionDays and cannot be overwritten or deleted until
+// then. Once enabled, the lock can only be extended.
+type ObjectLockConfig struct {
+	RetentionDays int `json:"retention_days"`
+}
+
+func (lock *ObjectLockConfig) validate() error {
+	if lock == nil {
+		return nil
+	}
+	if lock.RetentionDays <= 0 {
+		return fmt.Errorf("object lock retention_days must be positive")
+	}
+	return nil
+}
+
+// checkObjectLockChange rejects settings changes that would weaken an
+// existing object lock.
+func checkObjectLockChange(current, next *ObjectLockConfig) error {
+	if current == nil {
+		return nil
+	}
+	if next == nil || next.RetentionDays < current.RetentionDays {
+		return ErrObjectLockImmutable
+	}
+	return nil
+}
+
+// retainUntil returns the retention date for an object written now.
+func (lock *ObjectLockConfig) retainUntil(now time.Time) *time.Time {
+	if lock == nil {
+		return nil
+	}
+	until := now.AddDate(0, 0, lock.RetentionDays).UTC().Truncate(time.Second)
+	return &until
+}
+
+// checkRetention returns ErrObjectLocked if metadata is under legal hold or
+// still retained.
+func checkRetention(metadata *ObjectMetadata, now time.Time) error {
+	if metadata.LegalHold {
+		return fmt.Errorf("%w: legal hold is on", ErrObjectLocked)
+	}
+	if metadata.RetainUntil != nil && now.Before(*metadata.RetainUntil) {
+		return fmt.Errorf("%w until %s", ErrObjectLocked, metadata.RetainUntil.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// handleBucketObjectLock serves GET and PUT on /buckets/{name}?object-lock.
+// There is no DELETE: a lock can only be extended.
+func (s *StorageServer) handleBucketObjectLock(w http.ResponseWriter, r *http.Request) {
+	bucketName := strings.TrimPrefix(r.URL.Path, "/buckets/")
+
+	var lock *ObjectLockConfig
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		lock = &ObjectLockConfig{}
+		if err := json.NewDecoder(r.Body).Decode(lock); err != nil {
+			s.writeError(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid object lock: %v", err))
+			return
+		}
+		if err := lock.validate(); err != nil {
+			s.writeError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+	default:
+		s.writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var bucket Bucket
+	var err error
+	if r.Method == http.MethodGet {
+		bucket, err = s.storage.GetBucket(bucketName)
+	} else {
+		bucket, err = s.storage.UpdateBucketSettings(bucketName, func(settings *BucketSettings) error {
+			settings.ObjectLock = lock
+			return nil
+		})
+	}
+	if err != nil {
+		s.writeStorageError(w, r, err)
+		return
+	}
+
+	response := struct {
+		ObjectLock *ObjectLockConfig `json:"object_lock"`
+	}{bucket.Settings.ObjectLock}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// legalHoldHeader sets or reports the legal hold of an object: ON or OFF.
+const legalHoldHeader = "X-Object-Legal-Hold"
+
+// parseLockHeaders reads the per-object retention and legal hold requested
+// on an upload.
+func parseLockHeaders(r *http.Request) (*time.Time, bool, error) {
+	var retainUntil *time.Time
+	if v := r.Header.Get(retainUntilHeader); v != "" {
+		until, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return nil, false, fmt.Errorf("%s must be an RFC 3339 timestamp", retainUntilHeader)
+		}
+		until = until.UTC()
+		retainUntil = &until
+	}
+
+	legalHold, err := parseLegalHold(r.Header.Get(legalHoldHeader))
+	return retainUntil, legalHold, err
+}
+
+func parseLegalHold(value string) (bool, error) {
+	switch strings.ToUpper(value) {
+	case "", "OFF":
+		return false, nil
+	case "ON":
+		return true, nil
+	}
+	return false, fmt.Errorf("legal hold must be ON or OFF")
+}
+
+func formatLegalHold(on bool) string {
+	if on {
+		return "ON"
+	}
+	return "OFF"
+}
+
+// laterTime returns the later of two optional times.
+func laterTime(a, b *time.Time) *time.Time {
+	if a == nil || (b != nil && b.After(*a)) {
+		return b
+	}
+	return a
+}
+
+// updateObjectMetadata applies update to an object's metadata under the bucket
+// lock and persists it.
+func (storage *ObjectStorage) updateObjectMetadata(bucketName, objectKey string, update func(*ObjectMetadata) error) (*ObjectMetadata, error) {
+	storage.bucketMu.Lock()
+	defer storage.bucketMu.Unlock()
+
+	metadata, err := storage.loadObjectMetadata(bucketName, objectKey)
+	if err != nil {
+		return nil, fmt.Errorf("object not found")
+	}
+
+	if err := update(metadata); err != nil {
+		return nil, err
+	}
+
+	if err := storage.saveObjectMetaData(bucketName, metadata); err != nil {
+		return nil, fmt.Errorf("failed to save metadata: %w", err)
+	}
+	return metadata, nil
+}
+
+// SetRetention sets an object's retain-until date. Retention can be
+// extended but never shortened or removed.
+func (storage *ObjectStorage) SetRetention(bucketName, objectKey string, until time.Time) (*ObjectMetadata, error) {
+	until = until.UTC().Truncate(time.Second)
+	return storage.updateObjectMetadata(bucketName, objectKey, func(metadata *ObjectMetadata) error {
+		if metadata.RetainUntil != nil && until.Before(*metadata.RetainUntil) {
+			return fmt.Errorf("%w: retention is set until %s", ErrObjectLockImmutable, metadata.RetainUntil.Format(time.RFC3339))
+		}
+		metadata.RetainUntil = &until
+		return nil
+	})
+}
+
+// SetLegalHold places or releases a legal hold on an object.
+func (storage *ObjectStorage) SetLegalHold(bucketName, objectKey string, on bool) (*ObjectMetadata, error) {
+	return storage.updateObjectMetadata(bucketName, objectKey, func(metadata *ObjectMetadata) error {
+		metadata.LegalHold = on
+		return nil
+	})
+}
+
+type objectLockStatus struct {
+	RetainUntil *time.Time `json:"retain_until"`
+	LegalHold   string     `json:"legal_hold"`
+}
+
+// handleObjectLock serves GET and PUT on /objects/{bucket}/{key}?retention
+// and ?legal-hold. PUT ?retention takes {"retain_until": "..."}; PUT
+// ?legal-hold takes {"legal_hold": "ON"|"OFF"}.
+func (s *StorageServer) handleObjectLock(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/objects/")
+	bucketName, objectKey, ok := strings.Cut(path, "/")
+	if !ok || objectKey == "" {
+		s.writeError(w, r, http.StatusBadRequest, "Bucket and object key required")
+		return
+	}
+
+	var metadata *ObjectMetadata
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		metadata, err = s.storage.loadObjectMetadata(bucketName, objectKey)
+		if err != nil {
+			err = fmt.Errorf("object not found")
+		}
+	case http.MethodPut:
+		var req struct {
+			RetainUntil *time.Time `json:"retain_until"`
+			LegalHold   string     `json:"legal_hold"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			s.writeError(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid request body: %v", err))
+			return
+		}
+
+		if r.URL.Query().Has("retention") {
+			if req.RetainUntil == nil {
+				s.writeError(w, r, http.StatusBadRequest, "retain_until is required")
+				return
+			}
+			metadata, err = s.storage.SetRetention(bucketName, objectKey, *req.RetainUntil)
+		} else {
+			on, parseErr := parseLegalHold(req.LegalHold)
+			if parseErr != nil || req.LegalHold == "" {
+				s.writeError(w, r, http.StatusBadRequest, "legal_hold must be ON or OFF")
+				return
+			}
+			metadata, err = s.storage.SetLegalHold(bucketName, objectKey, on)
+		}
+	default:
+		s.writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if err != nil {
+		s.writeStorageError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(objectLockStatus{
+		RetainUntil: metadata.RetainUntil,
+		LegalHold:   formatLegalHold(metadata.LegalHold),
+	})
+}
+
+// setLockHeaders reports an object's retention and legal hold.
+func setLockHeaders(w http.ResponseWriter, metadata *ObjectMetadata) {
+	if metadata.RetainUntil != nil {
+		w.Header().Set(retainUntilHeader, metadata.RetainUntil.Format(time.RFC3339))
+	}
+	if metadata.LegalHold {
+		w.Header().Set(legalHoldHeader, formatLegalHold(true))
+	}
+}
@@ -0,0 +1,423 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	oidcRequestTimeout = 10 * time.Second
+
+	// oidcKeyRefreshInterval limits how often an unknown key ID makes the
+	// server fetch the provider's keys again.
+	oidcKeyRefreshInterval = time.Minute
+
+	// oidcClockSkew is the leeway for exp and nbf.
+	oidcClockSkew = time.Minute
+)
+
+// Roles an OIDC identity can be granted.
+const (
+	roleRead      = "read"       // download, stat and list objects
+	roleReadWrite = "read-write" // everything except /admin/
+	roleAdmin     = "admin"      // everything
+)
+
+// OIDCConfig lets the server accept ID or access tokens issued by an OpenID
+// Connect provider as bearer tokens, next to the static tokens.
+type OIDCConfig struct {
+	// Issuer must match the iss claim. The provider's keys are discovered
+	// from {Issuer}/.well-known/openid-configuration unless JWKSURL is set.
+	Issuer string `json:"issuer"`
+
+	// Audience must be one of the aud claim's values.
+	Audience string `json:"audience"`
+
+	JWKSURL string `json:"jwks_url,omitempty"`
+
+	// RolesClaim names the claim holding the identity's roles or groups,
+	// with dots for nested claims as in "realm_access.roles". It defaults
+	// to "roles".
+	RolesClaim string `json:"roles_claim,omitempty"`
+
+	// Roles maps values of RolesClaim to read, read-write or admin. An
+	// identity with several values gets the highest role; one without a
+	// mapped value is refused.
+	Roles map[string]string `json:"roles"`
+}
+
+func (c *OIDCConfig) validate() error {
+	if c == nil {
+		return nil
+	}
+	if u, err := url.Parse(c.Issuer); err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("oidc issuer must be a URL")
+	}
+	if c.Audience == "" {
+		return fmt.Errorf("oidc audience must not be empty")
+	}
+	if c.JWKSURL != "" {
+		if u, err := url.Parse(c.JWKSURL); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("oidc jwks_url must be a URL")
+		}
+	}
+	if len(c.Roles) == 0 {
+		return fmt.Errorf("oidc roles must map at least one claim value to a role")
+	}
+	for value, role := range c.Roles {
+		if roleRank(role) == 0 {
+			return fmt.Errorf("oidc role for %q must be read, read-write or admin", value)
+		}
+	}
+	return nil
+}
+
+func roleRank(role string) int {
+	switch role {
+	case roleRead:
+		return 1
+	case roleReadWrite:
+		return 2
+	case roleAdmin:
+		return 3
+	}
+	return 0
+}
+
+// roleIdentity is a caller named by a verified OIDC token or client
+// certificate.
+type roleIdentity struct {
+	// Subject is the token's sub claim or the certificate's name.
+	Subject string
+
+	// Role is empty when no claim value maps to a role.
+	Role string
+}
+
+// allows reports whether the identity's role covers a request.
+func (id *roleIdentity) allows(r *http.Request) bool {
+	switch id.Role {
+	case roleAdmin:
+		return true
+	case roleReadWrite:
+		return !isAdminPath(r.URL.Path)
+	case roleRead:
+		if isAdminPath(r.URL.Path) {
+			return false
+		}
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			return true
+		case http.MethodPost:
+			// Batch lookups and selects only read.
+			return strings.HasPrefix(r.URL.Path, "/objects/") && readOnlyPost(r)
+		}
+	}
+	return false
+}
+
+// oidcVerifier checks the signature and claims of OIDC tokens against the
+// provider's published keys, which it caches.
+type oidcVerifier struct {
+	config *OIDCConfig
+	client *http.Client
+
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+func newOIDCVerifier(config *OIDCConfig) *oidcVerifier {
+	return &oidcVerifier{
+		config: config,
+		client: &http.Client{Timeout: oidcRequestTimeout},
+	}
+}
+
+// looksLikeJWT reports whether a bearer token has the shape of a JWT, so
+// static tokens are not parsed.
+func looksLikeJWT(token string) bool {
+	return strings.HasPrefix(token, "eyJ") && strings.Count(token, ".") == 2
+}
+
+// verify checks a token and returns the identity it names.
+func (v *oidcVerifier) verify(token string) (*roleIdentity, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("invalid token header: %w", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid token signature: %w", err)
+	}
+	key, err := v.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyJWTSignature(header.Alg, key, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]any
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("invalid token claims: %w", err)
+	}
+	if iss, _ := claims["iss"].(string); iss != v.config.Issuer {
+		return nil, fmt.Errorf("token issuer %q is not %q", iss, v.config.Issuer)
+	}
+	if !claimContains(claims["aud"], v.config.Audience) {
+		return nil, fmt.Errorf("token audience does not include %q", v.config.Audience)
+	}
+	now := time.Now()
+	exp, ok := claims["exp"].(float64)
+	if !ok || now.After(time.Unix(int64(exp), 0).Add(oidcClockSkew)) {
+		return nil, errors.New("token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(oidcClockSkew).Before(time.Unix(int64(nbf), 0)) {
+		return nil, errors.New("token not valid yet")
+	}
+
+	identity := &roleIdentity{}
+	identity.Subject, _ = claims["sub"].(string)
+	for _, value := range claimValues(lookupClaim(claims, v.config.RolesClaim)) {
+		if role := v.config.Roles[value]; roleRank(role) > roleRank(identity.Role) {
+			identity.Role = role
+		}
+	}
+	return identity, nil
+}
+
+func decodeJWTPart(part string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// verifyJWTSignature checks an RS256/384/512 or ES256/384/512 signature.
+// Other algorithms, including "none" and HMAC, are refused.
+func verifyJWTSignature(alg string, key crypto.PublicKey, signed string, signature []byte) error {
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512", "ES512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported token algorithm %q", alg)
+	}
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if alg[0] != 'R' || rsa.VerifyPKCS1v15(key, hash, digest, signature) != nil {
+			return errors.New("invalid token signature")
+		}
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if alg[0] != 'E' || len(signature) != 2*size {
+			return errors.New("invalid token signature")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(key, digest, r, s) {
+			return errors.New("invalid token signature")
+		}
+	default:
+		return errors.New("unsupported signing key")
+	}
+	return nil
+}
+
+// lookupClaim follows a dotted claim name into nested objects.
+func lookupClaim(claims map[string]any, name string) any {
+	if name == "" {
+		name = "roles"
+	}
+	var value any = claims
+	for _, part := range strings.Split(name, ".") {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return nil
+		}
+		value = object[part]
+	}
+	return value
+}
+
+// claimValues returns a string claim or the strings in an array claim.
+func claimValues(claim any) []string {
+	switch claim := claim.(type) {
+	case string:
+		return []string{claim}
+	case []any:
+		values := make([]string, 0, len(claim))
+		for _, item := range claim {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+func claimContains(claim any, want string) bool {
+	for _, value := range claimValues(claim) {
+		if value == want {
+			return true
+		}
+	}
+	return false
+}
+
+// key returns the provider's key with the given ID. Keys are fetched on
+// first use and again when an unknown ID shows up, so rotated keys are
+// picked up, but at most once per oidcKeyRefreshInterval.
+func (v *oidcVerifier) key(kid string) (crypto.PublicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if key, ok := v.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(v.fetched) < oidcKeyRefreshInterval {
+		return nil, fmt.Errorf("unknown token key %q", kid)
+	}
+
+	v.fetched = time.Now()
+	keys, err := v.fetchKeys()
+	if err != nil {
+		return nil, err
+	}
+	v.keys = keys
+	if key, ok := v.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown token key %q", kid)
+}
+
+func (v *oidcVerifier) fetchKeys() (map[string]crypto.PublicKey, error) {
+	jwksURL := v.config.JWKSURL
+	if jwksURL == "" {
+		var discovery struct {
+			JWKSURI string `json:"jwks_uri"`
+		}
+		if err := v.getJSON(strings.TrimSuffix(v.config.Issuer, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+			return nil, err
+		}
+		if discovery.JWKSURI == "" {
+			return nil, errors.New("oidc discovery document has no jwks_uri")
+		}
+		jwksURL = discovery.JWKSURI
+	}
+
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := v.getJSON(jwksURL, &jwks); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		switch jwk.Kty {
+		case "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(jwk.N)
+			e, errE := base64.RawURLEncoding.DecodeString(jwk.E)
+			if errN != nil || errE != nil || len(e) > 4 {
+				continue
+			}
+			keys[jwk.Kid] = &rsa.PublicKey{
+				N: new(big.Int).SetBytes(n),
+				E: int(new(big.Int).SetBytes(e).Int64()),
+			}
+		case "EC":
+			curve := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}[jwk.Crv]
+			x, errX := base64.RawURLEncoding.DecodeString(jwk.X)
+			y, errY := base64.RawURLEncoding.DecodeString(jwk.Y)
+			if curve == nil || errX != nil || errY != nil {
+				continue
+			}
+			key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+			if !curve.IsOnCurve(key.X, key.Y) {
+				continue
+			}
+			keys[jwk.Kid] = key
+		}
+	}
+	return keys, nil
+}
+
+func (v *oidcVerifier) getJSON(url string, out any) error {
+	resp, err := v.client.Get(url)
+	if err != nil {
+		return fmt.Errorf("oidc request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("oidc request to %s failed: %s", url, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode oidc response from %s: %w", url, err)
+	}
+	return nil
+}
+
+// oidcIdentity returns the identity of the verified OIDC token r carries,
+// or nil. Verification failures are logged at debug level.
+func (s *StorageServer) oidcIdentity(r *http.Request) *roleIdentity {
+	if s.oidc == nil {
+		return nil
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || !looksLikeJWT(token) {
+		return nil
+	}
+	identity, err := s.oidc.verify(token)
+	if err != nil {
+		s.logger.Debug("oidc token rejected", "error", err, "request_id", RequestIDFromContext(r.Context()))
+		return nil
+	}
+	return identity
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// requestMetrics counts requests per second over the last minute in a ring
+// of one-second slots.
+type requestMetrics struct {
+	mu    sync.Mutex
+	slots [60]requestSlot
+
+	// total counts every request since the server started.
+	total   requestSlot
+	byClass map[string]int64
+}
+
+type requestSlot struct {
+	second       int64
+	requests     int64
+	clientErrors int64
+	serverErrors int64
+}
+
+func (m *requestMetrics) record(status int, class string, now time.Time) {
+	second := now.Unix()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	slot := &m.slots[second%int64(len(m.slots))]
+	if slot.second != second {
+		*slot = requestSlot{second: second}
+	}
+	slot.count(status)
+	m.total.count(status)
+
+	if m.byClass == nil {
+		m.byClass = make(map[string]int64)
+	}
+	m.byClass[class]++
+}
+
+func (slot *requestSlot) count(status int) {
+	slot.requests++
+	switch {
+	case status >= 500:
+		slot.serverErrors++
+	case status >= 400:
+		slot.clientErrors++
+	}
+}
+
+// RequestStats summarizes the requests served in the last minute.
+type RequestStats struct {
+	PerMinute    int64   `json:"per_minute"`
+	ClientErrors int64   `json:"client_errors"`
+	ServerErrors int64   `json:"server_errors"`
+	ErrorRate    float64 `json:"error_rate"`
+}
+
+func (m *requestMetrics) lastMinute(now time.Time) RequestStats {
+	oldest := now.Unix() - int64(len(m.slots))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var stats RequestStats
+	for _, slot := range m.slots {
+		if slot.second <= oldest {
+			continue
+		}
+		stats.PerMinute += slot.requests
+		stats.ClientErrors += slot.clientErrors
+		stats.ServerErrors += slot.serverErrors
+	}
+	if stats.PerMinute > 0 {
+		stats.ErrorRate = float64(stats.ServerErrors) / float64(stats.PerMinute)
+	}
+	return stats
+}
+
+// countRequests feeds every response status into the request metrics.
+func (s *StorageServer) countRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		s.metrics.record(recorder.status, requestClass(r), time.Now())
+	})
+}
+
+// Overview is the aggregated service state served by /admin/overview.
+type Overview struct {
+	GeneratedAt time.Time    `json:"generated_at"`
+	Uptime      string       `json:"uptime"`
+	Buckets     int          `json:"buckets"`
+	Objects     int64        `json:"objects"`
+	Bytes       int64        `json:"bytes"`
+	Requests    RequestStats `json:"requests"`
+	Disk        DiskStats    `json:"disk"`
+	LastGC      *GCSummary   `json:"last_gc,omitempty"`
+
+	// DataDirs lists every data directory when data_dirs is configured.
+	DataDirs []DataDirStats `json:"data_dirs,omitempty"`
+}
+
+type DiskStats struct {
+	FreeBytes  uint64 `json:"free_bytes"`
+	UsedBytes  uint64 `json:"used_bytes"`
+	TotalBytes uint64 `json:"total_bytes"`
+}
+
+// diskStats reports the space of the filesystem holding path.
+func diskStats(path string) (DiskStats, error) {
+	free, total, err := diskUsage(path)
+	if err != nil {
+		return DiskStats{}, err
+	}
+	return DiskStats{FreeBytes: free, UsedBytes: total - min(free, total), TotalBytes: total}, nil
+}
+
+type GCSummary struct {
+	At             time.Time `json:"at"`
+	Removed        int       `json:"removed"`
+	ReclaimedBytes int64     `json:"reclaimed_bytes"`
+}
+
+// TotalUsage sums the tracked usage of every bucket.
+func (storage *ObjectStorage) TotalUsage() (buckets int, usage BucketUsage, err error) {
+	storage.bucketMu.RLock()
+	defer storage.bucketMu.RUnlock()
+
+	list, err := storage.ListBuckets()
+	if err != nil {
+		return 0, usage, err
+	}
+
+	for _, bucket := range list {
+		bucketUsage, err := storage.bucketUsage(&bucket)
+		if err != nil {
+			return 0, usage, err
+		}
+		usage.Objects += bucketUsage.Objects
+		usage.Bytes += bucketUsage.Bytes
+	}
+	return len(list), usage, nil
+}
+
+// handleOverview serves GET /admin/overview, one call with everything a
+// dashboard needs.
+func (s *StorageServer) handleOverview(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	now := time.Now()
+	buckets, usage, err := s.storage.TotalUsage()
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	overview := Overview{
+		GeneratedAt: now,
+		Uptime:      now.Sub(s.started).Truncate(time.Second).String(),
+		Buckets:     buckets,
+		Objects:     usage.Objects,
+		Bytes:       usage.Bytes,
+		Requests:    s.metrics.lastMinute(now),
+	}
+
+	if disk, err := diskStats(s.storage.dataDir); err == nil {
+		overview.Disk = disk
+	}
+	if len(s.storage.roots) > 1 {
+		overview.DataDirs = s.storage.DataDirStats()
+	}
+
+	s.gc.mu.Lock()
+	if lastRun := s.gc.lastRun; lastRun != nil {
+		overview.LastGC = &GCSummary{At: lastRun.GeneratedAt, Removed: lastRun.Removed, ReclaimedBytes: lastRun.ReclaimedBytes}
+	}
+	s.gc.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(overview)
+}
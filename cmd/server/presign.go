@@ -0,0 +1,193 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxLinkExpiry caps how long a signed link stays valid.
+const maxLinkExpiry = 7 * 24 * time.Hour
+
+// PresignRequest is the body of POST /admin/presign. With a key it asks
+// for a link to download (GET) or upload (PUT) that object; without one,
+// for a link to list the bucket under prefix.
+type PresignRequest struct {
+	Bucket    string   `json:"bucket"`
+	Prefix    string   `json:"prefix"`
+	Key       string   `json:"key"`
+	Method    string   `json:"method"`
+	ExpiresIn Duration `json:"expires_in"`
+}
+
+// PresignResponse carries a signed link relative to the server root.
+type PresignResponse struct {
+	URL     string    `json:"url"`
+	Method  string    `json:"method,omitempty"`
+	Expires time.Time `json:"expires"`
+}
+
+// newSigningKey returns the key used to sign links. Without a configured key a
+// random one is generated, so links stop working when the server restarts.
+func newSigningKey(config *Config) ([]byte, bool) {
+	if config.SigningKey != "" {
+		return []byte(config.SigningKey), true
+	}
+
+	key := make([]byte, 32)
+	rand.Read(key)
+	return key, false
+}
+
+// listingSignature signs a listing of bucket restricted to prefix that
+// expires at the given Unix time.
+func (s *StorageServer) listingSignature(bucketName, prefix string, expires int64) string {
+	mac := hmac.New(sha256.New, s.signingKey)
+	fmt.Fprintf(mac, "GET\n/objects/%s\n%s\n%d", bucketName, prefix, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyListingLink checks the signature and expiry of a signed listing
+// request. The prefix is covered by the signature, so a link cannot be
+// widened to other folders of the bucket.
+func (s *StorageServer) verifyListingLink(bucketName string, query url.Values) (int, string, string) {
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil {
+		return http.StatusForbidden, "SignatureInvalid", "Signed link has no valid expiry"
+	}
+
+	expected := s.listingSignature(bucketName, query.Get("prefix"), expires)
+	if !hmac.Equal([]byte(expected), []byte(query.Get("signature"))) {
+		return http.StatusForbidden, "SignatureInvalid", "Signed link signature does not match"
+	}
+
+	if time.Now().Unix() > expires {
+		return http.StatusForbidden, "LinkExpired", "Signed link has expired"
+	}
+	return http.StatusOK, "", ""
+}
+
+// objectSignature signs a link to use method on an object until the given
+// Unix time. Object paths always have a "/" after the bucket and listing
+// paths never do, so the two kinds of signature cannot be swapped.
+func (s *StorageServer) objectSignature(method, bucketName, objectKey string, expires int64) string {
+	mac := hmac.New(sha256.New, s.signingKey)
+	fmt.Fprintf(mac, "%s\n/objects/%s/%s\n%d", method, bucketName, objectKey, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// handleSignedObject serves a request for an object made with a signed
+// link: a GET link also allows HEAD, and a PUT link uploads. The link is
+// its authorization, so nothing else can be done with it.
+func (s *StorageServer) handleSignedObject(w http.ResponseWriter, r *http.Request) {
+	method := r.Method
+	if method == http.MethodHead {
+		method = http.MethodGet
+	}
+	if method != http.MethodGet && method != http.MethodPut {
+		s.writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	bucketName, objectKey, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/objects/"), "/")
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil {
+		s.writeErrorCode(w, r, http.StatusForbidden, "SignatureInvalid", "Signed link has no valid expiry")
+		return
+	}
+	expected := s.objectSignature(method, bucketName, objectKey, expires)
+	if !hmac.Equal([]byte(expected), []byte(query.Get("signature"))) {
+		s.writeErrorCode(w, r, http.StatusForbidden, "SignatureInvalid", "Signed link signature does not match")
+		return
+	}
+	if time.Now().Unix() > expires {
+		s.writeErrorCode(w, r, http.StatusForbidden, "LinkExpired", "Signed link has expired")
+		return
+	}
+
+	if method == http.MethodPut {
+		s.handlePutObject(w, r)
+	} else {
+		s.handleGetObject(w, r)
+	}
+}
+
+// handlePresign serves POST /admin/presign, which issues a time-limited
+// link to list a bucket under a prefix, or to download or upload an
+// object.
+func (s *StorageServer) handlePresign(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req PresignRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeError(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid request body: %v", err))
+		return
+	}
+
+	if req.Bucket == "" || strings.Contains(req.Bucket, "/") {
+		s.writeError(w, r, http.StatusBadRequest, "A bucket name is required")
+		return
+	}
+
+	expiresIn := time.Duration(req.ExpiresIn)
+	if expiresIn <= 0 {
+		expiresIn = time.Hour
+	}
+	if expiresIn > maxLinkExpiry {
+		s.writeError(w, r, http.StatusBadRequest, fmt.Sprintf("expires_in must not exceed %s", maxLinkExpiry))
+		return
+	}
+
+	if _, err := s.backend.GetBucket(req.Bucket); err != nil {
+		s.writeStorageError(w, r, err)
+		return
+	}
+
+	expires := time.Now().Add(expiresIn).Truncate(time.Second)
+	if req.Key != "" {
+		method := strings.ToUpper(req.Method)
+		if method == "" {
+			method = http.MethodGet
+		}
+		if method != http.MethodGet && method != http.MethodPut {
+			s.writeError(w, r, http.StatusBadRequest, "method must be GET or PUT")
+			return
+		}
+
+		query := url.Values{}
+		query.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+		query.Set("signature", s.objectSignature(method, req.Bucket, req.Key, expires.Unix()))
+		link := url.URL{Path: fmt.Sprintf("/objects/%s/%s", req.Bucket, req.Key), RawQuery: query.Encode()}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(PresignResponse{URL: link.String(), Method: method, Expires: expires})
+		return
+	}
+	if req.Method != "" {
+		s.writeError(w, r, http.StatusBadRequest, "method requires a key")
+		return
+	}
+
+	query := url.Values{}
+	query.Set("prefix", req.Prefix)
+	query.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	query.Set("signature", s.listingSignature(req.Bucket, req.Prefix, expires.Unix()))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(PresignResponse{
+		URL:     fmt.Sprintf("/objects/%s?%s", req.Bucket, query.Encode()),
+		Expires: expires,
+	})
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// HTTPConfig selects the HTTP versions the listeners speak.
+type HTTPConfig struct {
+	// HTTP2 offers HTTP/2 to clients of TLS listeners through ALPN. It is on
+	// unless set to false.
+	HTTP2 *bool `json:"http2,omitempty"`
+
+	// H2C accepts HTTP/2 with prior knowledge on listeners without TLS, for
+	// deployments behind a proxy that terminates TLS.
+	H2C bool `json:"h2c,omitempty"`
+
+	// MaxConcurrentStreams limits the requests a client may have in flight
+	// on one HTTP/2 connection; 0 uses the default of 250.
+	MaxConcurrentStreams int `json:"max_concurrent_streams,omitempty"`
+}
+
+func (c *HTTPConfig) validate() error {
+	if c == nil {
+		return nil
+	}
+	if c.MaxConcurrentStreams < 0 {
+		return fmt.Errorf("http: max_concurrent_streams must not be negative")
+	}
+	if c.H2C && !c.http2() {
+		return fmt.Errorf("http: h2c requires http2")
+	}
+	return nil
+}
+
+func (c *HTTPConfig) http2() bool {
+	return c == nil || c.HTTP2 == nil || *c.HTTP2
+}
+
+// apply sets the protocols of a listener's server. HTTP/1.1 is always
+// served.
+func (c *HTTPConfig) apply(httpServer *http.Server) {
+	var protocols http.Protocols
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(c.http2())
+	if c != nil {
+		protocols.SetUnencryptedHTTP2(c.H2C)
+		if c.MaxConcurrentStreams > 0 {
+			httpServer.HTTP2 = &http.HTTP2Config{MaxConcurrentStreams: c.MaxConcurrentStreams}
+		}
+	}
+	httpServer.Protocols = &protocols
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ErrQuotaExceeded is returned when a write would take a bucket past its
+// quota.
+var ErrQuotaExceeded = errors.New("bucket quota exceeded")
+
+// BucketQuota limits a bucket's total size and object count. Zero means
+// unlimited.
+type BucketQuota struct {
+	MaxBytes   int64 `json:"max_bytes,omitempty"`
+	MaxObjects int64 `json:"max_objects,omitempty"`
+}
+
+// BucketUsage is maintained incrementally on every write and delete so quota
+// checks never rescan the bucket.
+type BucketUsage struct {
+	Objects int64 `json:"objects"`
+	Bytes   int64 `json:"bytes"`
+
+	// Largest is nil for an empty bucket, and also once the largest object
+	// is deleted, until the bucket is rescanned (see largestKnown).
+	Largest      *LargestObject `json:"largest_object,omitempty"`
+	LastActivity time.Time      `json:"last_activity,omitzero"`
+}
+
+type LargestObject struct {
+	Key  string `json:"key"`
+	Size int64  `json:"size"`
+}
+
+func (quota *BucketQuota) validate() error {
+	if quota == nil {
+		return nil
+	}
+	if quota.MaxBytes < 0 || quota.MaxObjects < 0 {
+		return fmt.Errorf("quota limits must not be negative")
+	}
+	return nil
+}
+
+// check returns ErrQuotaExceeded if usage changed by the given deltas would
+// exceed the quota.
+func (quota *BucketQuota) check(usage BucketUsage, deltaObjects, deltaBytes int64) error {
+	if quota == nil {
+		return nil
+	}
+	if deltaBytes > 0 && quota.MaxBytes > 0 && usage.Bytes+deltaBytes > quota.MaxBytes {
+		return fmt.Errorf("%w: %d of %d bytes used, write needs %d more", ErrQuotaExceeded, usage.Bytes, quota.MaxBytes, deltaBytes)
+	}
+	if deltaObjects > 0 && quota.MaxObjects > 0 && usage.Objects+deltaObjects > quota.MaxObjects {
+		return fmt.Errorf("%w: %d of %d objects used", ErrQuotaExceeded, usage.Objects, quota.MaxObjects)
+	}
+	return nil
+}
+
+// bucketUsage returns the tracked usage of a bucket, counting the bucket once
+// if it predates usage tracking. The caller must hold bucketMu.
+func (storage *ObjectStorage) bucketUsage(bucket *Bucket) (BucketUsage, error) {
+	if bucket.Usage != nil {
+		return *bucket.Usage, nil
+	}
+
+	usage := BucketUsage{}
+	err := storage.WalkObjects(bucket.Name, func(metadata ObjectMetadata) error {
+		usage.count(metadata)
+		return nil
+	})
+	if err != nil {
+		return usage, err
+	}
+
+	bucket.Usage = &usage
+	return usage, nil
+}
+
+// CheckQuota reports whether writing size bytes to objectKey would exceed
+// the bucket's quota. It is used to reject uploads with a known
+// Content-Length before any data is read.
+func (storage *ObjectStorage) CheckQuota(bucketName, objectKey string, size int64) error {
+	storage.bucketMu.Lock()
+	defer storage.bucketMu.Unlock()
+
+	bucket, err := storage.GetBucket(bucketName)
+	if err != nil || bucket.Settings.Quota == nil {
+		return nil
+	}
+
+	usage, err := storage.bucketUsage(&bucket)
+	if err != nil {
+		return err
+	}
+
+	deltaObjects, deltaBytes := int64(1), size
+	if existing, err := storage.loadObjectMetadata(bucketName, objectKey); err == nil {
+		deltaObjects, deltaBytes = 0, size-existing.Size
+	}
+	return bucket.Settings.Quota.check(usage, deltaObjects, deltaBytes)
+}
+
+// adjustUsage updates the tracked usage of a bucket after added replaced
+// removed; either may be nil. The caller must hold bucketMu.
+func (storage *ObjectStorage) adjustUsage(bucketName string, added, removed *ObjectMetadata) error {
+	bucket, err := storage.GetBucket(bucketName)
+	if err != nil {
+		return err
+	}
+
+	if bucket.Usage == nil {
+		// First tracked change: count the bucket as it is on disk now, which
+		// already reflects this change.
+		if _, err := storage.bucketUsage(&bucket); err != nil {
+			return err
+		}
+	} else {
+		bucket.Usage.apply(added, removed)
+	}
+	bucket.Usage.LastActivity = time.Now()
+
+	return storage.saveBucketMetaData(bucket)
+}
+
+// handleBucketQuota serves GET, PUT and DELETE on /buckets/{name}?quota.
+func (s *StorageServer) handleBucketQuota(w http.ResponseWriter, r *http.Request) {
+	bucketName := strings.TrimPrefix(r.URL.Path, "/buckets/")
+
+	var quota *BucketQuota
+	switch r.Method {
+	case http.MethodGet, http.MethodDelete:
+	case http.MethodPut:
+		quota = &BucketQuota{}
+		if err := json.NewDecoder(r.Body).Decode(quota); err != nil {
+			s.writeError(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid quota: %v", err))
+			return
+		}
+		if err := quota.validate(); err != nil {
+			s.writeError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+	default:
+		s.writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var bucket Bucket
+	var err error
+	if r.Method == http.MethodGet {
+		bucket, err = s.storage.GetBucket(bucketName)
+	} else {
+		bucket, err = s.storage.UpdateBucketSettings(bucketName, func(settings *BucketSettings) error {
+			settings.Quota = quota
+			return nil
+		})
+	}
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			s.writeError(w, r, http.StatusNotFound, "Bucket not found")
+		} else {
+			s.writeError(w, r, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	response := struct {
+		Quota *BucketQuota `json:"quota"`
+		Usage *BucketUsage `json:"usage,omitempty"`
+	}{bucket.Settings.Quota, bucket.Usage}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
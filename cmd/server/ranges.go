@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var errRangeNotSatisfiable = errors.New("range not satisfiable")
+
+// parseRange reads a Range header for an object of size bytes. Only a
+// single byte range is supported; ok is false when the header is absent or
+// asks for something else, and the whole object is served instead.
+func parseRange(header string, size int64) (start, length int64, ok bool, err error) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return 0, 0, false, nil
+	}
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, 0, false, nil
+	}
+
+	if first == "" {
+		// bytes=-N is the last N bytes.
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return 0, 0, false, nil
+		}
+		if n == 0 || size == 0 {
+			return 0, 0, false, errRangeNotSatisfiable
+		}
+		n = min(n, size)
+		return size - n, n, true, nil
+	}
+
+	start, err = strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, false, nil
+	}
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, false, nil
+		}
+		end = min(end, size-1)
+	}
+	if start >= size {
+		return 0, 0, false, errRangeNotSatisfiable
+	}
+	return start, end - start + 1, true, nil
+}
+
+// requestedRange returns the part of an object a GET asks for. A Range
+// with an If-Range naming another ETag gets the whole, current object.
+func requestedRange(r *http.Request, metadata *ObjectMetadata) (start, length int64, ok bool, err error) {
+	header := r.Header.Get("Range")
+	if header == "" {
+		return 0, 0, false, nil
+	}
+	if ifRange := r.Header.Get("If-Range"); ifRange != "" && strings.Trim(ifRange, `"`) != metadata.ETag {
+		return 0, 0, false, nil
+	}
+	return parseRange(header, metadata.Size)
+}
+
+// writeRange answers a ranged GET or HEAD with 206 and the requested bytes.
+// Ranged responses are never compressed.
+func (s *StorageServer) writeRange(w http.ResponseWriter, r *http.Request, reader io.Reader, metadata *ObjectMetadata, start, length int64) {
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+length-1, metadata.Size))
+	w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusPartialContent)
+		return
+	}
+
+	if seeker, ok := reader.(io.Seeker); ok {
+		if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+			s.writeError(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+	} else if _, err := io.CopyN(io.Discard, reader, start); err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s.writeCompressible(w, http.StatusPartialContent, io.LimitReader(reader, length), false)
+}
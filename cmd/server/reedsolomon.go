@@ -0,0 +1,209 @@
+package main
+
+import "fmt"
+
+// Arithmetic in GF(2^8) with the reducing polynomial x^8+x^4+x^3+x^2+1.
+var (
+	gfExp [510]byte
+	gfLog [256]byte
+)
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		gfExp[i] = byte(x)
+		gfLog[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+	for i := 255; i < len(gfExp); i++ {
+		gfExp[i] = gfExp[i-255]
+	}
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+func gfInv(a byte) byte {
+	return gfExp[255-int(gfLog[a])]
+}
+
+func gfPow(a byte, n int) byte {
+	if n == 0 {
+		return 1
+	}
+	if a == 0 {
+		return 0
+	}
+	return gfExp[(int(gfLog[a])*n)%255]
+}
+
+// gfMulAdd adds c*in to out.
+func gfMulAdd(out, in []byte, c byte) {
+	if c == 0 {
+		return
+	}
+	var table [256]byte
+	for x := 1; x < 256; x++ {
+		table[x] = gfMul(c, byte(x))
+	}
+	for i, v := range in {
+		out[i] ^= table[v]
+	}
+}
+
+type gfMatrix [][]byte
+
+func newGFMatrix(rows, cols int) gfMatrix {
+	m := make(gfMatrix, rows)
+	for r := range m {
+		m[r] = make([]byte, cols)
+	}
+	return m
+}
+
+func (m gfMatrix) mul(other gfMatrix) gfMatrix {
+	result := newGFMatrix(len(m), len(other[0]))
+	for r := range m {
+		for c := range other[0] {
+			var v byte
+			for i := range other {
+				v ^= gfMul(m[r][i], other[i][c])
+			}
+			result[r][c] = v
+		}
+	}
+	return result
+}
+
+// invert returns the inverse of a square matrix by Gauss-Jordan elimination.
+func (m gfMatrix) invert() (gfMatrix, error) {
+	n := len(m)
+	work := newGFMatrix(n, 2*n)
+	for r := range m {
+		copy(work[r], m[r])
+		work[r][n+r] = 1
+	}
+
+	for col := 0; col < n; col++ {
+		pivot := col
+		for pivot < n && work[pivot][col] == 0 {
+			pivot++
+		}
+		if pivot == n {
+			return nil, fmt.Errorf("matrix is singular")
+		}
+		work[col], work[pivot] = work[pivot], work[col]
+
+		scale := gfInv(work[col][col])
+		for c := range work[col] {
+			work[col][c] = gfMul(work[col][c], scale)
+		}
+		for r := 0; r < n; r++ {
+			if r != col && work[r][col] != 0 {
+				factor := work[r][col]
+				for c := range work[r] {
+					work[r][c] ^= gfMul(factor, work[col][c])
+				}
+			}
+		}
+	}
+
+	inverse := newGFMatrix(n, n)
+	for r := range inverse {
+		copy(inverse[r], work[r][n:])
+	}
+	return inverse, nil
+}
+
+// reedSolomon is a systematic Reed-Solomon code: data shards are stored as
+// they are, and any dataShards of the dataShards+parityShards shards are
+// enough to recover the data.
+type reedSolomon struct {
+	dataShards   int
+	parityShards int
+
+	// matrix maps data shards to all shards. Its top rows are the identity,
+	// and every square submatrix of its rows is invertible.
+	matrix gfMatrix
+}
+
+func newReedSolomon(dataShards, parityShards int) (*reedSolomon, error) {
+	total := dataShards + parityShards
+	if dataShards <= 0 || parityShards < 0 || total > 256 {
+		return nil, fmt.Errorf("invalid shard counts: %d data, %d parity", dataShards, parityShards)
+	}
+
+	vandermonde := newGFMatrix(total, dataShards)
+	for r := range vandermonde {
+		for c := range vandermonde[r] {
+			vandermonde[r][c] = gfPow(byte(r), c)
+		}
+	}
+
+	top, err := vandermonde[:dataShards].invert()
+	if err != nil {
+		return nil, err
+	}
+	return &reedSolomon{
+		dataShards:   dataShards,
+		parityShards: parityShards,
+		matrix:       vandermonde.mul(top),
+	}, nil
+}
+
+// encode computes the parity shards from the data shards. All shards must
+// have the same length.
+func (rs *reedSolomon) encode(shards [][]byte) {
+	for p := 0; p < rs.parityShards; p++ {
+		out := shards[rs.dataShards+p]
+		clear(out)
+		for d := 0; d < rs.dataShards; d++ {
+			gfMulAdd(out, shards[d], rs.matrix[rs.dataShards+p][d])
+		}
+	}
+}
+
+// reconstructData fills in the missing (nil) data shards from the shards
+// present. Missing parity shards are left nil.
+func (rs *reedSolomon) reconstructData(shards [][]byte, size int) error {
+	var present []int
+	for i, shard := range shards {
+		if shard != nil {
+			present = append(present, i)
+		}
+		if len(present) == rs.dataShards {
+			break
+		}
+	}
+	if len(present) < rs.dataShards {
+		return fmt.Errorf("only %d of %d shards available, need %d", len(present), len(shards), rs.dataShards)
+	}
+
+	sub := make(gfMatrix, rs.dataShards)
+	for i, shard := range present {
+		sub[i] = rs.matrix[shard]
+	}
+	decode, err := sub.invert()
+	if err != nil {
+		return err
+	}
+
+	for d := 0; d < rs.dataShards; d++ {
+		if shards[d] != nil {
+			continue
+		}
+		out := make([]byte, size)
+		for i, shard := range present {
+			gfMulAdd(out, shards[shard], decode[d][i])
+		}
+		shards[d] = out
+	}
+	return nil
+}
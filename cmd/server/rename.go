@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrObjectExists is returned when a rename target is already taken.
+var ErrObjectExists = errors.New("object already exists")
+
+// RenameObject moves an object to a new key in the same bucket. Only the
+// data file and metadata are moved, so tags, checksums, generation,
+// retention and the original modification time are kept. The source is
+// subject to object lock like a delete.
+func (storage *ObjectStorage) RenameObject(bucketName, objectKey, newKey string) (*ObjectMetadata, error) {
+	if err := validateObjectKey(newKey); err != nil {
+		return nil, err
+	}
+	storage.bucketMu.Lock()
+	defer storage.bucketMu.Unlock()
+
+	metadata, err := storage.loadObjectMetadata(bucketName, objectKey)
+	if errors.Is(err, ErrInvalidKey) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("object not found")
+	}
+	if err := checkRetention(metadata, time.Now()); err != nil {
+		return nil, err
+	}
+
+	if _, err := storage.loadObjectMetadata(bucketName, newKey); err == nil {
+		return nil, fmt.Errorf("%w: %s", ErrObjectExists, newKey)
+	}
+
+	root, err := storage.dataRoot(metadata.Location)
+	if err != nil {
+		return nil, err
+	}
+	oldPath := root.objectPath(bucketName, objectKey)
+	newPath := root.objectPath(bucketName, newKey)
+	// A data file without metadata, such as one left by an interrupted
+	// write, must not be replaced either.
+	if _, err := storage.Stat(newPath); err == nil {
+		return nil, fmt.Errorf("%w: %s", ErrObjectExists, newKey)
+	}
+	if err := storage.MkdirAll(filepath.Dir(newPath), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create object directory: %w", err)
+	}
+	if err := storage.Rename(oldPath, newPath); err != nil && !storage.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to rename object: %w", err)
+	}
+
+	storage.content.remove(bucketName, metadata)
+	old := *metadata
+	metadata.Key = newKey
+	if err := storage.saveObjectMetaData(bucketName, metadata); err != nil {
+		// Put the data back so the old metadata still describes it.
+		storage.Rename(newPath, oldPath)
+		metadata.Key = objectKey
+		storage.content.add(bucketName, metadata)
+		return nil, fmt.Errorf("failed to save metadata: %w", err)
+	}
+	storage.content.add(bucketName, metadata)
+
+	if err := storage.metadata.DeleteObject(bucketName, objectKey); err != nil {
+		storage.logger.Warn("failed to remove old metadata", "bucket", bucketName, "key", objectKey, "error", err)
+	}
+	if err := storage.adjustUsage(bucketName, metadata, &old); err != nil {
+		storage.logger.Warn("failed to update bucket usage", "bucket", bucketName, "error", err)
+	}
+
+	storage.logger.Debug("object renamed", "bucket", bucketName, "key", objectKey, "new_key", newKey)
+	return metadata, nil
+}
+
+// handleRenameObject serves POST /objects/{bucket}/{key}?rename with
+// {"to": "new/key"}, or POST /objects/{bucket}/{key}?rename={new/key} with
+// no body.
+func (s *StorageServer) handleRenameObject(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/objects/")
+	bucketName, objectKey, ok := strings.Cut(path, "/")
+	if !ok || objectKey == "" {
+		s.writeError(w, r, http.StatusBadRequest, "Bucket and object key required")
+		return
+	}
+
+	var req struct {
+		To string `json:"to"`
+	}
+	if req.To = r.URL.Query().Get("rename"); req.To == "" {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			s.writeError(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid request body: %v", err))
+			return
+		}
+	}
+	if req.To == "" || req.To == objectKey {
+		s.writeError(w, r, http.StatusBadRequest, "to must name a different key")
+		return
+	}
+
+	metadata, err := s.storage.RenameObject(bucketName, objectKey, req.To)
+	if err != nil {
+		s.writeStorageError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(metadata)
+
+	s.notify(EventCopy, bucketName, req.To, metadata)
+	s.notify(EventDelete, bucketName, objectKey, nil)
+	s.replicate(r, replicationPut, bucketName, req.To)
+	s.replicate(r, replicationDelete, bucketName, objectKey)
+}
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"storage-system/internal/ids"
+)
+
+const (
+	replicationTimeout    = 5 * time.Minute
+	replicationMaxBackoff = time.Minute
+
+	// replicationHeader marks requests sent by a replicating server. Changes
+	// made by them are not replicated further, so two servers can replicate
+	// to each other without looping.
+	replicationHeader = "X-Storage-Replicated"
+)
+
+// Replication operations.
+const (
+	replicationPut    = "put"
+	replicationDelete = "delete"
+)
+
+// ReplicationConfig mirrors object writes and deletes to peer servers.
+type ReplicationConfig struct {
+	Peers []ReplicationPeer `json:"peers"`
+
+	// Buckets limits replication to the listed buckets (all when empty).
+	Buckets []string `json:"buckets,omitempty"`
+}
+
+// ReplicationPeer is a remote storage server receiving replicated changes.
+type ReplicationPeer struct {
+	// Name identifies the peer in the status endpoint and names its queue
+	// directory.
+	Name string `json:"name"`
+	URL  string `json:"url"`
+
+	// Token is sent as a bearer token to the peer.
+	Token string `json:"token,omitempty"`
+}
+
+func (c *ReplicationConfig) validate() error {
+	if c == nil {
+		return nil
+	}
+	if len(c.Peers) == 0 {
+		return fmt.Errorf("replication: at least one peer is required")
+	}
+	seen := make(map[string]bool)
+	for _, peer := range c.Peers {
+		if peer.Name == "" || strings.Trim(peer.Name, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_") != "" {
+			return fmt.Errorf("replication: peer name %q must be non-empty and contain only letters, digits, '-' and '_'", peer.Name)
+		}
+		if seen[peer.Name] {
+			return fmt.Errorf("replication: duplicate peer name: %s", peer.Name)
+		}
+		seen[peer.Name] = true
+		if !strings.HasPrefix(peer.URL, "http://") && !strings.HasPrefix(peer.URL, "https://") {
+			return fmt.Errorf("replication: peer %s: url must be an http or https URL", peer.Name)
+		}
+	}
+	return nil
+}
+
+// replicationOp is one queued change. Ops are stored as {id}.json in the
+// peer's queue directory; IDs are ULIDs, so the directory lists in order.
+type replicationOp struct {
+	ID     string    `json:"id"`
+	Op     string    `json:"op"`
+	Bucket string    `json:"bucket"`
+	Key    string    `json:"key"`
+	Time   time.Time `json:"time"`
+}
+
+// replicaPeer is the queue and delivery state of one peer.
+type replicaPeer struct {
+	config ReplicationPeer
+	dir    string
+	wake   chan struct{}
+
+	mu          sync.Mutex
+	lastSuccess time.Time
+	lastError   string
+	lastErrorAt time.Time
+	failed      int64
+}
+
+type replication struct {
+	config *ReplicationConfig
+	client *http.Client
+	peers  []*replicaPeer
+}
+
+func newReplication(config *ReplicationConfig, dataDir string) *replication {
+	r := &replication{
+		config: config,
+		client: &http.Client{Timeout: replicationTimeout},
+	}
+	for _, peer := range config.Peers {
+		r.peers = append(r.peers, &replicaPeer{
+			config: peer,
+			dir:    filepath.Join(dataDir, "replication", peer.Name),
+			wake:   make(chan struct{}, 1),
+		})
+	}
+	return r
+}
+
+// replicate queues a change for every peer. Changes made by a replicating
+// server, and changes to buckets that are not replicated, are skipped. A
+// change that cannot be queued is logged; the peer misses it until the
+// object is written again.
+func (s *StorageServer) replicate(r *http.Request, op, bucketName, objectKey string) {
+	if s.replication == nil || r.Header.Get(replicationHeader) != "" {
+		return
+	}
+	if buckets := s.replication.config.Buckets; len(buckets) > 0 && !slices.Contains(buckets, bucketName) {
+		return
+	}
+
+	entry := replicationOp{
+		ID:     ids.New(),
+		Op:     op,
+		Bucket: bucketName,
+		Key:    objectKey,
+		Time:   time.Now().UTC(),
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+
+	for _, peer := range s.replication.peers {
+		if err := os.MkdirAll(peer.dir, 0755); err != nil {
+			s.logger.Error("failed to queue replication", "peer", peer.config.Name, "bucket", bucketName, "key", objectKey, "error", err)
+			continue
+		}
+		if err := os.WriteFile(filepath.Join(peer.dir, entry.ID+".json"), data, 0644); err != nil {
+			s.logger.Error("failed to queue replication", "peer", peer.config.Name, "bucket", bucketName, "key", objectKey, "error", err)
+			continue
+		}
+		select {
+		case peer.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// pending returns the queued ops of a peer, oldest first.
+func (peer *replicaPeer) pending() ([]string, error) {
+	entries, err := os.ReadDir(peer.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".json") {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+// runReplication starts one worker per peer. Each worker sends queued ops
+// in order until ctx is cancelled; ops queued before a restart are sent
+// once the server is back.
+func (s *StorageServer) runReplication(ctx context.Context) {
+	for _, peer := range s.replication.peers {
+		go s.replicatePeer(ctx, peer)
+	}
+}
+
+func (s *StorageServer) replicatePeer(ctx context.Context, peer *replicaPeer) {
+	ensured := make(map[string]bool)
+
+	for {
+		names, err := peer.pending()
+		if err != nil {
+			s.logger.Error("failed to read replication queue", "peer", peer.config.Name, "error", err)
+		}
+
+		for _, name := range names {
+			if !s.replicateOp(ctx, peer, filepath.Join(peer.dir, name), ensured) {
+				return
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-peer.wake:
+		}
+	}
+}
+
+// replicateOp sends one queued op, retrying with backoff while the peer is
+// unreachable or failing. Ops the peer rejects are dropped. It returns
+// false when ctx is cancelled.
+func (s *StorageServer) replicateOp(ctx context.Context, peer *replicaPeer, path string, ensured map[string]bool) bool {
+	var op replicationOp
+	data, err := os.ReadFile(path)
+	if err == nil {
+		err = json.Unmarshal(data, &op)
+	}
+	if err != nil {
+		s.logger.Error("dropping unreadable replication op", "peer", peer.config.Name, "file", path, "error", err)
+		os.Remove(path)
+		return true
+	}
+
+	backoff := time.Second
+	for {
+		retry, err := s.sendReplicationOp(ctx, peer, op, ensured)
+		if err == nil {
+			peer.mu.Lock()
+			peer.lastSuccess = time.Now()
+			peer.mu.Unlock()
+			os.Remove(path)
+			return true
+		}
+
+		peer.mu.Lock()
+		peer.lastError = err.Error()
+		peer.lastErrorAt = time.Now()
+		if !retry {
+			peer.failed++
+		}
+		peer.mu.Unlock()
+
+		if !retry {
+			s.logger.Error("replication rejected by peer, dropping op", "peer", peer.config.Name, "op", op.Op, "bucket", op.Bucket, "key", op.Key, "error", err)
+			os.Remove(path)
+			return true
+		}
+		s.logger.Warn("replication failed, retrying", "peer", peer.config.Name, "op", op.Op, "bucket", op.Bucket, "key", op.Key, "backoff", backoff, "error", err)
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, replicationMaxBackoff)
+	}
+}
+
+// sendReplicationOp applies an op to the peer. A put sends the object's
+// current data, so an object deleted locally since the op was queued is
+// skipped; its delete op follows in the queue. retry reports whether a
+// failure is worth retrying.
+func (s *StorageServer) sendReplicationOp(ctx context.Context, peer *replicaPeer, op replicationOp, ensured map[string]bool) (retry bool, err error) {
+	objectPath := "/objects/" + op.Bucket + "/" + op.Key
+
+	if op.Op == replicationDelete {
+		return s.peerRequest(ctx, peer, http.MethodDelete, objectPath, nil)
+	}
+
+	if !ensured[op.Bucket] {
+		// Creating a bucket is idempotent; it makes the bucket show up in
+		// the peer's bucket list.
+		if retry, err := s.peerRequest(ctx, peer, http.MethodPut, "/buckets/"+op.Bucket, nil); err != nil {
+			return retry, err
+		}
+		ensured[op.Bucket] = true
+	}
+
+	reader, metadata, err := s.backend.GetObject(op.Bucket, op.Key)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			return false, nil
+		}
+		return true, err
+	}
+	defer reader.Close()
+
+	return s.peerRequest(ctx, peer, http.MethodPut, objectPath, func(req *http.Request) {
+		req.Body = reader
+		req.ContentLength = metadata.Size
+		req.Header.Set("Content-Type", metadata.ContentType)
+		if digest, err := hex.DecodeString(metadata.ETag); err == nil && len(digest) == 16 {
+			req.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(digest))
+		}
+		if len(metadata.Tags) > 0 {
+			req.Header.Set(taggingHeader, formatTags(metadata.Tags))
+		}
+		if metadata.CacheControl != "" {
+			req.Header.Set("Cache-Control", metadata.CacheControl)
+		}
+		if metadata.Expires != nil {
+			req.Header.Set("Expires", metadata.Expires.Format(http.TimeFormat))
+		}
+		if metadata.RetainUntil != nil {
+			req.Header.Set(retainUntilHeader, metadata.RetainUntil.Format(time.RFC3339))
+		}
+		if metadata.LegalHold {
+			req.Header.Set(legalHoldHeader, formatLegalHold(true))
+		}
+	})
+}
+
+// peerRequest sends a request to a peer. Network errors, 5xx, 408 and 429
+// are retryable; other non-2xx responses are not.
+func (s *StorageServer) peerRequest(ctx context.Context, peer *replicaPeer, method, path string, prepare func(*http.Request)) (retry bool, err error) {
+	target, err := url.Parse(peer.config.URL)
+	if err != nil {
+		return false, err
+	}
+	target = target.JoinPath(path)
+
+	req, err := http.NewRequestWithContext(ctx, method, target.String(), nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set(replicationHeader, "true")
+	if peer.config.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+peer.config.Token)
+	}
+	if prepare != nil {
+		prepare(req)
+	}
+
+	resp, err := s.replication.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return false, nil
+	}
+	err = fmt.Errorf("%s %s: peer returned %s", method, path, resp.Status)
+	retry = resp.StatusCode >= 500 || resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests
+	return retry, err
+}
+
+// ReplicationStatus describes the replication queue of one peer.
+type ReplicationStatus struct {
+	Name    string `json:"name"`
+	URL     string `json:"url"`
+	Pending int    `json:"pending"`
+
+	// OldestPending is when the oldest queued change was made; LagSeconds
+	// is how long ago that was, or 0 when the peer is caught up.
+	OldestPending *time.Time `json:"oldest_pending,omitempty"`
+	LagSeconds    float64    `json:"lag_seconds"`
+
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+
+	// Failed counts ops dropped because the peer rejected them.
+	Failed int64 `json:"failed"`
+}
+
+func (peer *replicaPeer) status(now time.Time) (ReplicationStatus, error) {
+	status := ReplicationStatus{Name: peer.config.Name, URL: peer.config.URL}
+
+	names, err := peer.pending()
+	if err != nil {
+		return status, err
+	}
+	status.Pending = len(names)
+	if len(names) > 0 {
+		if oldest, err := ids.Time(strings.TrimSuffix(names[0], ".json")); err == nil {
+			status.OldestPending = &oldest
+			status.LagSeconds = now.Sub(oldest).Seconds()
+		}
+	}
+
+	peer.mu.Lock()
+	defer peer.mu.Unlock()
+	if !peer.lastSuccess.IsZero() {
+		lastSuccess := peer.lastSuccess
+		status.LastSuccess = &lastSuccess
+	}
+	if peer.lastError != "" {
+		lastErrorAt := peer.lastErrorAt
+		status.LastError = peer.lastError
+		status.LastErrorAt = &lastErrorAt
+	}
+	status.Failed = peer.failed
+	return status, nil
+}
+
+// handleReplicationStatus serves GET /admin/replication with the queue
+// length and lag of every peer.
+func (s *StorageServer) handleReplicationStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if s.replication == nil {
+		s.writeError(w, r, http.StatusNotFound, "Replication is not configured")
+		return
+	}
+
+	now := time.Now()
+	statuses := []ReplicationStatus{}
+	for _, peer := range s.replication.peers {
+		status, err := peer.status(now)
+		if err != nil {
+			s.writeError(w, r, http.StatusInternalServerError, fmt.Sprintf("failed to read replication queue: %v", err))
+			return
+		}
+		statuses = append(statuses, status)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(statuses)
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// defaultResponseCompressionMinSize is the smallest object gzipped on
+// download; below it the gzip header outweighs the savings.
+const defaultResponseCompressionMinSize = 1024
+
+// ResponseCompressionConfig controls gzip Content-Encoding of downloads for
+// clients that send Accept-Encoding: gzip. It is on unless disabled.
+type ResponseCompressionConfig struct {
+	// Enabled is true unless set to false.
+	Enabled *bool `json:"enabled,omitempty"`
+
+	// MinSize is the smallest object in bytes that is compressed; 0 uses
+	// 1024.
+	MinSize int64 `json:"min_size,omitempty"`
+
+	// Level is the gzip level from 1 (fastest) to 9 (smallest); 0 uses the
+	// default level.
+	Level int `json:"level,omitempty"`
+
+	// ContentTypes lists the content type prefixes that are compressed;
+	// empty uses the same list as compression at rest.
+	ContentTypes []string `json:"content_types,omitempty"`
+}
+
+func (c *ResponseCompressionConfig) validate() error {
+	if c == nil {
+		return nil
+	}
+	if c.MinSize < 0 {
+		return fmt.Errorf("response_compression: min_size must not be negative")
+	}
+	if c.Level < 0 || c.Level > gzip.BestCompression {
+		return fmt.Errorf("response_compression: level must be between 1 and 9")
+	}
+	return nil
+}
+
+func (c *ResponseCompressionConfig) enabled() bool {
+	return c == nil || c.Enabled == nil || *c.Enabled
+}
+
+// compressible reports whether a download of the given type and size may
+// be gzipped, before looking at the request and the bucket.
+func (c *ResponseCompressionConfig) compressible(contentType string, size int64) bool {
+	if !c.enabled() {
+		return false
+	}
+
+	minSize := int64(defaultResponseCompressionMinSize)
+	types := defaultCompressibleTypes
+	if c != nil {
+		if c.MinSize > 0 {
+			minSize = c.MinSize
+		}
+		if len(c.ContentTypes) > 0 {
+			types = c.ContentTypes
+		}
+	}
+	if size < minSize {
+		return false
+	}
+
+	contentType = strings.ToLower(contentType)
+	for _, prefix := range types {
+		if strings.HasPrefix(contentType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *ResponseCompressionConfig) level() int {
+	if c == nil || c.Level == 0 {
+		return gzip.DefaultCompression
+	}
+	return c.Level
+}
+
+// BucketResponseCompression overrides the server's response compression for
+// the downloads of one bucket.
+type BucketResponseCompression struct {
+	Enabled bool `json:"enabled"`
+}
+
+// handleBucketResponseCompression serves GET, PUT and DELETE on
+// /buckets/{name}?response-compression.
+func (s *StorageServer) handleBucketResponseCompression(w http.ResponseWriter, r *http.Request) {
+	bucketName := strings.TrimPrefix(r.URL.Path, "/buckets/")
+
+	var setting *BucketResponseCompression
+	switch r.Method {
+	case http.MethodGet, http.MethodDelete:
+	case http.MethodPut:
+		setting = &BucketResponseCompression{}
+		if err := json.NewDecoder(r.Body).Decode(setting); err != nil {
+			s.writeError(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid response compression setting: %v", err))
+			return
+		}
+	default:
+		s.writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var bucket Bucket
+	var err error
+	if r.Method == http.MethodGet {
+		bucket, err = s.storage.GetBucket(bucketName)
+	} else {
+		bucket, err = s.storage.UpdateBucketSettings(bucketName, func(settings *BucketSettings) error {
+			settings.ResponseCompression = setting
+			return nil
+		})
+	}
+	if err != nil {
+		s.writeStorageError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]*BucketResponseCompression{"response_compression": bucket.Settings.ResponseCompression})
+}
+
+// gzipResponse reports whether the download of an object should be sent
+// gzipped. Clients opt out per request by not accepting gzip, with
+// Cache-Control: no-transform, or with ?encoding=identity; buckets opt out
+// with their response compression setting. Responses that could be
+// gzipped get Vary: Accept-Encoding, so caches keep both forms apart.
+func (s *StorageServer) gzipResponse(w http.ResponseWriter, r *http.Request, bucketName string, metadata *ObjectMetadata) bool {
+	if !s.config.ResponseCompression.compressible(metadata.ContentType, metadata.Size) {
+		return false
+	}
+	w.Header().Add("Vary", "Accept-Encoding")
+
+	if r.Method != http.MethodGet || !acceptsGzip(r.Header.Get("Accept-Encoding")) || r.URL.Query().Get("encoding") == "identity" {
+		return false
+	}
+	if strings.Contains(strings.ToLower(r.Header.Get("Cache-Control")), "no-transform") {
+		return false
+	}
+
+	bucket, err := s.backend.GetBucket(bucketName)
+	if err != nil {
+		return false
+	}
+	return bucket.Settings.ResponseCompression == nil || bucket.Settings.ResponseCompression.Enabled
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip, either
+// by name or through "*", with a non-zero quality.
+func acceptsGzip(header string) bool {
+	accepted := false
+	for _, item := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(item), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "gzip" && coding != "x-gzip" && coding != "*" {
+			continue
+		}
+
+		quality := 1.0
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil {
+				quality = v
+			}
+		}
+		if coding != "*" {
+			// An explicit gzip entry overrides "*".
+			return quality > 0
+		}
+		accepted = quality > 0
+	}
+	return accepted
+}
+
+// writeCompressible sends an object body, gzipped when compress is set. It
+// must be called before the status is written. The gzipped response has no
+// Content-Length; ETag and checksum headers still describe the object's
+// data.
+func (s *StorageServer) writeCompressible(w http.ResponseWriter, status int, reader io.Reader, compress bool) {
+	if !compress {
+		w.WriteHeader(status)
+		io.Copy(w, reader)
+		return
+	}
+
+	w.Header().Del("Content-Length")
+	w.Header().Set("Content-Encoding", "gzip")
+	w.WriteHeader(status)
+
+	gz, _ := gzip.NewWriterLevel(w, s.config.ResponseCompression.level())
+	io.Copy(gz, reader)
+	gz.Close()
+}
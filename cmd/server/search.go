@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSearchConcurrency = 4
+	maxSearchConcurrency     = 32
+)
+
+type SearchResult struct {
+	Bucket string `json:"bucket"`
+	ObjectMetadata
+}
+
+// SearchFilter selects objects by their metadata. Zero fields match every
+// object; an object must match all the others.
+type SearchFilter struct {
+	Bucket string
+
+	// Key matches keys containing it.
+	Key string
+
+	// ContentType matches the media type, ignoring parameters. A value
+	// ending in "/*" matches the whole type, e.g. "image/*".
+	ContentType string
+
+	MinSize, MaxSize int64 // MaxSize < 0 means no limit
+	ModifiedAfter    time.Time
+
+	// Tags maps tag keys to required values; an empty value only requires
+	// the tag to be present.
+	Tags map[string]string
+}
+
+// parseSearchFilter reads a filter from the /search query parameters.
+func parseSearchFilter(query url.Values) (SearchFilter, error) {
+	filter := SearchFilter{
+		Bucket:      query.Get("bucket"),
+		Key:         query.Get("key"),
+		ContentType: strings.ToLower(query.Get("content-type")),
+		MaxSize:     -1,
+	}
+
+	var err error
+	if v := query.Get("min-size"); v != "" {
+		if filter.MinSize, err = strconv.ParseInt(v, 10, 64); err != nil || filter.MinSize < 0 {
+			return filter, fmt.Errorf("min-size must be a non-negative integer")
+		}
+	}
+	if v := query.Get("max-size"); v != "" {
+		if filter.MaxSize, err = strconv.ParseInt(v, 10, 64); err != nil || filter.MaxSize < 0 {
+			return filter, fmt.Errorf("max-size must be a non-negative integer")
+		}
+	}
+	if v := query.Get("modified-after"); v != "" {
+		if filter.ModifiedAfter, err = time.Parse(time.RFC3339, v); err != nil {
+			return filter, fmt.Errorf("modified-after must be an RFC 3339 timestamp")
+		}
+	}
+	for _, tag := range query["tag"] {
+		key, value, _ := strings.Cut(tag, "=")
+		if key == "" {
+			return filter, fmt.Errorf("tag must be key or key=value")
+		}
+		if filter.Tags == nil {
+			filter.Tags = make(map[string]string)
+		}
+		filter.Tags[key] = value
+	}
+
+	if filter.Bucket == "" && filter.Key == "" && filter.ContentType == "" && filter.MinSize == 0 &&
+		filter.MaxSize < 0 && filter.ModifiedAfter.IsZero() && len(filter.Tags) == 0 {
+		return filter, fmt.Errorf("at least one of key, bucket, content-type, min-size, max-size, modified-after or tag is required")
+	}
+	return filter, nil
+}
+
+func (f SearchFilter) matches(metadata ObjectMetadata) bool {
+	if f.Key != "" && !strings.Contains(metadata.Key, f.Key) {
+		return false
+	}
+	if f.ContentType != "" {
+		mediaType, _, err := mime.ParseMediaType(metadata.ContentType)
+		if err != nil {
+			return false
+		}
+		if prefix, ok := strings.CutSuffix(f.ContentType, "*"); ok {
+			if !strings.HasPrefix(mediaType, prefix) {
+				return false
+			}
+		} else if mediaType != f.ContentType {
+			return false
+		}
+	}
+	if metadata.Size < f.MinSize || f.MaxSize >= 0 && metadata.Size > f.MaxSize {
+		return false
+	}
+	if !f.ModifiedAfter.IsZero() && !metadata.LastModified.After(f.ModifiedAfter) {
+		return false
+	}
+	for key, value := range f.Tags {
+		actual, ok := metadata.Tags[key]
+		if !ok || value != "" && actual != value {
+			return false
+		}
+	}
+	return true
+}
+
+// SearchObjects walks every bucket, or only filter.Bucket, with at most
+// concurrency buckets in flight and sends each object matching filter to
+// results. The walk stops early when ctx is cancelled. results is closed
+// once all buckets have been searched.
+func (s *StorageServer) SearchObjects(ctx context.Context, filter SearchFilter, concurrency int, results chan<- SearchResult) error {
+	defer close(results)
+
+	var buckets []Bucket
+	if filter.Bucket != "" {
+		bucket, err := s.backend.GetBucket(filter.Bucket)
+		if err != nil {
+			return err
+		}
+		buckets = []Bucket{bucket}
+	} else {
+		var err error
+		if buckets, err = s.backend.ListBuckets(); err != nil {
+			return err
+		}
+	}
+
+	bucketNames := make(chan string)
+	var wg sync.WaitGroup
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for bucketName := range bucketNames {
+				err := s.backend.WalkObjects(bucketName, func(metadata ObjectMetadata) error {
+					if !filter.matches(metadata) {
+						return nil
+					}
+
+					select {
+					case results <- SearchResult{Bucket: bucketName, ObjectMetadata: metadata}:
+						return nil
+					case <-ctx.Done():
+						return ctx.Err()
+					}
+				})
+				if err != nil && ctx.Err() == nil {
+					s.logger.Warn("search skipped bucket", "bucket", bucketName, "error", err)
+				}
+			}
+		}()
+	}
+
+	for _, bucket := range buckets {
+		select {
+		case bucketNames <- bucket.Name:
+		case <-ctx.Done():
+		}
+	}
+	close(bucketNames)
+	wg.Wait()
+
+	return ctx.Err()
+}
+
+// handleSearch streams the objects matching the query parameters as NDJSON,
+// one SearchResult per line, flushing as results arrive.
+func (s *StorageServer) handleSearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	filter, err := parseSearchFilter(query)
+	if err != nil {
+		s.writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if filter.Bucket != "" {
+		if _, err := s.backend.GetBucket(filter.Bucket); err != nil {
+			s.writeError(w, r, http.StatusNotFound, "Bucket not found")
+			return
+		}
+	}
+
+	concurrency := defaultSearchConcurrency
+	if v := query.Get("concurrency"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxSearchConcurrency {
+			s.writeError(w, r, http.StatusBadRequest, "concurrency must be between 1 and 32")
+			return
+		}
+		concurrency = n
+	}
+
+	results := make(chan SearchResult, concurrency)
+	go s.SearchObjects(r.Context(), filter, concurrency, results)
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	controller := http.NewResponseController(w)
+	encoder := json.NewEncoder(w)
+
+	for result := range results {
+		if err := encoder.Encode(result); err != nil {
+			// Client went away; drain so the walkers can exit.
+			for range results {
+			}
+			return
+		}
+		controller.Flush()
+	}
+}
@@ -0,0 +1,1065 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Input and output formats of selects.
+const (
+	selectCSV  = "csv"
+	selectJSON = "json" // a JSON array or a stream of values, such as NDJSON
+)
+
+const (
+	// maxSelectRequest caps the size of a select request body.
+	maxSelectRequest = 64 << 10
+
+	// selectErrorTrailer reports an error found after the response started,
+	// such as a malformed row.
+	selectErrorTrailer = "X-Select-Error"
+)
+
+// SelectRequest is the body of POST /objects/{bucket}/{key}?select.
+type SelectRequest struct {
+	// Expression is the SQL query, such as
+	// SELECT name, age FROM s3object s WHERE s.age > 30 LIMIT 10.
+	Expression string `json:"expression"`
+
+	Input  SelectInput  `json:"input"`
+	Output SelectOutput `json:"output"`
+}
+
+// SelectInput describes the object's data.
+type SelectInput struct {
+	// Format is csv or json (a JSON array, or one value after another as in
+	// NDJSON); empty derives it from the object's content type.
+	Format string `json:"format,omitempty"`
+
+	// Header, for csv, is whether the first row names the columns. It
+	// defaults to true; without a header the columns are _1, _2, ...
+	Header *bool `json:"header,omitempty"`
+
+	// Delimiter separates csv fields; it defaults to ",".
+	Delimiter string `json:"delimiter,omitempty"`
+
+	// Compression is gzip when the object is gzip-compressed data.
+	Compression string `json:"compression,omitempty"`
+}
+
+// SelectOutput describes the response.
+type SelectOutput struct {
+	// Format is json, one object per line, or csv; it defaults to json.
+	Format string `json:"format,omitempty"`
+}
+
+// handleSelectObject serves POST /objects/{bucket}/{key}?select, which runs
+// a SQL query over a CSV or JSON object and streams back only the matching
+// rows.
+func (s *StorageServer) handleSelectObject(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/objects/")
+	bucketName, objectKey, _ := strings.Cut(path, "/")
+
+	var req SelectRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxSelectRequest)).Decode(&req); err != nil {
+		s.writeError(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid select request: %v", err))
+		return
+	}
+	query, err := parseSelect(req.Expression)
+	if err != nil {
+		s.writeErrorCode(w, r, http.StatusBadRequest, "InvalidExpression", err.Error())
+		return
+	}
+
+	reader, metadata, ok := s.openObject(w, r, bucketName, objectKey)
+	if !ok {
+		return
+	}
+	defer reader.Close()
+
+	records, err := newSelectReader(reader, req.Input, metadata.ContentType)
+	if err != nil {
+		s.writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	writer, err := newSelectWriter(w, req.Output, query, records)
+	if err != nil {
+		s.writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	w.Header().Set("Trailer", selectErrorTrailer)
+	w.WriteHeader(http.StatusOK)
+	if err := query.run(records, writer); err != nil {
+		s.logger.Warn("select failed", "bucket", bucketName, "key", objectKey, "error", err)
+		w.Header().Set(selectErrorTrailer, err.Error())
+	}
+}
+
+// selectRecord is one row of the object.
+type selectRecord interface {
+	// lookup returns the value at a column name or JSON path.
+	lookup(path []string) any
+
+	// all returns the whole row for SELECT *.
+	all() (names []string, values []any)
+}
+
+// selectReader reads the rows of an object one at a time. next returns
+// io.EOF after the last row.
+type selectReader interface {
+	next() (selectRecord, error)
+}
+
+func newSelectReader(data io.Reader, input SelectInput, contentType string) (selectReader, error) {
+	switch input.Compression {
+	case "":
+	case "gzip":
+		gz, err := gzip.NewReader(data)
+		if err != nil {
+			return nil, fmt.Errorf("object is not gzip data: %w", err)
+		}
+		data = gz
+	default:
+		return nil, fmt.Errorf("input compression must be gzip")
+	}
+
+	format := input.Format
+	if format == "" {
+		mediaType, _, _ := mime.ParseMediaType(contentType)
+		switch {
+		case mediaType == "text/csv":
+			format = selectCSV
+		case mediaType == "application/json", mediaType == "application/x-ndjson", strings.HasSuffix(mediaType, "+json"):
+			format = selectJSON
+		default:
+			return nil, fmt.Errorf("input format must be given for objects of type %s", contentType)
+		}
+	}
+
+	switch format {
+	case selectCSV:
+		return newCSVSelectReader(data, input)
+	case selectJSON, "ndjson":
+		return newJSONSelectReader(data)
+	}
+	return nil, fmt.Errorf("input format must be csv or json")
+}
+
+type csvSelectReader struct {
+	reader *csv.Reader
+	header []string
+	index  map[string]int
+}
+
+func newCSVSelectReader(data io.Reader, input SelectInput) (*csvSelectReader, error) {
+	reader := csv.NewReader(data)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+	if input.Delimiter != "" {
+		delimiter, size := utf8.DecodeRuneInString(input.Delimiter)
+		if size != len(input.Delimiter) || delimiter == '"' || delimiter == '\n' || delimiter == '\r' {
+			return nil, fmt.Errorf("input delimiter must be a single character")
+		}
+		reader.Comma = delimiter
+	}
+
+	c := &csvSelectReader{reader: reader}
+	if input.Header == nil || *input.Header {
+		header, err := reader.Read()
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("failed to read csv header: %w", err)
+		}
+		c.header = append([]string(nil), header...)
+		c.index = make(map[string]int, len(header))
+		for i, name := range c.header {
+			c.index[strings.ToLower(name)] = i
+		}
+	}
+	return c, nil
+}
+
+func (c *csvSelectReader) next() (selectRecord, error) {
+	fields, err := c.reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	return &csvRecord{reader: c, fields: fields}, nil
+}
+
+type csvRecord struct {
+	reader *csvSelectReader
+	fields []string
+}
+
+func (r *csvRecord) lookup(path []string) any {
+	if len(path) != 1 {
+		return nil
+	}
+	i, ok := r.reader.index[strings.ToLower(path[0])]
+	if !ok {
+		n, err := strconv.Atoi(strings.TrimPrefix(path[0], "_"))
+		if !strings.HasPrefix(path[0], "_") || err != nil || n < 1 {
+			return nil
+		}
+		i = n - 1
+	}
+	if i >= len(r.fields) {
+		return nil
+	}
+	return r.fields[i]
+}
+
+func (r *csvRecord) all() ([]string, []any) {
+	names := make([]string, len(r.fields))
+	values := make([]any, len(r.fields))
+	for i, field := range r.fields {
+		if i < len(r.reader.header) {
+			names[i] = r.reader.header[i]
+		} else {
+			names[i] = "_" + strconv.Itoa(i+1)
+		}
+		values[i] = field
+	}
+	return names, values
+}
+
+type jsonSelectReader struct {
+	decoder *json.Decoder
+	array   bool
+}
+
+func newJSONSelectReader(data io.Reader) (*jsonSelectReader, error) {
+	buffered := bufio.NewReader(data)
+	j := &jsonSelectReader{decoder: json.NewDecoder(buffered)}
+	j.decoder.UseNumber()
+
+	// A top-level array is read element by element.
+	for {
+		b, err := buffered.ReadByte()
+		if err == io.EOF {
+			return j, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !unicode.IsSpace(rune(b)) {
+			buffered.UnreadByte()
+			j.array = b == '['
+			break
+		}
+	}
+	if j.array {
+		if _, err := j.decoder.Token(); err != nil {
+			return nil, err
+		}
+	}
+	return j, nil
+}
+
+func (j *jsonSelectReader) next() (selectRecord, error) {
+	if j.array && !j.decoder.More() {
+		return nil, io.EOF
+	}
+	var raw json.RawMessage
+	if err := j.decoder.Decode(&raw); err != nil {
+		if err == io.EOF && j.array {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	var value any
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return &jsonRecord{raw: raw, value: value}, nil
+}
+
+type jsonRecord struct {
+	raw   json.RawMessage
+	value any
+}
+
+func (r *jsonRecord) lookup(path []string) any {
+	value := r.value
+	for _, name := range path {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return nil
+		}
+		if value, ok = object[name]; !ok {
+			return nil
+		}
+	}
+	return value
+}
+
+func (r *jsonRecord) all() ([]string, []any) {
+	return nil, []any{r.raw}
+}
+
+// selectWriter writes the rows a select returns.
+type selectWriter interface {
+	write(names []string, values []any) error
+	flush() error
+}
+
+func newSelectWriter(w http.ResponseWriter, output SelectOutput, query *selectQuery, records selectReader) (selectWriter, error) {
+	switch output.Format {
+	case "", selectJSON, "ndjson":
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		return &jsonSelectWriter{w: bufio.NewWriter(w)}, nil
+	case selectCSV:
+		if query.columns == nil && !query.count {
+			if _, ok := records.(*csvSelectReader); !ok {
+				return nil, fmt.Errorf("csv output of json input needs a column list instead of *")
+			}
+		}
+		w.Header().Set("Content-Type", "text/csv")
+		return &csvSelectWriter{w: csv.NewWriter(w)}, nil
+	}
+	return nil, fmt.Errorf("output format must be json or csv")
+}
+
+type jsonSelectWriter struct {
+	w       *bufio.Writer
+	scratch bytes.Buffer
+}
+
+func (j *jsonSelectWriter) write(names []string, values []any) error {
+	if names == nil && len(values) == 1 {
+		// A whole JSON record, written as it was stored.
+		j.scratch.Reset()
+		if err := json.Compact(&j.scratch, values[0].(json.RawMessage)); err != nil {
+			return err
+		}
+		j.scratch.WriteByte('\n')
+		_, err := j.w.Write(j.scratch.Bytes())
+		return err
+	}
+
+	j.w.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			j.w.WriteByte(',')
+		}
+		key, _ := json.Marshal(name)
+		value, err := json.Marshal(values[i])
+		if err != nil {
+			return err
+		}
+		j.w.Write(key)
+		j.w.WriteByte(':')
+		j.w.Write(value)
+	}
+	_, err := j.w.WriteString("}\n")
+	return err
+}
+
+func (j *jsonSelectWriter) flush() error {
+	return j.w.Flush()
+}
+
+type csvSelectWriter struct {
+	w *csv.Writer
+}
+
+func (c *csvSelectWriter) write(names []string, values []any) error {
+	fields := make([]string, len(values))
+	for i, value := range values {
+		fields[i] = selectString(value)
+	}
+	return c.w.Write(fields)
+}
+
+func (c *csvSelectWriter) flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// selectQuery is a parsed SELECT statement.
+type selectQuery struct {
+	// columns is nil for SELECT *.
+	columns []selectColumn
+
+	// count is set for SELECT COUNT(*).
+	count bool
+
+	where selectExpr
+
+	// limit is -1 without a LIMIT clause.
+	limit int64
+}
+
+type selectColumn struct {
+	name string
+	expr selectExpr
+}
+
+// run streams the rows of records that match the query to out.
+func (q *selectQuery) run(records selectReader, out selectWriter) error {
+	var rows, matched int64
+	var runErr error
+	for q.count || q.limit < 0 || matched < q.limit {
+		record, err := records.next()
+		if err == io.EOF {
+			break
+		}
+		rows++
+		if err != nil {
+			runErr = fmt.Errorf("failed to read row %d: %w", rows, err)
+			break
+		}
+		if q.where != nil && q.where.eval(record) != true {
+			continue
+		}
+		matched++
+		if q.count {
+			continue
+		}
+
+		var names []string
+		var values []any
+		if q.columns == nil {
+			names, values = record.all()
+		} else {
+			names = make([]string, len(q.columns))
+			values = make([]any, len(q.columns))
+			for i, column := range q.columns {
+				names[i], values[i] = column.name, column.expr.eval(record)
+			}
+		}
+		if err := out.write(names, values); err != nil {
+			runErr = err
+			break
+		}
+	}
+
+	if q.count && runErr == nil {
+		runErr = out.write([]string{"count"}, []any{matched})
+	}
+	if err := out.flush(); err != nil && runErr == nil {
+		runErr = err
+	}
+	return runErr
+}
+
+// selectExpr is an expression of a select. eval returns nil, a string, a
+// bool, a json.Number, an int64 or a JSON value.
+type selectExpr interface {
+	eval(record selectRecord) any
+}
+
+type selectLiteral struct {
+	value any
+}
+
+func (e selectLiteral) eval(selectRecord) any { return e.value }
+
+type selectColumnRef struct {
+	path []string
+}
+
+func (e selectColumnRef) eval(record selectRecord) any { return record.lookup(e.path) }
+
+type selectCompare struct {
+	op          string
+	left, right selectExpr
+}
+
+func (e selectCompare) eval(record selectRecord) any {
+	left, right := e.left.eval(record), e.right.eval(record)
+	if left == nil || right == nil {
+		return nil
+	}
+	c := selectCompareValues(left, right)
+	switch e.op {
+	case "=":
+		return c == 0
+	case "!=", "<>":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	}
+	return nil
+}
+
+// selectCompareValues compares two values as numbers when both are
+// numeric, and as text otherwise.
+func selectCompareValues(left, right any) int {
+	if l, ok := selectNumber(left); ok {
+		if r, ok := selectNumber(right); ok {
+			switch {
+			case l < r:
+				return -1
+			case l > r:
+				return 1
+			}
+			return 0
+		}
+	}
+	return strings.Compare(selectString(left), selectString(right))
+}
+
+func selectNumber(value any) (float64, bool) {
+	switch v := value.(type) {
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case int64:
+		return float64(v), true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func selectString(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	encoded, _ := json.Marshal(value)
+	return string(encoded)
+}
+
+type selectLogical struct {
+	and         bool
+	left, right selectExpr
+}
+
+// eval follows SQL's three-valued logic, with nil as unknown.
+func (e selectLogical) eval(record selectRecord) any {
+	left := e.left.eval(record)
+	if e.and && left == false || !e.and && left == true {
+		return left
+	}
+	right := e.right.eval(record)
+	if e.and && right == false || !e.and && right == true {
+		return right
+	}
+	if left == nil || right == nil {
+		return nil
+	}
+	return e.and
+}
+
+type selectNot struct {
+	expr selectExpr
+}
+
+func (e selectNot) eval(record selectRecord) any {
+	if value, ok := e.expr.eval(record).(bool); ok {
+		return !value
+	}
+	return nil
+}
+
+type selectLike struct {
+	expr    selectExpr
+	pattern *regexp.Regexp
+	negate  bool
+}
+
+func (e selectLike) eval(record selectRecord) any {
+	value := e.expr.eval(record)
+	if value == nil {
+		return nil
+	}
+	return e.pattern.MatchString(selectString(value)) != e.negate
+}
+
+type selectIsNull struct {
+	expr   selectExpr
+	negate bool
+}
+
+func (e selectIsNull) eval(record selectRecord) any {
+	return (e.expr.eval(record) == nil) != e.negate
+}
+
+type selectIn struct {
+	expr   selectExpr
+	list   []selectExpr
+	negate bool
+}
+
+func (e selectIn) eval(record selectRecord) any {
+	value := e.expr.eval(record)
+	if value == nil {
+		return nil
+	}
+	for _, item := range e.list {
+		if other := item.eval(record); other != nil && selectCompareValues(value, other) == 0 {
+			return !e.negate
+		}
+	}
+	return e.negate
+}
+
+// selectToken is a token of a select expression. Keywords and identifiers
+// are both words; keywords are matched case-insensitively.
+type selectToken struct {
+	kind  byte // 'w' word, 'q' quoted identifier, 's' string, 'n' number, 'o' operator, 0 end
+	value string
+}
+
+func tokenizeSelect(expression string) ([]selectToken, error) {
+	var tokens []selectToken
+	for i := 0; i < len(expression); {
+		c := expression[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '\'' || c == '"':
+			var value strings.Builder
+			j := i + 1
+			for {
+				if j >= len(expression) {
+					return nil, fmt.Errorf("unterminated %c at %d", c, i)
+				}
+				if expression[j] == c {
+					if j+1 < len(expression) && expression[j+1] == c {
+						value.WriteByte(c)
+						j += 2
+						continue
+					}
+					break
+				}
+				value.WriteByte(expression[j])
+				j++
+			}
+			kind := byte('s')
+			if c == '"' {
+				kind = 'q'
+			}
+			tokens = append(tokens, selectToken{kind, value.String()})
+			i = j + 1
+		case c >= '0' && c <= '9' || c == '-' && i+1 < len(expression) && expression[i+1] >= '0' && expression[i+1] <= '9':
+			j := i + 1
+			for j < len(expression) && (expression[j] >= '0' && expression[j] <= '9' || expression[j] == '.' || expression[j] == 'e' || expression[j] == 'E') {
+				j++
+			}
+			tokens = append(tokens, selectToken{'n', expression[i:j]})
+			i = j
+		case c == '_' || c < utf8.RuneSelf && unicode.IsLetter(rune(c)):
+			j := i + 1
+			for j < len(expression) && (expression[j] == '_' || expression[j] == '.' || expression[j] < utf8.RuneSelf && (unicode.IsLetter(rune(expression[j])) || unicode.IsDigit(rune(expression[j])))) {
+				j++
+			}
+			tokens = append(tokens, selectToken{'w', expression[i:j]})
+			i = j
+		default:
+			op := string(c)
+			if i+1 < len(expression) {
+				switch two := expression[i : i+2]; two {
+				case "!=", "<>", "<=", ">=":
+					op = two
+				}
+			}
+			switch op {
+			case "=", "!=", "<>", "<", "<=", ">", ">=", "(", ")", ",", "*":
+			default:
+				return nil, fmt.Errorf("unexpected %q at %d", op, i)
+			}
+			tokens = append(tokens, selectToken{'o', op})
+			i += len(op)
+		}
+	}
+	return append(tokens, selectToken{}), nil
+}
+
+// selectParser parses the supported subset of SQL:
+//
+//	SELECT * | COUNT(*) | column [AS name], ...
+//	FROM s3object [[AS] alias]
+//	[WHERE condition]
+//	[LIMIT n]
+//
+// Conditions combine comparisons (=, !=, <>, <, <=, >, >=), LIKE, IN,
+// IS [NOT] NULL, NOT, AND, OR and parentheses. Columns are CSV header
+// names, _1, _2, ... or dotted JSON paths, optionally prefixed with the
+// alias.
+type selectParser struct {
+	tokens []selectToken
+	pos    int
+	alias  string
+}
+
+func parseSelect(expression string) (*selectQuery, error) {
+	if strings.TrimSpace(expression) == "" {
+		return nil, fmt.Errorf("an expression is required")
+	}
+	tokens, err := tokenizeSelect(expression)
+	if err != nil {
+		return nil, err
+	}
+	p := &selectParser{tokens: tokens}
+	query, err := p.parse()
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression: %w", err)
+	}
+	return query, nil
+}
+
+func (p *selectParser) peek() selectToken { return p.tokens[p.pos] }
+
+func (p *selectParser) advance() selectToken {
+	token := p.tokens[p.pos]
+	if token.kind != 0 {
+		p.pos++
+	}
+	return token
+}
+
+// unread steps back over token, which was just read.
+func (p *selectParser) unread(token selectToken) {
+	if token.kind != 0 {
+		p.pos--
+	}
+}
+
+// keyword consumes the next token if it is the given keyword.
+func (p *selectParser) keyword(word string) bool {
+	if token := p.peek(); token.kind == 'w' && strings.EqualFold(token.value, word) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// operator consumes the next token if it is the given operator.
+func (p *selectParser) operator(op string) bool {
+	if token := p.peek(); token.kind == 'o' && token.value == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *selectParser) expect(op string) error {
+	if !p.operator(op) {
+		return p.unexpected("expected " + op)
+	}
+	return nil
+}
+
+func (p *selectParser) unexpected(context string) error {
+	token := p.peek()
+	if token.kind == 0 {
+		return fmt.Errorf("%s, found end of expression", context)
+	}
+	return fmt.Errorf("%s, found %q", context, token.value)
+}
+
+// selectKeywords cannot be used as unquoted column names or aliases.
+var selectKeywords = []string{"select", "from", "where", "limit", "and", "or", "not", "like", "in", "is", "null", "as", "true", "false"}
+
+func isSelectKeyword(word string) bool {
+	for _, keyword := range selectKeywords {
+		if strings.EqualFold(word, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *selectParser) parse() (*selectQuery, error) {
+	if !p.keyword("select") {
+		return nil, p.unexpected("expected SELECT")
+	}
+	query := &selectQuery{limit: -1}
+
+	// The alias is only known after FROM, so column references are
+	// resolved once the whole statement is read.
+	var refs []*selectColumnRef
+	switch {
+	case p.operator("*"):
+	case p.keyword("count"):
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		if err := p.expect("*"); err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		query.count = true
+	default:
+		for {
+			token := p.advance()
+			if token.kind != 'w' && token.kind != 'q' || token.kind == 'w' && isSelectKeyword(token.value) {
+				p.unread(token)
+				return nil, p.unexpected("expected a column")
+			}
+			ref := &selectColumnRef{path: selectPath(token)}
+			refs = append(refs, ref)
+			column := selectColumn{expr: ref}
+			if p.keyword("as") {
+				name := p.advance()
+				if name.kind != 'w' && name.kind != 'q' {
+					p.unread(name)
+					return nil, p.unexpected("expected a name after AS")
+				}
+				column.name = name.value
+			}
+			query.columns = append(query.columns, column)
+			if !p.operator(",") {
+				break
+			}
+		}
+	}
+
+	if !p.keyword("from") {
+		return nil, p.unexpected("expected FROM")
+	}
+	if table := p.advance(); table.kind != 'w' || !strings.EqualFold(table.value, "s3object") {
+		p.unread(table)
+		return nil, p.unexpected("expected FROM s3object")
+	}
+	p.keyword("as")
+	if token := p.peek(); token.kind == 'w' && !isSelectKeyword(token.value) {
+		p.alias = token.value
+		p.pos++
+	}
+
+	if p.keyword("where") {
+		where, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		query.where = where
+	}
+	if p.keyword("limit") {
+		token := p.advance()
+		limit, err := strconv.ParseInt(token.value, 10, 64)
+		if token.kind != 'n' || err != nil || limit < 0 {
+			p.unread(token)
+			return nil, p.unexpected("expected a row count after LIMIT")
+		}
+		query.limit = limit
+	}
+	if p.peek().kind != 0 {
+		return nil, p.unexpected("expected end of expression")
+	}
+
+	for i, ref := range refs {
+		p.resolve(ref)
+		if query.columns[i].name == "" {
+			query.columns[i].name = ref.path[len(ref.path)-1]
+		}
+	}
+	return query, nil
+}
+
+// selectPath splits a column reference into its path. Quoted identifiers
+// are a single name.
+func selectPath(token selectToken) []string {
+	if token.kind == 'q' {
+		return []string{token.value}
+	}
+	return strings.Split(token.value, ".")
+}
+
+// resolve strips the table alias from a column reference.
+func (p *selectParser) resolve(ref *selectColumnRef) {
+	if len(ref.path) > 1 && (strings.EqualFold(ref.path[0], "s3object") || p.alias != "" && ref.path[0] == p.alias) {
+		ref.path = ref.path[1:]
+	}
+}
+
+func (p *selectParser) parseOr() (selectExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = selectLogical{and: false, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *selectParser) parseAnd() (selectExpr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = selectLogical{and: true, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *selectParser) parseNot() (selectExpr, error) {
+	if p.keyword("not") {
+		expr, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return selectNot{expr}, nil
+	}
+	return p.parsePredicate()
+}
+
+func (p *selectParser) parsePredicate() (selectExpr, error) {
+	if p.operator("(") {
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return expr, p.expect(")")
+	}
+
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	if token := p.peek(); token.kind == 'o' {
+		switch token.value {
+		case "=", "!=", "<>", "<", "<=", ">", ">=":
+			p.pos++
+			right, err := p.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+			return selectCompare{op: token.value, left: left, right: right}, nil
+		}
+	}
+
+	if p.keyword("is") {
+		negate := p.keyword("not")
+		if !p.keyword("null") {
+			return nil, p.unexpected("expected NULL after IS")
+		}
+		return selectIsNull{expr: left, negate: negate}, nil
+	}
+
+	negate := p.keyword("not")
+	switch {
+	case p.keyword("like"):
+		token := p.advance()
+		if token.kind != 's' {
+			p.unread(token)
+			return nil, p.unexpected("expected a quoted pattern after LIKE")
+		}
+		return selectLike{expr: left, pattern: likePattern(token.value), negate: negate}, nil
+	case p.keyword("in"):
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		in := selectIn{expr: left, negate: negate}
+		for {
+			item, err := p.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+			in.list = append(in.list, item)
+			if !p.operator(",") {
+				break
+			}
+		}
+		return in, p.expect(")")
+	case negate:
+		return nil, p.unexpected("expected LIKE or IN after NOT")
+	}
+
+	// A bare operand, such as a boolean JSON field.
+	return left, nil
+}
+
+func (p *selectParser) parseOperand() (selectExpr, error) {
+	token := p.advance()
+	switch token.kind {
+	case 's':
+		return selectLiteral{token.value}, nil
+	case 'n':
+		if _, err := strconv.ParseFloat(token.value, 64); err != nil {
+			p.unread(token)
+			return nil, p.unexpected("expected a number")
+		}
+		return selectLiteral{json.Number(token.value)}, nil
+	case 'q':
+		ref := &selectColumnRef{path: selectPath(token)}
+		return ref, nil
+	case 'w':
+		switch strings.ToLower(token.value) {
+		case "true":
+			return selectLiteral{true}, nil
+		case "false":
+			return selectLiteral{false}, nil
+		case "null":
+			return selectLiteral{nil}, nil
+		}
+		if isSelectKeyword(token.value) {
+			break
+		}
+		ref := &selectColumnRef{path: selectPath(token)}
+		p.resolve(ref)
+		return ref, nil
+	}
+	p.unread(token)
+	return nil, p.unexpected("expected a column or value")
+}
+
+// likePattern compiles a LIKE pattern, where % matches any text and _ any
+// single character.
+func likePattern(pattern string) *regexp.Regexp {
+	var expr strings.Builder
+	expr.WriteString("(?s)^")
+	for _, c := range pattern {
+		switch c {
+		case '%':
+			expr.WriteString(".*")
+		case '_':
+			expr.WriteString(".")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	expr.WriteString("$")
+	return regexp.MustCompile(expr.String())
+}
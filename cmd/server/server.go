@@ -1,66 +1,384 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"crypto/md5"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	gohash "hash"
 	"io"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 )
 
 type Bucket struct {
-	Name    string    `json:"name"`
-	Created time.Time `json:"created"`
+	Name     string    `json:"name"`
+	Created  time.Time `json:"created"`
+	Template string    `json:"template,omitempty"`
+	BucketOwner
+	Settings BucketSettings `json:"settings"`
+	Usage    *BucketUsage   `json:"usage,omitempty"`
+}
+
+// BucketSettings holds the per-bucket configuration. Bucket templates are
+// named BucketSettings values applied when a bucket is created.
+type BucketSettings struct {
+	// Location is the region the bucket belongs to. It is fixed once set.
+	Location string `json:"location,omitempty"`
+
+	Labels    map[string]string `json:"labels,omitempty"`
+	Lifecycle []LifecycleRule   `json:"lifecycle,omitempty"`
+	Quota     *BucketQuota      `json:"quota,omitempty"`
+
+	ObjectLock *ObjectLockConfig `json:"object_lock,omitempty"`
+	Trash      *TrashConfig      `json:"trash,omitempty"`
+
+	Notifications []NotificationConfig `json:"notifications,omitempty"`
+
+	Website *WebsiteConfig `json:"website,omitempty"`
+
+	// Policy sets anonymous access when authentication is enabled.
+	Policy *BucketPolicy `json:"policy,omitempty"`
+
+	// CacheControl is sent with downloads of objects uploaded without a
+	// Cache-Control of their own.
+	CacheControl string `json:"cache_control,omitempty"`
+
+	// IPAccess, when set, restricts the client addresses that may use the
+	// bucket, in addition to the server's ip_access.
+	IPAccess *IPAccessConfig `json:"ip_access,omitempty"`
+
+	// ResponseCompression, when set, overrides whether downloads from the
+	// bucket may be gzipped.
+	ResponseCompression *BucketResponseCompression `json:"response_compression,omitempty"`
+
+	// MaxObjectSize, when positive, replaces the server's max_object_size
+	// for the bucket.
+	MaxObjectSize int64 `json:"max_object_size,omitempty"`
 }
 
 type ObjectStorage struct {
 	dataDir     string
 	metadataDir string
+	trashDir    string
+
+	// multipartDir holds multipart uploads in progress. uploadLocks
+	// serializes changes to an upload's directory, per upload ID.
+	multipartDir string
+	uploadLocks  keyedMutex
+	logger       *slog.Logger
+
+	// appendLocks serializes appends to each object, which read the object
+	// and rewrite it.
+	appendLocks keyedMutex
+
+	// metadata holds bucket records and object metadata.
+	metadata MetadataStore
+
+	// roots are the directories object data is placed on. The first is
+	// dataDir itself.
+	roots []*dataRoot
+
+	// kms, when set, encrypts every new object with its own data key.
+	kms KMS
+
+	// compression, when set, compresses objects of compressible types.
+	compression *CompressionConfig
+
+	// chunks, when set, stores object data deduplicated by content hash.
+	chunks *chunkStore
+
+	// erasure, when set, stores object data erasure coded across disks.
+	erasure *erasureStore
+
+	// content indexes objects by the SHA-256 of their data.
+	content *contentIndex
+
+	// bucketMu serializes read-modify-write updates of bucket metadata and
+	// the commit of object writes and deletes, so an object's data file and
+	// metadata always change together. Readers hold it shared while pairing
+	// a data file with its metadata.
+	bucketMu sync.RWMutex
 }
 
 type ObjectMetadata struct {
-	Key          string    `json:"key"`
-	Size         int64     `json:"size"`
-	ContentType  string    `json:"content_type"`
-	ETag         string    `json:"etag"`
-	LastModified time.Time `json:"last_modified"`
+	Key          string            `json:"key"`
+	Size         int64             `json:"size"`
+	ContentType  string            `json:"content_type"`
+	ETag         string            `json:"etag"`
+	LastModified time.Time         `json:"last_modified"`
+	Tags         map[string]string `json:"tags,omitempty"`
+
+	// CacheControl and Expires were given on upload and are sent with
+	// downloads of the object.
+	CacheControl string     `json:"cache_control,omitempty"`
+	Expires      *time.Time `json:"expires,omitempty"`
+
+	// Checksums maps an algorithm (sha256, crc32c) to the hex digest of the
+	// object data.
+	Checksums map[string]string `json:"checksums,omitempty"`
+
+	// Generation increases by one every time the key is written. Concurrent
+	// writers are ordered by commit: the last to commit wins and gets the
+	// highest generation.
+	Generation int64 `json:"generation"`
+
+	// Encryption is set when the object data is encrypted at rest.
+	Encryption *ObjectEncryption `json:"encryption,omitempty"`
+
+	// Compression names the algorithm the data is compressed with on disk.
+	// StoredSize is the size on disk after compression and encryption;
+	// Size is always the size of the original data.
+	Compression string `json:"compression,omitempty"`
+	StoredSize  int64  `json:"stored_size,omitempty"`
+
+	// Chunks lists the content hashes of the object's data when it is kept
+	// in the deduplicated chunk store; the data file is then empty.
+	Chunks []string `json:"chunks,omitempty"`
+
+	// Erasure is set when the object's data is kept as erasure-coded shards;
+	// the data file is then empty.
+	Erasure *ObjectErasure `json:"erasure,omitempty"`
+
+	// RetainUntil is set for objects written to a bucket with object lock;
+	// the object cannot be overwritten or deleted before then.
+	RetainUntil *time.Time `json:"retain_until,omitempty"`
+
+	// LegalHold blocks overwrite and deletion until it is released,
+	// regardless of RetainUntil.
+	LegalHold bool `json:"legal_hold,omitempty"`
+
+	// Location is the ID of the data directory holding the data file; it
+	// is empty for data_dir.
+	Location string `json:"location,omitempty"`
+
+	// Scan is the result of the virus scan of the object, when uploads are
+	// scanned.
+	Scan *ScanStatus `json:"scan,omitempty"`
 }
 
-func NewObjectStorage(baseDir string) *ObjectStorage {
+// PutOptions carries the optional attributes of an upload.
+type PutOptions struct {
+	ContentType string
+	Tags        map[string]string
+
+	// CacheControl and Expires are stored with the object and sent with
+	// its downloads.
+	CacheControl string
+	Expires      *time.Time
+
+	// ContentMD5, when set, is the digest the uploaded bytes must match.
+	ContentMD5 []byte
+
+	// ChecksumAlgorithm selects an additional checksum to compute and store.
+	// ExpectedChecksum, when set, is the hex value the data must match.
+	ChecksumAlgorithm string
+	ExpectedChecksum  string
+
+	// RetainUntil and LegalHold lock the new object. The bucket's object
+	// lock retention applies if it ends later than RetainUntil.
+	RetainUntil *time.Time
+	LegalHold   bool
+
+	// IfMatch, IfNoneMatch and IfGeneration make the write conditional on
+	// the object it replaces: IfMatch is the ETag it must have ("*" for any),
+	// IfNoneMatch requires the key to be free, and IfGeneration is the
+	// generation it must have, 0 meaning the key must be free.
+	IfMatch      string
+	IfNoneMatch  bool
+	IfGeneration *int64
+
+	// MaxSize, when positive, rejects data longer than it with
+	// ErrEntityTooLarge.
+	MaxSize int64
+
+	// Validate, when set, checks the data before anything is stored and
+	// returns the reader to store it from. It may set Scan.
+	Validate func(bucketName, objectKey string, opts *PutOptions, data io.Reader) (io.ReadCloser, error)
+
+	// Scan is the virus scan status stored with the object.
+	Scan *ScanStatus
+}
+
+// generationHeader reports the generation of the object version served or
+// written.
+const generationHeader = "X-Object-Generation"
+
+// ErrBadDigest is returned when uploaded data does not match the digest the
+// client supplied.
+var ErrBadDigest = errors.New("uploaded data does not match Content-MD5")
+
+// ErrPreconditionFailed is returned when a conditional write finds the
+// object is not in the state the writer expected.
+var ErrPreconditionFailed = errors.New("precondition failed")
+
+func NewObjectStorage(baseDir string, logger *slog.Logger) *ObjectStorage {
+	// Absolute paths let the os package use extended-length paths on
+	// Windows, so deep keys are not limited to 260 characters.
+	if abs, err := filepath.Abs(baseDir); err == nil {
+		baseDir = abs
+	}
+
 	dataDir := filepath.Join(baseDir, "data")
 	metadataDir := filepath.Join(baseDir, "metadata")
 
 	os.MkdirAll(dataDir, 0755)
 	os.MkdirAll(metadataDir, 0755)
 
-	return &ObjectStorage{
+	storage := &ObjectStorage{
 		dataDir:     dataDir,
 		metadataDir: metadataDir,
+		trashDir:    filepath.Join(baseDir, "trash"),
+
+		multipartDir: filepath.Join(baseDir, "multipart"),
+		logger:       logger,
+		content:      newContentIndex(),
 	}
+	storage.metadata = &fileMetadataStore{dir: metadataDir, logger: logger}
+	storage.roots = []*dataRoot{{path: baseDir, dataDir: dataDir, trashDir: storage.trashDir}}
+	return storage
 }
 
-func (storage *ObjectStorage) CreateBucket(bucketName string) error {
+func (storage *ObjectStorage) CreateBucket(bucketName, template string, owner BucketOwner, settings BucketSettings) error {
+	if err := validateBucketName(bucketName); err != nil {
+		return err
+	}
+
+	storage.bucketMu.Lock()
+	defer storage.bucketMu.Unlock()
+
+	bucket := Bucket{
+		Name:        bucketName,
+		Created:     time.Now(),
+		Template:    template,
+		BucketOwner: owner,
+		Settings:    settings,
+	}
+	if existing, err := storage.GetBucket(bucketName); err == nil {
+		if bucket, err = recreateBucket(existing, template, owner, settings); err != nil {
+			return err
+		}
+	}
+
 	bucketDir := filepath.Join(storage.dataDir, bucketName)
 	if err := storage.MkdirAll(bucketDir, 0755); err != nil {
 		return fmt.Errorf("failed to create Bucket: %w", err)
 	}
 
-	bucket := Bucket{
-		Name:    bucketName,
-		Created: time.Now(),
+	return storage.saveBucketMetaData(bucket)
+}
+
+// recreateBucket returns an existing bucket as it is after being created
+// again. The settings given, such as those of a template, replace the ones
+// they set and leave the others alone; the creation time, usage, owner and
+// location are kept, and object lock can only be extended.
+func recreateBucket(existing Bucket, template string, owner BucketOwner, settings BucketSettings) (Bucket, error) {
+	if err := keepLocation(existing.Settings.Location, &settings); err != nil {
+		return Bucket{}, err
+	}
+	merged, err := mergeBucketSettings(existing.Settings, settings)
+	if err != nil {
+		return Bucket{}, err
+	}
+	if err := checkObjectLockChange(existing.Settings.ObjectLock, merged.ObjectLock); err != nil {
+		return Bucket{}, err
 	}
 
-	return storage.saveBucketMetaData(bucket)
+	keepOwner(existing.BucketOwner, &owner)
+	existing.BucketOwner = owner
+	existing.Settings = merged
+	if template != "" {
+		existing.Template = template
+	}
+	return existing, nil
+}
+
+// mergeBucketSettings overlays the settings next sets on current. A
+// setting is set when it appears in next's JSON form, so zero values never
+// clear anything.
+func mergeBucketSettings(current, next BucketSettings) (BucketSettings, error) {
+	fields := map[string]json.RawMessage{}
+	for _, settings := range []BucketSettings{current, next} {
+		data, err := json.Marshal(settings)
+		if err != nil {
+			return BucketSettings{}, err
+		}
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return BucketSettings{}, err
+		}
+	}
+
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return BucketSettings{}, err
+	}
+	var merged BucketSettings
+	err = json.Unmarshal(data, &merged)
+	return merged, err
+}
+
+// GetBucket returns the metadata of an existing bucket.
+func (storage *ObjectStorage) GetBucket(bucketName string) (Bucket, error) {
+	if _, err := storage.Stat(filepath.Join(storage.dataDir, bucketName)); err != nil {
+		return Bucket{}, fmt.Errorf("bucket not found: %w", err)
+	}
+	return storage.loadBucketMetadata(bucketName)
+}
+
+// UpdateBucketSettings applies update to the settings of an existing bucket
+// and persists the result.
+func (storage *ObjectStorage) UpdateBucketSettings(bucketName string, update func(*BucketSettings) error) (Bucket, error) {
+	storage.bucketMu.Lock()
+	defer storage.bucketMu.Unlock()
+
+	bucket, err := storage.GetBucket(bucketName)
+	if err != nil {
+		return Bucket{}, err
+	}
+
+	lock, location := bucket.Settings.ObjectLock, bucket.Settings.Location
+	if err := update(&bucket.Settings); err != nil {
+		return Bucket{}, err
+	}
+	if err := checkObjectLockChange(lock, bucket.Settings.ObjectLock); err != nil {
+		return Bucket{}, err
+	}
+	if err := keepLocation(location, &bucket.Settings); err != nil {
+		return Bucket{}, err
+	}
+
+	if err := storage.saveBucketMetaData(bucket); err != nil {
+		return Bucket{}, fmt.Errorf("failed to save bucket metadata: %w", err)
+	}
+	return bucket, nil
 }
 
-func (storage *ObjectStorage) PutObject(bucketName, objectKey string, data io.Reader, contentType string) (*ObjectMetadata, error) {
-	objectPath := filepath.Join(storage.dataDir, bucketName, objectKey)
+func (storage *ObjectStorage) PutObject(bucketName, objectKey string, data io.Reader, opts PutOptions) (*ObjectMetadata, error) {
+	if err := validateObjectKey(objectKey); err != nil {
+		return nil, err
+	}
+	validated, err := opts.validate(bucketName, objectKey, data)
+	if err != nil {
+		return nil, err
+	}
+	defer validated.Close()
+	data = validated
+
+	root := storage.placeObject()
+	objectPath := root.objectPath(bucketName, objectKey)
 	objectDir := filepath.Dir(objectPath)
 
 	if err := storage.MkdirAll(objectDir, 0755); err != nil {
@@ -73,17 +391,141 @@ func (storage *ObjectStorage) PutObject(bucketName, objectKey string, data io.Re
 	}
 	defer tempFile.Close()
 
+	// Data is compressed, then encrypted, then written. Encoders are closed
+	// in reverse order once all data has been copied.
+	stored := &countingWriter{w: tempFile}
+	var dataWriter io.Writer = stored
+	var encoders []io.Closer
+
+	var chunker *chunkWriter
+	if storage.chunks != nil {
+		chunker = newChunkWriter(storage.chunks)
+		dataWriter = chunker
+		encoders = append(encoders, chunker)
+	}
+
+	var eraser *erasureWriter
+	if storage.erasure != nil {
+		eraser, err = storage.erasure.newWriter()
+		if err != nil {
+			storage.Remove(tempFile.Name())
+			return nil, err
+		}
+		dataWriter = eraser
+		encoders = append(encoders, eraser)
+	}
+
+	var encryption *ObjectEncryption
+	if storage.kms != nil {
+		dataKey, enc, err := newDataKey(storage.kms)
+		if err != nil {
+			storage.Remove(tempFile.Name())
+			return nil, err
+		}
+		encrypter, err := newEncryptingWriter(dataWriter, dataKey)
+		if err != nil {
+			storage.Remove(tempFile.Name())
+			return nil, err
+		}
+		encryption = enc
+		dataWriter = encrypter
+		encoders = append(encoders, encrypter)
+	}
+
+	var compression string
+	if storage.compression.applies(opts.ContentType) {
+		compressor, err := storage.compression.newWriter(dataWriter)
+		if err != nil {
+			storage.Remove(tempFile.Name())
+			return nil, err
+		}
+		compression = storage.compression.Algorithm
+		dataWriter = compressor
+		encoders = append(encoders, compressor)
+	}
+
 	hash := md5.New()
-	multiWriter := io.MultiWriter(tempFile, hash)
+	writers := []io.Writer{dataWriter, hash}
 
-	size, err := io.Copy(multiWriter, data)
+	var checksum gohash.Hash
+	if opts.ChecksumAlgorithm != "" {
+		checksum, err = newChecksumHash(opts.ChecksumAlgorithm)
+		if err != nil {
+			storage.Remove(tempFile.Name())
+			return nil, err
+		}
+		writers = append(writers, checksum)
+	}
+	multiWriter := io.MultiWriter(writers...)
+
+	size, err := io.Copy(multiWriter, opts.limitSize(data))
 	if err != nil {
 		storage.Remove(tempFile.Name())
 		return nil, fmt.Errorf("failed to write object data: %w", err)
 	}
 
+	for i := len(encoders) - 1; i >= 0; i-- {
+		if err := encoders[i].Close(); err != nil {
+			storage.Remove(tempFile.Name())
+			return nil, fmt.Errorf("failed to write object data: %w", err)
+		}
+	}
+
 	tempFile.Close()
 
+	digest := hash.Sum(nil)
+	if opts.ContentMD5 != nil && !bytes.Equal(digest, opts.ContentMD5) {
+		storage.Remove(tempFile.Name())
+		return nil, fmt.Errorf("%w: got %s", ErrBadDigest, base64.StdEncoding.EncodeToString(digest))
+	}
+
+	var checksums map[string]string
+	if checksum != nil {
+		value := hex.EncodeToString(checksum.Sum(nil))
+		if opts.ExpectedChecksum != "" && value != opts.ExpectedChecksum {
+			storage.Remove(tempFile.Name())
+			return nil, fmt.Errorf("%w: %s is %s", ErrBadChecksum, opts.ChecksumAlgorithm, value)
+		}
+		checksums = map[string]string{opts.ChecksumAlgorithm: value}
+	}
+
+	storage.bucketMu.Lock()
+	defer storage.bucketMu.Unlock()
+
+	deltaObjects, deltaBytes := int64(1), size
+	generation := int64(1)
+	existing, err := storage.loadObjectMetadata(bucketName, objectKey)
+	if err == nil {
+		if err := checkRetention(existing, time.Now()); err != nil {
+			storage.Remove(tempFile.Name())
+			return nil, err
+		}
+		deltaObjects, deltaBytes = 0, size-existing.Size
+		generation = existing.Generation + 1
+	}
+	if err := opts.checkPrecondition(existing); err != nil {
+		storage.Remove(tempFile.Name())
+		return nil, err
+	}
+
+	bucket, err := storage.GetBucket(bucketName)
+	if err != nil {
+		storage.Remove(tempFile.Name())
+		return nil, err
+	}
+
+	if bucket.Settings.Quota != nil {
+		usage, err := storage.bucketUsage(&bucket)
+		if err != nil {
+			storage.Remove(tempFile.Name())
+			return nil, fmt.Errorf("failed to compute bucket usage: %w", err)
+		}
+		if err := bucket.Settings.Quota.check(usage, deltaObjects, deltaBytes); err != nil {
+			storage.Remove(tempFile.Name())
+			return nil, err
+		}
+	}
+
 	if err := storage.Rename(tempFile.Name(), objectPath); err != nil {
 		storage.Remove(tempFile.Name())
 		return nil, fmt.Errorf("failed to finalize object: %w", err)
@@ -92,51 +534,197 @@ func (storage *ObjectStorage) PutObject(bucketName, objectKey string, data io.Re
 	metadata := &ObjectMetadata{
 		Key:          objectKey,
 		Size:         size,
-		ContentType:  contentType,
-		ETag:         hex.EncodeToString(hash.Sum(nil)),
+		ContentType:  opts.ContentType,
+		ETag:         hex.EncodeToString(digest),
 		LastModified: time.Now(),
+		Tags:         opts.Tags,
+		CacheControl: opts.CacheControl,
+		Expires:      opts.Expires,
+		Checksums:    checksums,
+		Generation:   generation,
+		Encryption:   encryption,
+		Compression:  compression,
+		StoredSize:   stored.n,
+		RetainUntil:  laterTime(bucket.Settings.ObjectLock.retainUntil(time.Now()), opts.RetainUntil),
+		LegalHold:    opts.LegalHold,
+		Location:     root.id,
+		Scan:         opts.Scan,
+	}
+	if chunker != nil {
+		metadata.Chunks = chunker.hashes
+	}
+	if eraser != nil {
+		metadata.Erasure = eraser.info()
 	}
 
 	if err := storage.saveObjectMetaData(bucketName, metadata); err != nil {
 		return nil, fmt.Errorf("failed to save metadata: %w", err)
 	}
 
+	if existing != nil {
+		storage.content.remove(bucketName, existing)
+
+		// The rename only replaced the previous data if it was on the same
+		// root.
+		if existing.Location != root.id {
+			if oldPath, err := storage.objectDataPath(bucketName, objectKey, existing.Location); err == nil {
+				if err := storage.Remove(oldPath); err != nil && !storage.IsNotExist(err) {
+					storage.logger.Warn("failed to remove previous object data", "bucket", bucketName, "key", objectKey, "error", err)
+				}
+			}
+		}
+	}
+	storage.content.add(bucketName, metadata)
+
+	if storage.chunks != nil {
+		storage.chunks.addRefs(metadata.Chunks)
+		if existing != nil {
+			storage.chunks.release(existing.Chunks)
+		}
+	}
+	if storage.erasure != nil {
+		storage.erasure.addRef(metadata.Erasure)
+		if existing != nil {
+			storage.erasure.release(existing.Erasure)
+		}
+	}
+
+	if err := storage.adjustUsage(bucketName, metadata, existing); err != nil {
+		storage.logger.Warn("failed to update bucket usage", "bucket", bucketName, "error", err)
+	}
+
+	storage.logger.Debug("object stored", "bucket", bucketName, "key", objectKey, "size", size, "etag", metadata.ETag, "generation", generation)
 	return metadata, nil
 }
 
 func (storage *ObjectStorage) GetObject(bucketName, objectKey string) (io.ReadCloser, *ObjectMetadata, error) {
-	objectPath := filepath.Join(storage.dataDir, bucketName, objectKey)
+	file, metadata, err := storage.openObject(bucketName, objectKey)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	if _, err := storage.Stat(objectPath); storage.IsNotExist(err) {
-		return nil, nil, fmt.Errorf("object not found")
+	var reader io.ReadCloser = file
+	if len(metadata.Chunks) > 0 {
+		file.Close()
+		if storage.chunks == nil {
+			return nil, nil, fmt.Errorf("object is deduplicated but dedup is not enabled")
+		}
+		reader = &chunkReader{store: storage.chunks, hashes: metadata.Chunks}
 	}
 
-	file, err := storage.Open(objectPath)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to open object: %w", err)
+	if metadata.Erasure != nil {
+		file.Close()
+		if storage.erasure == nil {
+			return nil, nil, fmt.Errorf("object is erasure coded but erasure coding is not enabled")
+		}
+		reader, err = storage.erasure.open(metadata.Erasure)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if metadata.Encryption != nil {
+		reader, err = storage.decryptObject(reader, metadata.Encryption)
+		if err != nil {
+			file.Close()
+			return nil, nil, err
+		}
 	}
 
+	if metadata.Compression != "" {
+		reader, err = decompressObject(reader, metadata.Compression)
+		if err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+	}
+	return reader, metadata, nil
+}
+
+// openObject opens the stored data of an object together with the metadata
+// describing that exact version.
+func (storage *ObjectStorage) openObject(bucketName, objectKey string) (*os.File, *ObjectMetadata, error) {
+	// The open file keeps reading the data it was opened on even if a newer
+	// write replaces it, so data and metadata stay paired once both are read
+	// under the lock.
+	storage.bucketMu.RLock()
+	defer storage.bucketMu.RUnlock()
+
 	metadata, err := storage.loadObjectMetadata(bucketName, objectKey)
+	if storage.IsNotExist(err) {
+		return nil, nil, fmt.Errorf("object not found")
+	}
 	if err != nil {
-		file.Close()
 		return nil, nil, fmt.Errorf("failed to load metadata: %w", err)
 	}
 
+	objectPath, err := storage.objectDataPath(bucketName, objectKey, metadata.Location)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := storage.Stat(objectPath); storage.IsNotExist(err) {
+		return nil, nil, fmt.Errorf("object not found")
+	}
+
+	file, err := storage.Open(objectPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open object: %w", err)
+	}
+
 	return file, metadata, nil
 }
 
 func (storage *ObjectStorage) DeleteObject(bucketName, objectKey string) error {
-	objectPath := filepath.Join(storage.dataDir, bucketName, objectKey)
+	if err := validateObjectKey(objectKey); err != nil {
+		return err
+	}
+	storage.bucketMu.Lock()
+	defer storage.bucketMu.Unlock()
+
+	objectPath := storage.roots[0].objectPath(bucketName, objectKey)
+	existing, loadErr := storage.loadObjectMetadata(bucketName, objectKey)
+	trashed := false
+	if loadErr == nil {
+		var err error
+		if objectPath, err = storage.objectDataPath(bucketName, objectKey, existing.Location); err != nil {
+			return err
+		}
+		if err := checkRetention(existing, time.Now()); err != nil {
+			return err
+		}
+
+		if bucket, err := storage.GetBucket(bucketName); err == nil && bucket.Settings.Trash != nil {
+			if err := storage.moveToTrash(bucketName, existing, bucket.Settings.Trash); err != nil {
+				return err
+			}
+			trashed = true
+		}
+	}
 
-	if err := storage.Remove(objectPath); err != nil && !storage.IsNotExist(err) {
-		return fmt.Errorf("failed to delete object: %w", err)
+	if !trashed {
+		if err := storage.Remove(objectPath); err != nil && !storage.IsNotExist(err) {
+			return fmt.Errorf("failed to delete object: %w", err)
+		}
 	}
 
-	metadataPath := filepath.Join(storage.dataDir, bucketName, objectKey+".json")
-	if err := storage.Remove(metadataPath); err != nil && !storage.IsNotExist(err) {
+	if err := storage.metadata.DeleteObject(bucketName, objectKey); err != nil {
 		return fmt.Errorf("failed to delete metadata: %w", err)
 	}
 
+	if loadErr == nil {
+		if err := storage.adjustUsage(bucketName, nil, existing); err != nil {
+			storage.logger.Warn("failed to update bucket usage", "bucket", bucketName, "error", err)
+		}
+		storage.content.remove(bucketName, existing)
+		if storage.chunks != nil && !trashed {
+			storage.chunks.release(existing.Chunks)
+		}
+		if storage.erasure != nil && !trashed {
+			storage.erasure.release(existing.Erasure)
+		}
+	}
+
+	storage.logger.Debug("object deleted", "bucket", bucketName, "key", objectKey, "trashed", trashed)
 	return nil
 }
 
@@ -151,6 +739,7 @@ func (storage *ObjectStorage) ListBuckets() ([]Bucket, error) {
 		if entry.IsDir() {
 			bucket, err := storage.loadBucketMetadata(entry.Name())
 			if err != nil {
+				storage.logger.Warn("skipping unreadable bucket metadata", "bucket", entry.Name(), "error", err)
 				continue
 			}
 			buckets = append(buckets, bucket)
@@ -159,86 +748,73 @@ func (storage *ObjectStorage) ListBuckets() ([]Bucket, error) {
 	return buckets, nil
 }
 
+// ListObjects returns the objects in a bucket. Only the metadata index is
+// consulted, so temp files or stray files in the data directory never show
+// up as objects.
 func (storage *ObjectStorage) ListObjects(bucketName string) ([]ObjectMetadata, error) {
-	bucketPath := filepath.Join(storage.dataDir, bucketName)
 	var objects []ObjectMetadata
-
-	err := filepath.Walk(bucketPath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		if !info.IsDir() {
-			relPath, err := filepath.Rel(bucketPath, path)
-			if err != nil {
-				return err
-			}
-
-			metadata, err := storage.loadObjectMetadata(bucketName, relPath)
-			if err != nil {
-				return nil
-			}
-
-			objects = append(objects, *metadata)
-		}
-
+	err := storage.WalkObjects(bucketName, func(metadata ObjectMetadata) error {
+		objects = append(objects, metadata)
 		return nil
 	})
-
 	return objects, err
 }
 
-func (storage *ObjectStorage) saveBucketMetaData(bucket Bucket) error {
-	metadataPath := filepath.Join(storage.metadataDir, bucket.Name+".json")
-	os.MkdirAll(filepath.Dir(metadataPath), 0755)
+// WalkObjects calls fn for every object in a bucket, in key order, without
+// holding the whole listing in memory. Returning an error from fn stops the
+// walk and returns that error.
+func (storage *ObjectStorage) WalkObjects(bucketName string, fn func(ObjectMetadata) error) error {
+	if _, err := storage.Stat(filepath.Join(storage.dataDir, bucketName)); err != nil {
+		return fmt.Errorf("bucket not found: %w", err)
+	}
+	return storage.metadata.WalkObjects(bucketName, fn)
+}
 
-	data, err := json.MarshalIndent(bucket, "", "	")
-	if err != nil {
-		return err
+// WalkKeyRange calls fn for the objects of a bucket in keys, in key order.
+func (storage *ObjectStorage) WalkKeyRange(bucketName string, keys KeyRange, fn func(ObjectMetadata) error) error {
+	if _, err := storage.Stat(filepath.Join(storage.dataDir, bucketName)); err != nil {
+		return fmt.Errorf("bucket not found: %w", err)
 	}
+	return storage.metadata.WalkKeyRange(bucketName, keys, fn)
+}
 
-	return storage.WriteFile(metadataPath, data, 0644)
+// CleanupTempFiles removes all upload temp files left behind by uploads that
+// never reached the final rename, returning the number of files removed. It
+// is meant for shutdown, after in-flight requests have drained.
+func (storage *ObjectStorage) CleanupTempFiles() (int, error) {
+	result, err := storage.RemoveStaleTempFiles(time.Now())
+	return result.Removed, err
 }
 
-func (storage *ObjectStorage) saveObjectMetaData(bucketName string, metadata *ObjectMetadata) error {
-	metadataPath := filepath.Join(storage.metadataDir, bucketName, metadata.Key+".json")
-	os.MkdirAll(filepath.Dir(metadataPath), 0755)
+func (storage *ObjectStorage) saveBucketMetaData(bucket Bucket) error {
+	return storage.metadata.SaveBucket(bucket)
+}
 
-	data, err := json.MarshalIndent(metadata, "", "	")
-	if err != nil {
+func (storage *ObjectStorage) saveObjectMetaData(bucketName string, metadata *ObjectMetadata) error {
+	if err := validateObjectKey(metadata.Key); err != nil {
 		return err
 	}
-
-	return storage.WriteFile(metadataPath, data, 0644)
+	return storage.metadata.SaveObject(bucketName, metadata)
 }
 
 func (storage *ObjectStorage) loadObjectMetadata(bucketName string, objectKey string) (*ObjectMetadata, error) {
-	metadataPath := filepath.Join(storage.metadataDir, bucketName, objectKey+".json")
-
-	data, err := storage.ReadFile(metadataPath)
-	if err != nil {
-		return nil, err
-	}
-
-	var metadata ObjectMetadata
-	err = json.Unmarshal(data, &metadata)
-	if err != nil {
+	if err := validateObjectKey(objectKey); err != nil {
 		return nil, err
 	}
-	return &metadata, nil
+	return storage.metadata.LoadObject(bucketName, objectKey)
 }
 
+// loadBucketMetadata returns the record of a bucket, or a fresh one when
+// the bucket has none yet.
 func (storage *ObjectStorage) loadBucketMetadata(bucketName string) (Bucket, error) {
-	metadataPath := filepath.Join(storage.metadataDir, bucketName+".json")
-
-	data, err := storage.ReadFile(metadataPath)
-	if err != nil {
+	bucket, err := storage.metadata.LoadBucket(bucketName)
+	if storage.IsNotExist(err) {
 		return Bucket{Name: bucketName, Created: time.Now()}, nil
 	}
-
-	var bucket Bucket
-	err = json.Unmarshal(data, &bucket)
-	return bucket, err
+	if err != nil {
+		return Bucket{}, err
+	}
+	return *bucket, nil
 }
 
 func (storage *ObjectStorage) ReadDir(path string) ([]os.DirEntry, error) {
@@ -278,27 +854,274 @@ func (storage *ObjectStorage) ReadFile(path string) ([]byte, error) {
 }
 
 type StorageServer struct {
+	backend Backend
+
+	// storage is the filesystem backend, or nil when another backend is
+	// configured.
 	storage *ObjectStorage
+
+	config *Config
+	logger *slog.Logger
+	gc     gcState
+
+	// signingKey authenticates signed links issued by /admin/presign.
+	signingKey []byte
+
+	started time.Time
+	metrics requestMetrics
+
+	// mirror is set when the server runs as a read-only mirror.
+	mirror *mirrorState
+
+	// notifier delivers bucket events to webhooks.
+	notifier *notifier
+
+	// events is set when an event bus is configured.
+	events *eventBus
+
+	// streams sends events to clients of the bucket event stream.
+	streams *eventStreams
+
+	// replication is set when replication peers are configured.
+	replication *replication
+
+	// audit is set when an audit log is configured.
+	audit *auditLog
+
+	// oidc is set when an OIDC provider is configured.
+	oidc *oidcVerifier
+
+	// bandwidth is set when bandwidth limits are configured.
+	bandwidth *bandwidthThrottle
+
+	// validators check uploads before they are stored.
+	validators []configuredValidator
+
+	// antivirus is set when virus scanning is configured.
+	antivirus *antivirus
+
+	// images caches transformed images; it is nil when image
+	// transformations are disabled.
+	images *imageCache
+}
+
+func NewStorageServer(backend Backend, config *Config, logger *slog.Logger) *StorageServer {
+	signingKey, configured := newSigningKey(config)
+	if !configured {
+		logger.Warn("no signing_key configured; signed links will not survive a restart")
+	}
+	storage, _ := backend.(*ObjectStorage)
+	s := &StorageServer{
+		backend:    backend,
+		storage:    storage,
+		config:     config,
+		logger:     logger,
+		signingKey: signingKey,
+		started:    time.Now(),
+		notifier:   newNotifier(config),
+		streams:    newEventStreams(),
+		validators: newUploadValidators(config),
+	}
+	if config.EventBus != nil {
+		s.events = newEventBus(config.EventBus)
+	}
+	if config.Replication != nil {
+		s.replication = newReplication(config.Replication, config.DataDir)
+	}
+	if config.AuditLog != "" {
+		s.audit = &auditLog{path: config.AuditLog}
+	}
+	if config.Auth != nil && config.Auth.OIDC != nil {
+		s.oidc = newOIDCVerifier(config.Auth.OIDC)
+	}
+	if config.Bandwidth != nil {
+		s.bandwidth = newBandwidthThrottle(config.Bandwidth)
+	}
+	if config.ImageTransforms.enabled() {
+		s.images = newImageCache(config.ImageTransforms.cacheSize())
+	}
+	if config.Antivirus != nil {
+		s.antivirus = newAntivirus(config.Antivirus, backend)
+		s.validators = append(s.validators, configuredValidator{uploadValidator: s.antivirus, buckets: config.Antivirus.Buckets})
+	}
+	if config.Mirror != nil {
+		s.mirror = &mirrorState{
+			client:   &http.Client{Timeout: mirrorRequestTimeout},
+			inflight: make(map[string]chan struct{}),
+			checked:  make(map[string]time.Time),
+		}
+	}
+	return s
+}
+
+// Handler returns the HTTP handler serving the full storage API with the
+// default middleware chain.
+func (s *StorageServer) Handler() http.Handler {
+	return s.HandlerFor(ListenerConfig{Routes: routesAll})
+}
+
+func (s *StorageServer) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/buckets/", s.validatePathNames("/buckets/", s.handleBucket))
+	mux.HandleFunc("/buckets", s.handleListBuckets)
+	mux.HandleFunc("/objects/", s.validatePathNames("/objects/", func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, "/objects/")
+		query := r.URL.Query()
+		if !strings.Contains(path, "/") {
+			s.handleListObjects(w, r)
+		} else if query.Has("signature") {
+			s.handleSignedObject(w, r)
+		} else if query.Has("retention") || query.Has("legal-hold") {
+			s.requireFilesystem(s.handleObjectLock)(w, r)
+		} else if query.Has("uploads") || query.Has("upload-id") {
+			s.requireFilesystem(s.handleMultipart)(w, r)
+		} else if query.Has("rename") {
+			s.requireFilesystem(s.handleRenameObject)(w, r)
+		} else if query.Has("append") {
+			s.requireFilesystem(s.handleAppendObject)(w, r)
+		} else if r.Method == http.MethodPatch {
+			s.requireFilesystem(s.handleUpdateObjectMetadata)(w, r)
+		} else if query.Has("select") {
+			s.handleSelectObject(w, r)
+		} else if (r.Method == http.MethodGet || r.Method == http.MethodHead) && (query.Has("resize") || query.Has("format")) {
+			s.handleImageTransform(w, r)
+		} else if r.Method == http.MethodPut {
+			s.handlePutObject(w, r)
+		} else if r.Method == http.MethodDelete {
+			s.handleDeleteObject(w, r)
+		} else {
+			s.handleGetObject(w, r)
+		}
+	}))
+
+	mux.HandleFunc("/content/", s.requireFilesystem(s.handleContent))
+	mux.HandleFunc("/trash/", s.validatePathNames("/trash/", s.requireFilesystem(s.handleTrash)))
+	mux.HandleFunc("/search", s.handleSearch)
+	mux.HandleFunc("/website/", s.validatePathNames("/website/", s.handleWebsite))
+	mux.HandleFunc("/auth/token", s.handleIssueToken)
+	mux.HandleFunc("/admin/apply", s.requireFilesystem(s.handleApply))
+	mux.HandleFunc("/admin/gc", s.requireFilesystem(s.handleGC))
+	mux.HandleFunc("/admin/kms/rewrap", s.requireFilesystem(s.handleKMSRewrap))
+	mux.HandleFunc("/admin/presign", s.handlePresign)
+	mux.HandleFunc("/admin/presign/post", s.handlePresignPost)
+	mux.HandleFunc("/admin/overview", s.requireFilesystem(s.handleOverview))
+	mux.HandleFunc("/admin/stats", s.handleStats)
+	mux.HandleFunc("/admin/janitor", s.requireFilesystem(s.handleJanitor))
+	mux.HandleFunc("/admin/replication", s.handleReplicationStatus)
+	mux.HandleFunc("/admin/audit", s.handleAudit)
+
+	mux.HandleFunc("/health", s.handleLiveness)
+	mux.HandleFunc("/healthz", s.handleLiveness)
+	mux.HandleFunc("/readyz", s.handleReadiness)
+
+	return mux
+}
+
+type errorResponse struct {
+	Error     string `json:"error"`
+	Code      string `json:"code,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// writeError sends a JSON error body carrying the request ID so failures can
+// be matched against server logs.
+func (s *StorageServer) writeError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	s.writeErrorCode(w, r, status, "", message)
+}
+
+// writeErrorCode is writeError with a stable machine-readable error code.
+func (s *StorageServer) writeErrorCode(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	if status >= http.StatusInternalServerError {
+		s.logger.Error("request failed", "path", r.URL.Path, "error", message, "request_id", RequestIDFromContext(r.Context()))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorResponse{
+		Error:     message,
+		Code:      code,
+		RequestID: RequestIDFromContext(r.Context()),
+	})
 }
 
-func NewStorageServer(storage *ObjectStorage) *StorageServer {
-	return &StorageServer{storage: storage}
+// handleBucket dispatches /buckets/{name} requests to the bucket itself or
+// to one of its configuration subresources selected by query parameter.
+func (s *StorageServer) handleBucket(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	switch {
+	case strings.HasSuffix(strings.TrimPrefix(r.URL.Path, "/buckets/"), "/usage"):
+		s.handleBucketUsage(w, r)
+	case query.Has("lifecycle"):
+		s.requireFilesystem(s.handleBucketLifecycle)(w, r)
+	case query.Has("quota"):
+		s.requireFilesystem(s.handleBucketQuota)(w, r)
+	case query.Has("compare"):
+		s.requireFilesystem(s.handleBucketCompare)(w, r)
+	case query.Has("object-lock"):
+		s.requireFilesystem(s.handleBucketObjectLock)(w, r)
+	case query.Has("trash"):
+		s.requireFilesystem(s.handleBucketTrash)(w, r)
+	case query.Has("notifications"):
+		s.requireFilesystem(s.handleBucketNotifications)(w, r)
+	case query.Has("events"):
+		s.handleBucketEvents(w, r)
+	case query.Has("location"):
+		s.handleBucketLocation(w, r)
+	case query.Has("website"):
+		s.requireFilesystem(s.handleBucketWebsite)(w, r)
+	case query.Has("ip-access"):
+		s.requireFilesystem(s.handleBucketIPAccess)(w, r)
+	case query.Has("cache-control"):
+		s.requireFilesystem(s.handleBucketCacheControl)(w, r)
+	case query.Has("response-compression"):
+		s.requireFilesystem(s.handleBucketResponseCompression)(w, r)
+	case query.Has("max-object-size"):
+		s.requireFilesystem(s.handleBucketMaxObjectSize)(w, r)
+	case query.Has("policy"):
+		s.requireFilesystem(s.handleBucketPolicy)(w, r)
+	case r.Method == http.MethodHead:
+		s.handleHeadBucket(w, r)
+	default:
+		s.handleCreateBucket(w, r)
+	}
 }
 
 func (s *StorageServer) handleCreateBucket(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPut {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		s.writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	bucketName := strings.TrimPrefix(r.URL.Path, "/buckets/")
 	if bucketName == "" {
-		http.Error(w, "Bucket name required", http.StatusBadRequest)
+		s.writeError(w, r, http.StatusBadRequest, "Bucket name required")
+		return
+	}
+
+	templateName := r.URL.Query().Get("template")
+	var settings BucketSettings
+	if templateName != "" {
+		template, ok := s.config.BucketTemplates[templateName]
+		if !ok {
+			s.writeError(w, r, http.StatusBadRequest, fmt.Sprintf("Unknown bucket template: %s", templateName))
+			return
+		}
+		settings = template
+	}
+
+	location, err := requestedLocation(r)
+	if err != nil {
+		s.writeError(w, r, http.StatusBadRequest, err.Error())
 		return
 	}
+	if location != "" {
+		settings.Location = location
+	}
 
-	if err := s.storage.CreateBucket(bucketName); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := s.backend.CreateBucket(bucketName, templateName, s.requestedOwner(r), settings); err != nil {
+		s.writeStorageError(w, r, err)
 		return
 	}
 
@@ -306,32 +1129,37 @@ func (s *StorageServer) handleCreateBucket(w http.ResponseWriter, r *http.Reques
 	json.NewEncoder(w).Encode(map[string]string{"status": "bucket created"})
 }
 
-func (s *StorageServer) handleListBuckets(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPut {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	buckets, err := s.storage.ListBuckets()
+// handleHeadBucket serves HEAD /buckets/{bucket}: 200 if the bucket exists
+// and 404 if not, with its location, owner and tenant as headers.
+func (s *StorageServer) handleHeadBucket(w http.ResponseWriter, r *http.Request) {
+	bucket, err := s.backend.GetBucket(strings.TrimPrefix(r.URL.Path, "/buckets/"))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		s.writeStorageError(w, r, err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(buckets)
+	if bucket.Settings.Location != "" {
+		w.Header().Set(bucketLocationHeader, bucket.Settings.Location)
+	}
+	if bucket.Owner != "" {
+		w.Header().Set(bucketOwnerHeader, bucket.Owner)
+	}
+	if bucket.Tenant != "" {
+		w.Header().Set(bucketTenantHeader, bucket.Tenant)
+	}
+	w.WriteHeader(http.StatusOK)
 }
 
 func (s *StorageServer) handlePutObject(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPut {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		s.writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	path := strings.TrimPrefix(r.URL.Path, "/objects/")
 	parts := strings.SplitN(path, "/", 2)
 	if len(parts) < 2 {
-		http.Error(w, "Bucket and object key required", http.StatusBadRequest)
+		s.writeError(w, r, http.StatusBadRequest, "Bucket and object key required")
 		return
 	}
 
@@ -341,39 +1169,187 @@ func (s *StorageServer) handlePutObject(w http.ResponseWriter, r *http.Request)
 		contentType = "application/octet-stream"
 	}
 
-	metadata, err := s.storage.PutObject(bucketName, objectKey, r.Body, contentType)
+	tags, err := parseTags(r.Header.Get(taggingHeader))
+	if err != nil {
+		s.writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	opts := PutOptions{
+		ContentType: contentType,
+		Tags:        tags,
+		Validate:    s.validateUpload,
+	}
+
+	opts.CacheControl, opts.Expires, err = parseCacheHeaders(r)
+	if err != nil {
+		s.writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	opts.RetainUntil, opts.LegalHold, err = parseLockHeaders(r)
+	if err != nil {
+		s.writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	opts.ChecksumAlgorithm, opts.ExpectedChecksum, err = parseChecksumRequest(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		s.writeErrorCode(w, r, http.StatusBadRequest, "InvalidChecksum", err.Error())
 		return
 	}
 
+	opts.IfMatch, opts.IfNoneMatch, err = parseConditionalHeaders(r)
+	if err != nil {
+		s.writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if header := r.Header.Get("Content-MD5"); header != "" {
+		digest, err := base64.StdEncoding.DecodeString(header)
+		if err != nil || len(digest) != md5.Size {
+			s.writeErrorCode(w, r, http.StatusBadRequest, "InvalidDigest", "Content-MD5 must be a base64-encoded MD5 digest")
+			return
+		}
+		opts.ContentMD5 = digest
+	}
+
+	var metadata *ObjectMetadata
+	event := EventPut
+	if reference := r.Header.Get(contentReferenceHeader); reference != "" {
+		if s.storage == nil {
+			s.writeNotSupported(w, r)
+			return
+		}
+		event = EventCopy
+
+		hash, err := parseContentReference(reference)
+		if err != nil {
+			s.writeError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		metadata, err = s.storage.PutObjectFromContent(bucketName, objectKey, hash, s.readableBy(r), opts)
+		if err != nil {
+			s.writeStorageError(w, r, err)
+			return
+		}
+	} else {
+		opts.MaxSize = s.maxObjectSize(bucketName)
+		if !s.checkObjectSize(w, r, opts.MaxSize) {
+			return
+		}
+		if r.ContentLength > 0 && s.storage != nil {
+			if err := s.storage.CheckQuota(bucketName, objectKey, r.ContentLength); err != nil {
+				s.writeStorageError(w, r, err)
+				return
+			}
+		}
+
+		metadata, err = s.backend.PutObject(bucketName, objectKey, r.Body, opts)
+		if err != nil {
+			s.writeStorageError(w, r, err)
+			return
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("ETag", metadata.ETag)
+	w.Header().Set(generationHeader, strconv.FormatInt(metadata.Generation, 10))
+	setChecksumHeaders(w, metadata)
+	if metadata.Encryption != nil {
+		w.Header().Set(encryptionKeyHeader, metadata.Encryption.KeyID)
+	}
+	setLockHeaders(w, metadata)
+	setScanHeaders(w, metadata)
 	json.NewEncoder(w).Encode(metadata)
+
+	s.notify(event, bucketName, objectKey, metadata)
+	s.replicate(r, replicationPut, bucketName, objectKey)
+}
+
+// writeStorageError maps errors returned by ObjectStorage to HTTP responses.
+func (s *StorageServer) writeStorageError(w http.ResponseWriter, r *http.Request, err error) {
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.Is(err, ErrBadDigest):
+		s.writeErrorCode(w, r, http.StatusBadRequest, "BadDigest", err.Error())
+	case errors.Is(err, ErrBadChecksum):
+		s.writeErrorCode(w, r, http.StatusBadRequest, "BadChecksum", err.Error())
+	case errors.Is(err, ErrEntityTooLarge):
+		s.writeErrorCode(w, r, http.StatusRequestEntityTooLarge, "EntityTooLarge", err.Error())
+	case errors.As(err, &maxBytesErr):
+		s.writeErrorCode(w, r, http.StatusRequestEntityTooLarge, "EntityTooLarge", fmt.Sprintf("Request body exceeds %d bytes", maxBytesErr.Limit))
+	case errors.Is(err, ErrUploadRejected):
+		s.writeErrorCode(w, r, http.StatusUnprocessableEntity, "UploadRejected", err.Error())
+	case errors.Is(err, ErrValidatorUnavailable):
+		s.writeErrorCode(w, r, http.StatusServiceUnavailable, "ValidatorUnavailable", err.Error())
+	case errors.Is(err, ErrQuotaExceeded):
+		s.writeErrorCode(w, r, http.StatusRequestEntityTooLarge, "QuotaExceeded", err.Error())
+	case errors.Is(err, ErrContentNotFound):
+		s.writeErrorCode(w, r, http.StatusPreconditionFailed, "ContentNotFound", err.Error())
+	case errors.Is(err, ErrObjectLocked):
+		s.writeErrorCode(w, r, http.StatusForbidden, "ObjectLocked", err.Error())
+	case errors.Is(err, ErrObjectLockImmutable):
+		s.writeErrorCode(w, r, http.StatusConflict, "ObjectLockImmutable", err.Error())
+	case errors.Is(err, ErrBucketLocationImmutable):
+		s.writeErrorCode(w, r, http.StatusConflict, "BucketLocationImmutable", err.Error())
+	case errors.Is(err, ErrNoSuchUpload):
+		s.writeErrorCode(w, r, http.StatusNotFound, "NoSuchUpload", err.Error())
+	case errors.Is(err, ErrInvalidPart):
+		s.writeErrorCode(w, r, http.StatusBadRequest, "InvalidPart", err.Error())
+	case errors.Is(err, ErrObjectExists):
+		s.writeErrorCode(w, r, http.StatusConflict, "ObjectExists", err.Error())
+	case errors.Is(err, ErrPreconditionFailed):
+		s.writeErrorCode(w, r, http.StatusPreconditionFailed, "PreconditionFailed", err.Error())
+	case errors.Is(err, ErrInvalidKey):
+		s.writeErrorCode(w, r, http.StatusBadRequest, "InvalidKey", err.Error())
+	case errors.Is(err, ErrInvalidBucketName):
+		s.writeErrorCode(w, r, http.StatusBadRequest, "InvalidBucketName", err.Error())
+	case errors.Is(err, ErrAppendPosition):
+		s.writeErrorCode(w, r, http.StatusConflict, "InvalidAppendPosition", err.Error())
+	case strings.Contains(err.Error(), "not found"):
+		s.writeError(w, r, http.StatusNotFound, err.Error())
+	default:
+		s.writeError(w, r, http.StatusInternalServerError, err.Error())
+	}
+}
+
+func (s *StorageServer) handleDeleteObject(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/objects/")
+	parts := strings.SplitN(path, "/", 2)
+	if len(parts) < 2 || parts[1] == "" {
+		s.writeError(w, r, http.StatusBadRequest, "Bucket and object key required")
+		return
+	}
+
+	if err := s.backend.DeleteObject(parts[0], parts[1]); err != nil {
+		s.writeStorageError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	s.notify(EventDelete, parts[0], parts[1], nil)
+	s.replicate(r, replicationDelete, parts[0], parts[1])
 }
 
 func (s *StorageServer) handleGetObject(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		s.writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	path := strings.TrimPrefix(r.URL.Path, "/objects/")
 	parts := strings.SplitN(path, "/", 2)
 	if len(parts) < 2 {
-		http.Error(w, "Bucket and object key required", http.StatusBadRequest)
+		s.writeError(w, r, http.StatusBadRequest, "Bucket and object key required")
 		return
 	}
 
 	bucketName, objectKey := parts[0], parts[1]
 
-	reader, metadata, err := s.storage.GetObject(bucketName, objectKey)
-	if err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			http.Error(w, "Object not found", http.StatusNotFound)
-		} else {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-		}
+	reader, metadata, ok := s.openObject(w, r, bucketName, objectKey)
+	if !ok {
 		return
 	}
 	defer reader.Close()
@@ -382,13 +1358,87 @@ func (s *StorageServer) handleGetObject(w http.ResponseWriter, r *http.Request)
 	w.Header().Set("ETag", metadata.ETag)
 	w.Header().Set("Last-Modified", metadata.LastModified.Format(http.TimeFormat))
 	w.Header().Set("Content-Length", fmt.Sprintf("%d", metadata.Size))
+	if len(metadata.Tags) > 0 {
+		w.Header().Set(taggingHeader, formatTags(metadata.Tags))
+	}
+	w.Header().Set(generationHeader, strconv.FormatInt(metadata.Generation, 10))
+	setChecksumHeaders(w, metadata)
+	if metadata.Encryption != nil {
+		w.Header().Set(encryptionKeyHeader, metadata.Encryption.KeyID)
+	}
+	setLockHeaders(w, metadata)
+	setScanHeaders(w, metadata)
+	s.setCacheHeaders(w, bucketName, metadata)
+	w.Header().Set("Accept-Ranges", "bytes")
+
+	start, length, ranged, err := requestedRange(r, metadata)
+	if err != nil {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", metadata.Size))
+		w.Header().Del("Content-Length")
+		s.writeErrorCode(w, r, http.StatusRequestedRangeNotSatisfiable, "InvalidRange", "The requested range is not satisfiable")
+		return
+	}
+	if ranged {
+		s.writeRange(w, r, reader, metadata, start, length)
+		return
+	}
+	compress := s.gzipResponse(w, r, bucketName, metadata)
+
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	s.writeCompressible(w, http.StatusOK, reader, compress)
+}
+
+// openObject returns an object's data and metadata for a download, first
+// fetching it from the upstream when mirroring. It writes the error response
+// and returns false when the object cannot be read.
+func (s *StorageServer) openObject(w http.ResponseWriter, r *http.Request, bucketName, objectKey string) (io.ReadCloser, *ObjectMetadata, bool) {
+	if s.mirror != nil {
+		if err := s.syncFromUpstream(bucketName, objectKey); err != nil {
+			if strings.Contains(err.Error(), "not found") {
+				s.writeError(w, r, http.StatusNotFound, "Object not found")
+			} else {
+				s.writeError(w, r, http.StatusBadGateway, err.Error())
+			}
+			return nil, nil, false
+		}
+	}
 
-	io.Copy(w, reader)
+	reader, metadata, err := s.backend.GetObject(bucketName, objectKey)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			s.writeError(w, r, http.StatusNotFound, "Object not found")
+		} else {
+			s.writeError(w, r, http.StatusInternalServerError, err.Error())
+		}
+		return nil, nil, false
+	}
+	return reader, metadata, true
 }
 
 func (s *StorageServer) handleListObjects(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		switch {
+		case r.URL.Query().Has("etags"):
+			s.handleBulkETags(w, r)
+			return
+		case r.URL.Query().Has("stat"):
+			s.handleBulkStat(w, r)
+			return
+		case isFormUpload(r):
+			s.handleFormUpload(w, r)
+			return
+		}
+	}
+	if r.Method == http.MethodDelete {
+		s.handleDeletePrefix(w, r)
+		return
+	}
+
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		s.writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
@@ -399,47 +1449,204 @@ func (s *StorageServer) handleListObjects(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	objects, err := s.storage.ListObjects(bucketName)
+	query := r.URL.Query()
+	if query.Has("signature") {
+		if status, code, msg := s.verifyListingLink(bucketName, query); status != http.StatusOK {
+			s.writeErrorCode(w, r, status, code, msg)
+			return
+		}
+	}
+
+	if s.mirror != nil {
+		s.proxyUpstreamList(w, r)
+		return
+	}
+
+	req, err := parseListRequest(query)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		s.writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if req.delimiter != "" {
+		listing, next, err := s.listDelimited(bucketName, req)
+		if err != nil {
+			s.writeError(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if next != "" {
+			w.Header().Set(continuationTokenHeader, next)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(listing)
 		return
 	}
 
+	objects, next, err := s.listObjects(bucketName, req)
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if next != "" {
+		w.Header().Set(continuationTokenHeader, next)
+	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(objects)
 }
 
 func main() {
-	storage := NewObjectStorage("./storage")
-	server := NewStorageServer(storage)
+	config, err := LoadConfig(os.Args[1:])
+	if err != nil {
+		log.Fatal("Invalid configuration: ", err)
+	}
 
-	http.HandleFunc("/buckets/", server.handleCreateBucket)
-	http.HandleFunc("/buckets", server.handleListBuckets)
-	http.HandleFunc("/objects/", func(w http.ResponseWriter, r *http.Request) {
-		path := strings.TrimPrefix(r.URL.Path, "/objects/")
-		if !strings.Contains(path, "/") {
-			server.handleListObjects(w, r)
-		} else if r.Method == http.MethodPut {
-			server.handlePutObject(w, r)
-		} else {
-			server.handleGetObject(w, r)
+	logOutput := os.Stdout
+	if config.LogFile != "" {
+		logOutput, err = os.OpenFile(config.LogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			log.Fatal("Failed to open log file: ", err)
 		}
-	})
+		defer logOutput.Close()
+	}
 
-	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("OK"))
-	})
+	logger, err := NewLogger(logOutput, config.LogLevel, config.LogFormat)
+	if err != nil {
+		log.Fatal("Invalid configuration: ", err)
+	}
+	slog.SetDefault(logger)
 
-	log.Println("Object storage server starting on :8080")
-	log.Println("API endpoints:")
-	log.Println("  PUT /buckets/{name} - Create bucket")
-	log.Println("  GET /buckets - List buckets")
-	log.Println("  PUT /objects/{bucket}/{key} - Upload object")
-	log.Println("  GET /objects/{bucket}/{key} - Download object")
-	log.Println("  GET /objects/{bucket} - List objects in bucket")
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
 
-	if err := http.ListenAndServe(":8080", nil); err != nil {
-		log.Fatal("Server failed to start:", err)
+	// Report to the Windows service control manager before the potentially
+	// slow index load, which must not hold up the service start.
+	serviceStopped, err := startService(stop, logger)
+	if err != nil {
+		logger.Error("failed to start service", "error", err)
+		os.Exit(1)
+	}
+
+	backend, err := NewBackend(config, logger)
+	if err != nil {
+		log.Fatal("Failed to open storage backend: ", err)
 	}
+	server := NewStorageServer(backend, config, logger.With("component", "http"))
+	if server.antivirus != nil {
+		if err := server.antivirus.ensureQuarantineBucket(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	errorLog := slog.NewLogLogger(logger.With("component", "http").Handler(), slog.LevelWarn)
+
+	var httpServers []*http.Server
+	serverErr := make(chan error, len(config.ListenerConfigs()))
+
+	for _, l := range config.ListenerConfigs() {
+		listener, err := listen(l)
+		if err != nil {
+			logger.Error("server failed to start", "listener", l.String(), "error", err)
+			os.Exit(1)
+		}
+
+		tlsConfig, err := l.TLS.serverConfig()
+		if err != nil {
+			logger.Error("server failed to start", "listener", l.String(), "error", err)
+			os.Exit(1)
+		}
+
+		httpServer := &http.Server{
+			Handler:   server.HandlerFor(l),
+			ErrorLog:  errorLog,
+			TLSConfig: tlsConfig,
+		}
+		config.HTTP.apply(httpServer)
+		httpServers = append(httpServers, httpServer)
+
+		logger.Info("listening", "listener", l.String(), "routes", l.Routes, "tls", l.TLS.Enabled(), "client_certs", l.TLS.ClientCAFile != "", "protocols", httpServer.Protocols.String())
+
+		go func(l ListenerConfig) {
+			if l.TLS.Enabled() {
+				serverErr <- httpServer.ServeTLS(listener, l.TLS.CertFile, l.TLS.KeyFile)
+			} else {
+				serverErr <- httpServer.Serve(listener)
+			}
+		}(l)
+	}
+
+	logger.Info("object storage server started", "backend", config.Backend, "data_dir", config.DataDir, "data_dirs", config.DataDirs)
+	logger.Debug("API endpoints",
+		"create_bucket", "PUT /buckets/{name}",
+		"list_buckets", "GET /buckets",
+		"put_object", "PUT /objects/{bucket}/{key}",
+		"get_object", "GET /objects/{bucket}/{key}",
+		"list_objects", "GET /objects/{bucket}",
+	)
+
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+	if server.storage != nil {
+		go server.runLifecycleWorker(workerCtx, time.Duration(config.LifecycleInterval))
+		go server.runTempJanitor(workerCtx, time.Duration(config.JanitorInterval), time.Duration(config.TempFileMaxAge))
+	}
+	server.runNotifier(workerCtx)
+	if server.antivirus != nil && server.antivirus.async() {
+		server.runAntivirus(workerCtx)
+	}
+	if server.events != nil {
+		go server.runEventBus(workerCtx)
+	}
+	if server.replication != nil {
+		server.runReplication(workerCtx)
+	}
+
+	pending := len(httpServers)
+	select {
+	case err := <-serverErr:
+		logger.Error("server failed", "error", err)
+		pending--
+	case sig := <-stop:
+		logger.Info("draining in-flight requests", "signal", sig.String(), "timeout", time.Duration(config.DrainTimeout))
+	}
+
+	stopWorkers()
+	server.streams.close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(config.DrainTimeout))
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, httpServer := range httpServers {
+		wg.Add(1)
+		go func(httpServer *http.Server) {
+			defer wg.Done()
+			if err := httpServer.Shutdown(ctx); err != nil {
+				logger.Warn("drain did not complete", "error", err)
+				httpServer.Close()
+			}
+		}(httpServer)
+	}
+	wg.Wait()
+
+	for ; pending > 0; pending-- {
+		if err := <-serverErr; err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("server error", "error", err)
+		}
+	}
+
+	if server.storage != nil {
+		removed, err := server.storage.CleanupTempFiles()
+		if err != nil {
+			logger.Error("failed to clean up temp files", "error", err)
+		} else if removed > 0 {
+			logger.Info("removed incomplete upload temp files", "count", removed)
+		}
+		if err := server.storage.metadata.Close(); err != nil {
+			logger.Error("failed to close metadata store", "error", err)
+		}
+	}
+
+	logger.Info("server stopped")
+	serviceStopped()
 }
@@ -0,0 +1,14 @@
+//go:build !windows
+
+package main
+
+import (
+	"log/slog"
+	"os"
+)
+
+// startService is a no-op outside Windows; the server is stopped with
+// SIGINT or SIGTERM.
+func startService(stop chan<- os.Signal, logger *slog.Logger) (stopped func(), err error) {
+	return func() {}, nil
+}
@@ -0,0 +1,165 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+const serviceName = "storage-server"
+
+const (
+	serviceWin32OwnProcess = 0x10
+
+	serviceStopped      = 1
+	serviceStopPending  = 3
+	serviceRunning      = 4
+	serviceAcceptStop   = 0x1
+	serviceAcceptShtdwn = 0x4
+
+	serviceControlStop        = 1
+	serviceControlInterrogate = 4
+	serviceControlShutdown    = 5
+
+	errorCallNotImplemented             = 120
+	errorFailedServiceControllerConnect = 1063
+)
+
+var (
+	advapi32                     = syscall.NewLazyDLL("advapi32.dll")
+	startServiceCtrlDispatcher   = advapi32.NewProc("StartServiceCtrlDispatcherW")
+	registerServiceCtrlHandlerEx = advapi32.NewProc("RegisterServiceCtrlHandlerExW")
+	setServiceStatus             = advapi32.NewProc("SetServiceStatus")
+)
+
+type serviceTableEntry struct {
+	name *uint16
+	proc uintptr
+}
+
+type serviceStatus struct {
+	serviceType             uint32
+	currentState            uint32
+	controlsAccepted        uint32
+	win32ExitCode           uint32
+	serviceSpecificExitCode uint32
+	checkPoint              uint32
+	waitHint                uint32
+}
+
+// windowsService connects the process to the service control manager. Stop
+// and shutdown requests are delivered to main as os.Interrupt.
+type windowsService struct {
+	stop    chan<- os.Signal
+	logger  *slog.Logger
+	handle  uintptr
+	started chan bool
+	done    chan struct{}
+}
+
+// startService reports the server to the Windows service control manager
+// when it was started as a service. It returns once the service is running,
+// or immediately when the process runs from a console. stopped must be
+// called after the server has shut down.
+func startService(stop chan<- os.Signal, logger *slog.Logger) (stopped func(), err error) {
+	svc := &windowsService{
+		stop:    stop,
+		logger:  logger,
+		started: make(chan bool, 1),
+		done:    make(chan struct{}),
+	}
+
+	name, err := syscall.UTF16PtrFromString(serviceName)
+	if err != nil {
+		return nil, err
+	}
+	table := []serviceTableEntry{
+		{name: name, proc: syscall.NewCallback(svc.serviceMain)},
+		{},
+	}
+
+	dispatched := make(chan error, 1)
+	go func() {
+		// The dispatcher blocks this thread until the service stops.
+		runtime.LockOSThread()
+		ret, _, callErr := startServiceCtrlDispatcher.Call(uintptr(unsafe.Pointer(&table[0])))
+		if ret == 0 {
+			dispatched <- callErr
+		} else {
+			dispatched <- nil
+		}
+		svc.started <- false
+	}()
+
+	if running := <-svc.started; !running {
+		err := <-dispatched
+		var errno syscall.Errno
+		if errors.As(err, &errno) && errno == errorFailedServiceControllerConnect {
+			// Not started by the service control manager.
+			return func() {}, nil
+		}
+		if err == nil {
+			err = errors.New("service did not start")
+		}
+		return nil, err
+	}
+
+	logger.Info("running as Windows service", "service", serviceName)
+	return func() {
+		close(svc.done)
+		<-dispatched
+	}, nil
+}
+
+func (svc *windowsService) setStatus(state, accepts uint32) {
+	status := serviceStatus{
+		serviceType:      serviceWin32OwnProcess,
+		currentState:     state,
+		controlsAccepted: accepts,
+	}
+	setServiceStatus.Call(svc.handle, uintptr(unsafe.Pointer(&status)))
+}
+
+// serviceMain runs on a thread started by the service control manager and
+// returns once the server has stopped.
+func (svc *windowsService) serviceMain(argc uint32, argv **uint16) uintptr {
+	name, _ := syscall.UTF16PtrFromString(serviceName)
+	handle, _, err := registerServiceCtrlHandlerEx.Call(
+		uintptr(unsafe.Pointer(name)),
+		syscall.NewCallback(svc.handleControl),
+		0,
+	)
+	if handle == 0 {
+		svc.logger.Error("failed to register service control handler", "error", err)
+		svc.started <- false
+		return 0
+	}
+	svc.handle = handle
+
+	svc.setStatus(serviceRunning, serviceAcceptStop|serviceAcceptShtdwn)
+	svc.started <- true
+
+	<-svc.done
+	svc.setStatus(serviceStopped, 0)
+	return 0
+}
+
+func (svc *windowsService) handleControl(control, eventType uint32, eventData, context uintptr) uintptr {
+	switch control {
+	case serviceControlStop, serviceControlShutdown:
+		svc.setStatus(serviceStopPending, 0)
+		select {
+		case svc.stop <- os.Interrupt:
+		default:
+		}
+		return 0
+	case serviceControlInterrogate:
+		return 0
+	}
+	return errorCallNotImplemented
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"time"
+)
+
+// Stats is the capacity and traffic report served by /admin/stats.
+type Stats struct {
+	GeneratedAt time.Time `json:"generated_at"`
+	StartedAt   time.Time `json:"started_at"`
+	Uptime      string    `json:"uptime"`
+	Buckets     int       `json:"buckets"`
+	Objects     int64     `json:"objects"`
+	Bytes       int64     `json:"bytes"`
+
+	// Disk and DataDirs are only reported by the filesystem backend.
+	Disk     *DiskStats     `json:"disk,omitempty"`
+	DataDirs []DataDirStats `json:"data_dirs,omitempty"`
+
+	Requests  RequestCounters `json:"requests"`
+	PerBucket []BucketStats   `json:"per_bucket"`
+}
+
+// RequestCounters counts the requests served since the server started.
+type RequestCounters struct {
+	Total        int64            `json:"total"`
+	ClientErrors int64            `json:"client_errors"`
+	ServerErrors int64            `json:"server_errors"`
+	ByClass      map[string]int64 `json:"by_class"`
+	LastMinute   RequestStats     `json:"last_minute"`
+}
+
+type BucketStats struct {
+	Name     string `json:"name"`
+	Location string `json:"location,omitempty"`
+	Objects  int64  `json:"objects"`
+	Bytes    int64  `json:"bytes"`
+}
+
+func (m *requestMetrics) counters(now time.Time) RequestCounters {
+	lastMinute := m.lastMinute(now)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	byClass := maps.Clone(m.byClass)
+	if byClass == nil {
+		byClass = map[string]int64{}
+	}
+	return RequestCounters{
+		Total:        m.total.requests,
+		ClientErrors: m.total.clientErrors,
+		ServerErrors: m.total.serverErrors,
+		ByClass:      byClass,
+		LastMinute:   lastMinute,
+	}
+}
+
+// BucketStats returns the tracked usage of every bucket.
+func (storage *ObjectStorage) BucketStats() ([]BucketStats, error) {
+	storage.bucketMu.RLock()
+	defer storage.bucketMu.RUnlock()
+
+	buckets, err := storage.ListBuckets()
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make([]BucketStats, 0, len(buckets))
+	for _, bucket := range buckets {
+		usage, err := storage.bucketUsage(&bucket)
+		if err != nil {
+			return nil, err
+		}
+		stats = append(stats, BucketStats{Name: bucket.Name, Location: bucket.Settings.Location, Objects: usage.Objects, Bytes: usage.Bytes})
+	}
+	return stats, nil
+}
+
+// bucketStats uses the filesystem backend's usage tracking and walks the
+// buckets of other backends.
+func (s *StorageServer) bucketStats() ([]BucketStats, error) {
+	if s.storage != nil {
+		return s.storage.BucketStats()
+	}
+
+	buckets, err := s.backend.ListBuckets()
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make([]BucketStats, 0, len(buckets))
+	for _, bucket := range buckets {
+		stat := BucketStats{Name: bucket.Name, Location: bucket.Settings.Location}
+		err := s.backend.WalkObjects(bucket.Name, func(metadata ObjectMetadata) error {
+			stat.Objects++
+			stat.Bytes += metadata.Size
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		stats = append(stats, stat)
+	}
+	return stats, nil
+}
+
+// handleStats serves GET /admin/stats for dashboards and capacity planning.
+func (s *StorageServer) handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	now := time.Now()
+	perBucket, err := s.bucketStats()
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	stats := Stats{
+		GeneratedAt: now,
+		StartedAt:   s.started,
+		Uptime:      now.Sub(s.started).Truncate(time.Second).String(),
+		Buckets:     len(perBucket),
+		Requests:    s.metrics.counters(now),
+		PerBucket:   perBucket,
+	}
+	for _, bucket := range perBucket {
+		stats.Objects += bucket.Objects
+		stats.Bytes += bucket.Bytes
+	}
+
+	if s.storage != nil {
+		if disk, err := diskStats(s.storage.dataDir); err == nil {
+			stats.Disk = &disk
+		}
+		if len(s.storage.roots) > 1 {
+			stats.DataDirs = s.storage.DataDirStats()
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
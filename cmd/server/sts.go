@@ -0,0 +1,210 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// scopedTokenPrefix starts every scoped token, which tells them apart from
+// configured tokens.
+const scopedTokenPrefix = "sts."
+
+const (
+	defaultScopedTokenExpiry = time.Hour
+	maxScopedTokenExpiry     = 12 * time.Hour
+)
+
+// Actions a scoped token can grant.
+const (
+	scopeRead   = "read"   // download and HEAD objects
+	scopeList   = "list"   // list objects under the prefix
+	scopeWrite  = "write"  // upload, append and multipart uploads
+	scopeDelete = "delete" // delete single objects
+)
+
+var scopeActions = []string{scopeRead, scopeList, scopeWrite, scopeDelete}
+
+// TokenScope is what a scoped token may do. It is signed into the token
+// itself, so the server keeps no state and tokens cannot be revoked before
+// they expire.
+type TokenScope struct {
+	Bucket  string    `json:"bucket"`
+	Prefix  string    `json:"prefix,omitempty"`
+	Actions []string  `json:"actions"`
+	Expires time.Time `json:"expires"`
+
+	// Subject is the principal that issued the token.
+	Subject string `json:"subject"`
+}
+
+// TokenRequest is the body of POST /auth/token.
+type TokenRequest struct {
+	Bucket    string   `json:"bucket"`
+	Prefix    string   `json:"prefix"`
+	Actions   []string `json:"actions"`
+	ExpiresIn Duration `json:"expires_in"`
+}
+
+// TokenResponse carries a scoped token and its scope.
+type TokenResponse struct {
+	Token string     `json:"token"`
+	Scope TokenScope `json:"scope"`
+}
+
+// scopedTokenSignature signs an encoded scope. The prefix keeps it from
+// ever matching a listing or form policy signature.
+func (s *StorageServer) scopedTokenSignature(encodedScope string) string {
+	mac := hmac.New(sha256.New, s.signingKey)
+	fmt.Fprintf(mac, "STS\n%s", encodedScope)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// handleIssueToken serves POST /auth/token, which exchanges the caller's
+// token or OIDC identity for a short-lived token limited to one bucket, an optional key
+// prefix and a set of actions.
+func (s *StorageServer) handleIssueToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if s.config.Auth == nil {
+		s.writeErrorCode(w, r, http.StatusNotImplemented, "NotImplemented", "Authentication is not enabled")
+		return
+	}
+	if s.scopedToken(r) != nil {
+		s.writeErrorCode(w, r, http.StatusForbidden, "AccessDenied", "Scoped tokens cannot issue tokens")
+		return
+	}
+
+	var req TokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeError(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid request body: %v", err))
+		return
+	}
+
+	if req.Bucket == "" || strings.Contains(req.Bucket, "/") {
+		s.writeError(w, r, http.StatusBadRequest, "A bucket name is required")
+		return
+	}
+	if len(req.Actions) == 0 {
+		s.writeError(w, r, http.StatusBadRequest, fmt.Sprintf("actions must list some of %s", strings.Join(scopeActions, ", ")))
+		return
+	}
+	for _, action := range req.Actions {
+		if !slices.Contains(scopeActions, action) {
+			s.writeError(w, r, http.StatusBadRequest, fmt.Sprintf("Unknown action %q; use %s", action, strings.Join(scopeActions, ", ")))
+			return
+		}
+	}
+
+	expiresIn := time.Duration(req.ExpiresIn)
+	if expiresIn <= 0 {
+		expiresIn = defaultScopedTokenExpiry
+	}
+	if expiresIn > maxScopedTokenExpiry {
+		s.writeError(w, r, http.StatusBadRequest, fmt.Sprintf("expires_in must not exceed %s", maxScopedTokenExpiry))
+		return
+	}
+
+	if _, err := s.backend.GetBucket(req.Bucket); err != nil {
+		s.writeStorageError(w, r, err)
+		return
+	}
+
+	scope := TokenScope{
+		Bucket:  req.Bucket,
+		Prefix:  req.Prefix,
+		Actions: req.Actions,
+		Expires: time.Now().Add(expiresIn).Truncate(time.Second).UTC(),
+		Subject: s.principal(r),
+	}
+	data, _ := json.Marshal(scope)
+	encoded := base64.RawURLEncoding.EncodeToString(data)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(TokenResponse{
+		Token: scopedTokenPrefix + encoded + "." + s.scopedTokenSignature(encoded),
+		Scope: scope,
+	})
+}
+
+// scopedToken returns the verified, unexpired scope of the scoped token r
+// carries, or nil.
+func (s *StorageServer) scopedToken(r *http.Request) *TokenScope {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "+scopedTokenPrefix)
+	if !ok {
+		return nil
+	}
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(s.scopedTokenSignature(encoded)), []byte(signature)) {
+		return nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil
+	}
+	var scope TokenScope
+	if err := json.Unmarshal(data, &scope); err != nil || time.Now().After(scope.Expires) {
+		return nil
+	}
+	return &scope
+}
+
+// allows reports whether the scope covers a request. Only single-object
+// requests and listings of the token's bucket are covered; renames, object
+// lock, batch lookups, prefix deletes, bucket settings and every other
+// endpoint need a full token.
+func (scope *TokenScope) allows(r *http.Request) bool {
+	path, ok := strings.CutPrefix(r.URL.Path, "/objects/")
+	if !ok {
+		return false
+	}
+	bucketName, objectKey, hasKey := strings.Cut(path, "/")
+	if bucketName != scope.Bucket {
+		return false
+	}
+	query := r.URL.Query()
+
+	if !hasKey {
+		return r.Method == http.MethodGet && scope.can(scopeList) && strings.HasPrefix(query.Get("prefix"), scope.Prefix)
+	}
+	if objectKey == "" || !strings.HasPrefix(objectKey, scope.Prefix) {
+		return false
+	}
+	if query.Has("rename") || query.Has("retention") || query.Has("legal-hold") {
+		return false
+	}
+
+	if readOnlyPost(r) {
+		return scope.can(scopeRead)
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		if query.Has("upload-id") {
+			return scope.can(scopeWrite)
+		}
+		return scope.can(scopeRead)
+	case http.MethodPut, http.MethodPost, http.MethodPatch:
+		return scope.can(scopeWrite)
+	case http.MethodDelete:
+		if query.Has("upload-id") {
+			return scope.can(scopeWrite)
+		}
+		return scope.can(scopeDelete)
+	}
+	return false
+}
+
+func (scope *TokenScope) can(action string) bool {
+	return slices.Contains(scope.Actions, action)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// taggingHeader carries object tags on upload and download, encoded as a URL
+// query string ("team=ops&env=prod").
+const taggingHeader = "X-Object-Tagging"
+
+const maxObjectTags = 10
+
+func parseTags(header string) (map[string]string, error) {
+	if header == "" {
+		return nil, nil
+	}
+
+	values, err := url.ParseQuery(header)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s header: %w", taggingHeader, err)
+	}
+
+	if len(values) > maxObjectTags {
+		return nil, fmt.Errorf("at most %d tags are allowed per object", maxObjectTags)
+	}
+
+	tags := make(map[string]string, len(values))
+	for key, vals := range values {
+		if key == "" {
+			return nil, fmt.Errorf("invalid %s header: empty tag key", taggingHeader)
+		}
+		tags[key] = vals[len(vals)-1]
+	}
+	return tags, nil
+}
+
+func formatTags(tags map[string]string) string {
+	values := url.Values{}
+	for key, value := range tags {
+		values.Set(key, value)
+	}
+	return values.Encode()
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"storage-system/internal/ids"
+)
+
+// TrashConfig turns deletes in a bucket into soft deletes: deleted objects
+// are moved to the bucket's trash and can be restored until they are purged
+// RetentionDays later.
+type TrashConfig struct {
+	RetentionDays int `json:"retention_days"`
+}
+
+func (trash *TrashConfig) validate() error {
+	if trash == nil {
+		return nil
+	}
+	if trash.RetentionDays <= 0 {
+		return fmt.Errorf("trash retention_days must be positive")
+	}
+	return nil
+}
+
+// TrashEntry is a deleted object kept in a bucket's trash. Object is the
+// metadata the object had when it was deleted.
+type TrashEntry struct {
+	ID        string         `json:"id"`
+	DeletedAt time.Time      `json:"deleted_at"`
+	ExpiresAt time.Time      `json:"expires_at"`
+	Object    ObjectMetadata `json:"object"`
+}
+
+// trashPath returns the path of a trash entry in the primary trash
+// directory; its entry file is this path with a .json suffix. The data sits
+// next to it unless the object was on another data directory.
+func (storage *ObjectStorage) trashPath(bucketName, id string) string {
+	return filepath.Join(storage.trashDir, bucketName, id)
+}
+
+// moveToTrash moves the data of a deleted object into the bucket's trash and
+// records its metadata there. The caller must hold bucketMu and removes the
+// object's metadata afterwards.
+func (storage *ObjectStorage) moveToTrash(bucketName string, metadata *ObjectMetadata, trash *TrashConfig) error {
+	now := time.Now()
+	entry := TrashEntry{
+		ID:        ids.New(),
+		DeletedAt: now,
+		ExpiresAt: now.AddDate(0, 0, trash.RetentionDays),
+		Object:    *metadata,
+	}
+
+	entryPath := storage.trashPath(bucketName, entry.ID) + ".json"
+	path, err := storage.trashDataPath(bucketName, entry.ID, metadata.Location)
+	if err != nil {
+		return err
+	}
+	for _, dir := range []string{filepath.Dir(entryPath), filepath.Dir(path)} {
+		if err := storage.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create trash directory: %w", err)
+		}
+	}
+
+	objectPath, err := storage.objectDataPath(bucketName, metadata.Key, metadata.Location)
+	if err != nil {
+		return err
+	}
+	if err := storage.Rename(objectPath, path); err != nil {
+		return fmt.Errorf("failed to move object to trash: %w", err)
+	}
+
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err == nil {
+		err = storage.WriteFile(entryPath, data, 0644)
+	}
+	if err != nil {
+		storage.Rename(path, objectPath)
+		return fmt.Errorf("failed to save trash entry: %w", err)
+	}
+	return nil
+}
+
+func (storage *ObjectStorage) loadTrashEntry(bucketName, id string) (*TrashEntry, error) {
+	if id == "" || strings.ContainsAny(id, `/\.`) {
+		return nil, fmt.Errorf("trash entry not found")
+	}
+
+	data, err := storage.ReadFile(storage.trashPath(bucketName, id) + ".json")
+	if err != nil {
+		return nil, fmt.Errorf("trash entry not found")
+	}
+
+	var entry TrashEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, fmt.Errorf("failed to parse trash entry: %w", err)
+	}
+	return &entry, nil
+}
+
+// ListTrash returns the trash entries of a bucket, oldest first.
+func (storage *ObjectStorage) ListTrash(bucketName string) ([]TrashEntry, error) {
+	if _, err := storage.GetBucket(bucketName); err != nil {
+		return nil, err
+	}
+
+	dirEntries, err := storage.ReadDir(filepath.Join(storage.trashDir, bucketName))
+	if storage.IsNotExist(err) {
+		return []TrashEntry{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read trash: %w", err)
+	}
+
+	entries := []TrashEntry{}
+	for _, dirEntry := range dirEntries {
+		id, ok := strings.CutSuffix(dirEntry.Name(), ".json")
+		if !ok {
+			continue
+		}
+		entry, err := storage.loadTrashEntry(bucketName, id)
+		if err != nil {
+			storage.logger.Warn("skipping unreadable trash entry", "bucket", bucketName, "id", id, "error", err)
+			continue
+		}
+		entries = append(entries, *entry)
+	}
+
+	slices.SortFunc(entries, func(a, b TrashEntry) int {
+		return strings.Compare(a.ID, b.ID)
+	})
+	return entries, nil
+}
+
+// RestoreObject moves a trash entry back to its original key. It fails if
+// the key has been written since the delete.
+func (storage *ObjectStorage) RestoreObject(bucketName, id string) (*ObjectMetadata, error) {
+	storage.bucketMu.Lock()
+	defer storage.bucketMu.Unlock()
+
+	entry, err := storage.loadTrashEntry(bucketName, id)
+	if err != nil {
+		return nil, err
+	}
+	metadata := &entry.Object
+
+	if _, err := storage.loadObjectMetadata(bucketName, metadata.Key); err == nil {
+		return nil, fmt.Errorf("%w: %s", ErrObjectExists, metadata.Key)
+	}
+
+	bucket, err := storage.GetBucket(bucketName)
+	if err != nil {
+		return nil, err
+	}
+	if bucket.Settings.Quota != nil {
+		usage, err := storage.bucketUsage(&bucket)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compute bucket usage: %w", err)
+		}
+		if err := bucket.Settings.Quota.check(usage, 1, metadata.Size); err != nil {
+			return nil, err
+		}
+	}
+
+	path, err := storage.trashDataPath(bucketName, id, metadata.Location)
+	if err != nil {
+		return nil, err
+	}
+	objectPath, err := storage.objectDataPath(bucketName, metadata.Key, metadata.Location)
+	if err != nil {
+		return nil, err
+	}
+	if err := storage.MkdirAll(filepath.Dir(objectPath), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create object directory: %w", err)
+	}
+	if err := storage.Rename(path, objectPath); err != nil {
+		return nil, fmt.Errorf("failed to restore object: %w", err)
+	}
+
+	if err := storage.saveObjectMetaData(bucketName, metadata); err != nil {
+		storage.Rename(objectPath, path)
+		return nil, fmt.Errorf("failed to save metadata: %w", err)
+	}
+	if err := storage.Remove(storage.trashPath(bucketName, id) + ".json"); err != nil && !storage.IsNotExist(err) {
+		storage.logger.Warn("failed to remove trash entry", "bucket", bucketName, "id", id, "error", err)
+	}
+
+	if err := storage.adjustUsage(bucketName, metadata, nil); err != nil {
+		storage.logger.Warn("failed to update bucket usage", "bucket", bucketName, "error", err)
+	}
+	storage.content.add(bucketName, metadata)
+
+	storage.logger.Debug("object restored from trash", "bucket", bucketName, "key", metadata.Key, "id", id)
+	return metadata, nil
+}
+
+// PurgeTrashEntry permanently deletes a trash entry.
+func (storage *ObjectStorage) PurgeTrashEntry(bucketName, id string) error {
+	storage.bucketMu.Lock()
+	defer storage.bucketMu.Unlock()
+
+	entry, err := storage.loadTrashEntry(bucketName, id)
+	if err != nil {
+		return err
+	}
+
+	path, err := storage.trashDataPath(bucketName, id, entry.Object.Location)
+	if err != nil {
+		return err
+	}
+	if err := storage.Remove(path); err != nil && !storage.IsNotExist(err) {
+		return fmt.Errorf("failed to delete trashed object: %w", err)
+	}
+	if err := storage.Remove(storage.trashPath(bucketName, id) + ".json"); err != nil && !storage.IsNotExist(err) {
+		return fmt.Errorf("failed to delete trash entry: %w", err)
+	}
+
+	// Chunks and shards stay referenced while an object is in the trash.
+	if storage.chunks != nil {
+		storage.chunks.release(entry.Object.Chunks)
+	}
+	if storage.erasure != nil {
+		storage.erasure.release(entry.Object.Erasure)
+	}
+	return nil
+}
+
+// PurgeExpiredTrash permanently deletes every trash entry past its
+// retention and returns the number purged.
+func (storage *ObjectStorage) PurgeExpiredTrash(ctx context.Context, now time.Time) (int, error) {
+	dirEntries, err := storage.ReadDir(storage.trashDir)
+	if storage.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read trash: %w", err)
+	}
+
+	purged := 0
+	for _, dirEntry := range dirEntries {
+		bucketName := dirEntry.Name()
+		entries, err := storage.ListTrash(bucketName)
+		if err != nil {
+			continue
+		}
+
+		for _, entry := range entries {
+			if err := ctx.Err(); err != nil {
+				return purged, err
+			}
+			if now.Before(entry.ExpiresAt) {
+				continue
+			}
+			if err := storage.PurgeTrashEntry(bucketName, entry.ID); err != nil {
+				storage.logger.Error("failed to purge trash entry", "bucket", bucketName, "id", entry.ID, "error", err)
+				continue
+			}
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// handleTrash serves the trash API:
+//
+//	GET    /trash/{bucket}               list deleted objects
+//	POST   /trash/{bucket}/{id}?restore  restore an entry to its key
+//	DELETE /trash/{bucket}/{id}          purge an entry permanently
+func (s *StorageServer) handleTrash(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/trash/")
+	bucketName, id, _ := strings.Cut(path, "/")
+	if bucketName == "" {
+		s.writeError(w, r, http.StatusBadRequest, "Bucket name required")
+		return
+	}
+
+	switch {
+	case id == "" && r.Method == http.MethodGet:
+		entries, err := s.storage.ListTrash(bucketName)
+		if err != nil {
+			s.writeStorageError(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entries)
+
+	case id != "" && r.Method == http.MethodPost && r.URL.Query().Has("restore"):
+		metadata, err := s.storage.RestoreObject(bucketName, id)
+		if err != nil {
+			s.writeStorageError(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(metadata)
+		s.replicate(r, replicationPut, bucketName, metadata.Key)
+
+	case id != "" && r.Method == http.MethodDelete:
+		if err := s.storage.PurgeTrashEntry(bucketName, id); err != nil {
+			s.writeStorageError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		s.writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// handleBucketTrash serves GET, PUT and DELETE on /buckets/{name}?trash.
+// Disabling the trash keeps existing entries until they expire.
+func (s *StorageServer) handleBucketTrash(w http.ResponseWriter, r *http.Request) {
+	bucketName := strings.TrimPrefix(r.URL.Path, "/buckets/")
+
+	var trash *TrashConfig
+	switch r.Method {
+	case http.MethodGet, http.MethodDelete:
+	case http.MethodPut:
+		trash = &TrashConfig{}
+		if err := json.NewDecoder(r.Body).Decode(trash); err != nil {
+			s.writeError(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid trash configuration: %v", err))
+			return
+		}
+		if err := trash.validate(); err != nil {
+			s.writeError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+	default:
+		s.writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var bucket Bucket
+	var err error
+	if r.Method == http.MethodGet {
+		bucket, err = s.storage.GetBucket(bucketName)
+	} else {
+		bucket, err = s.storage.UpdateBucketSettings(bucketName, func(settings *BucketSettings) error {
+			settings.Trash = trash
+			return nil
+		})
+	}
+	if err != nil {
+		s.writeStorageError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]*TrashConfig{"trash": bucket.Settings.Trash})
+}
+
+// trashedObjects returns the objects in the trash, whose chunks and shards
+// must survive garbage collection until the entries are purged.
+func (storage *ObjectStorage) trashedObjects() ([]ObjectMetadata, error) {
+	dirEntries, err := storage.ReadDir(storage.trashDir)
+	if storage.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var objects []ObjectMetadata
+	for _, dirEntry := range dirEntries {
+		entries, err := storage.ListTrash(dirEntry.Name())
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			objects = append(objects, entry.Object)
+		}
+	}
+	return objects, nil
+}
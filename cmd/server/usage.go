@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// count adds an object found while scanning a bucket.
+func (usage *BucketUsage) count(metadata ObjectMetadata) {
+	usage.Objects++
+	usage.Bytes += metadata.Size
+	if usage.Largest == nil || metadata.Size > usage.Largest.Size {
+		usage.Largest = &LargestObject{Key: metadata.Key, Size: metadata.Size}
+	}
+	if metadata.LastModified.After(usage.LastActivity) {
+		usage.LastActivity = metadata.LastModified
+	}
+}
+
+// largestKnown reports whether Largest is accurate. It is not after the
+// largest object was removed, since finding the next one takes a scan.
+func (usage *BucketUsage) largestKnown() bool {
+	return usage.Largest != nil || usage.Objects == 0
+}
+
+// apply records that added replaced removed; either may be nil.
+func (usage *BucketUsage) apply(added, removed *ObjectMetadata) {
+	known := usage.largestKnown()
+
+	if removed != nil {
+		usage.Objects--
+		usage.Bytes -= removed.Size
+		if usage.Largest != nil && usage.Largest.Key == removed.Key {
+			usage.Largest = nil
+			// An object at least as large as the one it replaces is still
+			// the largest.
+			known = added != nil && added.Size >= removed.Size
+		}
+	}
+
+	if added != nil {
+		usage.Objects++
+		usage.Bytes += added.Size
+		if known && (usage.Largest == nil || added.Size > usage.Largest.Size) {
+			usage.Largest = &LargestObject{Key: added.Key, Size: added.Size}
+		}
+	}
+}
+
+// BucketUsage returns the tracked usage of a bucket. Only when the largest
+// object has been deleted since the last call is the bucket rescanned.
+func (storage *ObjectStorage) BucketUsage(bucketName string) (BucketUsage, error) {
+	storage.bucketMu.Lock()
+	defer storage.bucketMu.Unlock()
+
+	bucket, err := storage.GetBucket(bucketName)
+	if err != nil {
+		return BucketUsage{}, err
+	}
+
+	if bucket.Usage != nil && !bucket.Usage.largestKnown() {
+		lastActivity := bucket.Usage.LastActivity
+		bucket.Usage = nil
+		if _, err := storage.bucketUsage(&bucket); err != nil {
+			return BucketUsage{}, err
+		}
+		bucket.Usage.LastActivity = laterOf(bucket.Usage.LastActivity, lastActivity)
+		if err := storage.saveBucketMetaData(bucket); err != nil {
+			storage.logger.Warn("failed to save bucket usage", "bucket", bucketName, "error", err)
+		}
+		return *bucket.Usage, nil
+	}
+
+	return storage.bucketUsage(&bucket)
+}
+
+func laterOf(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
+
+// bucketUsageReport uses the filesystem backend's tracked usage and scans
+// the buckets of other backends.
+func (s *StorageServer) bucketUsageReport(bucketName string) (BucketUsage, error) {
+	if s.storage != nil {
+		return s.storage.BucketUsage(bucketName)
+	}
+
+	var usage BucketUsage
+	err := s.backend.WalkObjects(bucketName, func(metadata ObjectMetadata) error {
+		usage.count(metadata)
+		return nil
+	})
+	return usage, err
+}
+
+// handleBucketUsage serves GET /buckets/{bucket}/usage.
+func (s *StorageServer) handleBucketUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	bucketName := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/buckets/"), "/usage")
+	usage, err := s.bucketUsageReport(bucketName)
+	if err != nil {
+		s.writeStorageError(w, r, err)
+		return
+	}
+
+	response := struct {
+		Bucket string `json:"bucket"`
+		BucketUsage
+	}{bucketName, usage}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
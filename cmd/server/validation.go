@@ -0,0 +1,371 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Upload validator types.
+const (
+	validatorContentType = "content_type"
+	validatorExtension   = "extension"
+	validatorWebhook     = "webhook"
+)
+
+// sniffLength is how much of an upload content type detection looks at.
+const sniffLength = 512
+
+const (
+	defaultValidatorTimeout = 10 * time.Second
+
+	// maxRejectionReason caps how much of a webhook's response is used as
+	// the reason for a rejection.
+	maxRejectionReason = 1024
+)
+
+// ErrUploadRejected is returned when an upload validator refuses an upload.
+var ErrUploadRejected = errors.New("upload rejected")
+
+// ErrValidatorUnavailable is returned when an upload validator cannot give
+// an answer, so the upload is refused.
+var ErrValidatorUnavailable = errors.New("upload validator unavailable")
+
+// UploadValidatorConfig configures one check that every upload must pass
+// before it is stored.
+type UploadValidatorConfig struct {
+	// Type is content_type, extension or webhook.
+	Type string `json:"type"`
+
+	// Buckets limits the validator to these buckets; empty applies it to
+	// all.
+	Buckets []string `json:"buckets,omitempty"`
+
+	// Allow and Deny list detected content type prefixes for content_type
+	// and key extensions such as ".jpg" for extension. With Allow set,
+	// everything else is refused; Deny wins over Allow.
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+
+	// MatchDeclared makes content_type refuse uploads whose declared
+	// Content-Type is of a different kind (image, video, ...) than the
+	// detected one.
+	MatchDeclared bool `json:"match_declared,omitempty"`
+
+	// URL receives a POST for every upload for webhook. SendBody includes
+	// the data; otherwise only the headers describe the upload.
+	URL      string   `json:"url,omitempty"`
+	SendBody bool     `json:"send_body,omitempty"`
+	Timeout  Duration `json:"timeout,omitempty"`
+
+	// FailOpen accepts uploads when the webhook cannot be reached or
+	// fails, instead of refusing them.
+	FailOpen bool `json:"fail_open,omitempty"`
+}
+
+func (c UploadValidatorConfig) validate() error {
+	switch c.Type {
+	case validatorContentType, validatorExtension:
+		if c.URL != "" || c.SendBody || c.FailOpen {
+			return fmt.Errorf("upload validator %s: url, send_body and fail_open only apply to webhook", c.Type)
+		}
+	case validatorWebhook:
+		if !strings.HasPrefix(c.URL, "http://") && !strings.HasPrefix(c.URL, "https://") {
+			return fmt.Errorf("upload validator webhook: url must be an http or https URL")
+		}
+		if c.Timeout < 0 {
+			return fmt.Errorf("upload validator webhook: timeout must not be negative")
+		}
+		if len(c.Allow) > 0 || len(c.Deny) > 0 || c.MatchDeclared {
+			return fmt.Errorf("upload validator webhook: allow, deny and match_declared do not apply")
+		}
+	default:
+		return fmt.Errorf("upload validator type must be %s, %s or %s", validatorContentType, validatorExtension, validatorWebhook)
+	}
+	if c.Type == validatorExtension && c.MatchDeclared {
+		return fmt.Errorf("upload validator extension: match_declared only applies to content_type")
+	}
+	return nil
+}
+
+// pendingUpload is an upload that has been received but not stored.
+type pendingUpload struct {
+	Bucket       string
+	Key          string
+	ContentType  string
+	DetectedType string
+
+	// Size is -1 unless a validator reads the data.
+	Size int64
+
+	// Scan is the virus scan status a validator recorded for the upload.
+	Scan *ScanStatus
+
+	// spool holds the data when a validator reads it.
+	spool *os.File
+}
+
+// data returns the upload's data, for validators that read it.
+func (u *pendingUpload) data() io.Reader {
+	return io.NewSectionReader(u.spool, 0, u.Size)
+}
+
+// uploadValidator checks uploads. Validators are built into the server;
+// Go's plugin package is not used because it does not work on Windows and
+// needs plugins built with exactly the server's toolchain.
+type uploadValidator interface {
+	// validate returns an error wrapping ErrUploadRejected or
+	// ErrValidatorUnavailable to refuse an upload.
+	validate(ctx context.Context, upload *pendingUpload) error
+
+	// readsBody reports whether validate needs the upload's data.
+	readsBody() bool
+}
+
+// configuredValidator applies a validator to the buckets it is configured
+// for.
+type configuredValidator struct {
+	uploadValidator
+	buckets []string
+}
+
+func (v configuredValidator) appliesTo(bucketName string) bool {
+	return len(v.buckets) == 0 || slices.Contains(v.buckets, bucketName)
+}
+
+func newUploadValidators(config *Config) []configuredValidator {
+	validators := make([]configuredValidator, 0, len(config.UploadValidators))
+	for _, c := range config.UploadValidators {
+		var v uploadValidator
+		switch c.Type {
+		case validatorContentType:
+			v = &contentTypeValidator{allow: c.Allow, deny: c.Deny, matchDeclared: c.MatchDeclared}
+		case validatorExtension:
+			v = &extensionValidator{allow: c.Allow, deny: c.Deny}
+		case validatorWebhook:
+			timeout := time.Duration(c.Timeout)
+			if timeout == 0 {
+				timeout = defaultValidatorTimeout
+			}
+			v = &webhookValidator{
+				url:      c.URL,
+				sendBody: c.SendBody,
+				failOpen: c.FailOpen,
+				secret:   []byte(config.WebhookSecret),
+				client:   &http.Client{Timeout: timeout},
+			}
+		}
+		validators = append(validators, configuredValidator{uploadValidator: v, buckets: c.Buckets})
+	}
+	return validators
+}
+
+// contentTypeValidator checks the content type detected from the first
+// bytes of an upload.
+type contentTypeValidator struct {
+	allow, deny   []string
+	matchDeclared bool
+}
+
+func (v *contentTypeValidator) readsBody() bool { return false }
+
+func (v *contentTypeValidator) validate(ctx context.Context, upload *pendingUpload) error {
+	detected := upload.DetectedType
+	hasPrefix := func(prefix string) bool { return strings.HasPrefix(detected, prefix) }
+	if slices.ContainsFunc(v.deny, hasPrefix) || (len(v.allow) > 0 && !slices.ContainsFunc(v.allow, hasPrefix)) {
+		return fmt.Errorf("%w: content of type %s is not allowed", ErrUploadRejected, detected)
+	}
+
+	if v.matchDeclared && detected != "application/octet-stream" && !strings.HasPrefix(detected, "text/plain") {
+		declared, _, _ := mime.ParseMediaType(upload.ContentType)
+		declaredKind, _, _ := strings.Cut(declared, "/")
+		detectedKind, _, _ := strings.Cut(detected, "/")
+		if declaredKind != detectedKind {
+			return fmt.Errorf("%w: declared content type %s does not match detected %s", ErrUploadRejected, upload.ContentType, detected)
+		}
+	}
+	return nil
+}
+
+// extensionValidator checks the extension of the object key.
+type extensionValidator struct {
+	allow, deny []string
+}
+
+func (v *extensionValidator) readsBody() bool { return false }
+
+func (v *extensionValidator) validate(ctx context.Context, upload *pendingUpload) error {
+	ext := strings.ToLower(path.Ext(upload.Key))
+	matches := func(entry string) bool { return strings.ToLower(entry) == ext }
+	if slices.ContainsFunc(v.deny, matches) || (len(v.allow) > 0 && !slices.ContainsFunc(v.allow, matches)) {
+		if ext == "" {
+			return fmt.Errorf("%w: keys without an extension are not allowed", ErrUploadRejected)
+		}
+		return fmt.Errorf("%w: %s files are not allowed", ErrUploadRejected, ext)
+	}
+	return nil
+}
+
+// webhookValidator asks an external service. A 2xx response accepts the
+// upload, a 4xx response refuses it with the response body as the reason.
+type webhookValidator struct {
+	url      string
+	sendBody bool
+	failOpen bool
+	secret   []byte
+	client   *http.Client
+}
+
+func (v *webhookValidator) readsBody() bool { return v.sendBody }
+
+func (v *webhookValidator) validate(ctx context.Context, upload *pendingUpload) error {
+	var body io.Reader = http.NoBody
+	var signature []byte
+	if v.sendBody {
+		mac := hmac.New(sha256.New, v.secret)
+		if _, err := io.Copy(mac, upload.data()); err != nil {
+			return err
+		}
+		body, signature = upload.data(), mac.Sum(nil)
+	} else {
+		signature = hmac.New(sha256.New, v.secret).Sum(nil)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, v.url, body)
+	if err != nil {
+		return err
+	}
+	if v.sendBody {
+		req.ContentLength = upload.Size
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set(webhookEventHeader, "upload.validate")
+	req.Header.Set("X-Upload-Bucket", upload.Bucket)
+	req.Header.Set("X-Upload-Key", upload.Key)
+	req.Header.Set("X-Upload-Content-Type", upload.ContentType)
+	req.Header.Set("X-Upload-Detected-Type", upload.DetectedType)
+	if upload.Size >= 0 {
+		req.Header.Set("X-Upload-Size", strconv.FormatInt(upload.Size, 10))
+	}
+	if len(v.secret) > 0 {
+		req.Header.Set(webhookSignatureHeader, "sha256="+hex.EncodeToString(signature))
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return v.unavailable(err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode <= 299:
+		return nil
+	case resp.StatusCode >= 400 && resp.StatusCode <= 499:
+		reason, _ := io.ReadAll(io.LimitReader(resp.Body, maxRejectionReason))
+		if len(bytes.TrimSpace(reason)) == 0 {
+			reason = []byte("refused by validation webhook")
+		}
+		return fmt.Errorf("%w: %s", ErrUploadRejected, bytes.TrimSpace(reason))
+	}
+	return v.unavailable(fmt.Errorf("webhook returned %s", resp.Status))
+}
+
+func (v *webhookValidator) unavailable(err error) error {
+	if v.failOpen {
+		return nil
+	}
+	return fmt.Errorf("%w: %v", ErrValidatorUnavailable, err)
+}
+
+// validateUpload runs the validators of a bucket over an upload before it
+// is stored and returns the data to store. Only the first bytes are
+// buffered unless a validator reads the data, which is then spooled to a
+// temp file and removed when the returned reader is closed.
+func (s *StorageServer) validateUpload(bucketName, objectKey string, opts *PutOptions, data io.Reader) (io.ReadCloser, error) {
+	var validators []configuredValidator
+	readsBody := false
+	for _, v := range s.validators {
+		if v.appliesTo(bucketName) {
+			validators = append(validators, v)
+			readsBody = readsBody || v.readsBody()
+		}
+	}
+	if len(validators) == 0 {
+		return io.NopCloser(data), nil
+	}
+
+	upload := &pendingUpload{Bucket: bucketName, Key: objectKey, ContentType: opts.ContentType, Size: -1}
+	var head []byte
+	var body io.ReadCloser
+	if readsBody {
+		spool, err := os.CreateTemp("", "validate-*.tmp")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create temp file: %w", err)
+		}
+		body = &spoolFile{File: spool}
+		if upload.Size, err = io.Copy(spool, data); err != nil {
+			body.Close()
+			return nil, fmt.Errorf("failed to read object data: %w", err)
+		}
+		upload.spool = spool
+		head = make([]byte, min(upload.Size, sniffLength))
+		spool.ReadAt(head, 0)
+	} else {
+		buffered := bufio.NewReaderSize(data, sniffLength)
+		var err error
+		if head, err = buffered.Peek(sniffLength); err != nil && err != io.EOF {
+			return nil, fmt.Errorf("failed to read object data: %w", err)
+		}
+		body = io.NopCloser(buffered)
+	}
+	upload.DetectedType = http.DetectContentType(head)
+
+	ctx := context.Background()
+	for _, v := range validators {
+		if err := v.validate(ctx, upload); err != nil {
+			body.Close()
+			return nil, err
+		}
+	}
+	if upload.spool != nil {
+		if _, err := upload.spool.Seek(0, io.SeekStart); err != nil {
+			body.Close()
+			return nil, err
+		}
+	}
+	opts.Scan = upload.Scan
+	return body, nil
+}
+
+// spoolFile is a temp file that is removed when closed.
+type spoolFile struct {
+	*os.File
+}
+
+func (f *spoolFile) Close() error {
+	f.File.Close()
+	return os.Remove(f.Name())
+}
+
+// validate runs opts.Validate over an upload, if set, and returns the data
+// to store, which the caller must close.
+func (opts *PutOptions) validate(bucketName, objectKey string, data io.Reader) (io.ReadCloser, error) {
+	if opts.Validate == nil {
+		return io.NopCloser(data), nil
+	}
+	return opts.Validate(bucketName, objectKey, opts, data)
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// WebsiteConfig lets a bucket serve a static site under /website/{bucket}/
+// and, with website_domain set, on {bucket}.{website_domain}.
+type WebsiteConfig struct {
+	// IndexDocument is served for requests to the site root or to any path
+	// ending in a slash, such as "index.html".
+	IndexDocument string `json:"index_document"`
+
+	// ErrorDocument, when set, is the key of the page served with status
+	// 404 for missing objects.
+	ErrorDocument string `json:"error_document,omitempty"`
+}
+
+func (website *WebsiteConfig) validate() error {
+	if website == nil {
+		return nil
+	}
+	if website.IndexDocument == "" {
+		return fmt.Errorf("website index_document is required")
+	}
+	if strings.Contains(website.IndexDocument, "/") {
+		return fmt.Errorf("website index_document must not contain a slash")
+	}
+	return nil
+}
+
+// handleBucketWebsite serves GET, PUT and DELETE on /buckets/{name}?website.
+func (s *StorageServer) handleBucketWebsite(w http.ResponseWriter, r *http.Request) {
+	bucketName := strings.TrimPrefix(r.URL.Path, "/buckets/")
+
+	var website *WebsiteConfig
+	switch r.Method {
+	case http.MethodGet, http.MethodDelete:
+	case http.MethodPut:
+		website = &WebsiteConfig{}
+		if err := json.NewDecoder(r.Body).Decode(website); err != nil {
+			s.writeError(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid website configuration: %v", err))
+			return
+		}
+		if err := website.validate(); err != nil {
+			s.writeError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+	default:
+		s.writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var bucket Bucket
+	var err error
+	if r.Method == http.MethodGet {
+		bucket, err = s.storage.GetBucket(bucketName)
+	} else {
+		bucket, err = s.storage.UpdateBucketSettings(bucketName, func(settings *BucketSettings) error {
+			settings.Website = website
+			return nil
+		})
+	}
+	if err != nil {
+		s.writeStorageError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]*WebsiteConfig{"website": bucket.Settings.Website})
+}
+
+// websiteHosts rewrites requests for {bucket}.{website_domain} to
+// /website/{bucket}/..., so they pass through the same middleware as
+// path-based website requests and never reach the API.
+func (s *StorageServer) websiteHosts(next http.Handler) http.Handler {
+	if s.config.WebsiteDomain == "" {
+		return next
+	}
+
+	suffix := "." + strings.ToLower(s.config.WebsiteDomain)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		bucketName, ok := strings.CutSuffix(strings.ToLower(host), suffix)
+		if !ok || bucketName == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.URL = new(url.URL)
+		*r2.URL = *r.URL
+		r2.URL.Path = "/website/" + bucketName + r.URL.Path
+		r2.URL.RawPath = ""
+		next.ServeHTTP(w, r2)
+	})
+}
+
+// handleWebsite serves GET and HEAD on /website/{bucket}/{path} from a
+// bucket with a website configuration.
+func (s *StorageServer) handleWebsite(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		s.writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	bucketName, sitePath, hasPath := strings.Cut(strings.TrimPrefix(r.URL.Path, "/website/"), "/")
+	if bucketName == "" {
+		s.writeError(w, r, http.StatusBadRequest, "Bucket name required")
+		return
+	}
+
+	bucket, err := s.backend.GetBucket(bucketName)
+	if err != nil {
+		s.writeStorageError(w, r, err)
+		return
+	}
+	website := bucket.Settings.Website
+	if website == nil {
+		s.writeErrorCode(w, r, http.StatusNotFound, "NoSuchWebsiteConfiguration", "Bucket is not configured as a website")
+		return
+	}
+
+	if !hasPath {
+		redirectToDirectory(w, bucketName)
+		return
+	}
+
+	key := sitePath
+	if key == "" || strings.HasSuffix(key, "/") {
+		key += website.IndexDocument
+	}
+
+	reader, metadata, err := s.backend.GetObject(bucketName, key)
+	if err == nil {
+		defer reader.Close()
+		s.writeWebsiteObject(w, r, bucketName, http.StatusOK, metadata, reader)
+		return
+	}
+	if !strings.Contains(err.Error(), "not found") {
+		s.writeError(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// A path naming a directory with an index document is redirected to
+	// the directory, so relative links in the page resolve.
+	if key == sitePath {
+		if _, err := s.backend.StatObject(bucketName, key+"/"+website.IndexDocument); err == nil {
+			redirectToDirectory(w, path.Base(key))
+			return
+		}
+	}
+
+	if website.ErrorDocument != "" {
+		reader, metadata, err := s.backend.GetObject(bucketName, website.ErrorDocument)
+		if err == nil {
+			defer reader.Close()
+			s.writeWebsiteObject(w, r, bucketName, http.StatusNotFound, metadata, reader)
+			return
+		}
+	}
+	s.writeError(w, r, http.StatusNotFound, "Not found")
+}
+
+// redirectToDirectory redirects a request for .../name to .../name/. The
+// location is relative because host-based requests have been rewritten and
+// http.Redirect would make it absolute using the rewritten path.
+func redirectToDirectory(w http.ResponseWriter, name string) {
+	w.Header().Set("Location", url.PathEscape(name)+"/")
+	w.WriteHeader(http.StatusMovedPermanently)
+}
+
+// writeWebsiteObject sends an object as a web page. Objects uploaded
+// without a specific content type get one from their extension, so pages,
+// stylesheets and scripts render in browsers.
+func (s *StorageServer) writeWebsiteObject(w http.ResponseWriter, r *http.Request, bucketName string, status int, metadata *ObjectMetadata, reader io.Reader) {
+	contentType := metadata.ContentType
+	if contentType == "" || contentType == "application/octet-stream" {
+		if byExt := mime.TypeByExtension(path.Ext(metadata.Key)); byExt != "" {
+			contentType = byExt
+		}
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("ETag", metadata.ETag)
+	w.Header().Set("Last-Modified", metadata.LastModified.Format(http.TimeFormat))
+	w.Header().Set("Content-Length", strconv.FormatInt(metadata.Size, 10))
+	s.setCacheHeaders(w, bucketName, metadata)
+	compress := s.gzipResponse(w, r, bucketName, metadata)
+
+	if r.Method == http.MethodHead {
+		w.WriteHeader(status)
+		return
+	}
+	s.writeCompressible(w, status, reader, compress)
+}
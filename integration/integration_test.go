@@ -0,0 +1,480 @@
+//go:build integration
+
+// Package integration runs end-to-end checks against the real server and
+// CLI binaries: it builds both, boots the server on a temporary data
+// directory and drives it over HTTP and through the CLI, including
+// concurrent writers and a server killed in the middle of an upload. After
+// each scenario every object's data is checked against its ETag.
+//
+// Run with: go test -tags integration ./integration (or: make integration)
+package integration
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+var serverBinary, cliBinary string
+
+func TestMain(m *testing.M) {
+	os.Exit(run(m))
+}
+
+func run(m *testing.M) int {
+	dir, err := os.MkdirTemp("", "storage-integration-")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+
+	serverBinary = filepath.Join(dir, "storage-server")
+	cliBinary = filepath.Join(dir, "storage-cli")
+	for binary, pkg := range map[string]string{serverBinary: "storage-system/cmd/server", cliBinary: "storage-system/cmd/cli"} {
+		cmd := exec.Command("go", "build", "-o", binary, pkg)
+		cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+		if err := cmd.Run(); err != nil {
+			fmt.Fprintf(os.Stderr, "building %s: %v\n", pkg, err)
+			return 1
+		}
+	}
+	return m.Run()
+}
+
+// testServer is a server process on a data directory that outlives it, so
+// a test can kill it and start another on the same data.
+type testServer struct {
+	t       *testing.T
+	dataDir string
+	addr    string
+	url     string
+	log     *bytes.Buffer
+	cmd     *exec.Cmd
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	s := &testServer{t: t, dataDir: filepath.Join(t.TempDir(), "storage"), log: &bytes.Buffer{}}
+	s.addr = freeAddr(t)
+	s.url = "http://" + s.addr
+	s.start()
+	t.Cleanup(func() {
+		s.stop(syscall.SIGKILL)
+		if t.Failed() {
+			t.Logf("server log:\n%s", s.log)
+		}
+	})
+	return s
+}
+
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+// start runs the server and waits until it reports ready.
+func (s *testServer) start() {
+	s.t.Helper()
+	s.cmd = exec.Command(serverBinary, "--listen", s.addr, "--data-dir", s.dataDir)
+	s.cmd.Stdout, s.cmd.Stderr = s.log, s.log
+	if err := s.cmd.Start(); err != nil {
+		s.t.Fatal(err)
+	}
+	for range 100 {
+		if resp, err := http.Get(s.url + "/readyz"); err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	s.t.Fatal("server did not become ready")
+}
+
+// stop signals the server and waits for it to exit.
+func (s *testServer) stop(sig os.Signal) {
+	if s.cmd == nil {
+		return
+	}
+	s.cmd.Process.Signal(sig)
+	s.cmd.Wait()
+	s.cmd = nil
+}
+
+// cli runs the CLI against the server in dir and returns its output. Local
+// paths must be relative to dir: the CLI treats arguments containing a
+// slash as bucket/object.
+func (s *testServer) cli(dir string, args ...string) string {
+	s.t.Helper()
+	cmd := exec.Command(cliBinary, append([]string{"--server", s.url}, args...)...)
+	cmd.Dir = dir
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		s.t.Fatalf("storage-cli %s: %v\n%s", strings.Join(args, " "), err, output)
+	}
+	return string(output)
+}
+
+func (s *testServer) do(method, path string, body io.Reader) *http.Response {
+	s.t.Helper()
+	req, err := http.NewRequest(method, s.url+path, body)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		s.t.Fatalf("%s %s: %v", method, path, err)
+	}
+	return resp
+}
+
+// expect sends a request and fails the test unless it gets status; it
+// returns the response body.
+func (s *testServer) expect(status int, method, path string, body io.Reader) []byte {
+	s.t.Helper()
+	resp := s.do(method, path, body)
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	if resp.StatusCode != status {
+		s.t.Fatalf("%s %s: got %s, want %d: %s", method, path, resp.Status, status, data)
+	}
+	return data
+}
+
+func (s *testServer) put(bucketName, objectKey string, data []byte) {
+	s.t.Helper()
+	s.expect(http.StatusOK, http.MethodPut, "/objects/"+bucketName+"/"+objectKey, bytes.NewReader(data))
+}
+
+func (s *testServer) get(bucketName, objectKey string) []byte {
+	s.t.Helper()
+	return s.expect(http.StatusOK, http.MethodGet, "/objects/"+bucketName+"/"+objectKey, nil)
+}
+
+type listedObject struct {
+	Key        string `json:"key"`
+	Size       int64  `json:"size"`
+	ETag       string `json:"etag"`
+	Generation int64  `json:"generation"`
+}
+
+// objects lists every object in a bucket.
+func (s *testServer) objects(bucketName string) []listedObject {
+	s.t.Helper()
+	var objects []listedObject
+	if err := json.Unmarshal(s.expect(http.StatusOK, http.MethodGet, "/objects/"+bucketName, nil), &objects); err != nil {
+		s.t.Fatal(err)
+	}
+	return objects
+}
+
+func (s *testServer) keys(bucketName string) []string {
+	s.t.Helper()
+	var keys []string
+	for _, object := range s.objects(bucketName) {
+		keys = append(keys, object.Key)
+	}
+	return keys
+}
+
+// checkBucket verifies that every object's data has its listed size and,
+// for objects uploaded in one request, matches its ETag. Multipart ETags
+// are not an MD5 of the data, so those objects are checked by size only.
+func (s *testServer) checkBucket(bucketName string) {
+	s.t.Helper()
+	for _, object := range s.objects(bucketName) {
+		data := s.get(bucketName, object.Key)
+		if int64(len(data)) != object.Size {
+			s.t.Errorf("%s/%s: read %d bytes, listed size is %d", bucketName, object.Key, len(data), object.Size)
+		}
+		if strings.Contains(object.ETag, "-") {
+			continue
+		}
+		if sum := md5.Sum(data); hex.EncodeToString(sum[:]) != object.ETag {
+			s.t.Errorf("%s/%s: data MD5 %x does not match ETag %s", bucketName, object.Key, sum, object.ETag)
+		}
+	}
+}
+
+func randomData(t *testing.T, size int) []byte {
+	t.Helper()
+	data := make([]byte, size)
+	rand.Read(data)
+	return data
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCLIWorkflow(t *testing.T) {
+	s := newTestServer(t)
+	work := t.TempDir()
+	hello := []byte("hello integration\n")
+	writeFile(t, filepath.Join(work, "hello.txt"), hello)
+
+	s.cli(work, "mb", "it")
+	s.cli(work, "cp", "hello.txt", "it/docs/hello.txt")
+	if output := s.cli(work, "ls", "-r", "it"); !strings.Contains(output, "docs/hello.txt") {
+		t.Fatalf("uploaded object not listed:\n%s", output)
+	}
+
+	s.cli(work, "cp", "it/docs/hello.txt", "downloaded.txt")
+	if downloaded, _ := os.ReadFile(filepath.Join(work, "downloaded.txt")); !bytes.Equal(downloaded, hello) {
+		t.Fatalf("downloaded file is %q, want %q", downloaded, hello)
+	}
+
+	s.cli(work, "mv", "it/docs/hello.txt", "it/docs/renamed.txt")
+	if keys := s.keys("it"); len(keys) != 1 || keys[0] != "docs/renamed.txt" {
+		t.Fatalf("after mv the bucket holds %q, want only docs/renamed.txt", keys)
+	}
+	s.checkBucket("it")
+
+	s.cli(work, "rm", "it/docs/renamed.txt")
+	if keys := s.keys("it"); len(keys) != 0 {
+		t.Fatalf("bucket not empty after rm: %q", keys)
+	}
+}
+
+func TestSync(t *testing.T) {
+	s := newTestServer(t)
+	work := t.TempDir()
+	files := map[string][]byte{}
+	for i := 1; i <= 20; i++ {
+		name := fmt.Sprintf("file%d.bin", i)
+		if i%4 == 0 {
+			name = "nested/" + name
+		}
+		files[name] = randomData(t, i*1000)
+		writeFile(t, filepath.Join(work, "tree", name), files[name])
+	}
+
+	s.cli(work, "mb", "it")
+	s.cli(work, "sync", "--parallel", "8", "./tree", "it/tree")
+	if keys := s.keys("it"); len(keys) != len(files) {
+		t.Fatalf("sync uploaded %d objects, want %d", len(keys), len(files))
+	}
+	for name, data := range files {
+		if got := s.get("it", "tree/"+name); !bytes.Equal(got, data) {
+			t.Errorf("tree/%s does not match the local file", name)
+		}
+	}
+
+	if output := s.cli(work, "sync", "./tree", "it/tree"); !strings.Contains(output, "up to date") {
+		t.Fatalf("second sync of unchanged files transferred something:\n%s", output)
+	}
+
+	files["file1.bin"] = []byte("changed")
+	writeFile(t, filepath.Join(work, "tree", "file1.bin"), files["file1.bin"])
+	os.Remove(filepath.Join(work, "tree", "file2.bin"))
+	delete(files, "file2.bin")
+	output := s.cli(work, "sync", "--delete", "./tree", "it/tree")
+	if n := strings.Count(output, "uploaded to"); n != 1 {
+		t.Fatalf("sync after one change uploaded %d files, want 1:\n%s", n, output)
+	}
+	if keys := s.keys("it"); len(keys) != len(files) {
+		t.Fatalf("sync --delete left %d objects, want %d", len(keys), len(files))
+	}
+
+	s.cli(work, "sync", "it/tree", "./copy")
+	for name, data := range files {
+		if got, err := os.ReadFile(filepath.Join(work, "copy", name)); err != nil || !bytes.Equal(got, data) {
+			t.Errorf("downloaded %s does not match the uploaded file (err %v)", name, err)
+		}
+	}
+
+	// "tree" could be a bucket as well as the local directory, so the
+	// direction must be given.
+	cmd := exec.Command(cliBinary, "--server", s.url, "sync", "tree", "it/tree")
+	cmd.Dir = work
+	if output, err := cmd.CombinedOutput(); err == nil || !strings.Contains(string(output), "--direction") {
+		t.Errorf("sync with ambiguous arguments: %v\n%s", err, output)
+	}
+	if output := s.cli(work, "sync", "--direction", "up", "tree", "it/tree"); !strings.Contains(output, "up to date") {
+		t.Errorf("sync --direction up of unchanged files transferred something:\n%s", output)
+	}
+	s.checkBucket("it")
+}
+
+func TestMultipartUpload(t *testing.T) {
+	s := newTestServer(t)
+	work := t.TempDir()
+	data := randomData(t, 5<<20+12345)
+	writeFile(t, filepath.Join(work, "big.bin"), data)
+
+	s.cli(work, "mb", "it")
+	output := s.cli(work, "--verbose", "cp", "--part-size", "1", "--parallel", "4", "big.bin", "it/big.bin")
+	if parts := strings.Count(output, "Uploaded part "); parts != 6 {
+		t.Fatalf("uploaded in %d parts, want 6:\n%s", parts, output)
+	}
+	if got := s.get("it", "big.bin"); !bytes.Equal(got, data) {
+		t.Fatalf("multipart object does not match the uploaded file: got %d bytes, want %d", len(got), len(data))
+	}
+
+	// Completing the upload removes its staged parts.
+	if entries, _ := os.ReadDir(filepath.Join(s.dataDir, "multipart")); len(entries) != 0 {
+		t.Errorf("multipart upload left %d staging entries behind", len(entries))
+	}
+	s.checkBucket("it")
+}
+
+func TestConcurrentWriters(t *testing.T) {
+	s := newTestServer(t)
+	s.expect(http.StatusCreated, http.MethodPut, "/buckets/it", nil)
+
+	const writers = 16
+	uploads := make(map[string]bool, writers)
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for range writers {
+		data := randomData(t, 200000)
+		sum := md5.Sum(data)
+		uploads[hex.EncodeToString(sum[:])] = true
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := http.DefaultClient.Do(mustRequest(http.MethodPut, s.url+"/objects/it/race", data))
+			if err != nil {
+				errs <- err
+				return
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				errs <- fmt.Errorf("concurrent upload: %s", resp.Status)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	resp := s.do(http.MethodHead, "/objects/it/race", nil)
+	resp.Body.Close()
+	if generation := resp.Header.Get("X-Object-Generation"); generation != strconv.Itoa(writers) {
+		t.Errorf("generation after %d writes is %s", writers, generation)
+	}
+	sum := md5.Sum(s.get("it", "race"))
+	if !uploads[hex.EncodeToString(sum[:])] {
+		t.Fatal("object data is a mix of concurrent uploads")
+	}
+	s.checkBucket("it")
+}
+
+func mustRequest(method, url string, data []byte) *http.Request {
+	req, err := http.NewRequest(method, url, bytes.NewReader(data))
+	if err != nil {
+		panic(err)
+	}
+	return req
+}
+
+func TestCrashDuringUpload(t *testing.T) {
+	s := newTestServer(t)
+	s.expect(http.StatusCreated, http.MethodPut, "/buckets/it", nil)
+	s.put("it", "crash", []byte("version one"))
+
+	// Send the start of a large upload, then kill the server while it
+	// waits for the rest.
+	body, writer := io.Pipe()
+	req, _ := http.NewRequest(http.MethodPut, s.url+"/objects/it/crash", body)
+	req.ContentLength = 20 << 20
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if resp, err := http.DefaultClient.Do(req); err == nil {
+			resp.Body.Close()
+		}
+	}()
+	writer.Write(randomData(t, 1<<20))
+	tempFiles := filepath.Join(s.dataDir, "data", "it", "upload-*.tmp")
+	waitFor(t, "the upload to reach the disk", func() bool {
+		matches, _ := filepath.Glob(tempFiles)
+		return len(matches) > 0
+	})
+	s.stop(syscall.SIGKILL)
+	writer.CloseWithError(io.ErrUnexpectedEOF)
+	<-done
+
+	s.start()
+	if got := s.get("it", "crash"); string(got) != "version one" {
+		t.Fatalf("after the crash the object is %q, want the previous version", got)
+	}
+	s.checkBucket("it")
+
+	var janitor struct {
+		Removed int `json:"removed"`
+	}
+	if err := json.Unmarshal(s.expect(http.StatusOK, http.MethodPost, "/admin/janitor?max_age=0s", nil), &janitor); err != nil {
+		t.Fatal(err)
+	}
+	if janitor.Removed < 1 {
+		t.Error("janitor did not remove the abandoned temp file")
+	}
+	if matches, _ := filepath.Glob(tempFiles); len(matches) != 0 {
+		t.Errorf("temp files left after the janitor ran: %q", matches)
+	}
+}
+
+func TestRestart(t *testing.T) {
+	s := newTestServer(t)
+	s.expect(http.StatusCreated, http.MethodPut, "/buckets/it", nil)
+	objects := map[string][]byte{}
+	for i := range 10 {
+		key := fmt.Sprintf("dir%d/object%d", i%3, i)
+		objects[key] = randomData(t, 1000+i)
+		s.put("it", key, objects[key])
+	}
+
+	s.stop(syscall.SIGTERM)
+	s.start()
+	for key, data := range objects {
+		if got := s.get("it", key); !bytes.Equal(got, data) {
+			t.Errorf("%s changed across a restart", key)
+		}
+	}
+	s.checkBucket("it")
+}
+
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	for range 200 {
+		if condition() {
+			return
+		}
+		time.Sleep(25 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
@@ -0,0 +1,129 @@
+// Package ids generates the identifiers used across the server: request
+// IDs, event IDs and trash entry IDs.
+//
+// IDs are ULIDs: a 48-bit millisecond timestamp followed by 80 random bits,
+// written as 26 characters of Crockford base32. They sort lexicographically
+// in creation order, and IDs from one generator are strictly increasing even
+// within the same millisecond.
+package ids
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Generator produces unique, sortable identifiers.
+type Generator interface {
+	New() string
+}
+
+// Default is the generator used by New. Replace it to plug in another
+// scheme; it must be safe for concurrent use.
+var Default Generator = NewULIDGenerator(rand.Reader)
+
+// New returns an identifier from the default generator.
+func New() string {
+	return Default.New()
+}
+
+const encoding = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ULIDGenerator produces monotonic ULIDs.
+type ULIDGenerator struct {
+	entropy io.Reader
+
+	mu     sync.Mutex
+	lastMs uint64
+	last   [10]byte
+}
+
+// NewULIDGenerator returns a generator drawing its random bits from
+// entropy.
+func NewULIDGenerator(entropy io.Reader) *ULIDGenerator {
+	return &ULIDGenerator{entropy: entropy}
+}
+
+// New returns the next ULID. Within one millisecond the random part of the
+// previous ID is incremented instead of drawn again, which keeps IDs
+// ordered; if the clock goes backwards the previous timestamp is reused.
+func (g *ULIDGenerator) New() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ms := uint64(time.Now().UnixMilli())
+	if ms <= g.lastMs {
+		ms = g.lastMs
+		if !increment(g.last[:]) {
+			// The random part overflowed; move to the next millisecond.
+			ms++
+			g.fill()
+		}
+	} else {
+		g.fill()
+	}
+	g.lastMs = ms
+
+	var id [16]byte
+	for i := 0; i < 6; i++ {
+		id[i] = byte(ms >> (40 - 8*i))
+	}
+	copy(id[6:], g.last[:])
+	return encode(id)
+}
+
+func (g *ULIDGenerator) fill() {
+	if _, err := io.ReadFull(g.entropy, g.last[:]); err != nil {
+		panic(fmt.Sprintf("ids: failed to read entropy: %v", err))
+	}
+}
+
+// increment adds one to a big-endian number, reporting false on overflow.
+func increment(b []byte) bool {
+	for i := len(b) - 1; i >= 0; i-- {
+		b[i]++
+		if b[i] != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// encode writes the 128 bits of id as 26 base32 characters, the first of
+// which carries only 3 bits.
+func encode(id [16]byte) string {
+	var out [26]byte
+	var acc uint64
+	bits := 2 // pad 128 bits to 130 so they divide into 5-bit groups
+	n := 0
+	for _, b := range id {
+		acc = acc<<8 | uint64(b)
+		bits += 8
+		for bits >= 5 {
+			bits -= 5
+			out[n] = encoding[(acc>>bits)&31]
+			n++
+		}
+	}
+	return string(out[:])
+}
+
+// Time returns the creation time encoded in a ULID.
+func Time(id string) (time.Time, error) {
+	if len(id) != 26 {
+		return time.Time{}, fmt.Errorf("ids: %q is not a ULID", id)
+	}
+
+	var ms uint64
+	for _, c := range strings.ToUpper(id[:10]) {
+		v := strings.IndexRune(encoding, c)
+		if v < 0 {
+			return time.Time{}, fmt.Errorf("ids: %q is not a ULID", id)
+		}
+		ms = ms<<5 | uint64(v)
+	}
+	return time.UnixMilli(int64(ms)), nil
+}
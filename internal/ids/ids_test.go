@@ -0,0 +1,97 @@
+package ids
+
+import (
+	"bytes"
+	"crypto/rand"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestULIDFormat(t *testing.T) {
+	g := NewULIDGenerator(rand.Reader)
+	before := time.Now().Truncate(time.Millisecond)
+	id := g.New()
+	after := time.Now()
+
+	if len(id) != 26 {
+		t.Fatalf("%q has %d characters, want 26", id, len(id))
+	}
+	for _, c := range id {
+		if !strings.ContainsRune(encoding, c) {
+			t.Errorf("%q contains %q, which is not Crockford base32", id, c)
+		}
+	}
+	// 128 bits in 26 characters leave the first one only 3 bits.
+	if id[0] > '7' {
+		t.Errorf("%q starts with %q, past the 48-bit timestamp range", id, id[0])
+	}
+	created, err := Time(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Before(before) || created.After(after) {
+		t.Errorf("Time(%q) = %s, want between %s and %s", id, created, before, after)
+	}
+}
+
+// TestULIDMonotonic generates IDs while the previous one is ahead of the
+// clock, so they all share its millisecond and only the random part may
+// order them.
+func TestULIDMonotonic(t *testing.T) {
+	g := NewULIDGenerator(rand.Reader)
+	g.lastMs = uint64(time.Now().Add(time.Hour).UnixMilli())
+
+	prev := g.New()
+	for range 1000 {
+		id := g.New()
+		if id <= prev {
+			t.Fatalf("%q does not sort after %q", id, prev)
+		}
+		if id[:10] != prev[:10] {
+			t.Fatalf("%q left the millisecond of %q", id, prev)
+		}
+		prev = id
+	}
+}
+
+// TestULIDOverflow increments a random part that is all ones. The ID moves
+// to the next millisecond with fresh random bits from the entropy reader.
+func TestULIDOverflow(t *testing.T) {
+	entropy := bytes.Repeat([]byte{0x01}, 10)
+	g := NewULIDGenerator(bytes.NewReader(entropy))
+	ms := uint64(time.Now().Add(time.Hour).UnixMilli())
+	g.lastMs = ms
+	g.last = [10]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+
+	previous := ulid(ms, g.last[:])
+
+	id := g.New()
+	if id <= previous {
+		t.Errorf("%q does not sort after %q", id, previous)
+	}
+	if g.lastMs != ms+1 {
+		t.Errorf("timestamp %d, want %d", g.lastMs, ms+1)
+	}
+	created, err := Time(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.UnixMilli() != int64(ms+1) {
+		t.Errorf("Time(%q) = %d, want %d", id, created.UnixMilli(), ms+1)
+	}
+
+	if want := ulid(ms+1, entropy); id != want {
+		t.Errorf("got %q, want %q with the entropy reader's bits", id, want)
+	}
+}
+
+// ulid encodes the ID with timestamp ms and the given random part.
+func ulid(ms uint64, random []byte) string {
+	var id [16]byte
+	for i := range 6 {
+		id[i] = byte(ms >> (40 - 8*i))
+	}
+	copy(id[6:], random)
+	return encode(id)
+}
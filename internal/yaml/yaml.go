@@ -0,0 +1,405 @@
+// Package yaml converts the subset of YAML the server's config file and
+// the CLI's profiles file are written in to JSON, so they can be decoded
+// with encoding/json and the same field names and types as JSON. It
+// understands block style:
+//
+//	listen: ":8443"
+//	drain_timeout: 1m
+//	tls:
+//	  cert_file: /etc/storage/server.crt   # comment
+//	auth:
+//	  tokens: [ci-token, "deploy token"]
+//	listeners:
+//	  - address: ":9000"
+//	    routes: admin
+//
+// That is: mappings and sequences indented with spaces, "- " items that
+// may themselves be mappings, one-line [a, b] and {k: v} collections of
+// scalars, and plain, single- or double-quoted scalars. Plain scalars that
+// read as null, a boolean or a number become one; quote values such as
+// "8080" where a string is expected. Block scalars (| and >), anchors,
+// aliases, tags and multiple documents are not supported.
+package yaml
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ToJSON converts a YAML document, which must be a mapping, to JSON. An
+// empty document is an empty object.
+func ToJSON(data []byte) ([]byte, error) {
+	lines, err := splitLines(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 {
+		return []byte("{}"), nil
+	}
+	if lines[0].indent != 0 || isSequenceItem(lines[0].text) {
+		return nil, fmt.Errorf("line %d: the document must be a mapping", lines[0].n)
+	}
+
+	p := &parser{lines: lines}
+	value, err := p.node(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.i < len(lines) {
+		return nil, fmt.Errorf("line %d: unexpected indentation", lines[p.i].n)
+	}
+	return json.Marshal(value)
+}
+
+// contentLine is a line of content: its number, indentation and text without
+// the indentation or a trailing comment.
+type contentLine struct {
+	n      int
+	indent int
+	text   string
+}
+
+func splitLines(data []byte) ([]contentLine, error) {
+	var lines []contentLine
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if n == 1 {
+			line = strings.TrimPrefix(line, "\ufeff")
+		}
+		content := strings.TrimLeft(line, " ")
+		if strings.HasPrefix(content, "\t") {
+			return nil, fmt.Errorf("line %d: indent with spaces, not tabs", n)
+		}
+		text, err := stripComment(content)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		if text == "" {
+			continue
+		}
+		if text == "---" || text == "..." {
+			if len(lines) > 0 && text == "---" {
+				return nil, fmt.Errorf("line %d: only one document is supported", n)
+			}
+			continue
+		}
+		lines = append(lines, contentLine{n: n, indent: len(line) - len(content), text: text})
+	}
+	return lines, scanner.Err()
+}
+
+// stripComment removes a "#" comment and trailing space from a line.
+// A "#" only starts a comment at the start of the line or after a space,
+// and never inside a quoted value; a quote only starts one at the start of
+// a value, so apostrophes in plain values are kept.
+func stripComment(s string) (string, error) {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case (c == '"' || c == '\'') && (i == 0 || strings.IndexByte(" [{,", s[i-1]) >= 0):
+			quote = c
+		case c == '#' && (i == 0 || s[i-1] == ' '):
+			return strings.TrimRight(s[:i], " "), nil
+		}
+	}
+	if quote != 0 {
+		return "", fmt.Errorf("unterminated quoted value")
+	}
+	return strings.TrimRight(s, " \r"), nil
+}
+
+type parser struct {
+	lines []contentLine
+	i     int
+}
+
+// node parses the mapping or sequence whose entries start at the current
+// line, which is indented by indent.
+func (p *parser) node(indent int) (any, error) {
+	if isSequenceItem(p.lines[p.i].text) {
+		return p.sequence(indent)
+	}
+	return p.mapping(indent)
+}
+
+func (p *parser) mapping(indent int) (map[string]any, error) {
+	m := map[string]any{}
+	for p.i < len(p.lines) {
+		line := p.lines[p.i]
+		if line.indent < indent {
+			break
+		}
+		if line.indent > indent {
+			return nil, fmt.Errorf("line %d: unexpected indentation", line.n)
+		}
+		if isSequenceItem(line.text) {
+			return nil, fmt.Errorf("line %d: expected key: value, not a list item", line.n)
+		}
+
+		colon := mappingColon(line.text)
+		if colon < 0 {
+			return nil, fmt.Errorf("line %d: expected key: value", line.n)
+		}
+		key, err := parseKey(line.text[:colon])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line.n, err)
+		}
+		if _, ok := m[key]; ok {
+			return nil, fmt.Errorf("line %d: %q is set twice", line.n, key)
+		}
+		rest := strings.TrimSpace(line.text[colon+1:])
+		p.i++
+
+		if rest != "" {
+			if m[key], err = parseValue(rest); err != nil {
+				return nil, fmt.Errorf("line %d: %w", line.n, err)
+			}
+			continue
+		}
+
+		// A nested mapping or sequence is indented further; a sequence may
+		// also start at the key's own indentation.
+		switch {
+		case p.i < len(p.lines) && p.lines[p.i].indent > indent:
+			m[key], err = p.node(p.lines[p.i].indent)
+		case p.i < len(p.lines) && p.lines[p.i].indent == indent && isSequenceItem(p.lines[p.i].text):
+			m[key], err = p.sequence(indent)
+		default:
+			m[key] = nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func (p *parser) sequence(indent int) ([]any, error) {
+	items := []any{}
+	for p.i < len(p.lines) {
+		line := p.lines[p.i]
+		if line.indent != indent || !isSequenceItem(line.text) {
+			if line.indent > indent {
+				return nil, fmt.Errorf("line %d: unexpected indentation", line.n)
+			}
+			break
+		}
+
+		rest := strings.TrimLeft(line.text[1:], " ")
+		switch {
+		case rest == "":
+			// The item is the mapping or sequence on the following lines.
+			p.i++
+			if p.i >= len(p.lines) || p.lines[p.i].indent <= indent {
+				items = append(items, nil)
+				continue
+			}
+			item, err := p.node(p.lines[p.i].indent)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+
+		case isSequenceItem(rest) || mappingColon(rest) >= 0:
+			// "- key: value" starts a mapping, and "- - x" a sequence, whose
+			// entries are indented to where the first one starts.
+			itemIndent := indent + len(line.text) - len(rest)
+			p.lines[p.i] = contentLine{n: line.n, indent: itemIndent, text: rest}
+			item, err := p.node(itemIndent)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+
+		default:
+			item, err := parseValue(rest)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line.n, err)
+			}
+			items = append(items, item)
+			p.i++
+		}
+	}
+	return items, nil
+}
+
+func isSequenceItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// mappingColon returns the index of the ":" that ends the key of a
+// "key: value" line, or -1. The colon must be followed by a space or end
+// the line, so values such as URLs are not split.
+func mappingColon(text string) int {
+	if strings.HasPrefix(text, "[") || strings.HasPrefix(text, "{") {
+		return -1
+	}
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case (c == '"' || c == '\'') && i == 0:
+			quote = c
+		case c == ':' && (i == len(text)-1 || text[i+1] == ' '):
+			return i
+		}
+	}
+	return -1
+}
+
+func parseKey(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return "", fmt.Errorf("empty key")
+	}
+	value, err := parseScalar(s)
+	if err != nil {
+		return "", err
+	}
+	if quoted := s[0] == '"' || s[0] == '\''; quoted {
+		return value.(string), nil
+	}
+	return s, nil
+}
+
+// parseValue parses the value after "key:" or "- ": a scalar or a one-line
+// collection of scalars.
+func parseValue(s string) (any, error) {
+	switch s[0] {
+	case '[':
+		if !strings.HasSuffix(s, "]") {
+			return nil, fmt.Errorf("a [ list must end on the same line")
+		}
+		items := []any{}
+		for _, part := range splitFlow(s[1 : len(s)-1]) {
+			item, err := flowScalar(part)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+
+	case '{':
+		if !strings.HasSuffix(s, "}") {
+			return nil, fmt.Errorf("a { mapping must end on the same line")
+		}
+		m := map[string]any{}
+		for _, part := range splitFlow(s[1 : len(s)-1]) {
+			colon := mappingColon(part)
+			if colon < 0 {
+				return nil, fmt.Errorf("expected key: value in %s", s)
+			}
+			key, err := parseKey(part[:colon])
+			if err != nil {
+				return nil, err
+			}
+			if m[key], err = flowScalar(strings.TrimSpace(part[colon+1:])); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+
+	case '|', '>':
+		return nil, fmt.Errorf("block scalars (| and >) are not supported; use a quoted string")
+	case '&', '*', '!':
+		return nil, fmt.Errorf("anchors, aliases and tags are not supported")
+	}
+	return parseScalar(s)
+}
+
+// splitFlow splits the inside of a one-line collection at the commas that
+// are not quoted. Empty entries, as after a trailing comma, are dropped.
+func splitFlow(s string) []string {
+	var parts []string
+	var quote byte
+	start := 0
+	for i := 0; i <= len(s); i++ {
+		if i == len(s) || quote == 0 && s[i] == ',' {
+			if part := strings.TrimSpace(s[start:i]); part != "" {
+				parts = append(parts, part)
+			}
+			start = i + 1
+			continue
+		}
+		switch c := s[i]; {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case (c == '"' || c == '\'') && strings.TrimSpace(s[start:i]) == "":
+			quote = c
+		}
+	}
+	return parts
+}
+
+func flowScalar(s string) (any, error) {
+	if strings.ContainsAny(s[:1], "[{") {
+		return nil, fmt.Errorf("nested [ and { collections are not supported")
+	}
+	return parseValue(s)
+}
+
+var (
+	intPattern   = regexp.MustCompile(`^[-+]?(0|[1-9][0-9]*)$`)
+	floatPattern = regexp.MustCompile(`^[-+]?([0-9]+\.[0-9]*|\.[0-9]+)([eE][-+]?[0-9]+)?$|^[-+]?[0-9]+[eE][-+]?[0-9]+$`)
+)
+
+// parseScalar parses a quoted or plain scalar.
+func parseScalar(s string) (any, error) {
+	switch s[0] {
+	case '"':
+		value, err := strconv.Unquote(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid double-quoted value %s", s)
+		}
+		return value, nil
+	case '\'':
+		if len(s) < 2 || s[len(s)-1] != '\'' {
+			return nil, fmt.Errorf("invalid single-quoted value %s", s)
+		}
+		inner := s[1 : len(s)-1]
+		if strings.Contains(strings.ReplaceAll(inner, "''", ""), "'") {
+			return nil, fmt.Errorf("invalid single-quoted value %s", s)
+		}
+		return strings.ReplaceAll(inner, "''", "'"), nil
+	}
+
+	switch s {
+	case "~", "null", "Null", "NULL":
+		return nil, nil
+	case "true", "True", "TRUE":
+		return true, nil
+	case "false", "False", "FALSE":
+		return false, nil
+	}
+	if intPattern.MatchString(s) {
+		return json.Number(strings.TrimPrefix(s, "+")), nil
+	}
+	if floatPattern.MatchString(s) {
+		return strconv.ParseFloat(s, 64)
+	}
+	return s, nil
+}
@@ -0,0 +1,125 @@
+package yaml
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestToJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		want string // JSON, compared by value
+		err  string // part of the error, when one is expected
+	}{
+		{
+			name: "scalars",
+			yaml: `
+# comment
+listen: ":8443"   # trailing comment
+data_dir: /var/lib/storage
+log_file: 'it''s.log'
+signing_key: "a # not a comment"
+website_domain: it's.example.com
+max_object_size: 1048576
+ratio: 0.5
+enabled: true
+missing: ~
+mode: 0755
+`,
+			want: `{"listen":":8443","data_dir":"/var/lib/storage","log_file":"it's.log","signing_key":"a # not a comment",
+				"website_domain":"it's.example.com","max_object_size":1048576,"ratio":0.5,"enabled":true,"missing":null,"mode":"0755"}`,
+		},
+		{
+			name: "nested",
+			yaml: `
+tls:
+  cert_file: /etc/storage/server.crt
+  key_file: /etc/storage/server.key
+mirror:
+  upstream: http://primary:8080/path
+empty:
+`,
+			want: `{"tls":{"cert_file":"/etc/storage/server.crt","key_file":"/etc/storage/server.key"},
+				"mirror":{"upstream":"http://primary:8080/path"},"empty":null}`,
+		},
+		{
+			name: "sequences",
+			yaml: `
+data_dirs:
+  - /mnt/a
+  - "/mnt/b"
+tokens: [ci-token, "deploy, token", 'x']
+none: []
+labels: {team: storage, tier: "1"}
+listeners:
+- address: ":9000"
+  routes: admin
+  tls:
+    cert_file: admin.crt
+-
+  address: ":9001"
+- - nested
+`,
+			want: `{"data_dirs":["/mnt/a","/mnt/b"],"tokens":["ci-token","deploy, token","x"],"none":[],
+				"labels":{"team":"storage","tier":"1"},
+				"listeners":[{"address":":9000","routes":"admin","tls":{"cert_file":"admin.crt"}},{"address":":9001"},["nested"]]}`,
+		},
+		{
+			name: "profiles",
+			yaml: `
+default: prod # the profile used without --profile
+profiles:
+  prod:
+    server: "https://storage.example.com"   # comment
+    token: 'ci-token'
+  local:
+    server: http://localhost:8080
+`,
+			want: `{"default":"prod","profiles":{"prod":{"server":"https://storage.example.com","token":"ci-token"},
+				"local":{"server":"http://localhost:8080"}}}`,
+		},
+		{
+			name: "empty",
+			yaml: "# nothing set\n---\n",
+			want: `{}`,
+		},
+		{name: "tab indent", yaml: "tls:\n\tcert_file: x\n", err: "line 2: indent with spaces"},
+		{name: "indented past a scalar", yaml: "listen: :80\n  extra: 1\n", err: "line 2: unexpected indentation"},
+		{name: "inconsistent indentation", yaml: "tls:\n    cert_file: x\n  key_file: y\n", err: "line 3: unexpected indentation"},
+		{name: "key set twice", yaml: "listen: a\nlisten: b\n", err: `line 2: "listen" is set twice`},
+		{name: "block scalar", yaml: "note: |\n  text\n", err: "block scalars"},
+		{name: "anchor", yaml: "base: &base\n", err: "anchors"},
+		{name: "unterminated quote", yaml: "listen: \"unterminated\n", err: "unterminated"},
+		{name: "top-level sequence", yaml: "- a\n", err: "must be a mapping"},
+		{name: "not a mapping", yaml: "just text\n", err: "line 1: expected key: value"},
+		{name: "two documents", yaml: "a: 1\n---\nb: 2\n", err: "only one document"},
+		{name: "nested flow collection", yaml: "tokens: [a, [b]]\n", err: "nested"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := ToJSON([]byte(tt.yaml))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got, want any
+			if err := json.Unmarshal(data, &got); err != nil {
+				t.Fatalf("invalid JSON %s: %v", data, err)
+			}
+			if err := json.Unmarshal([]byte(tt.want), &want); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %s\nwant %s", data, tt.want)
+			}
+		})
+	}
+}